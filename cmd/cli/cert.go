@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newCertCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cert",
+		Short: "Manage the TLS certificates of service domains",
+	}
+
+	upload := &cobra.Command{
+		Use:   "upload",
+		Short: "Store a certificate and key obtained elsewhere for a domain",
+		RunE:  cli.runCertUpload,
+	}
+	upload.Flags().String("domain", "", "Domain the certificate is for")
+	upload.Flags().String("cert", "", "PEM certificate chain file, leaf first")
+	upload.Flags().String("key", "", "PEM private key file")
+	for _, name := range []string{"domain", "cert", "key"} {
+		upload.MarkFlagRequired(name)
+	}
+
+	selfSigned := &cobra.Command{
+		Use:   "self-signed",
+		Short: "Create a self-signed certificate for an internal domain",
+		RunE:  cli.runCertSelfSigned,
+	}
+	selfSigned.Flags().String("domain", "", "Domain the certificate is for")
+	selfSigned.Flags().Int("days", 365, "Number of days the certificate is valid")
+	selfSigned.MarkFlagRequired("domain")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the stored certificates and when they expire",
+		RunE:  cli.runCertList,
+	}
+
+	cmd.AddCommand(upload, selfSigned, list)
+	return cmd
+}
+
+func (cli *CLI) runCertUpload(cmd *cobra.Command, args []string) error {
+	certPEM, err := os.ReadFile(cmd.Flag("cert").Value.String())
+	if err != nil {
+		return fmt.Errorf("error reading certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(cmd.Flag("key").Value.String())
+	if err != nil {
+		return fmt.Errorf("error reading key: %w", err)
+	}
+	cert, err := cli.cm.UploadCertificate(context.Background(), cmd.Flag("domain").Value.String(), certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded certificate for %s, valid until %s\n", cert.Domain, formatExpiry(cert.NotAfter))
+	return nil
+}
+
+func (cli *CLI) runCertSelfSigned(cmd *cobra.Command, args []string) error {
+	days, _ := cmd.Flags().GetInt("days")
+	if days < 0 {
+		return fmt.Errorf("--days must not be negative")
+	}
+	cert, err := cli.cm.SelfSignedCertificate(context.Background(), cmd.Flag("domain").Value.String(), time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created self-signed certificate for %s, valid until %s\n", cert.Domain, formatExpiry(cert.NotAfter))
+	return nil
+}
+
+func (cli *CLI) runCertList(cmd *cobra.Command, args []string) error {
+	stored, err := cli.cm.ListCertificates()
+	if err != nil {
+		return err
+	}
+	if len(stored) == 0 {
+		fmt.Println("No certificates stored")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Domain", "Source", "Expires", "Updated"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+
+	for _, cert := range stored {
+		table.Append([]string{
+			cert.Domain,
+			cert.Source,
+			formatExpiry(cert.NotAfter),
+			cert.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	table.Render()
+	warnExpiring(stored)
+	return nil
+}
+
+// formatExpiry shows when a certificate expires, highlighted when that is soon
+func formatExpiry(notAfter time.Time) string {
+	now := time.Now()
+	expiry := notAfter.Format(time.RFC3339)
+	switch {
+	case !now.Before(notAfter):
+		return color.RedString(expiry + " (expired)")
+	case container.CertificateExpiring(notAfter, now):
+		return color.YellowString(expiry)
+	}
+	return expiry
+}
+
+// listCertificate shows the certificate of a domain in the container list
+func listCertificate(certs map[string]database.Certificate, domain string) string {
+	cert, ok := certs[strings.ToLower(domain)]
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%s %s", cert.Source, formatExpiry(cert.NotAfter))
+}
+
+// warnExpiring prints a warning for each certificate close to expiry
+func warnExpiring(certs []database.Certificate) {
+	now := time.Now()
+	for _, cert := range certs {
+		if container.CertificateExpiring(cert.NotAfter, now) {
+			fmt.Fprintf(os.Stderr, "%s certificate of %s expires %s\n", color.YellowString("Warning:"), cert.Domain, cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newDBCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Manage the orchestrator database",
+	}
+
+	migrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending schema migrations, backing up the database first",
+		Run:   cli.runDBMigrate,
+	}
+	migrate.Flags().Bool("status", false, "Only show which migrations have been applied")
+
+	cmd.AddCommand(migrate, &cobra.Command{
+		Use:   "backup <file>",
+		Short: "Write a consistent copy of the database while it stays in use",
+		Run:   cli.runDBBackup,
+	})
+	return cmd
+}
+
+func (cli *CLI) runDBBackup(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: db backup <file>")
+		return
+	}
+	if err := cli.cm.Db.Backup(args[0]); err != nil {
+		cli.cm.Logger.Error("Error backing up database: %v", err)
+		return
+	}
+	fmt.Printf("Backed up the database to %s\n", args[0])
+}
+
+func (cli *CLI) runDBMigrate(cmd *cobra.Command, args []string) {
+	if status, _ := cmd.Flags().GetBool("status"); status {
+		states, err := cli.cm.Db.MigrationStatus()
+		if err != nil {
+			cli.cm.Logger.Error("Error reading migration status: %v", err)
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied {
+				applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s: %s\n", state.Version, state.Name, applied)
+		}
+		return
+	}
+
+	report, err := cli.cm.Db.Migrate()
+	if report != nil && report.Backup != "" {
+		fmt.Printf("Backed up the database to %s\n", report.Backup)
+	}
+	if report != nil {
+		for _, state := range report.Applied {
+			fmt.Printf("Applied %04d_%s\n", state.Version, state.Name)
+		}
+	}
+	if err != nil {
+		cli.cm.Logger.Error("Error migrating database: %v", err)
+		return
+	}
+	if len(report.Applied) == 0 {
+		fmt.Println("The database schema is up to date")
+	}
+}
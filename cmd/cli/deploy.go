@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/pkg/docker"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newDeployCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Build an image from a local directory and create or update its container",
+		Run:   cli.runDeploy,
+	}
+
+	cmd.Flags().String("from-dir", "", "Directory to use as the build context")
+	cmd.Flags().String("dockerfile", "", "Path of the Dockerfile inside the build context")
+	cmd.Flags().StringToString("build-arg", nil, "Build arguments (KEY=VALUE)")
+	cmd.Flags().String("target", "", "Build stage to target")
+	cmd.Flags().StringToString("label", nil, "Image labels (KEY=VALUE)")
+	cmd.Flags().String("domain", "", "Domain name for the container")
+	cmd.Flags().String("image", "", "Image repository to tag the build with, defaults to the container name")
+	cmd.Flags().String("name", "", "Name for the container")
+	cmd.Flags().String("port", "", "Container port")
+	cmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+
+	history := &cobra.Command{
+		Use:   "history <service-name>",
+		Short: "List the past deployments of a service with the output of their hooks",
+		Run:   cli.runDeployHistory,
+	}
+	history.Flags().Int("limit", 10, "Number of deployments to show, 0 for all")
+	cmd.AddCommand(history)
+
+	return cmd
+}
+
+func (cli *CLI) runDeploy(cmd *cobra.Command, args []string) {
+	dir := cmd.Flag("from-dir").Value.String()
+	if dir == "" {
+		cli.cm.Logger.Error("Build context directory is required")
+		fmt.Println("Usage: deploy --from-dir <directory> --name <container-name>")
+		return
+	}
+	buildArgs, _ := cmd.Flags().GetStringToString("build-arg")
+	labels, _ := cmd.Flags().GetStringToString("label")
+
+	buildContext, err := docker.TarBuildContext(dir)
+	if err != nil {
+		cli.cm.Logger.Error("Error reading build context: %v", err)
+		return
+	}
+	defer buildContext.Close()
+
+	config := &container.ContainerConfig{
+		DomainName:    cmd.Flag("domain").Value.String(),
+		ImageName:     cmd.Flag("image").Value.String(),
+		ContainerName: cmd.Flag("name").Value.String(),
+		ContainerPort: cmd.Flag("port").Value.String(),
+	}
+	opts := docker.BuildOptions{
+		Dockerfile: cmd.Flag("dockerfile").Value.String(),
+		BuildArgs:  buildArgs,
+		Target:     cmd.Flag("target").Value.String(),
+		Labels:     labels,
+	}
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		plan, err := cli.cm.DryRun(context.Background(), func(dry *container.ContainerManager) error {
+			return dry.BuildAndDeploy(context.Background(), buildContext, opts, docker.BuildVersion(dir), config, os.Stdout)
+		})
+		if plan == nil {
+			cli.cm.Logger.Error("Error planning deploy: %v", err)
+			return
+		}
+		printPlan(plan, err)
+		return
+	}
+
+	err = cli.cm.BuildAndDeploy(cli.ctx(), buildContext, opts, docker.BuildVersion(dir), config, os.Stdout)
+	if err != nil {
+		cli.cm.Logger.Error("Error deploying container: %v", err)
+		return
+	}
+	cli.cm.Logger.Info("Deployed image %s as container %s", config.ImageName, config.ContainerName)
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newImageCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Export and import service images for hosts without registry access",
+	}
+
+	saveCmd := &cobra.Command{
+		Use:   "save <container-name>...",
+		Short: "Save the images and manifest of one or more services to a tarball",
+		Run:   cli.runImageSave,
+	}
+	saveCmd.Flags().StringP("output", "o", "images.tar", "Tarball to write")
+
+	loadCmd := &cobra.Command{
+		Use:   "load <tarball>",
+		Short: "Load images from a tarball and create or update its services",
+		Run:   cli.runImageLoad,
+	}
+	loadCmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+
+	cmd.AddCommand(saveCmd, loadCmd)
+	return cmd
+}
+
+func (cli *CLI) runImageSave(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cli.cm.Logger.Error("At least one container name is required")
+		fmt.Println("Usage: image save <container-name>... [-o images.tar]")
+		return
+	}
+	output := cmd.Flag("output").Value.String()
+
+	f, err := os.Create(output)
+	if err != nil {
+		cli.cm.Logger.Error("Error creating %s: %v", output, err)
+		return
+	}
+	defer f.Close()
+
+	if err := cli.cm.ExportServices(context.Background(), args, f); err != nil {
+		cli.cm.Logger.Error("Error saving images: %v", err)
+		os.Remove(output)
+		return
+	}
+	cli.cm.Logger.Info("Saved images to %s", output)
+}
+
+func (cli *CLI) runImageLoad(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cli.cm.Logger.Error("Tarball path is required")
+		fmt.Println("Usage: image load <tarball>")
+		return
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		cli.cm.Logger.Error("Error opening %s: %v", args[0], err)
+		return
+	}
+	defer f.Close()
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		plan, err := cli.cm.DryRun(context.Background(), func(dry *container.ContainerManager) error {
+			_, err := dry.ImportServices(context.Background(), f, os.Stdout)
+			return err
+		})
+		if plan == nil {
+			cli.cm.Logger.Error("Error planning image load: %v", err)
+			return
+		}
+		printPlan(plan, err)
+		return
+	}
+
+	services, err := cli.cm.ImportServices(cli.ctx(), f, os.Stdout)
+	if err != nil {
+		cli.cm.Logger.Error("Error loading images: %v", err)
+		return
+	}
+	for _, service := range services {
+		cli.cm.Logger.Info("Loaded %s (%s)", service.ContainerName, service.ImageName)
+	}
+}
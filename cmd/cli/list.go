@@ -1,7 +1,12 @@
 package cli
 
 import (
+	"fmt"
 	"os"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
 
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
@@ -22,9 +27,18 @@ func (cli *CLI) runList(cmd *cobra.Command, args []string) {
 		cli.cm.Logger.Error("Error listing containers: %v", err)
 		return
 	}
+	stored, err := cli.cm.ListCertificates()
+	if err != nil {
+		cli.cm.Logger.Error("Error listing certificates: %v", err)
+		return
+	}
+	certs := make(map[string]database.Certificate, len(stored))
+	for _, c := range stored {
+		certs[strings.ToLower(c.Domain)] = c
+	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "ID", "Image", "Domain", "Port", "Status"})
+	table.SetHeader([]string{"Name", "ID", "Image", "Domain", "Certificate", "Ports", "Status"})
 	table.SetBorder(false)
 	table.SetAutoWrapText(false)
 	table.SetAutoFormatHeaders(true)
@@ -50,10 +64,28 @@ func (cli *CLI) runList(cmd *cobra.Command, args []string) {
 			container.ContainerID[:12],
 			container.ImageName,
 			container.DomainName,
-			container.ContainerPort + ":" + container.HostPort,
+			listCertificate(certs, container.DomainName),
+			listPorts(container),
 			status,
 		})
 	}
 
 	table.Render()
+	warnExpiring(stored)
+}
+
+// listPorts shows where each port of an instance is published
+func listPorts(c container.ContainerConfig) string {
+	if len(c.Ports) == 0 {
+		return c.ContainerPort + ":" + c.HostPort
+	}
+	var ports []string
+	for _, p := range c.Ports {
+		port := fmt.Sprintf("%s %d/%s", p.Name, p.ContainerPort, p.Protocol)
+		if p.HostPort != 0 {
+			port = fmt.Sprintf("%s %d->%d/%s", p.Name, p.HostPort, p.ContainerPort, p.Protocol)
+		}
+		ports = append(ports, port)
+	}
+	return strings.Join(ports, ", ")
 }
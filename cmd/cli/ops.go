@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newOpsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ops",
+		Short: "Show queued, running and finished operations on services",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List operations, newest first",
+		Run:   cli.runOpsList,
+	}
+	list.Flags().String("service", "", "Only show operations on this service")
+	list.Flags().String("status", "", "Only show operations with this status: queued, running, succeeded or failed")
+	list.Flags().Int("limit", 20, "Number of operations to show, 0 for all")
+
+	cmd.AddCommand(list, &cobra.Command{
+		Use:   "get <operation-id>",
+		Short: "Show the status and result of an operation",
+		Run:   cli.runOpsGet,
+	})
+
+	return cmd
+}
+
+func (cli *CLI) runOpsList(cmd *cobra.Command, args []string) {
+	limit, _ := cmd.Flags().GetInt("limit")
+	ops, err := cli.cm.Db.ListOperations(cmd.Flag("service").Value.String(), cmd.Flag("status").Value.String(), limit)
+	if err != nil {
+		cli.cm.Logger.Error("Error listing operations: %v", err)
+		return
+	}
+	if len(ops) == 0 {
+		fmt.Println("No operations found")
+		return
+	}
+	for _, op := range ops {
+		printOperation(op)
+	}
+}
+
+func (cli *CLI) runOpsGet(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: ops get <operation-id>")
+		return
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		cli.cm.Logger.Error("Invalid operation ID %q", args[0])
+		return
+	}
+	op, err := cli.cm.Db.GetOperation(id)
+	if err != nil {
+		cli.cm.Logger.Error("Error getting operation: %v", err)
+		return
+	}
+	printOperation(*op)
+	if op.Result != "" {
+		fmt.Printf("  result: %s\n", op.Result)
+	}
+}
+
+func printOperation(op database.Operation) {
+	fmt.Printf("#%d %s %s %s (%s)", op.ID, op.CreatedAt.Format(time.RFC3339), op.Kind, op.ServiceName, op.Status)
+	if !op.StartedAt.IsZero() && !op.FinishedAt.IsZero() {
+		fmt.Printf(" in %s", op.FinishedAt.Sub(op.StartedAt))
+	}
+	if op.Message != "" {
+		fmt.Printf(": %s", op.Message)
+	}
+	fmt.Println()
+}
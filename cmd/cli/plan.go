@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+)
+
+func printPlan(plan *container.Plan, err error) {
+	fmt.Println("Dry run, nothing was changed. Plan:")
+	for i, step := range plan.Steps {
+		fmt.Printf("  %d. %s %s", i+1, step.Action, step.Target)
+		if step.Detail != "" {
+			fmt.Printf(" (%s)", step.Detail)
+		}
+		fmt.Println()
+	}
+	if len(plan.RowsAdded) > 0 || len(plan.RowsDeleted) > 0 {
+		fmt.Println("Database:")
+		for _, row := range plan.RowsDeleted {
+			fmt.Printf("  - %s %s %s:%s\n", row.ContainerName, row.ImageName, row.HostPort, row.ContainerPort)
+		}
+		for _, row := range plan.RowsAdded {
+			fmt.Printf("  + %s %s %s:%s\n", row.ContainerName, row.ImageName, row.HostPort, row.ContainerPort)
+		}
+	}
+	if len(plan.RouteDiff) > 0 {
+		fmt.Println("Routes:")
+		for _, line := range plan.RouteDiff {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if err != nil {
+		fmt.Printf("The operation would fail: %s\n", err)
+	}
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/policy"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newPolicyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Check services against an image admission policy",
+	}
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check every service in a manifest against a policy, exiting non-zero on violations",
+		RunE:  cli.runPolicyCheck,
+	}
+	checkCmd.Flags().StringP("file", "f", "", "Service manifest to check")
+	checkCmd.Flags().String("policy", "", "Image policy file, defaults to the configured policy")
+	checkCmd.Flags().String("environment", "", "Environment the services are deployed to, defaults to the configured environment")
+
+	cmd.AddCommand(checkCmd)
+	return cmd
+}
+
+func (cli *CLI) runPolicyCheck(cmd *cobra.Command, args []string) error {
+	manifest, err := container.LoadManifest(cmd.Flag("file").Value.String())
+	if err != nil {
+		return err
+	}
+
+	imagePolicy := cli.cm.Policy
+	if path := cmd.Flag("policy").Value.String(); path != "" {
+		if imagePolicy, err = policy.Load(path); err != nil {
+			return err
+		}
+	}
+	environment := cli.cm.Environment
+	if cmd.Flags().Changed("environment") {
+		environment = cmd.Flag("environment").Value.String()
+	}
+
+	labels := func(image string) map[string]string {
+		imageLabels, err := cli.cm.DockerClient.ImageLabels(context.Background(), image)
+		if err != nil {
+			cli.cm.Logger.Warn("Labels of %s not checked: %v", image, err)
+			return nil
+		}
+		return imageLabels
+	}
+
+	failed := 0
+	for i, err := range manifest.CheckPolicy(imagePolicy, environment, labels) {
+		name := manifest.Services[i].ContainerName
+		if err == nil {
+			fmt.Printf("%s %s\n", color.GreenString("PASS"), name)
+			continue
+		}
+		failed++
+		fmt.Printf("%s %s\n", color.RedString("FAIL"), name)
+		if violation, ok := err.(*policy.ViolationError); ok {
+			for _, v := range violation.Violations {
+				fmt.Printf("  - [%s] %s\n", v.Rule, v.Message)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services violate the image policy", failed, len(manifest.Services))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newPortsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ports",
+		Short: "List the host ports allocated to services",
+		Run:   cli.runPorts,
+	}
+}
+
+func (cli *CLI) runPorts(cmd *cobra.Command, args []string) {
+	leases, err := cli.cm.Db.ListPortLeases()
+	if err != nil {
+		cli.cm.Logger.Error("Error listing ports: %v", err)
+		return
+	}
+	var ranges []string
+	for _, r := range cli.cm.PortRanges() {
+		ranges = append(ranges, r.String())
+	}
+	fmt.Printf("Allocating from %v\n", ranges)
+	if len(leases) == 0 {
+		fmt.Println("No ports allocated")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Port", "Service", "Replica", "Name", "Container", "State", "Sticky"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+
+	for _, lease := range leases {
+		state := lease.State()
+		if state == database.PortPending {
+			state += " until " + lease.ExpiresAt.Format(time.RFC3339)
+		}
+		containerID := lease.ContainerID
+		if len(containerID) > 12 {
+			containerID = containerID[:12]
+		}
+		table.Append([]string{
+			strconv.Itoa(lease.Port) + "/" + lease.Protocol,
+			lease.ServiceName,
+			strconv.Itoa(lease.Replica),
+			lease.PortName,
+			containerID,
+			state,
+			strconv.FormatBool(lease.Sticky),
+		})
+	}
+	table.Render()
+}
+
+// portsFromFlags reads the ports given with --expose
+func portsFromFlags(cmd *cobra.Command) ([]container.PortSpec, error) {
+	values, _ := cmd.Flags().GetStringSlice("expose")
+	var ports []container.PortSpec
+	for _, value := range values {
+		p, err := container.ParsePortSpec(value)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
 	"github.com/spf13/cobra"
 )
 
@@ -15,6 +16,7 @@ func (cli *CLI) newRemoveCommand() *cobra.Command {
 	}
 
 	cmd.Flags().Bool("full", false, "Remove both the container and its image")
+	cmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
 
 	return cmd
 }
@@ -29,41 +31,29 @@ func (cli *CLI) runRemove(cmd *cobra.Command, args []string) {
 	containerName := args[0]
 	fullRemove, _ := cmd.Flags().GetBool("full")
 
-	containers, err := cli.cm.Db.GetContainersByPartialName(containerName)
-	if err != nil {
-		cli.cm.Logger.Error("Error fetching container: %v", err)
-		return
-	}
-
-	if len(containers) == 0 {
-		cli.cm.Logger.Error("No containers found matching '%s'", containerName)
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		plan, err := cli.cm.DryRun(context.Background(), func(dry *container.ContainerManager) error {
+			return dry.RemoveService(context.Background(), containerName, fullRemove)
+		})
+		if plan == nil {
+			cli.cm.Logger.Error("Error planning remove: %v", err)
+			return
+		}
+		printPlan(plan, err)
 		return
 	}
 
-	if len(containers) > 1 {
-		fmt.Printf("Multiple containers found matching '%s':\n", containerName)
-		for _, c := range containers {
-			cli.cm.Logger.Info("- %s (ID: %s)\n", c.ContainerName, c.ContainerID)
-		}
-		cli.cm.Logger.Info("Please specify the exact container name.")
+	service := cli.cm.ServiceName(&container.ContainerConfig{ContainerName: containerName})
+	_, err := cli.cm.RunOperation(cli.ctx(), service, "remove", container.RemoveParams(containerName, fullRemove), func(ctx context.Context) (string, error) {
+		return "", cli.cm.RemoveService(ctx, containerName, fullRemove)
+	})
+	if err != nil {
+		cli.cm.Logger.Error("Error removing container: %v", err)
 		return
 	}
-
-	container := containers[0]
-
 	if fullRemove {
-		err = cli.cm.RemoveContainerAndImage(context.Background(), container.ContainerID)
-		if err != nil {
-			cli.cm.Logger.Error("Error removing container and image: %v", err)
-			return
-		}
-		cli.cm.Logger.Info("Successfully removed container '%s' and its image.\n", container.ContainerName)
+		cli.cm.Logger.Info("Successfully removed container '%s' and its image.\n", containerName)
 	} else {
-		err = cli.cm.RemoveContainer(context.Background(), container.ContainerID)
-		if err != nil {
-			cli.cm.Logger.Error("Error removing container: %v", err)
-			return
-		}
-		cli.cm.Logger.Info("Successfully removed container '%s'.\n", container.ContainerName)
+		cli.cm.Logger.Info("Successfully removed container '%s'.\n", containerName)
 	}
 }
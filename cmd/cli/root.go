@@ -65,6 +65,12 @@ func (cli *CLI) ExecuteWithArgs(args []string) error {
 func (cli *CLI) GetContainerManager() *container.ContainerManager {
 	return cli.cm
 }
+
+// ctx is the context of changes made from the cli, they are recorded as made by the local user
+func (cli *CLI) ctx() context.Context {
+	return container.WithActor(context.Background(), container.LocalActor())
+}
+
 func (cli *CLI) Run() error {
 	return cli.rootCmd.Execute()
 }
@@ -76,5 +82,15 @@ func (cli *CLI) initCommands() {
 		cli.newRemoveCommand(),
 		cli.newUpdateCommand(),
 		cli.newServeCommand(),
+		cli.newDeployCommand(),
+		cli.newImageCommand(),
+		cli.newPolicyCommand(),
+		cli.newOpsCommand(),
+		cli.newStateCommand(),
+		cli.newAuditCommand(),
+		cli.newPortsCommand(),
+		cli.newCertCommand(),
+		cli.newHashPasswordCommand(),
+		cli.newDBCommand(),
 	)
 }
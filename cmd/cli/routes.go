@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// routesFromFlags reads the routes given with --route and --routes-file
+func routesFromFlags(cmd *cobra.Command) ([]container.RouteSpec, error) {
+	values, _ := cmd.Flags().GetStringSlice("route")
+	var routes []container.RouteSpec
+	for _, value := range values {
+		r, err := container.ParseRouteSpec(value)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	if path, _ := cmd.Flags().GetString("routes-file"); path != "" {
+		fromFile, err := container.LoadRouteSpecs(path)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, fromFile...)
+	}
+	return routes, nil
+}
+
+func (cli *CLI) newHashPasswordCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "hash-password",
+		Short: "Hash a password read from stdin for the basic_auth users of a route",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("error reading password: %w", err)
+			}
+			password = strings.TrimRight(password, "\r\n")
+			if password == "" {
+				return errors.New("password is empty")
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				return fmt.Errorf("error hashing password: %w", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(hash))
+			return nil
+		},
+	}
+}
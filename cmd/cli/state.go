@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newStateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Export the state of the orchestrator or restore it on another host",
+	}
+
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Write the specs, deployment history, certificates and registry logins of all services to a document",
+		Run:   cli.runStateExport,
+	}
+	export.Flags().StringP("output", "o", "", "File to write, standard output by default")
+	export.Flags().String("format", "yaml", "Document format: yaml or json")
+
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Restore the services of an exported document that do not exist on this host",
+		Long: "Restore the services of an exported document that do not exist on this host.\n\n" +
+			"Certificates, ACME accounts and registry logins keep their keys and passwords encrypted with CERT_STORE_KEY, they are imported when this host has the same key. " +
+			"Registry logins are stored when an image is pulled with them and CERT_STORE_KEY is set, pass --registry-login for private registries the export has no login for.",
+		Run: cli.runStateImport,
+	}
+	importCmd.Flags().StringSlice("registry-login", nil, "Login for a private registry as host=username:password, such as docker.io=me:token, used to pull the images of its services instead of the login in the document (repeatable)")
+	importCmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+
+	cmd.AddCommand(export, importCmd)
+	return cmd
+}
+
+func (cli *CLI) runStateExport(cmd *cobra.Command, args []string) {
+	state, err := cli.cm.ExportState()
+	if err != nil {
+		cli.cm.Logger.Error("Error exporting state: %v", err)
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	output := cmd.Flag("output").Value.String()
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			cli.cm.Logger.Error("Error creating %s: %v", output, err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := container.WriteState(w, state, cmd.Flag("format").Value.String()); err != nil {
+		cli.cm.Logger.Error("Error writing state: %v", err)
+		return
+	}
+	if output != "" {
+		cli.cm.Logger.Info("Exported %d services to %s", len(state.Services), output)
+	}
+}
+
+func (cli *CLI) runStateImport(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: state import <file>")
+		return
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		cli.cm.Logger.Error("Error opening %s: %v", args[0], err)
+		return
+	}
+	defer f.Close()
+
+	state, err := container.ReadState(f)
+	if err != nil {
+		cli.cm.Logger.Error("Error reading state: %v", err)
+		return
+	}
+	logins, _ := cmd.Flags().GetStringSlice("registry-login")
+	credentials := make(container.RegistryCredentials)
+	for _, l := range logins {
+		host, login, err := container.ParseRegistryLogin(l)
+		if err != nil {
+			cli.cm.Logger.Error("Error reading registry login: %v", err)
+			return
+		}
+		credentials[host] = login
+	}
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		var result *container.StateImport
+		plan, err := cli.cm.DryRun(context.Background(), func(dry *container.ContainerManager) error {
+			var err error
+			result, err = dry.ImportState(context.Background(), state, credentials)
+			return err
+		})
+		if plan == nil {
+			cli.cm.Logger.Error("Error planning state import: %v", err)
+			return
+		}
+		printPlan(plan, err)
+		if result != nil {
+			printStateImport(result.Restored, result.Skipped, failedMessages(result))
+		}
+		return
+	}
+
+	result, err := cli.cm.ImportState(cli.ctx(), state, credentials)
+	if err != nil {
+		cli.cm.Logger.Error("Error importing state: %v", err)
+		return
+	}
+	printStateImport(result.Restored, result.Skipped, failedMessages(result))
+}
+
+func failedMessages(result *container.StateImport) map[string]string {
+	failed := make(map[string]string)
+	for name, err := range result.Failed {
+		failed[name] = err.Error()
+	}
+	return failed
+}
+
+func printStateImport(restored, skipped []string, failed map[string]string) {
+	for _, name := range restored {
+		fmt.Printf("restored %s\n", name)
+	}
+	for _, name := range skipped {
+		fmt.Printf("skipped %s: it already exists\n", name)
+	}
+	names := make([]string, 0, len(failed))
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("failed %s: %s\n", name, failed[name])
+	}
+}
@@ -18,8 +18,31 @@ func (cli *CLI) newUpdateCommand() *cobra.Command {
 	cmd.Flags().String("image", "", "Image name for the container")
 	cmd.Flags().String("name", "", "Name for the container")
 	cmd.Flags().String("port", "", "Container port")
+	cmd.Flags().StringSlice("expose", nil, "Named port as name:container_port[/protocol][:role[:host_port[:public_port]]], role is http, grpc, tcp or none, tcp ports with a public port go through the proxy (repeatable)")
 	cmd.Flags().String("username", "", "Registry username")
 	cmd.Flags().String("password", "", "Registry password")
+	cmd.Flags().String("memory", "", "Memory limit, e.g. 512m")
+	cmd.Flags().String("cpus", "", "CPU limit, e.g. 0.5")
+	cmd.Flags().Int("replicas", 0, "Number of instances to run")
+	cmd.Flags().String("lb", "", "Load balancing strategy: round_robin or least_connections")
+	cmd.Flags().Bool("sticky-ports", false, "Keep the host port of each replica across updates and restarts")
+	cmd.Flags().String("pull", "", "Pull the image always (the default), only when missing or never; images built by deploy are never pulled")
+	cmd.Flags().String("publish", "", "Publish the port on every interface (public), on loopback only (loopback) or not at all, behind the proxy (private)")
+	cmd.Flags().StringSlice("network", nil, "Network the instances join, other services on it reach them by the service name (repeatable)")
+	cmd.Flags().StringSlice("route", nil, "Route as host[/path][:option,...] with the options strip, https and apex, such as example.com/api:strip, used instead of the domain; the longest matching path wins (repeatable)")
+	cmd.Flags().String("routes-file", "", "YAML file listing routes with their redirects, header rules, HSTS, CORS, auth and rate limits, added to --route")
+	cmd.Flags().Int("max-connections", 0, "Requests in flight each instance takes at most, the rest are answered like requests over a rate limit")
+	addHookFlags(cmd)
+	cmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+	cmd.Flags().Int("max-surge", 0, "Instances started above the replica count during the update")
+	cmd.Flags().Int("max-unavailable", 0, "Instances that may be down during the update")
+	cmd.Flags().String("readiness-path", "", "HTTP path that must answer before an instance receives traffic")
+	cmd.Flags().Duration("readiness-timeout", 0, "How long to wait for each new instance to become ready")
+	cmd.Flags().String("on-failure", "", "What to do when an instance is not ready: pause or abort")
+	cmd.Flags().String("strategy", "", "Update strategy: rolling or canary, canary needs the built-in proxy")
+	cmd.Flags().IntSlice("steps", nil, "Percentages of traffic shifted to the canary, e.g. 5,25,50,100")
+	cmd.Flags().Duration("interval", 0, "How long each canary step is watched")
+	cmd.Flags().Float64("max-error-rate", 0, "Share of canary requests that may fail with a 5xx, e.g. 0.05")
 
 	return cmd
 }
@@ -32,9 +55,55 @@ func (cli *CLI) runUpdate(cmd *cobra.Command, args []string) {
 		ContainerPort:    cmd.Flag("port").Value.String(),
 		RegistryUsername: cmd.Flag("username").Value.String(),
 		RegistryPassword: cmd.Flag("password").Value.String(),
+		MemoryLimit:      cmd.Flag("memory").Value.String(),
+		CPULimit:         cmd.Flag("cpus").Value.String(),
+		LoadBalancer:     cmd.Flag("lb").Value.String(),
+		Publish:          cmd.Flag("publish").Value.String(),
+		PullPolicy:       cmd.Flag("pull").Value.String(),
+	}
+	config.Replicas, _ = cmd.Flags().GetInt("replicas")
+	config.MaxConnections, _ = cmd.Flags().GetInt("max-connections")
+	config.StickyPorts, _ = cmd.Flags().GetBool("sticky-ports")
+	config.Networks, _ = cmd.Flags().GetStringSlice("network")
+	ports, err := portsFromFlags(cmd)
+	if err != nil {
+		cli.cm.Logger.Error("Error reading ports: %v", err)
+		return
+	}
+	config.Ports = ports
+	routes, err := routesFromFlags(cmd)
+	if err != nil {
+		cli.cm.Logger.Error("Error reading routes: %v", err)
+		return
+	}
+	config.Routes = routes
+	config.Hooks = hooksFromFlags(cmd)
+	config.Rollout.MaxSurge, _ = cmd.Flags().GetInt("max-surge")
+	config.Rollout.MaxUnavailable, _ = cmd.Flags().GetInt("max-unavailable")
+	config.Rollout.ReadinessPath = cmd.Flag("readiness-path").Value.String()
+	config.Rollout.ReadinessTimeout, _ = cmd.Flags().GetDuration("readiness-timeout")
+	config.Rollout.OnFailure = cmd.Flag("on-failure").Value.String()
+	config.Rollout.Strategy = cmd.Flag("strategy").Value.String()
+	config.Rollout.Steps, _ = cmd.Flags().GetIntSlice("steps")
+	config.Rollout.Interval, _ = cmd.Flags().GetDuration("interval")
+	config.Rollout.MaxErrorRate, _ = cmd.Flags().GetFloat64("max-error-rate")
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		plan, err := cli.cm.DryRun(context.Background(), func(dry *container.ContainerManager) error {
+			return dry.UpdateExistingContainer(context.Background(), config)
+		})
+		if plan == nil {
+			cli.cm.Logger.Error("Error planning update: %v", err)
+			return
+		}
+		printPlan(plan, err)
+		return
 	}
 
-	err := cli.cm.UpdateExistingContainer(context.Background(), config)
+	_, err = cli.cm.RunOperation(cli.ctx(), cli.cm.ServiceName(config), "update", config, func(ctx context.Context) (string, error) {
+		err := cli.cm.UpdateExistingContainer(ctx, config)
+		return config.ContainerID, err
+	})
 	if err != nil {
 		cli.cm.Logger.Error("Error updating container: %v", err)
 		return
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newAuditCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show who changed which services and how it went",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List audit log entries, newest first",
+		Run:   cli.runAuditList,
+	}
+	auditFilterFlags(list)
+	list.Flags().Int("limit", 50, "Number of entries to show, 0 for all")
+
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Write the audit log as JSON lines, newest first",
+		Run:   cli.runAuditExport,
+	}
+	auditFilterFlags(export)
+	export.Flags().StringP("output", "o", "", "File to write, standard output by default")
+
+	cmd.AddCommand(list, export)
+	return cmd
+}
+
+func auditFilterFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("service", "", "Only show changes to this service")
+	flags.String("actor", "", "Only show changes made by this actor")
+	flags.String("since", "", "Only show changes after this time, RFC 3339 or a duration such as 24h")
+	flags.String("until", "", "Only show changes before this time, RFC 3339 or a duration such as 1h")
+}
+
+func (cli *CLI) listAuditLog(cmd *cobra.Command) ([]database.AuditEntry, error) {
+	now := time.Now()
+	since, err := container.ParseAuditTime(cmd.Flag("since").Value.String(), now)
+	if err != nil {
+		return nil, err
+	}
+	until, err := container.ParseAuditTime(cmd.Flag("until").Value.String(), now)
+	if err != nil {
+		return nil, err
+	}
+	req := &pb.ListAuditLogRequest{
+		ServiceName: cmd.Flag("service").Value.String(),
+		Actor:       cmd.Flag("actor").Value.String(),
+	}
+	if !since.IsZero() {
+		req.Since = since.Unix()
+	}
+	if !until.IsZero() {
+		req.Until = until.Unix()
+	}
+	if cmd.Flags().Lookup("limit") != nil {
+		limit, _ := cmd.Flags().GetInt("limit")
+		req.Limit = int32(limit)
+	}
+	resp, err := cli.client.client.ListAuditLog(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []database.AuditEntry
+	for _, e := range resp.Entries {
+		entries = append(entries, database.AuditEntry{
+			ID:          e.Id,
+			Time:        time.Unix(e.Time, 0),
+			Actor:       e.Actor,
+			Source:      e.Source,
+			Action:      e.Action,
+			ServiceName: e.ServiceName,
+			Params:      e.ParamsJson,
+			Result:      e.Result,
+			Error:       e.Error,
+			Duration:    time.Duration(e.DurationMs) * time.Millisecond,
+			OperationID: e.OperationId,
+		})
+	}
+	return entries, nil
+}
+
+func (cli *CLI) runAuditList(cmd *cobra.Command, args []string) {
+	entries, err := cli.listAuditLog(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing audit log: %v\n", err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No audit log entries found")
+		return
+	}
+	for _, e := range entries {
+		printAuditEntry(e)
+	}
+}
+
+func (cli *CLI) runAuditExport(cmd *cobra.Command, args []string) {
+	entries, err := cli.listAuditLog(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing audit log: %v\n", err)
+		return
+	}
+	var w io.Writer = os.Stdout
+	output := cmd.Flag("output").Value.String()
+	if output != "" {
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", output, err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := container.WriteAuditJSONL(w, entries); err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting audit log: %v\n", err)
+		return
+	}
+	if output != "" {
+		fmt.Printf("Exported %d audit log entries to %s\n", len(entries), output)
+	}
+}
+
+func printAuditEntry(e database.AuditEntry) {
+	fmt.Printf("#%d %s %s %s %s (%s, %s)", e.ID, e.Time.Format(time.RFC3339), e.Actor, e.Action, e.ServiceName, e.Result, e.Duration)
+	if e.Source != "" {
+		fmt.Printf(" from %s", e.Source)
+	}
+	if e.OperationID != 0 {
+		fmt.Printf(" operation #%d", e.OperationID)
+	}
+	fmt.Println()
+	if e.Params != "" && e.Params != "{}" {
+		fmt.Printf("  params: %s\n", e.Params)
+	}
+	if e.Error != "" {
+		fmt.Printf("  error: %s\n", e.Error)
+	}
+}
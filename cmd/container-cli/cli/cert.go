@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newCertCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cert",
+		Short: "Manage the TLS certificates of service domains",
+	}
+
+	upload := &cobra.Command{
+		Use:   "upload",
+		Short: "Store a certificate and key obtained elsewhere for a domain",
+		RunE:  cli.runCertUpload,
+	}
+	upload.Flags().String("domain", "", "Domain the certificate is for")
+	upload.Flags().String("cert", "", "PEM certificate chain file, leaf first")
+	upload.Flags().String("key", "", "PEM private key file")
+	for _, name := range []string{"domain", "cert", "key"} {
+		upload.MarkFlagRequired(name)
+	}
+
+	selfSigned := &cobra.Command{
+		Use:   "self-signed",
+		Short: "Create a self-signed certificate for an internal domain",
+		RunE:  cli.runCertSelfSigned,
+	}
+	selfSigned.Flags().String("domain", "", "Domain the certificate is for")
+	selfSigned.Flags().Int("days", 365, "Number of days the certificate is valid")
+	selfSigned.MarkFlagRequired("domain")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the stored certificates and when they expire",
+		RunE:  cli.runCertList,
+	}
+
+	cmd.AddCommand(upload, selfSigned, list)
+	return cmd
+}
+
+func (cli *CLI) runCertUpload(cmd *cobra.Command, args []string) error {
+	certPEM, err := os.ReadFile(cmd.Flag("cert").Value.String())
+	if err != nil {
+		return fmt.Errorf("error reading certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(cmd.Flag("key").Value.String())
+	if err != nil {
+		return fmt.Errorf("error reading key: %w", err)
+	}
+	cert, err := cli.client.client.UploadCertificate(context.Background(), &pb.UploadCertificateRequest{
+		Domain:  cmd.Flag("domain").Value.String(),
+		CertPem: certPEM,
+		KeyPem:  keyPEM,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded certificate for %s, valid until %s\n", cert.Domain, formatExpiry(cert))
+	return nil
+}
+
+func (cli *CLI) runCertSelfSigned(cmd *cobra.Command, args []string) error {
+	days, _ := cmd.Flags().GetInt("days")
+	cert, err := cli.client.client.CreateSelfSignedCertificate(context.Background(), &pb.CreateSelfSignedCertificateRequest{
+		Domain:    cmd.Flag("domain").Value.String(),
+		ValidDays: int32(days),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created self-signed certificate for %s, valid until %s\n", cert.Domain, formatExpiry(cert))
+	return nil
+}
+
+func (cli *CLI) runCertList(cmd *cobra.Command, args []string) error {
+	resp, err := cli.client.client.ListCertificates(context.Background(), &pb.ListCertificatesRequest{})
+	if err != nil {
+		return err
+	}
+	if len(resp.Certificates) == 0 {
+		fmt.Println("No certificates stored")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Domain", "Source", "Expires", "Updated"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+
+	for _, cert := range resp.Certificates {
+		table.Append([]string{
+			cert.Domain,
+			cert.Source,
+			formatExpiry(cert),
+			time.Unix(cert.UpdatedAt, 0).Format(time.RFC3339),
+		})
+	}
+	table.Render()
+	warnExpiring(resp.Certificates)
+	return nil
+}
+
+// formatExpiry shows when a certificate expires, highlighted when that is soon
+func formatExpiry(cert *pb.CertificateInfo) string {
+	notAfter := time.Unix(cert.NotAfter, 0)
+	expiry := notAfter.Format(time.RFC3339)
+	switch {
+	case !time.Now().Before(notAfter):
+		return color.RedString(expiry + " (expired)")
+	case cert.Expiring:
+		return color.YellowString(expiry)
+	}
+	return expiry
+}
+
+// listCertificate shows the certificate of a domain in the container list
+func listCertificate(certs map[string]*pb.CertificateInfo, domain string) string {
+	cert, ok := certs[strings.ToLower(domain)]
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%s %s", cert.Source, formatExpiry(cert))
+}
+
+// warnExpiring prints a warning for each certificate close to expiry
+func warnExpiring(certs []*pb.CertificateInfo) {
+	for _, cert := range certs {
+		if cert.Expiring {
+			fmt.Fprintf(os.Stderr, "%s certificate of %s expires %s\n", color.YellowString("Warning:"), cert.Domain, time.Unix(cert.NotAfter, 0).Format(time.RFC3339))
+		}
+	}
+}
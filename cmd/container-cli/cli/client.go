@@ -1,9 +1,14 @@
 package cli
 
 import (
+	"context"
+	"os"
+	"os/user"
+
 	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
 type Client struct {
@@ -12,7 +17,16 @@ type Client struct {
 }
 
 func NewClient(address string) (*Client, error) {
-	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	actor := localUser()
+	conn, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(withActor(ctx, actor), method, req, reply, cc, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(withActor(ctx, actor), desc, cc, method, opts...)
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -23,3 +37,18 @@ func NewClient(address string) (*Client, error) {
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
+
+// withActor names the user of the cli to the orchestrator for its audit log
+func withActor(ctx context.Context, actor string) context.Context {
+	if actor == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "x-actor", actor)
+}
+
+func localUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
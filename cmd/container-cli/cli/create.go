@@ -20,8 +20,22 @@ func (cli *CLI) newCreateCommand() *cobra.Command {
 	cmd.Flags().String("image", "", "Image name for the container")
 	cmd.Flags().String("name", "", "Name for the container")
 	cmd.Flags().String("port", "", "Container port")
+	cmd.Flags().StringSlice("expose", nil, "Named port as name:container_port[/protocol][:role[:host_port[:public_port]]], role is http, grpc, tcp or none, tcp ports with a public port go through the proxy (repeatable)")
 	cmd.Flags().String("username", "", "Registry username")
 	cmd.Flags().String("password", "", "Registry password")
+	cmd.Flags().String("memory", "", "Memory limit, e.g. 512m")
+	cmd.Flags().String("cpus", "", "CPU limit, e.g. 0.5")
+	cmd.Flags().Int("replicas", 0, "Number of instances to run")
+	cmd.Flags().String("lb", "", "Load balancing strategy: round_robin or least_connections")
+	cmd.Flags().Bool("sticky-ports", false, "Keep the host port of each replica across updates and restarts")
+	cmd.Flags().String("pull", "", "Pull the image always (the default), only when missing or never; images built by deploy are never pulled")
+	cmd.Flags().String("publish", "", "Publish the port on every interface (public), on loopback only (loopback) or not at all, behind the proxy (private)")
+	cmd.Flags().StringSlice("network", nil, "Network the instances join, other services on it reach them by the service name (repeatable)")
+	cmd.Flags().StringSlice("route", nil, "Route as host[/path][:option,...] with the options strip, https and apex, such as example.com/api:strip, used instead of the domain; the longest matching path wins (repeatable)")
+	cmd.Flags().String("routes-file", "", "YAML file listing routes with their redirects, header rules, HSTS, CORS, auth and rate limits, added to --route")
+	cmd.Flags().Int("max-connections", 0, "Requests in flight each instance takes at most, the rest are answered like requests over a rate limit")
+	addHookFlags(cmd)
+	cmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
 
 	return cmd
 }
@@ -34,11 +48,40 @@ func (cli *CLI) runCreate(cmd *cobra.Command, args []string) {
 		ContainerPort:    cmd.Flag("port").Value.String(),
 		RegistryUsername: cmd.Flag("username").Value.String(),
 		RegistryPassword: cmd.Flag("password").Value.String(),
+		MemoryLimit:      cmd.Flag("memory").Value.String(),
+		CpuLimit:         cmd.Flag("cpus").Value.String(),
+		LoadBalancer:     cmd.Flag("lb").Value.String(),
+		Publish:          cmd.Flag("publish").Value.String(),
+		PullPolicy:       cmd.Flag("pull").Value.String(),
 	}
-	resp, err := cli.client.client.CreateContainer(context.Background(), &pb.CreateContainerRequest{Config: config})
+	replicas, _ := cmd.Flags().GetInt("replicas")
+	config.Replicas = int32(replicas)
+	maxConnections, _ := cmd.Flags().GetInt("max-connections")
+	config.MaxConnections = int32(maxConnections)
+	config.StickyPorts, _ = cmd.Flags().GetBool("sticky-ports")
+	config.Networks, _ = cmd.Flags().GetStringSlice("network")
+	ports, err := portsFromFlags(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading ports: %v\n", err)
+		return
+	}
+	config.Ports = ports
+	routes, err := routesFromFlags(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading routes: %v\n", err)
+		return
+	}
+	config.Routes = routes
+	config.PreDeployHooks, config.PostDeployHooks = hooksFromFlags(cmd)
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	resp, err := cli.client.client.CreateContainer(context.Background(), &pb.CreateContainerRequest{Config: config, DryRun: dryRun})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating container: %v\n", err)
 		return
 	}
+	if dryRun {
+		printPlan(resp.Plan)
+		return
+	}
 	fmt.Printf("Container created successfully with ID: %s\n", resp.ContainerId)
 }
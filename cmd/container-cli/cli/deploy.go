@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dgunzy/go-container-orchestrator/pkg/docker"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+const buildContextChunkSize = 64 * 1024
+
+func (cli *CLI) newDeployCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Build an image from a local directory and create or update its container",
+		Run:   cli.runDeploy,
+	}
+
+	cmd.Flags().String("from-dir", "", "Directory to use as the build context")
+	cmd.Flags().String("dockerfile", "", "Path of the Dockerfile inside the build context")
+	cmd.Flags().StringToString("build-arg", nil, "Build arguments (KEY=VALUE)")
+	cmd.Flags().String("target", "", "Build stage to target")
+	cmd.Flags().StringToString("label", nil, "Image labels (KEY=VALUE)")
+	cmd.Flags().String("domain", "", "Domain name for the container")
+	cmd.Flags().String("image", "", "Image repository to tag the build with, defaults to the container name")
+	cmd.Flags().String("name", "", "Name for the container")
+	cmd.Flags().String("port", "", "Container port")
+	cmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status <service-name>",
+		Short: "Show the progress of the current or last update of a service and why each step was taken",
+		Run:   cli.runDeployStatus,
+	})
+	history := &cobra.Command{
+		Use:   "history <service-name>",
+		Short: "List the past deployments of a service with the output of their hooks",
+		Run:   cli.runDeployHistory,
+	}
+	history.Flags().Int("limit", 10, "Number of deployments to show, 0 for all")
+	cmd.AddCommand(history)
+
+	return cmd
+}
+
+func (cli *CLI) runDeploy(cmd *cobra.Command, args []string) {
+	dir := cmd.Flag("from-dir").Value.String()
+	if dir == "" {
+		fmt.Println("Usage: deploy --from-dir <directory> --name <container-name>")
+		return
+	}
+	buildArgs, _ := cmd.Flags().GetStringToString("build-arg")
+	labels, _ := cmd.Flags().GetStringToString("label")
+
+	buildContext, err := docker.TarBuildContext(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading build context: %v\n", err)
+		return
+	}
+	defer buildContext.Close()
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	stream, err := cli.client.client.BuildAndDeploy(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting deploy: %v\n", err)
+		return
+	}
+
+	err = stream.Send(&pb.BuildAndDeployRequest{Payload: &pb.BuildAndDeployRequest_Metadata{Metadata: &pb.BuildMetadata{
+		Config: &pb.ContainerConfig{
+			DomainName:    cmd.Flag("domain").Value.String(),
+			ImageName:     cmd.Flag("image").Value.String(),
+			ContainerName: cmd.Flag("name").Value.String(),
+			ContainerPort: cmd.Flag("port").Value.String(),
+		},
+		Options: &pb.BuildOptions{
+			Dockerfile: cmd.Flag("dockerfile").Value.String(),
+			BuildArgs:  buildArgs,
+			Target:     cmd.Flag("target").Value.String(),
+			Labels:     labels,
+			Version:    docker.BuildVersion(dir),
+		},
+		DryRun: dryRun,
+	}}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending build metadata: %v\n", err)
+		return
+	}
+
+	// A dry run builds nothing, so the build context is not uploaded
+	buf := make([]byte, buildContextChunkSize)
+	for !dryRun {
+		n, err := buildContext.Read(buf)
+		if n > 0 {
+			chunk := &pb.BuildAndDeployRequest{Payload: &pb.BuildAndDeployRequest_ContextChunk{ContextChunk: buf[:n]}}
+			if err := stream.Send(chunk); err != nil {
+				fmt.Fprintf(os.Stderr, "Error uploading build context: %v\n", err)
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading build context: %v\n", err)
+			return
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error uploading build context: %v\n", err)
+		return
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error deploying container: %v\n", err)
+			return
+		}
+		fmt.Print(resp.Output)
+		if resp.Plan != nil {
+			printPlan(resp.Plan)
+			continue
+		}
+		if resp.ImageName != "" {
+			fmt.Printf("Deployed image %s as container %s\n", resp.ImageName, resp.ContainerId)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+func addHookFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("pre-deploy", nil, "Shell command run before the new version receives traffic, aborts the deploy if it fails (repeatable)")
+	cmd.Flags().StringArray("post-deploy", nil, "Shell command run after the new version receives traffic (repeatable)")
+	cmd.Flags().Duration("hook-timeout", 0, "How long each hook may run, defaults to 10m")
+	cmd.Flags().String("hook-run-in", "", "Where hooks run: instance (exec in the new container) or oneoff (a separate container from the new image)")
+}
+
+// hooksFromFlags runs each hook command through sh so it may use pipes and variables
+func hooksFromFlags(cmd *cobra.Command) (pre, post []*pb.Hook) {
+	timeout, _ := cmd.Flags().GetDuration("hook-timeout")
+	runIn := cmd.Flag("hook-run-in").Value.String()
+	hooks := func(flag string) []*pb.Hook {
+		commands, _ := cmd.Flags().GetStringArray(flag)
+		var result []*pb.Hook
+		for _, command := range commands {
+			result = append(result, &pb.Hook{
+				Command:        []string{"sh", "-c", command},
+				TimeoutSeconds: int32(timeout.Seconds()),
+				RunIn:          runIn,
+			})
+		}
+		return result
+	}
+	return hooks("pre-deploy"), hooks("post-deploy")
+}
+
+func (cli *CLI) runDeployHistory(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: deploy history <service-name>")
+		return
+	}
+	limit, _ := cmd.Flags().GetInt("limit")
+	resp, err := cli.client.client.ListDeployments(context.Background(), &pb.ListDeploymentsRequest{ServiceName: args[0], Limit: int32(limit)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing deployments: %v\n", err)
+		return
+	}
+	if len(resp.Deployments) == 0 {
+		fmt.Printf("No deployments recorded for %s\n", args[0])
+		return
+	}
+	for _, d := range resp.Deployments {
+		fmt.Printf("#%d %s %s %s (%s)", d.Id, time.Unix(d.StartedAt, 0).Format(time.RFC3339), d.Strategy, d.ImageName, d.Status)
+		if d.Message != "" {
+			fmt.Printf(": %s", d.Message)
+		}
+		fmt.Println()
+		for _, h := range d.Hooks {
+			fmt.Printf("  %s %q exit %d in %s\n", h.Phase, h.Command, h.ExitCode, time.Duration(h.DurationMs)*time.Millisecond)
+			if h.Error != "" {
+				fmt.Printf("    error: %s\n", h.Error)
+			}
+			for _, line := range strings.Split(strings.TrimRight(h.Output, "\n"), "\n") {
+				if line != "" {
+					fmt.Printf("    | %s\n", line)
+				}
+			}
+		}
+	}
+}
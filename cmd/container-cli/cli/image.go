@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+const imageBundleChunkSize = 64 * 1024
+
+func (cli *CLI) newImageCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Export and import service images for hosts without registry access",
+	}
+
+	saveCmd := &cobra.Command{
+		Use:   "save <container-name>...",
+		Short: "Save the images and manifest of one or more services to a tarball",
+		Run:   cli.runImageSave,
+	}
+	saveCmd.Flags().StringP("output", "o", "images.tar", "Tarball to write")
+
+	loadCmd := &cobra.Command{
+		Use:   "load <tarball>",
+		Short: "Load images from a tarball and create or update its services",
+		Run:   cli.runImageLoad,
+	}
+	loadCmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+
+	cmd.AddCommand(saveCmd, loadCmd)
+	return cmd
+}
+
+func (cli *CLI) runImageSave(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: image save <container-name>... [-o images.tar]")
+		return
+	}
+	output := cmd.Flag("output").Value.String()
+
+	stream, err := cli.client.client.SaveImages(context.Background(), &pb.SaveImagesRequest{ContainerNames: args})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving images: %v\n", err)
+		return
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", output, err)
+		return
+	}
+	defer f.Close()
+
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving images: %v\n", err)
+			os.Remove(output)
+			return
+		}
+		if _, err := f.Write(resp.Chunk); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", output, err)
+			return
+		}
+	}
+	fmt.Printf("Saved images to %s\n", output)
+}
+
+func (cli *CLI) runImageLoad(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: image load <tarball>")
+		return
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", args[0], err)
+		return
+	}
+	defer f.Close()
+
+	stream, err := cli.client.client.LoadImages(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading images: %v\n", err)
+		return
+	}
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	buf := make([]byte, imageBundleChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&pb.LoadImagesRequest{Chunk: buf[:n], DryRun: dryRun}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error uploading images: %v\n", err)
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", args[0], err)
+			return
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading images: %v\n", err)
+		return
+	}
+	if dryRun {
+		printPlan(resp.Plan)
+		return
+	}
+	for _, c := range resp.Containers {
+		fmt.Printf("Loaded %s (%s)\n", c.ContainerName, c.ImageName)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
 	"github.com/fatih/color"
@@ -25,9 +26,13 @@ func (cli *CLI) runList(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "Error listing containers: %v\n", err)
 		return
 	}
+	certs := make(map[string]*pb.CertificateInfo, len(resp.Certificates))
+	for _, c := range resp.Certificates {
+		certs[strings.ToLower(c.Domain)] = c
+	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "ID", "Image", "Domain", "Port", "Status"})
+	table.SetHeader([]string{"Name", "ID", "Image", "Domain", "Certificate", "Ports", "Status"})
 	// ... (rest of the table setup)
 
 	for _, container := range resp.Containers {
@@ -42,9 +47,27 @@ func (cli *CLI) runList(cmd *cobra.Command, args []string) {
 			container.ContainerId[:12],
 			container.ImageName,
 			container.DomainName,
-			container.ContainerPort + ":" + container.HostPort,
+			listCertificate(certs, container.DomainName),
+			listPorts(container),
 			status,
 		})
 	}
 	table.Render()
+	warnExpiring(resp.Certificates)
+}
+
+// listPorts shows where each port of an instance is published
+func listPorts(c *pb.ContainerConfig) string {
+	if len(c.Ports) == 0 {
+		return c.ContainerPort + ":" + c.HostPort
+	}
+	var ports []string
+	for _, p := range c.Ports {
+		port := fmt.Sprintf("%s %d/%s", p.Name, p.ContainerPort, p.Protocol)
+		if p.HostPort != 0 {
+			port = fmt.Sprintf("%s %d->%d/%s", p.Name, p.HostPort, p.ContainerPort, p.Protocol)
+		}
+		ports = append(ports, port)
+	}
+	return strings.Join(ports, ", ")
 }
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newOpsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ops",
+		Short: "Show queued, running and finished operations on services",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List operations, newest first",
+		Run:   cli.runOpsList,
+	}
+	list.Flags().String("service", "", "Only show operations on this service")
+	list.Flags().String("status", "", "Only show operations with this status: queued, running, succeeded or failed")
+	list.Flags().Int("limit", 20, "Number of operations to show, 0 for all")
+
+	cmd.AddCommand(list, &cobra.Command{
+		Use:   "get <operation-id>",
+		Short: "Show the status and result of an operation",
+		Run:   cli.runOpsGet,
+	})
+
+	return cmd
+}
+
+func (cli *CLI) runOpsList(cmd *cobra.Command, args []string) {
+	limit, _ := cmd.Flags().GetInt("limit")
+	resp, err := cli.client.client.ListOperations(context.Background(), &pb.ListOperationsRequest{
+		ServiceName: cmd.Flag("service").Value.String(),
+		Status:      cmd.Flag("status").Value.String(),
+		Limit:       int32(limit),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing operations: %v\n", err)
+		return
+	}
+	if len(resp.Operations) == 0 {
+		fmt.Println("No operations found")
+		return
+	}
+	for _, op := range resp.Operations {
+		printOperation(op)
+	}
+}
+
+func (cli *CLI) runOpsGet(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: ops get <operation-id>")
+		return
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid operation ID %q\n", args[0])
+		return
+	}
+	op, err := cli.client.client.GetOperation(context.Background(), &pb.GetOperationRequest{Id: id})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting operation: %v\n", err)
+		return
+	}
+	printOperation(op)
+	if op.Result != "" {
+		fmt.Printf("  result: %s\n", op.Result)
+	}
+}
+
+func printOperation(op *pb.Operation) {
+	fmt.Printf("#%d %s %s %s (%s)", op.Id, time.Unix(op.CreatedAt, 0).Format(time.RFC3339), op.Kind, op.ServiceName, op.Status)
+	if op.StartedAt != 0 && op.FinishedAt != 0 {
+		fmt.Printf(" in %s", time.Duration(op.FinishedAt-op.StartedAt)*time.Second)
+	}
+	if op.Message != "" {
+		fmt.Printf(": %s", op.Message)
+	}
+	fmt.Println()
+}
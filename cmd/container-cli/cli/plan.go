@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"fmt"
+
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+)
+
+func printPlan(plan *pb.Plan) {
+	fmt.Println("Dry run, nothing was changed. Plan:")
+	for i, step := range plan.Steps {
+		fmt.Printf("  %d. %s %s", i+1, step.Action, step.Target)
+		if step.Detail != "" {
+			fmt.Printf(" (%s)", step.Detail)
+		}
+		fmt.Println()
+	}
+	if len(plan.RowsAdded) > 0 || len(plan.RowsDeleted) > 0 {
+		fmt.Println("Database:")
+		for _, row := range plan.RowsDeleted {
+			fmt.Printf("  - %s %s %s:%s\n", row.ContainerName, row.ImageName, row.HostPort, row.ContainerPort)
+		}
+		for _, row := range plan.RowsAdded {
+			fmt.Printf("  + %s %s %s:%s\n", row.ContainerName, row.ImageName, row.HostPort, row.ContainerPort)
+		}
+	}
+	if len(plan.RouteDiff) > 0 {
+		fmt.Println("Routes:")
+		for _, line := range plan.RouteDiff {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if plan.Error != "" {
+		fmt.Printf("The operation would fail: %s\n", plan.Error)
+	}
+}
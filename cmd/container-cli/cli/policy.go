@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/policy"
+	"github.com/dgunzy/go-container-orchestrator/pkg/docker"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newPolicyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Check services against an image admission policy",
+	}
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check every service in a manifest against a policy, exiting non-zero on violations",
+		RunE:  cli.runPolicyCheck,
+	}
+	checkCmd.Flags().StringP("file", "f", "", "Service manifest to check")
+	checkCmd.Flags().String("policy", "policy.yaml", "Image policy file")
+	checkCmd.Flags().String("environment", "", "Environment the services are deployed to")
+
+	cmd.AddCommand(checkCmd)
+	return cmd
+}
+
+func (cli *CLI) runPolicyCheck(cmd *cobra.Command, args []string) error {
+	manifest, err := container.LoadManifest(cmd.Flag("file").Value.String())
+	if err != nil {
+		return err
+	}
+	imagePolicy, err := policy.Load(cmd.Flag("policy").Value.String())
+	if err != nil {
+		return err
+	}
+
+	// Labels can only be checked for images present in a local docker daemon
+	dockerClient, dockerErr := docker.NewClient()
+	labels := func(image string) map[string]string {
+		if dockerErr != nil {
+			return nil
+		}
+		imageLabels, inspectErr := dockerClient.ImageLabels(context.Background(), image)
+		if inspectErr != nil {
+			fmt.Printf("Labels of %s not checked: %v\n", image, inspectErr)
+			return nil
+		}
+		return imageLabels
+	}
+
+	return printPolicyResults(manifest, manifest.CheckPolicy(imagePolicy, cmd.Flag("environment").Value.String(), labels))
+}
+
+func printPolicyResults(manifest *container.Manifest, results []error) error {
+	failed := 0
+	for i, err := range results {
+		name := manifest.Services[i].ContainerName
+		if err == nil {
+			fmt.Printf("%s %s\n", color.GreenString("PASS"), name)
+			continue
+		}
+		failed++
+		fmt.Printf("%s %s\n", color.RedString("FAIL"), name)
+		if violation, ok := err.(*policy.ViolationError); ok {
+			for _, v := range violation.Violations {
+				fmt.Printf("  - [%s] %s\n", v.Rule, v.Message)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services violate the image policy", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newPortsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ports",
+		Short: "List the host ports allocated to services",
+		Run:   cli.runPorts,
+	}
+}
+
+func (cli *CLI) runPorts(cmd *cobra.Command, args []string) {
+	resp, err := cli.client.client.ListPorts(context.Background(), &pb.ListPortsRequest{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing ports: %v\n", err)
+		return
+	}
+	fmt.Printf("Allocating from %v\n", resp.Ranges)
+	if len(resp.Leases) == 0 {
+		fmt.Println("No ports allocated")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Port", "Service", "Replica", "Name", "Container", "State", "Sticky"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+
+	for _, lease := range resp.Leases {
+		state := lease.State
+		if state == database.PortPending {
+			state += " until " + time.Unix(lease.ExpiresAt, 0).Format(time.RFC3339)
+		}
+		containerID := lease.ContainerId
+		if len(containerID) > 12 {
+			containerID = containerID[:12]
+		}
+		table.Append([]string{
+			strconv.Itoa(int(lease.Port)) + "/" + lease.Protocol,
+			lease.ServiceName,
+			strconv.Itoa(int(lease.Replica)),
+			lease.PortName,
+			containerID,
+			state,
+			strconv.FormatBool(lease.Sticky),
+		})
+	}
+	table.Render()
+}
+
+// portsFromFlags reads the ports given with --expose
+func portsFromFlags(cmd *cobra.Command) ([]*pb.ServicePort, error) {
+	values, _ := cmd.Flags().GetStringSlice("expose")
+	var ports []*pb.ServicePort
+	for _, value := range values {
+		p, err := container.ParsePortSpec(value)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, &pb.ServicePort{
+			Name:          p.Name,
+			Protocol:      p.Protocol,
+			ContainerPort: int32(p.ContainerPort),
+			HostPort:      int32(p.HostPort),
+			Role:          p.Role,
+			PublicPort:    int32(p.PublicPort),
+		})
+	}
+	return ports, nil
+}
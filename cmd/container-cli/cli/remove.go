@@ -17,6 +17,7 @@ func (cli *CLI) newRemoveCommand() *cobra.Command {
 	}
 
 	cmd.Flags().Bool("full", false, "Remove both the container and its image")
+	cmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
 
 	return cmd
 }
@@ -29,15 +30,21 @@ func (cli *CLI) runRemove(cmd *cobra.Command, args []string) {
 	}
 	containerName := args[0]
 	fullRemove, _ := cmd.Flags().GetBool("full")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 	resp, err := cli.client.client.RemoveContainer(context.Background(), &pb.RemoveContainerRequest{
 		ContainerName: containerName,
 		RemoveImage:   fullRemove,
+		DryRun:        dryRun,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error removing container: %v\n", err)
 		return
 	}
+	if dryRun {
+		printPlan(resp.Plan)
+		return
+	}
 	if resp.Success {
 		fmt.Printf("Successfully removed container '%s'", containerName)
 		if fullRemove {
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newRolloutCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollout",
+		Short: "Control updates in progress, see deploy status for their progress",
+	}
+
+	cmd.AddCommand(
+		cli.newRolloutActionCommand("pause", "Pause an update after the batch in progress"),
+		cli.newRolloutActionCommand("resume", "Resume a paused update, retrying the batch that failed"),
+		cli.newRolloutActionCommand("abort", "Abort an update and roll back to the previous version"),
+	)
+	return cmd
+}
+
+func (cli *CLI) newRolloutActionCommand(action, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " <service-name>",
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				fmt.Printf("Usage: rollout %s <service-name>\n", action)
+				return
+			}
+			status, err := cli.client.client.ControlRollout(context.Background(), &pb.ControlRolloutRequest{
+				ServiceName: args[0],
+				Action:      action,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error sending %s to rollout: %v\n", action, err)
+				return
+			}
+			printRolloutStatus(status)
+		},
+	}
+}
+
+func (cli *CLI) runDeployStatus(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: deploy status <service-name>")
+		return
+	}
+	status, err := cli.client.client.DeployStatus(context.Background(), &pb.DeployStatusRequest{ServiceName: args[0]})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting deploy status: %v\n", err)
+		return
+	}
+	printRolloutStatus(status)
+	for _, decision := range status.Decisions {
+		fmt.Printf("  %s\n", decision)
+	}
+}
+
+func printRolloutStatus(status *pb.RolloutStatus) {
+	fmt.Printf("%s -> %s (%s): %s, %d/%d instances updated", status.ServiceName, status.ImageName, status.Strategy, status.Phase, status.Updated, status.Total)
+	if status.CanaryWeight > 0 {
+		fmt.Printf(", %d%% of traffic on the canary", status.CanaryWeight)
+	}
+	if status.Message != "" {
+		fmt.Printf(" (%s)", status.Message)
+	}
+	fmt.Println()
+}
+
+// watchRollout prints the progress of an update started after since until done is closed
+func (cli *CLI) watchRollout(service string, since time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var last *pb.RolloutStatus
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		status, err := cli.client.client.DeployStatus(context.Background(), &pb.DeployStatusRequest{ServiceName: service})
+		if err != nil || status.StartedAt < since.Unix() {
+			continue
+		}
+		var seen int
+		if last != nil {
+			seen = len(last.Decisions)
+		}
+		for _, decision := range status.Decisions[min(seen, len(status.Decisions)):] {
+			fmt.Printf("  %s\n", decision)
+		}
+		if last == nil || status.Phase != last.Phase || status.Updated != last.Updated || status.Message != last.Message {
+			printRolloutStatus(status)
+		}
+		last = status
+	}
+}
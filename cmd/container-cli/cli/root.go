@@ -39,6 +39,16 @@ func (cli *CLI) initCommands() {
 		cli.newListCommand(),
 		cli.newRemoveCommand(),
 		cli.newUpdateCommand(),
+		cli.newDeployCommand(),
+		cli.newImageCommand(),
+		cli.newPolicyCommand(),
+		cli.newRolloutCommand(),
+		cli.newOpsCommand(),
+		cli.newStateCommand(),
+		cli.newAuditCommand(),
+		cli.newPortsCommand(),
+		cli.newCertCommand(),
+		cli.newHashPasswordCommand(),
 		// cli.newServeCommand(),
 	)
 }
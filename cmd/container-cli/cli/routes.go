@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// routesFromFlags reads the routes given with --route and --routes-file
+func routesFromFlags(cmd *cobra.Command) ([]*pb.Route, error) {
+	values, _ := cmd.Flags().GetStringSlice("route")
+	var specs []container.RouteSpec
+	for _, value := range values {
+		r, err := container.ParseRouteSpec(value)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, r)
+	}
+	if path, _ := cmd.Flags().GetString("routes-file"); path != "" {
+		fromFile, err := container.LoadRouteSpecs(path)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, fromFile...)
+	}
+	var routes []*pb.Route
+	for _, r := range specs {
+		routes = append(routes, routeToProto(r))
+	}
+	return routes, nil
+}
+
+func routeToProto(r container.RouteSpec) *pb.Route {
+	route := &pb.Route{
+		Host:            r.Host,
+		Path:            r.Path,
+		StripPrefix:     r.StripPrefix,
+		HttpsRedirect:   r.HTTPSRedirect,
+		RedirectToApex:  r.RedirectToApex,
+		RequestHeaders:  headerRulesToProto(r.RequestHeaders),
+		ResponseHeaders: headerRulesToProto(r.ResponseHeaders),
+	}
+	for _, redirect := range r.Redirects {
+		route.Redirects = append(route.Redirects, &pb.Redirect{From: redirect.From, To: redirect.To, Status: int32(redirect.Status)})
+	}
+	if r.HSTS != (routing.HSTS{}) {
+		route.Hsts = &pb.HSTS{MaxAge: int32(r.HSTS.MaxAge), IncludeSubdomains: r.HSTS.IncludeSubdomains, Preload: r.HSTS.Preload}
+	}
+	if r.CORS.Enabled() {
+		route.Cors = &pb.CORS{
+			AllowedOrigins:   r.CORS.AllowedOrigins,
+			AllowedMethods:   r.CORS.AllowedMethods,
+			AllowedHeaders:   r.CORS.AllowedHeaders,
+			ExposedHeaders:   r.CORS.ExposedHeaders,
+			AllowCredentials: r.CORS.AllowCredentials,
+			MaxAge:           int32(r.CORS.MaxAge),
+		}
+	}
+	if !r.Auth.IsZero() {
+		route.Auth = &pb.Auth{
+			Allow:     r.Auth.Allow,
+			Deny:      r.Auth.Deny,
+			BasicAuth: r.Auth.BasicAuth,
+			Realm:     r.Auth.Realm,
+		}
+		if forward := r.Auth.ForwardAuth; forward.Enabled() {
+			route.Auth.ForwardAuth = &pb.ForwardAuth{
+				Url:             forward.URL,
+				Service:         forward.Service,
+				Path:            forward.Path,
+				ResponseHeaders: forward.ResponseHeaders,
+			}
+		}
+	}
+	if !r.Limits.IsZero() {
+		route.Limits = &pb.Limits{
+			PerClient: &pb.RateLimit{Rate: r.Limits.PerClient.Rate, Burst: int32(r.Limits.PerClient.Burst)},
+			PerRoute:  &pb.RateLimit{Rate: r.Limits.PerRoute.Rate, Burst: int32(r.Limits.PerRoute.Burst)},
+			Status:    int32(r.Limits.Status),
+			Message:   r.Limits.Message,
+		}
+	}
+	return route
+}
+
+func headerRulesToProto(rules routing.HeaderRules) *pb.HeaderRules {
+	if rules.IsZero() {
+		return nil
+	}
+	return &pb.HeaderRules{Set: rules.Set, Add: rules.Add, Remove: rules.Remove}
+}
+
+func (cli *CLI) newHashPasswordCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "hash-password",
+		Short: "Hash a password read from stdin for the basic_auth users of a route",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("error reading password: %w", err)
+			}
+			password = strings.TrimRight(password, "\r\n")
+			if password == "" {
+				return errors.New("password is empty")
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				return fmt.Errorf("error hashing password: %w", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(hash))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) newStateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Export the state of the orchestrator or restore it on another host",
+	}
+
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Write the specs, deployment history, certificates and registry logins of all services to a document",
+		Run:   cli.runStateExport,
+	}
+	export.Flags().StringP("output", "o", "", "File to write, standard output by default")
+	export.Flags().String("format", "yaml", "Document format: yaml or json")
+
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Restore the services of an exported document that do not exist on this host",
+		Long: "Restore the services of an exported document that do not exist on this host.\n\n" +
+			"Certificates, ACME accounts and registry logins keep their keys and passwords encrypted with CERT_STORE_KEY, they are imported when this host has the same key. " +
+			"Registry logins are stored when an image is pulled with them and CERT_STORE_KEY is set, pass --registry-login for private registries the export has no login for.",
+		Run: cli.runStateImport,
+	}
+	importCmd.Flags().StringSlice("registry-login", nil, "Login for a private registry as host=username:password, such as docker.io=me:token, used to pull the images of its services instead of the login in the document (repeatable)")
+	importCmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+
+	cmd.AddCommand(export, importCmd)
+	return cmd
+}
+
+func (cli *CLI) runStateExport(cmd *cobra.Command, args []string) {
+	resp, err := cli.client.client.ExportState(context.Background(), &pb.ExportStateRequest{Format: cmd.Flag("format").Value.String()})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting state: %v\n", err)
+		return
+	}
+	output := cmd.Flag("output").Value.String()
+	if output == "" {
+		os.Stdout.Write(resp.Document)
+		return
+	}
+	if err := os.WriteFile(output, resp.Document, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", output, err)
+		return
+	}
+	fmt.Printf("Exported state to %s\n", output)
+}
+
+func (cli *CLI) runStateImport(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: state import <file>")
+		return
+	}
+	doc, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", args[0], err)
+		return
+	}
+	logins, _ := cmd.Flags().GetStringSlice("registry-login")
+	credentials := make(map[string]*pb.RegistryLogin)
+	for _, l := range logins {
+		host, login, err := container.ParseRegistryLogin(l)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading registry login: %v\n", err)
+			return
+		}
+		credentials[host] = &pb.RegistryLogin{Username: login.Username, Password: login.Password}
+	}
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	resp, err := cli.client.client.ImportState(context.Background(), &pb.ImportStateRequest{Document: doc, DryRun: dryRun, RegistryCredentials: credentials})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing state: %v\n", err)
+		return
+	}
+	if dryRun {
+		printPlan(resp.Plan)
+	}
+	for _, name := range resp.Restored {
+		fmt.Printf("restored %s\n", name)
+	}
+	for _, name := range resp.Skipped {
+		fmt.Printf("skipped %s: it already exists\n", name)
+	}
+	names := make([]string, 0, len(resp.Failed))
+	for name := range resp.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("failed %s: %s\n", name, resp.Failed[name])
+	}
+}
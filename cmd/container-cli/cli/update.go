@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
 	"github.com/spf13/cobra"
@@ -20,8 +21,31 @@ func (cli *CLI) newUpdateCommand() *cobra.Command {
 	cmd.Flags().String("image", "", "Image name for the container")
 	cmd.Flags().String("name", "", "Name for the container")
 	cmd.Flags().String("port", "", "Container port")
+	cmd.Flags().StringSlice("expose", nil, "Named port as name:container_port[/protocol][:role[:host_port[:public_port]]], role is http, grpc, tcp or none, tcp ports with a public port go through the proxy (repeatable)")
 	cmd.Flags().String("username", "", "Registry username")
 	cmd.Flags().String("password", "", "Registry password")
+	cmd.Flags().String("memory", "", "Memory limit, e.g. 512m")
+	cmd.Flags().String("cpus", "", "CPU limit, e.g. 0.5")
+	cmd.Flags().Int("replicas", 0, "Number of instances to run")
+	cmd.Flags().String("lb", "", "Load balancing strategy: round_robin or least_connections")
+	cmd.Flags().Bool("sticky-ports", false, "Keep the host port of each replica across updates and restarts")
+	cmd.Flags().String("pull", "", "Pull the image always (the default), only when missing or never; images built by deploy are never pulled")
+	cmd.Flags().String("publish", "", "Publish the port on every interface (public), on loopback only (loopback) or not at all, behind the proxy (private)")
+	cmd.Flags().StringSlice("network", nil, "Network the instances join, other services on it reach them by the service name (repeatable)")
+	cmd.Flags().StringSlice("route", nil, "Route as host[/path][:option,...] with the options strip, https and apex, such as example.com/api:strip, used instead of the domain; the longest matching path wins (repeatable)")
+	cmd.Flags().String("routes-file", "", "YAML file listing routes with their redirects, header rules, HSTS, CORS, auth and rate limits, added to --route")
+	cmd.Flags().Int("max-connections", 0, "Requests in flight each instance takes at most, the rest are answered like requests over a rate limit")
+	addHookFlags(cmd)
+	cmd.Flags().Bool("dry-run", false, "Show what would happen without changing anything")
+	cmd.Flags().Int("max-surge", 0, "Instances started above the replica count during the update")
+	cmd.Flags().Int("max-unavailable", 0, "Instances that may be down during the update")
+	cmd.Flags().String("readiness-path", "", "HTTP path that must answer before an instance receives traffic")
+	cmd.Flags().Duration("readiness-timeout", 0, "How long to wait for each new instance to become ready")
+	cmd.Flags().String("on-failure", "", "What to do when an instance is not ready: pause or abort")
+	cmd.Flags().String("strategy", "", "Update strategy: rolling or canary, canary needs the built-in proxy")
+	cmd.Flags().IntSlice("steps", nil, "Percentages of traffic shifted to the canary, e.g. 5,25,50,100")
+	cmd.Flags().Duration("interval", 0, "How long each canary step is watched")
+	cmd.Flags().Float64("max-error-rate", 0, "Share of canary requests that may fail with a 5xx, e.g. 0.05")
 
 	return cmd
 }
@@ -34,8 +58,47 @@ func (cli *CLI) runUpdate(cmd *cobra.Command, args []string) {
 		ContainerPort:    cmd.Flag("port").Value.String(),
 		RegistryUsername: cmd.Flag("username").Value.String(),
 		RegistryPassword: cmd.Flag("password").Value.String(),
+		MemoryLimit:      cmd.Flag("memory").Value.String(),
+		CpuLimit:         cmd.Flag("cpus").Value.String(),
+		LoadBalancer:     cmd.Flag("lb").Value.String(),
+		Publish:          cmd.Flag("publish").Value.String(),
+		PullPolicy:       cmd.Flag("pull").Value.String(),
 	}
+	replicas, _ := cmd.Flags().GetInt("replicas")
+	config.Replicas = int32(replicas)
+	maxConnections, _ := cmd.Flags().GetInt("max-connections")
+	config.MaxConnections = int32(maxConnections)
+	config.StickyPorts, _ = cmd.Flags().GetBool("sticky-ports")
+	config.Networks, _ = cmd.Flags().GetStringSlice("network")
+	ports, err := portsFromFlags(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading ports: %v\n", err)
+		return
+	}
+	config.Ports = ports
+	routes, err := routesFromFlags(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading routes: %v\n", err)
+		return
+	}
+	config.Routes = routes
+	config.PreDeployHooks, config.PostDeployHooks = hooksFromFlags(cmd)
+	config.Rollout = rolloutFromFlags(cmd)
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		resp, err := cli.client.client.UpdateContainer(context.Background(), &pb.UpdateContainerRequest{Config: config, DryRun: true})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error updating container: %v\n", err)
+			return
+		}
+		printPlan(resp.Plan)
+		return
+	}
+
+	done := make(chan struct{})
+	go cli.watchRollout(config.ContainerName, time.Now(), done)
 	resp, err := cli.client.client.UpdateContainer(context.Background(), &pb.UpdateContainerRequest{Config: config})
+	close(done)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error updating container: %v\n", err)
 		return
@@ -46,3 +109,26 @@ func (cli *CLI) runUpdate(cmd *cobra.Command, args []string) {
 		fmt.Println("Failed to update the container")
 	}
 }
+
+func rolloutFromFlags(cmd *cobra.Command) *pb.RolloutConfig {
+	maxSurge, _ := cmd.Flags().GetInt("max-surge")
+	maxUnavailable, _ := cmd.Flags().GetInt("max-unavailable")
+	readinessTimeout, _ := cmd.Flags().GetDuration("readiness-timeout")
+	steps, _ := cmd.Flags().GetIntSlice("steps")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	maxErrorRate, _ := cmd.Flags().GetFloat64("max-error-rate")
+	rollout := &pb.RolloutConfig{
+		MaxSurge:                int32(maxSurge),
+		MaxUnavailable:          int32(maxUnavailable),
+		ReadinessPath:           cmd.Flag("readiness-path").Value.String(),
+		ReadinessTimeoutSeconds: int32(readinessTimeout.Seconds()),
+		OnFailure:               cmd.Flag("on-failure").Value.String(),
+		Strategy:                cmd.Flag("strategy").Value.String(),
+		IntervalSeconds:         int32(interval.Seconds()),
+		MaxErrorRate:            maxErrorRate,
+	}
+	for _, step := range steps {
+		rollout.Steps = append(rollout.Steps, int32(step))
+	}
+	return rollout
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// actorHeader is the metadata a client names its user with. The API has no authentication
+// yet, so it is what the client claims; the peer address is recorded as the source of the
+// change next to it.
+const actorHeader = "x-actor"
+
+func requestActor(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(actorHeader); len(values) > 0 && values[0] != "" {
+			return "grpc:" + values[0]
+		}
+	}
+	if source := requestSource(ctx); source != "" {
+		return "grpc:" + source
+	}
+	return "grpc"
+}
+
+// requestSource is the peer address of a request, which the client cannot choose
+func requestSource(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func auditContext(ctx context.Context) context.Context {
+	return container.WithSource(container.WithActor(ctx, requestActor(ctx)), requestSource(ctx))
+}
+
+// auditUnaryInterceptor sets the actor and source that the changes made by a request are
+// audited as
+func auditUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(auditContext(ctx), req)
+}
+
+func auditStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &actorStream{ServerStream: ss, ctx: auditContext(ss.Context())})
+}
+
+type actorStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *actorStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *server) ListAuditLog(ctx context.Context, req *pb.ListAuditLogRequest) (*pb.ListAuditLogResponse, error) {
+	filter := database.AuditFilter{ServiceName: req.ServiceName, Actor: req.Actor, Limit: int(req.Limit)}
+	if req.Since != 0 {
+		filter.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until != 0 {
+		filter.Until = time.Unix(req.Until, 0)
+	}
+	entries, err := s.cm.Db.ListAuditLog(filter)
+	if err != nil {
+		s.cm.Logger.Error("Error listing audit log: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &pb.ListAuditLogResponse{}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, &pb.AuditEntry{
+			Id:          e.ID,
+			Time:        e.Time.Unix(),
+			Actor:       e.Actor,
+			Source:      e.Source,
+			Action:      e.Action,
+			ServiceName: e.ServiceName,
+			ParamsJson:  e.Params,
+			Result:      e.Result,
+			Error:       e.Error,
+			DurationMs:  e.Duration.Milliseconds(),
+			OperationId: e.OperationID,
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/pkg/docker"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// buildOutputWriter forwards build output to the client as it is produced
+type buildOutputWriter struct {
+	stream pb.ContainerService_BuildAndDeployServer
+}
+
+func (w *buildOutputWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&pb.BuildAndDeployResponse{Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *server) BuildAndDeploy(stream pb.ContainerService_BuildAndDeployServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	metadata := req.GetMetadata()
+	if metadata == nil || metadata.Config == nil {
+		return status.Error(codes.InvalidArgument, "first message must contain the build metadata")
+	}
+
+	// Spool the build context to disk so a slow build never holds up the upload
+	buildContext, err := os.CreateTemp("", "build-context-*.tar")
+	if err != nil {
+		s.cm.Logger.Error("Error creating build context file: %v", err)
+		return err
+	}
+	defer os.Remove(buildContext.Name())
+	defer buildContext.Close()
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := buildContext.Write(req.GetContextChunk()); err != nil {
+			s.cm.Logger.Error("Error writing build context: %v", err)
+			return err
+		}
+	}
+	if _, err := buildContext.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	options := metadata.GetOptions()
+	buildOptions := docker.BuildOptions{
+		Dockerfile: options.GetDockerfile(),
+		BuildArgs:  options.GetBuildArgs(),
+		Target:     options.GetTarget(),
+		Labels:     options.GetLabels(),
+	}
+
+	config := configFromProto(metadata.Config)
+	if metadata.DryRun {
+		plan, err := s.cm.DryRun(stream.Context(), func(dry *container.ContainerManager) error {
+			return dry.BuildAndDeploy(stream.Context(), buildContext, buildOptions, options.GetVersion(), config, io.Discard)
+		})
+		if plan == nil {
+			return statusError(err)
+		}
+		return stream.Send(&pb.BuildAndDeployResponse{ImageName: config.ImageName, Plan: planToProto(plan, err)})
+	}
+	err = s.cm.BuildAndDeploy(stream.Context(), buildContext, buildOptions, options.GetVersion(), config, &buildOutputWriter{stream: stream})
+	if err != nil {
+		s.cm.Logger.Error("Error building and deploying container: %v", err)
+		return statusError(err)
+	}
+
+	return stream.Send(&pb.BuildAndDeployResponse{ImageName: config.ImageName, ContainerId: config.ContainerID})
+}
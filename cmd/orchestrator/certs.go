@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/certs"
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) UploadCertificate(ctx context.Context, req *pb.UploadCertificateRequest) (*pb.CertificateInfo, error) {
+	cert, err := s.cm.UploadCertificate(ctx, req.Domain, req.CertPem, req.KeyPem)
+	if err != nil {
+		s.cm.Logger.Error("Error uploading certificate: %v", err)
+		return nil, certificateError(err)
+	}
+	return certificateToProto(cert, time.Now()), nil
+}
+
+func (s *server) CreateSelfSignedCertificate(ctx context.Context, req *pb.CreateSelfSignedCertificateRequest) (*pb.CertificateInfo, error) {
+	if req.ValidDays < 0 {
+		return nil, status.Error(codes.InvalidArgument, "valid_days must not be negative")
+	}
+	cert, err := s.cm.SelfSignedCertificate(ctx, req.Domain, time.Duration(req.ValidDays)*24*time.Hour)
+	if err != nil {
+		s.cm.Logger.Error("Error creating self-signed certificate: %v", err)
+		return nil, certificateError(err)
+	}
+	return certificateToProto(cert, time.Now()), nil
+}
+
+func (s *server) ListCertificates(ctx context.Context, req *pb.ListCertificatesRequest) (*pb.ListCertificatesResponse, error) {
+	stored, err := s.cm.ListCertificates()
+	if err != nil {
+		s.cm.Logger.Error("Error listing certificates: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.ListCertificatesResponse{
+		Certificates: certificatesToProto(stored),
+		WarnDays:     int32(container.CertWarnBefore().Hours() / 24),
+	}, nil
+}
+
+func certificateError(err error) error {
+	if errors.Is(err, container.ErrCertificatesDisabled) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+func certificateToProto(cert *certs.Certificate, now time.Time) *pb.CertificateInfo {
+	return &pb.CertificateInfo{
+		Domain:    cert.Domain,
+		Source:    cert.Source,
+		NotAfter:  cert.NotAfter.Unix(),
+		UpdatedAt: now.Unix(),
+		Expiring:  container.CertificateExpiring(cert.NotAfter, now),
+	}
+}
+
+func certificatesToProto(stored []database.Certificate) []*pb.CertificateInfo {
+	now := time.Now()
+	var result []*pb.CertificateInfo
+	for _, c := range stored {
+		result = append(result, &pb.CertificateInfo{
+			Domain:    c.Domain,
+			Source:    c.Source,
+			NotAfter:  c.NotAfter.Unix(),
+			UpdatedAt: c.UpdatedAt.Unix(),
+			Expiring:  container.CertificateExpiring(c.NotAfter, now),
+		})
+	}
+	return result
+}
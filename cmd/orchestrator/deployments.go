@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) ListDeployments(ctx context.Context, req *pb.ListDeploymentsRequest) (*pb.ListDeploymentsResponse, error) {
+	deployments, err := s.cm.Db.ListDeployments(req.ServiceName, int(req.Limit))
+	if err != nil {
+		s.cm.Logger.Error("Error listing deployments: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &pb.ListDeploymentsResponse{}
+	for _, d := range deployments {
+		resp.Deployments = append(resp.Deployments, deploymentToProto(d))
+	}
+	return resp, nil
+}
+
+func deploymentToProto(d database.Deployment) *pb.Deployment {
+	deployment := &pb.Deployment{
+		Id:          d.ID,
+		ServiceName: d.ServiceName,
+		ImageName:   d.ImageName,
+		Strategy:    d.Strategy,
+		Status:      d.Status,
+		Message:     d.Message,
+		StartedAt:   d.StartedAt.Unix(),
+	}
+	if !d.FinishedAt.IsZero() {
+		deployment.FinishedAt = d.FinishedAt.Unix()
+	}
+	for _, h := range d.Hooks {
+		deployment.Hooks = append(deployment.Hooks, &pb.HookRun{
+			Phase:      h.Phase,
+			Command:    h.Command,
+			ExitCode:   int32(h.ExitCode),
+			Output:     h.Output,
+			Error:      h.Error,
+			StartedAt:  h.StartedAt.Unix(),
+			DurationMs: h.Duration.Milliseconds(),
+		})
+	}
+	return deployment
+}
+
+func hooksFromProto(hooks []*pb.Hook) []container.Hook {
+	var result []container.Hook
+	for _, h := range hooks {
+		result = append(result, container.Hook{
+			Command: h.GetCommand(),
+			Timeout: time.Duration(h.GetTimeoutSeconds()) * time.Second,
+			RunIn:   h.GetRunIn(),
+		})
+	}
+	return result
+}
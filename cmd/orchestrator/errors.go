@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/policy"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusError maps manager errors onto gRPC status codes, other errors are returned unchanged
+func statusError(err error) error {
+	var violation *policy.ViolationError
+	if errors.As(err, &violation) {
+		st := status.New(codes.FailedPrecondition, violation.Error())
+		failure := &errdetails.PreconditionFailure{}
+		for _, v := range violation.Violations {
+			failure.Violations = append(failure.Violations, &errdetails.PreconditionFailure_Violation{
+				Type:        v.Rule,
+				Subject:     violation.Image,
+				Description: v.Message,
+			})
+		}
+		if detailed, err := st.WithDetails(failure); err == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const imageBundleChunkSize = 64 * 1024
+
+// bundleChunkWriter sends an image bundle to the client in chunks
+type bundleChunkWriter struct {
+	stream pb.ContainerService_SaveImagesServer
+}
+
+func (w *bundleChunkWriter) Write(p []byte) (int, error) {
+	for sent := 0; sent < len(p); sent += imageBundleChunkSize {
+		end := min(sent+imageBundleChunkSize, len(p))
+		if err := w.stream.Send(&pb.SaveImagesResponse{Chunk: p[sent:end]}); err != nil {
+			return sent, err
+		}
+	}
+	return len(p), nil
+}
+
+func (s *server) SaveImages(req *pb.SaveImagesRequest, stream pb.ContainerService_SaveImagesServer) error {
+	if len(req.ContainerNames) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one container name is required")
+	}
+	if err := s.cm.ExportServices(stream.Context(), req.ContainerNames, &bundleChunkWriter{stream: stream}); err != nil {
+		s.cm.Logger.Error("Error saving images: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *server) LoadImages(stream pb.ContainerService_LoadImagesServer) error {
+	bundle, err := os.CreateTemp("", "image-bundle-*.tar")
+	if err != nil {
+		s.cm.Logger.Error("Error creating image bundle file: %v", err)
+		return err
+	}
+	defer os.Remove(bundle.Name())
+	defer bundle.Close()
+
+	dryRun := false
+	for first := true; ; first = false {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			dryRun = req.DryRun
+		}
+		if _, err := bundle.Write(req.Chunk); err != nil {
+			s.cm.Logger.Error("Error writing image bundle: %v", err)
+			return err
+		}
+	}
+	if _, err := bundle.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if dryRun {
+		plan, err := s.cm.DryRun(stream.Context(), func(dry *container.ContainerManager) error {
+			_, err := dry.ImportServices(stream.Context(), bundle, io.Discard)
+			return err
+		})
+		if plan == nil {
+			return statusError(err)
+		}
+		return stream.SendAndClose(&pb.LoadImagesResponse{Plan: planToProto(plan, err)})
+	}
+
+	services, err := s.cm.ImportServices(stream.Context(), bundle, io.Discard)
+	if err != nil {
+		s.cm.Logger.Error("Error loading images: %v", err)
+		return statusError(err)
+	}
+
+	var containers []*pb.ContainerConfig
+	for _, c := range services {
+		containers = append(containers, &pb.ContainerConfig{
+			DomainName:    c.DomainName,
+			ImageName:     c.ImageName,
+			ContainerName: c.ContainerName,
+			ContainerId:   c.ContainerID,
+			ContainerPort: c.ContainerPort,
+		})
+	}
+	return stream.SendAndClose(&pb.LoadImagesResponse{Containers: containers})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"net"
+	"time"
 
 	"github.com/dgunzy/go-container-orchestrator/internal/container"
 	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
@@ -16,22 +17,27 @@ type server struct {
 }
 
 func (s *server) CreateContainer(ctx context.Context, req *pb.CreateContainerRequest) (*pb.CreateContainerResponse, error) {
-	config := &container.ContainerConfig{
-		DomainName:       req.Config.DomainName,
-		ImageName:        req.Config.ImageName,
-		ContainerName:    req.Config.ContainerName,
-		ContainerPort:    req.Config.ContainerPort,
-		RegistryUsername: req.Config.RegistryUsername,
-		RegistryPassword: req.Config.RegistryPassword,
+	config := configFromProto(req.Config)
+	if req.DryRun {
+		plan, err := s.cm.DryRun(ctx, func(dry *container.ContainerManager) error {
+			return dry.CreateNewContainer(ctx, config)
+		})
+		if plan == nil {
+			return nil, err
+		}
+		return &pb.CreateContainerResponse{Plan: planToProto(plan, err)}, nil
 	}
 
-	err := s.cm.CreateNewContainer(ctx, config)
+	id, err := s.cm.RunOperation(ctx, config.ContainerName, "create", config, func(ctx context.Context) (string, error) {
+		err := s.cm.CreateNewContainer(ctx, config)
+		return config.ContainerID, err
+	})
 	if err != nil {
 		s.cm.Logger.Error("Error creating container: %v", err)
-		return nil, err
+		return nil, statusError(err)
 	}
 
-	return &pb.CreateContainerResponse{ContainerId: config.ContainerID}, nil
+	return &pb.CreateContainerResponse{ContainerId: config.ContainerID, OperationId: id}, nil
 }
 
 func (s *server) ListContainers(ctx context.Context, req *pb.ListContainersRequest) (*pb.ListContainersResponse, error) {
@@ -51,39 +57,104 @@ func (s *server) ListContainers(ctx context.Context, req *pb.ListContainersReque
 			ContainerPort: c.ContainerPort,
 			HostPort:      c.HostPort,
 			Status:        c.Status,
+			Ports:         portsToProto(c.Ports),
 		})
 	}
 
-	return &pb.ListContainersResponse{Containers: pbContainers}, nil
+	certificates, err := s.cm.ListCertificates()
+	if err != nil {
+		s.cm.Logger.Error("Error listing certificates: %v", err)
+		return nil, err
+	}
+	return &pb.ListContainersResponse{Containers: pbContainers, Certificates: certificatesToProto(certificates)}, nil
 }
 
 func (s *server) UpdateContainer(ctx context.Context, req *pb.UpdateContainerRequest) (*pb.UpdateContainerResponse, error) {
-	config := &container.ContainerConfig{
-		DomainName:       req.Config.DomainName,
-		ImageName:        req.Config.ImageName,
-		ContainerName:    req.Config.ContainerName,
-		ContainerPort:    req.Config.ContainerPort,
-		RegistryUsername: req.Config.RegistryUsername,
-		RegistryPassword: req.Config.RegistryPassword,
+	config := configFromProto(req.Config)
+	if req.DryRun {
+		plan, err := s.cm.DryRun(ctx, func(dry *container.ContainerManager) error {
+			return dry.UpdateExistingContainer(ctx, config)
+		})
+		if plan == nil {
+			return nil, err
+		}
+		return &pb.UpdateContainerResponse{Success: err == nil, Plan: planToProto(plan, err)}, nil
 	}
 
-	err := s.cm.UpdateExistingContainer(ctx, config)
+	id, err := s.cm.RunOperation(ctx, s.cm.ServiceName(config), "update", config, func(ctx context.Context) (string, error) {
+		err := s.cm.UpdateExistingContainer(ctx, config)
+		return config.ContainerID, err
+	})
 	if err != nil {
 		s.cm.Logger.Error("Error creating container: %v", err)
-		return nil, err
+		return nil, statusError(err)
 	}
 
-	return &pb.UpdateContainerResponse{Success: true}, nil
+	return &pb.UpdateContainerResponse{Success: true, OperationId: id}, nil
 }
 
 func (s *server) RemoveContainer(ctx context.Context, req *pb.RemoveContainerRequest) (*pb.RemoveContainerResponse, error) {
-	err := s.cm.RemoveContainer(ctx, req.ContainerName)
+	if req.DryRun {
+		plan, err := s.cm.DryRun(ctx, func(dry *container.ContainerManager) error {
+			return dry.RemoveService(ctx, req.ContainerName, req.RemoveImage)
+		})
+		if plan == nil {
+			return nil, err
+		}
+		return &pb.RemoveContainerResponse{Success: err == nil, Plan: planToProto(plan, err)}, nil
+	}
+
+	service := s.cm.ServiceName(&container.ContainerConfig{ContainerName: req.ContainerName})
+	id, err := s.cm.RunOperation(ctx, service, "remove", container.RemoveParams(req.ContainerName, req.RemoveImage), func(ctx context.Context) (string, error) {
+		return "", s.cm.RemoveService(ctx, req.ContainerName, req.RemoveImage)
+	})
 	if err != nil {
-		s.cm.Logger.Error("Error creating container: %v", err)
-		return nil, err
+		s.cm.Logger.Error("Error removing container: %v", err)
+		return nil, statusError(err)
 	}
 
-	return &pb.RemoveContainerResponse{Success: true}, nil
+	return &pb.RemoveContainerResponse{Success: true, OperationId: id}, nil
+}
+
+func configFromProto(c *pb.ContainerConfig) *container.ContainerConfig {
+	var steps []int
+	for _, step := range c.GetRollout().GetSteps() {
+		steps = append(steps, int(step))
+	}
+	return &container.ContainerConfig{
+		DomainName:       c.GetDomainName(),
+		ImageName:        c.GetImageName(),
+		ContainerName:    c.GetContainerName(),
+		ContainerPort:    c.GetContainerPort(),
+		RegistryUsername: c.GetRegistryUsername(),
+		RegistryPassword: c.GetRegistryPassword(),
+		MemoryLimit:      c.GetMemoryLimit(),
+		CPULimit:         c.GetCpuLimit(),
+		Replicas:         int(c.GetReplicas()),
+		LoadBalancer:     c.GetLoadBalancer(),
+		StickyPorts:      c.GetStickyPorts(),
+		Publish:          c.GetPublish(),
+		PullPolicy:       c.GetPullPolicy(),
+		Networks:         c.GetNetworks(),
+		Ports:            portsFromProto(c.GetPorts()),
+		Routes:           routesFromProto(c.GetRoutes()),
+		MaxConnections:   int(c.GetMaxConnections()),
+		Rollout: container.RolloutConfig{
+			MaxSurge:         int(c.GetRollout().GetMaxSurge()),
+			MaxUnavailable:   int(c.GetRollout().GetMaxUnavailable()),
+			ReadinessPath:    c.GetRollout().GetReadinessPath(),
+			ReadinessTimeout: time.Duration(c.GetRollout().GetReadinessTimeoutSeconds()) * time.Second,
+			OnFailure:        c.GetRollout().GetOnFailure(),
+			Strategy:         c.GetRollout().GetStrategy(),
+			Steps:            steps,
+			Interval:         time.Duration(c.GetRollout().GetIntervalSeconds()) * time.Second,
+			MaxErrorRate:     c.GetRollout().GetMaxErrorRate(),
+		},
+		Hooks: container.Hooks{
+			PreDeploy:  hooksFromProto(c.GetPreDeployHooks()),
+			PostDeploy: hooksFromProto(c.GetPostDeployHooks()),
+		},
+	}
 }
 
 func main() {
@@ -92,12 +163,19 @@ func main() {
 		log.Fatalf("Failed to create ContainerManager: %v", err)
 	}
 
+	// The daemon runs the health checker and the built-in proxy
+	go func() {
+		if err := cm.RunAsDaemon(context.Background()); err != nil {
+			log.Printf("ContainerManager daemon stopped: %v", err)
+		}
+	}()
+
 	lis, err := net.Listen("tcp", ":50051")
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.UnaryInterceptor(auditUnaryInterceptor), grpc.StreamInterceptor(auditStreamInterceptor))
 	pb.RegisterContainerServiceServer(s, &server{cm: cm})
 
 	log.Printf("Server listening at %v", lis.Addr())
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) GetOperation(ctx context.Context, req *pb.GetOperationRequest) (*pb.Operation, error) {
+	op, err := s.cm.Db.GetOperation(req.Id)
+	if errors.Is(err, database.ErrOperationNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		s.cm.Logger.Error("Error getting operation: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return operationToProto(*op), nil
+}
+
+func (s *server) ListOperations(ctx context.Context, req *pb.ListOperationsRequest) (*pb.ListOperationsResponse, error) {
+	ops, err := s.cm.Db.ListOperations(req.ServiceName, req.Status, int(req.Limit))
+	if err != nil {
+		s.cm.Logger.Error("Error listing operations: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &pb.ListOperationsResponse{}
+	for _, op := range ops {
+		resp.Operations = append(resp.Operations, operationToProto(op))
+	}
+	return resp, nil
+}
+
+func operationToProto(op database.Operation) *pb.Operation {
+	operation := &pb.Operation{
+		Id:          op.ID,
+		ServiceName: op.ServiceName,
+		Kind:        op.Kind,
+		Status:      op.Status,
+		Message:     op.Message,
+		Result:      op.Result,
+		CreatedAt:   op.CreatedAt.Unix(),
+	}
+	if !op.StartedAt.IsZero() {
+		operation.StartedAt = op.StartedAt.Unix()
+	}
+	if !op.FinishedAt.IsZero() {
+		operation.FinishedAt = op.FinishedAt.Unix()
+	}
+	return operation
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+)
+
+// planToProto reports a dry run, an operation that would fail still returns its plan up
+// to the failure along with the reason
+func planToProto(plan *container.Plan, err error) *pb.Plan {
+	p := &pb.Plan{RouteDiff: plan.RouteDiff}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	for _, step := range plan.Steps {
+		p.Steps = append(p.Steps, &pb.PlanStep{Action: step.Action, Target: step.Target, Detail: step.Detail})
+	}
+	for _, row := range plan.RowsAdded {
+		p.RowsAdded = append(p.RowsAdded, rowToProto(row))
+	}
+	for _, row := range plan.RowsDeleted {
+		p.RowsDeleted = append(p.RowsDeleted, rowToProto(row))
+	}
+	return p
+}
+
+func rowToProto(c database.ContainerInfo) *pb.ContainerConfig {
+	return &pb.ContainerConfig{
+		DomainName:    c.DomainName,
+		ImageName:     c.ImageName,
+		ContainerName: c.ContainerName,
+		ContainerId:   c.ContainerID,
+		ContainerPort: c.ContainerPort,
+		HostPort:      c.HostPort,
+		Status:        c.Status,
+	}
+}
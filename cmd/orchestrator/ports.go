@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) ListPorts(ctx context.Context, req *pb.ListPortsRequest) (*pb.ListPortsResponse, error) {
+	leases, err := s.cm.Db.ListPortLeases()
+	if err != nil {
+		s.cm.Logger.Error("Error listing ports: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &pb.ListPortsResponse{}
+	for _, r := range s.cm.PortRanges() {
+		resp.Ranges = append(resp.Ranges, r.String())
+	}
+	for _, lease := range leases {
+		l := &pb.PortLease{
+			Port:        int32(lease.Port),
+			Protocol:    lease.Protocol,
+			ServiceName: lease.ServiceName,
+			Replica:     int32(lease.Replica),
+			PortName:    lease.PortName,
+			ContainerId: lease.ContainerID,
+			Sticky:      lease.Sticky,
+			State:       lease.State(),
+			LeasedAt:    lease.LeasedAt.Unix(),
+		}
+		if !lease.ExpiresAt.IsZero() {
+			l.ExpiresAt = lease.ExpiresAt.Unix()
+		}
+		resp.Leases = append(resp.Leases, l)
+	}
+	return resp, nil
+}
+
+func portsFromProto(ports []*pb.ServicePort) []container.PortSpec {
+	var result []container.PortSpec
+	for _, p := range ports {
+		result = append(result, container.PortSpec{
+			Name:          p.GetName(),
+			Protocol:      p.GetProtocol(),
+			ContainerPort: int(p.GetContainerPort()),
+			HostPort:      int(p.GetHostPort()),
+			Role:          p.GetRole(),
+			PublicPort:    int(p.GetPublicPort()),
+		})
+	}
+	return result
+}
+
+func portsToProto(ports []container.PortSpec) []*pb.ServicePort {
+	var result []*pb.ServicePort
+	for _, p := range ports {
+		result = append(result, &pb.ServicePort{
+			Name:          p.Name,
+			Protocol:      p.Protocol,
+			ContainerPort: int32(p.ContainerPort),
+			HostPort:      int32(p.HostPort),
+			Role:          p.Role,
+			PublicPort:    int32(p.PublicPort),
+		})
+	}
+	return result
+}
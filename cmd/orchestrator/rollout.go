@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) DeployStatus(ctx context.Context, req *pb.DeployStatusRequest) (*pb.RolloutStatus, error) {
+	rollout, ok := s.cm.GetRolloutStatus(req.ServiceName)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no update of %s since the orchestrator started", req.ServiceName)
+	}
+	return rolloutToProto(rollout), nil
+}
+
+func (s *server) ControlRollout(ctx context.Context, req *pb.ControlRolloutRequest) (*pb.RolloutStatus, error) {
+	var err error
+	switch req.Action {
+	case "pause":
+		err = s.cm.PauseRollout(ctx, req.ServiceName)
+	case "resume":
+		err = s.cm.ResumeRollout(ctx, req.ServiceName)
+	case "abort":
+		err = s.cm.AbortRollout(ctx, req.ServiceName)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown rollout action %q", req.Action)
+	}
+	if err != nil {
+		s.cm.Logger.Error("Error controlling rollout: %v", err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return s.DeployStatus(ctx, &pb.DeployStatusRequest{ServiceName: req.ServiceName})
+}
+
+func rolloutToProto(r container.RolloutStatus) *pb.RolloutStatus {
+	return &pb.RolloutStatus{
+		ServiceName:  r.Service,
+		ImageName:    r.Image,
+		Phase:        string(r.Phase),
+		Updated:      int32(r.Updated),
+		Total:        int32(r.Total),
+		Message:      r.Message,
+		StartedAt:    r.StartedAt.Unix(),
+		UpdatedAt:    r.UpdatedAt.Unix(),
+		Strategy:     r.Strategy,
+		CanaryWeight: int32(r.CanaryWeight),
+		Decisions:    r.Decisions,
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+)
+
+func routesFromProto(routes []*pb.Route) []container.RouteSpec {
+	var result []container.RouteSpec
+	for _, r := range routes {
+		spec := container.RouteSpec{
+			Host:        r.GetHost(),
+			Path:        r.GetPath(),
+			StripPrefix: r.GetStripPrefix(),
+		}
+		spec.HTTPSRedirect = r.GetHttpsRedirect()
+		spec.RedirectToApex = r.GetRedirectToApex()
+		for _, redirect := range r.GetRedirects() {
+			spec.Redirects = append(spec.Redirects, routing.Redirect{From: redirect.GetFrom(), To: redirect.GetTo(), Status: int(redirect.GetStatus())})
+		}
+		spec.RequestHeaders = headerRulesFromProto(r.GetRequestHeaders())
+		spec.ResponseHeaders = headerRulesFromProto(r.GetResponseHeaders())
+		if hsts := r.GetHsts(); hsts != nil {
+			spec.HSTS = routing.HSTS{MaxAge: int(hsts.GetMaxAge()), IncludeSubdomains: hsts.GetIncludeSubdomains(), Preload: hsts.GetPreload()}
+		}
+		if cors := r.GetCors(); cors != nil {
+			spec.CORS = routing.CORS{
+				AllowedOrigins:   cors.GetAllowedOrigins(),
+				AllowedMethods:   cors.GetAllowedMethods(),
+				AllowedHeaders:   cors.GetAllowedHeaders(),
+				ExposedHeaders:   cors.GetExposedHeaders(),
+				AllowCredentials: cors.GetAllowCredentials(),
+				MaxAge:           int(cors.GetMaxAge()),
+			}
+		}
+		if auth := r.GetAuth(); auth != nil {
+			spec.Auth = routing.Auth{
+				Allow:     auth.GetAllow(),
+				Deny:      auth.GetDeny(),
+				BasicAuth: auth.GetBasicAuth(),
+				Realm:     auth.GetRealm(),
+			}
+			if forward := auth.GetForwardAuth(); forward != nil {
+				spec.Auth.ForwardAuth = routing.ForwardAuth{
+					URL:             forward.GetUrl(),
+					Service:         forward.GetService(),
+					Path:            forward.GetPath(),
+					ResponseHeaders: forward.GetResponseHeaders(),
+				}
+			}
+		}
+		if limits := r.GetLimits(); limits != nil {
+			spec.Limits = routing.Limits{
+				PerClient: rateLimitFromProto(limits.GetPerClient()),
+				PerRoute:  rateLimitFromProto(limits.GetPerRoute()),
+				Status:    int(limits.GetStatus()),
+				Message:   limits.GetMessage(),
+			}
+		}
+		result = append(result, spec)
+	}
+	return result
+}
+
+func rateLimitFromProto(limit *pb.RateLimit) routing.RateLimit {
+	return routing.RateLimit{Rate: limit.GetRate(), Burst: int(limit.GetBurst())}
+}
+
+func headerRulesFromProto(rules *pb.HeaderRules) routing.HeaderRules {
+	if rules == nil {
+		return routing.HeaderRules{}
+	}
+	return routing.HeaderRules{Set: rules.GetSet(), Add: rules.GetAdd(), Remove: rules.GetRemove()}
+}
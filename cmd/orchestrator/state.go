@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	pb "github.com/dgunzy/go-container-orchestrator/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) ExportState(ctx context.Context, req *pb.ExportStateRequest) (*pb.ExportStateResponse, error) {
+	state, err := s.cm.ExportState()
+	if err != nil {
+		s.cm.Logger.Error("Error exporting state: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var doc bytes.Buffer
+	if err := container.WriteState(&doc, state, req.Format); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.ExportStateResponse{Document: doc.Bytes()}, nil
+}
+
+func (s *server) ImportState(ctx context.Context, req *pb.ImportStateRequest) (*pb.ImportStateResponse, error) {
+	state, err := container.ReadState(bytes.NewReader(req.Document))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	credentials := make(container.RegistryCredentials)
+	for host, login := range req.RegistryCredentials {
+		credentials[host] = container.RegistryLogin{Username: login.GetUsername(), Password: login.GetPassword()}
+	}
+	if req.DryRun {
+		var result *container.StateImport
+		plan, err := s.cm.DryRun(ctx, func(dry *container.ContainerManager) error {
+			var err error
+			result, err = dry.ImportState(ctx, state, credentials)
+			return err
+		})
+		if plan == nil {
+			return nil, statusError(err)
+		}
+		resp := &pb.ImportStateResponse{}
+		if result != nil {
+			resp = stateImportToProto(result)
+		}
+		resp.Plan = planToProto(plan, err)
+		return resp, nil
+	}
+
+	result, err := s.cm.ImportState(ctx, state, credentials)
+	if err != nil {
+		s.cm.Logger.Error("Error importing state: %v", err)
+		return nil, statusError(err)
+	}
+	return stateImportToProto(result), nil
+}
+
+func stateImportToProto(result *container.StateImport) *pb.ImportStateResponse {
+	resp := &pb.ImportStateResponse{Restored: result.Restored, Skipped: result.Skipped, Failed: make(map[string]string)}
+	for name, err := range result.Failed {
+		resp.Failed[name] = err.Error()
+	}
+	return resp
+}
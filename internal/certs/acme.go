@@ -0,0 +1,263 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+type Logger interface {
+	Info(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// The challenges a domain can be validated with. HTTP-01 is answered on port 80,
+// TLS-ALPN-01 during the TLS handshake on port 443, only the built-in proxy answers it.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// LetsEncrypt is the directory of the Let's Encrypt production CA
+const LetsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+
+const defaultRenewBefore = 30 * 24 * time.Hour
+
+type IssuerConfig struct {
+	// DirectoryURL is the ACME directory of the CA, Let's Encrypt when empty
+	DirectoryURL string
+	// Email is the contact of the account registered with the CA
+	Email string
+	// Challenge is http-01, the default, or tls-alpn-01
+	Challenge string
+	// RenewBefore is how long before they expire certificates are renewed, 30 days when zero
+	RenewBefore time.Duration
+	// HTTPClient talks to the CA, the default client when nil
+	HTTPClient *http.Client
+}
+
+// Issuer requests certificates from an ACME CA and keeps them in a Store
+type Issuer struct {
+	config     IssuerConfig
+	store      *Store
+	challenges *Challenges
+	logger     Logger
+
+	// mu serializes orders, the account is registered by the first one
+	mu     sync.Mutex
+	client *acme.Client
+}
+
+func NewIssuer(config IssuerConfig, store *Store, challenges *Challenges, logger Logger) (*Issuer, error) {
+	if config.DirectoryURL == "" {
+		config.DirectoryURL = LetsEncrypt
+	}
+	switch config.Challenge {
+	case "":
+		config.Challenge = ChallengeHTTP01
+	case ChallengeHTTP01, ChallengeTLSALPN01:
+	default:
+		return nil, fmt.Errorf("unknown ACME challenge %q, use http-01 or tls-alpn-01", config.Challenge)
+	}
+	if config.RenewBefore <= 0 {
+		config.RenewBefore = defaultRenewBefore
+	}
+	return &Issuer{config: config, store: store, challenges: challenges, logger: logger}, nil
+}
+
+// Challenge is the challenge type domains are validated with
+func (i *Issuer) Challenge() string {
+	return i.config.Challenge
+}
+
+// Due reports whether a certificate should be renewed
+func (i *Issuer) Due(cert *Certificate) bool {
+	return time.Until(cert.NotAfter) < i.config.RenewBefore
+}
+
+// Issue orders a certificate for a domain, answers its challenge and stores the result
+func (i *Issuer) Issue(ctx context.Context, domain string) (*Certificate, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	client, err := i.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i.logger.Info("Requesting certificate for %s from %s", domain, i.config.DirectoryURL)
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, fmt.Errorf("error creating order for %s: %w", domain, err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := i.authorize(ctx, client, url); err != nil {
+			return nil, fmt.Errorf("error validating %s: %w", domain, err)
+		}
+	}
+	if _, err := client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("error waiting for order of %s: %w", domain, err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating certificate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{domain}}, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating certificate request: %w", err)
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("error finalizing order of %s: %w", domain, err)
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, encodePEM("CERTIFICATE", der)...)
+	}
+	keyPEM, err := marshalKey(key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := i.store.Save(domain, SourceACME, certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	i.logger.Info("Issued certificate for %s, valid until %s", domain, cert.NotAfter.Format(time.RFC3339))
+	return cert, nil
+}
+
+// authorize answers the configured challenge of an authorization and waits for the CA to
+// validate it
+func (i *Issuer) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == i.config.Challenge {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offers no %s challenge", i.config.Challenge)
+	}
+
+	var cleanup func()
+	switch chal.Type {
+	case ChallengeHTTP01:
+		keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		cleanup = i.challenges.setHTTP(chal.Token, keyAuth)
+	case ChallengeTLSALPN01:
+		cert, err := client.TLSALPN01ChallengeCert(chal.Token, authz.Identifier.Value)
+		if err != nil {
+			return err
+		}
+		cleanup = i.challenges.setTLSALPN(authz.Identifier.Value, &cert)
+	}
+	defer cleanup()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// account returns a client for the account of the CA, registering it on first use. The
+// account key is kept in the store so renewals use the same account.
+func (i *Issuer) account(ctx context.Context) (*acme.Client, error) {
+	if i.client != nil {
+		return i.client, nil
+	}
+	keyPEM, err := i.store.accountKey(i.config.DirectoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("error loading ACME account: %w", err)
+	}
+	var key crypto.Signer
+	if keyPEM != nil {
+		if key, err = parseKey(keyPEM); err != nil {
+			return nil, fmt.Errorf("error loading ACME account: %w", err)
+		}
+	} else {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generating ACME account key: %w", err)
+		}
+		if keyPEM, err = marshalKey(ecKey); err != nil {
+			return nil, err
+		}
+		key = ecKey
+	}
+
+	client := &acme.Client{Key: key, DirectoryURL: i.config.DirectoryURL, HTTPClient: i.config.HTTPClient, UserAgent: "go-container-orchestrator"}
+	var contact []string
+	if i.config.Email != "" {
+		contact = []string{"mailto:" + i.config.Email}
+	}
+	account, err := client.Register(ctx, &acme.Account{Contact: contact}, acme.AcceptTOS)
+	var uri string
+	switch {
+	case errors.Is(err, acme.ErrAccountAlreadyExists):
+		uri = string(client.KID)
+	case err != nil:
+		return nil, fmt.Errorf("error registering ACME account: %w", err)
+	default:
+		uri = account.URI
+		i.logger.Info("Registered ACME account %s", uri)
+	}
+	if err := i.store.saveAccount(i.config.DirectoryURL, uri, keyPEM); err != nil {
+		return nil, fmt.Errorf("error saving ACME account: %w", err)
+	}
+	i.client = client
+	return client, nil
+}
+
+func marshalKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding key: %w", err)
+	}
+	return encodePEM("EC PRIVATE KEY", der), nil
+}
+
+// parseKey reads a PEM private key in any of the formats x509 knows
+func parseKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM key found")
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("key cannot sign")
+	}
+	return signer, nil
+}
@@ -0,0 +1,575 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/certs"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+)
+
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "orchestrator-certs-test-*")
+	if err != nil {
+		fmt.Printf("Failed to create log directory: %v\n", err)
+		os.Exit(1)
+	}
+	if err := logging.Setup(logDir); err != nil {
+		fmt.Printf("Failed to set up logging: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+type testLogger struct{}
+
+func (testLogger) Info(format string, args ...interface{})  {}
+func (testLogger) Error(format string, args ...interface{}) {}
+
+func openStore(t *testing.T) (database.Store, *certs.Store) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "certs.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.InitSchema())
+	store, err := certs.NewStore(db, newStoreKey(t))
+	require.NoError(t, err)
+	return db, store
+}
+
+func newStoreKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+// selfSigned returns a certificate for domain and its key, PEM encoded
+func selfSigned(t *testing.T, domain string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestParseKey(t *testing.T) {
+	key := newStoreKey(t)
+	parsed, err := certs.ParseKey(base64.StdEncoding.EncodeToString(key) + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	_, err = certs.ParseKey(base64.StdEncoding.EncodeToString(key[:16]))
+	assert.ErrorContains(t, err, "must be 32")
+	_, err = certs.ParseKey("not base64!")
+	assert.Error(t, err)
+}
+
+func TestStore(t *testing.T) {
+	db, store := openStore(t)
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	certPEM, keyPEM := selfSigned(t, "app.example.com", notAfter)
+
+	saved, err := store.Save("app.example.com", certs.SourceACME, certPEM, keyPEM)
+	require.NoError(t, err)
+	assert.True(t, saved.NotAfter.Equal(notAfter))
+
+	loaded, err := store.Load("app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, keyPEM, loaded.KeyPEM)
+	assert.Equal(t, certPEM, loaded.CertPEM)
+	assert.Equal(t, certs.SourceACME, loaded.Source)
+	assert.True(t, loaded.NotAfter.Equal(notAfter))
+
+	stored, err := db.GetCertificate("app.example.com")
+	require.NoError(t, err)
+	assert.NotContains(t, stored.KeyData, "PRIVATE KEY", "Keys are stored encrypted")
+
+	listed, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, keyPEM, listed[0].KeyPEM)
+
+	other, err := certs.NewStore(db, newStoreKey(t))
+	require.NoError(t, err)
+	_, err = other.Load("app.example.com")
+	assert.ErrorContains(t, err, "decrypting", "Another key cannot open the certificate")
+
+	_, otherKey := selfSigned(t, "app.example.com", notAfter)
+	_, err = store.Save("app.example.com", certs.SourceACME, certPEM, otherKey)
+	assert.ErrorContains(t, err, "invalid certificate or key")
+	_, err = store.Save("api.example.com", certs.SourceACME, certPEM, keyPEM)
+	assert.ErrorContains(t, err, "does not cover api.example.com")
+
+	_, err = store.Load("missing.example.com")
+	assert.ErrorIs(t, err, database.ErrCertificateNotFound)
+}
+
+func TestRestore(t *testing.T) {
+	db, store := openStore(t)
+	certPEM, keyPEM := selfSigned(t, "app.example.com", time.Now().Add(24*time.Hour))
+	_, err := store.Save("app.example.com", certs.SourceManual, certPEM, keyPEM)
+	require.NoError(t, err)
+	exported, err := db.GetCertificate("app.example.com")
+	require.NoError(t, err)
+
+	key := newStoreKey(t)
+	target, _ := openStore(t)
+	other, err := certs.NewStore(target, key)
+	require.NoError(t, err)
+	assert.ErrorContains(t, other.Restore(*exported), "decrypting", "A certificate is only restored where its key opens")
+	_, err = target.GetCertificate("app.example.com")
+	assert.ErrorIs(t, err, database.ErrCertificateNotFound)
+	assert.Error(t, other.RestoreAccount(database.ACMEAccount{DirectoryURL: "https://ca.example.com/directory", KeyData: exported.KeyData}))
+
+	require.NoError(t, store.Restore(*exported), "The store that encrypted a key opens it")
+	loaded, err := store.Load("app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, keyPEM, loaded.KeyPEM)
+}
+
+func TestRegistryLogin(t *testing.T) {
+	db, store := openStore(t)
+	_, _, err := store.RegistryLogin("registry.example.com")
+	assert.ErrorIs(t, err, database.ErrRegistryLoginNotFound)
+
+	require.NoError(t, store.SaveRegistryLogin("registry.example.com", "deploy", "s3cret"))
+	username, password, err := store.RegistryLogin("registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", username)
+	assert.Equal(t, "s3cret", password)
+
+	stored, err := db.GetRegistryLogin("registry.example.com")
+	require.NoError(t, err)
+	assert.NotContains(t, stored.PasswordData, "s3cret", "Passwords are stored encrypted")
+	stored.Host = "other.example.com"
+	assert.Error(t, store.RestoreRegistryLogin(*stored), "A password copied to another registry does not open")
+
+	other, err := certs.NewStore(db, newStoreKey(t))
+	require.NoError(t, err)
+	_, _, err = other.RegistryLogin("registry.example.com")
+	assert.ErrorContains(t, err, "decrypting", "Another key cannot open the password")
+}
+
+func TestSelfSigned(t *testing.T) {
+	_, store := openStore(t)
+
+	cert, err := store.SelfSigned("db.internal", 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, certs.SourceSelfSigned, cert.Source)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), cert.NotAfter, time.Minute)
+
+	pair, err := tls.X509KeyPair(cert.CertPEM, cert.KeyPEM)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	assert.NoError(t, leaf.VerifyHostname("db.internal"))
+	assert.Contains(t, leaf.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+
+	loaded, err := store.Load("db.internal")
+	require.NoError(t, err)
+	assert.Equal(t, cert.KeyPEM, loaded.KeyPEM)
+
+	cert, err = store.SelfSigned("10.0.0.5", 0)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(certs.DefaultSelfSignedValidity), cert.NotAfter, time.Minute)
+}
+
+// fakeCA is an ACME CA in the spirit of Pebble. It does not check request signatures,
+// it validates challenges synchronously with validate and signs certificates with its root.
+type fakeCA struct {
+	t        *testing.T
+	server   *httptest.Server
+	root     *x509.Certificate
+	rootKey  *ecdsa.PrivateKey
+	validity time.Duration
+	validate func(kind, token, keyAuth, domain string) error
+
+	mu       sync.Mutex
+	nonce    int
+	accounts map[string]string
+	orders   []*fakeOrder
+}
+
+type fakeOrder struct {
+	domain  string
+	token   string
+	status  string
+	authz   string
+	certPEM []byte
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	root, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &fakeCA{t: t, root: root, rootKey: key, validity: 90 * 24 * time.Hour, accounts: make(map[string]string)}
+	ca.server = httptest.NewServer(http.HandlerFunc(ca.serve))
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *fakeCA) directory() string {
+	return ca.server.URL + "/directory"
+}
+
+func (ca *fakeCA) serve(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.nonce))
+
+	if r.URL.Path == "/directory" {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   ca.server.URL + "/nonce",
+			"newAccount": ca.server.URL + "/account",
+			"newOrder":   ca.server.URL + "/order",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	var jws struct{ Protected, Payload string }
+	require.NoError(ca.t, json.NewDecoder(r.Body).Decode(&jws))
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	require.NoError(ca.t, err)
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	require.NoError(ca.t, err)
+
+	var id int
+	switch {
+	case r.URL.Path == "/account":
+		var header struct{ JWK map[string]string }
+		require.NoError(ca.t, json.Unmarshal(protected, &header))
+		thumbprint := jwkThumbprint(header.JWK)
+		status := http.StatusOK
+		if _, ok := ca.accounts[thumbprint]; !ok {
+			ca.accounts[thumbprint] = fmt.Sprintf("%s/account/%d", ca.server.URL, len(ca.accounts)+1)
+			status = http.StatusCreated
+		}
+		w.Header().Set("Location", ca.accounts[thumbprint])
+		writeJSON(w, status, map[string]string{"status": "valid"})
+	case r.URL.Path == "/order":
+		var req struct {
+			Identifiers []struct{ Type, Value string }
+		}
+		require.NoError(ca.t, json.Unmarshal(payload, &req))
+		require.Len(ca.t, req.Identifiers, 1)
+		order := &fakeOrder{domain: req.Identifiers[0].Value, token: fmt.Sprintf("token-%d", len(ca.orders)), status: acme.StatusPending}
+		ca.orders = append(ca.orders, order)
+		id = len(ca.orders) - 1
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", ca.server.URL, id))
+		writeJSON(w, http.StatusCreated, ca.orderJSON(id))
+	case scan(r.URL.Path, "/order/%d", &id):
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", ca.server.URL, id))
+		writeJSON(w, http.StatusOK, ca.orderJSON(id))
+	case scan(r.URL.Path, "/authz/%d", &id):
+		writeJSON(w, http.StatusOK, ca.authzJSON(id))
+	case strings.HasPrefix(r.URL.Path, "/chal/"):
+		var kind string
+		_, err := fmt.Sscanf(strings.Replace(r.URL.Path, "/", " ", -1), " chal %d %s", &id, &kind)
+		require.NoError(ca.t, err)
+		order := ca.orders[id]
+		keyAuth := order.token + "." + ca.thumbprint(protected)
+		order.authz = acme.StatusValid
+		if err := ca.validate(kind, order.token, keyAuth, order.domain); err != nil {
+			order.authz, order.status = acme.StatusInvalid, acme.StatusInvalid
+		} else {
+			order.status = acme.StatusReady
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"type": kind, "url": ca.server.URL + r.URL.Path, "token": order.token, "status": order.authz})
+	case scan(r.URL.Path, "/finalize/%d", &id):
+		var req struct{ CSR string }
+		require.NoError(ca.t, json.Unmarshal(payload, &req))
+		ca.issue(id, req.CSR)
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", ca.server.URL, id))
+		writeJSON(w, http.StatusOK, ca.orderJSON(id))
+	case scan(r.URL.Path, "/cert/%d", &id):
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.orders[id].certPEM)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func scan(path, format string, id *int) bool {
+	_, err := fmt.Sscanf(path, format, id)
+	return err == nil
+}
+
+func (ca *fakeCA) orderJSON(id int) map[string]any {
+	order := ca.orders[id]
+	result := map[string]any{
+		"status":         order.status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": order.domain}},
+		"authorizations": []string{fmt.Sprintf("%s/authz/%d", ca.server.URL, id)},
+		"finalize":       fmt.Sprintf("%s/finalize/%d", ca.server.URL, id),
+	}
+	if order.certPEM != nil {
+		result["certificate"] = fmt.Sprintf("%s/cert/%d", ca.server.URL, id)
+	}
+	return result
+}
+
+func (ca *fakeCA) authzJSON(id int) map[string]any {
+	order := ca.orders[id]
+	status := order.authz
+	if status == "" {
+		status = acme.StatusPending
+	}
+	var challenges []map[string]string
+	for _, kind := range []string{certs.ChallengeHTTP01, certs.ChallengeTLSALPN01} {
+		challenges = append(challenges, map[string]string{
+			"type":   kind,
+			"url":    fmt.Sprintf("%s/chal/%d/%s", ca.server.URL, id, kind),
+			"token":  order.token,
+			"status": status,
+		})
+	}
+	return map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": order.domain},
+		"challenges": challenges,
+	}
+}
+
+// thumbprint finds the account of a request signed with its key ID
+func (ca *fakeCA) thumbprint(protected []byte) string {
+	var header struct{ KID string }
+	require.NoError(ca.t, json.Unmarshal(protected, &header))
+	for thumbprint, kid := range ca.accounts {
+		if kid == header.KID {
+			return thumbprint
+		}
+	}
+	ca.t.Fatalf("unknown account %q", header.KID)
+	return ""
+}
+
+func (ca *fakeCA) issue(id int, encodedCSR string) {
+	order := ca.orders[id]
+	der, err := base64.RawURLEncoding.DecodeString(encodedCSR)
+	require.NoError(ca.t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(ca.t, err)
+	require.Equal(ca.t, []string{order.domain}, csr.DNSNames)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(id + 2)),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(ca.validity),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, ca.root, csr.PublicKey, ca.rootKey)
+	require.NoError(ca.t, err)
+	order.certPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw})...)
+	order.status = acme.StatusValid
+}
+
+// jwkThumbprint is the RFC 7638 thumbprint of an EC key
+func jwkThumbprint(jwk map[string]string) string {
+	canonical := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// fetchHTTPChallenge validates an HTTP-01 challenge against a server answering them
+func fetchHTTPChallenge(server *httptest.Server) func(kind, token, keyAuth, domain string) error {
+	return func(kind, token, keyAuth, domain string) error {
+		if kind != certs.ChallengeHTTP01 {
+			return fmt.Errorf("unexpected challenge %s", kind)
+		}
+		resp, err := http.Get(server.URL + certs.ChallengePath + token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != keyAuth {
+			return fmt.Errorf("got key authorization %q", body)
+		}
+		return nil
+	}
+}
+
+func TestIssueHTTP01(t *testing.T) {
+	ca := newFakeCA(t)
+	_, store := openStore(t)
+	challenges := certs.NewChallenges()
+	challengeServer := httptest.NewServer(challenges)
+	defer challengeServer.Close()
+	ca.validate = fetchHTTPChallenge(challengeServer)
+
+	issuer, err := certs.NewIssuer(certs.IssuerConfig{DirectoryURL: ca.directory(), Email: "ops@example.com"}, store, challenges, testLogger{})
+	require.NoError(t, err)
+	assert.Equal(t, certs.ChallengeHTTP01, issuer.Challenge(), "HTTP-01 is the default challenge")
+
+	cert, err := issuer.Issue(context.Background(), "app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, certs.SourceACME, cert.Source)
+	assert.False(t, issuer.Due(cert), "A fresh certificate is not due for renewal")
+
+	loaded, err := store.Load("app.example.com")
+	require.NoError(t, err)
+	pair, err := tls.X509KeyPair(loaded.CertPEM, loaded.KeyPEM)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.root)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "app.example.com", Roots: roots})
+	assert.NoError(t, err, "The stored certificate is the one the CA signed")
+	_, ok := challenges.HTTPChallenge("token-0")
+	assert.False(t, ok, "Challenges are removed once validated")
+
+	// A certificate that expires within RenewBefore is due, renewing it reuses the account
+	ca.validity = 10 * 24 * time.Hour
+	renewed, err := certs.NewIssuer(certs.IssuerConfig{DirectoryURL: ca.directory()}, store, challenges, testLogger{})
+	require.NoError(t, err)
+	short, err := renewed.Issue(context.Background(), "app.example.com")
+	require.NoError(t, err)
+	assert.True(t, renewed.Due(short), "Certificates expiring within 30 days are due")
+	assert.Len(t, ca.accounts, 1, "The account key is kept in the store")
+}
+
+func TestIssueTLSALPN01(t *testing.T) {
+	ca := newFakeCA(t)
+	_, store := openStore(t)
+	challenges := certs.NewChallenges()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		NextProtos: []string{acme.ALPNProto},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, ok := challenges.TLSALPNChallenge(hello.ServerName); ok {
+				return cert, nil
+			}
+			return nil, errors.New("no challenge")
+		},
+	})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	// acmeIdentifier is the extension carrying the digest of the key authorization
+	acmeIdentifier := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+	ca.validate = func(kind, token, keyAuth, domain string) error {
+		if kind != certs.ChallengeTLSALPN01 {
+			return fmt.Errorf("unexpected challenge %s", kind)
+		}
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", listener.Addr().String(), &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{acme.ALPNProto},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != acme.ALPNProto {
+			return fmt.Errorf("negotiated %q", state.NegotiatedProtocol)
+		}
+		digest := sha256.Sum256([]byte(keyAuth))
+		want, _ := asn1.Marshal(digest[:])
+		for _, ext := range state.PeerCertificates[0].Extensions {
+			if ext.Id.Equal(acmeIdentifier) && ext.Critical && string(ext.Value) == string(want) {
+				return nil
+			}
+		}
+		return errors.New("no acmeIdentifier for the key authorization")
+	}
+
+	issuer, err := certs.NewIssuer(certs.IssuerConfig{DirectoryURL: ca.directory(), Challenge: certs.ChallengeTLSALPN01}, store, challenges, testLogger{})
+	require.NoError(t, err)
+	_, err = issuer.Issue(context.Background(), "tls.example.com")
+	require.NoError(t, err)
+	_, err = store.Load("tls.example.com")
+	assert.NoError(t, err)
+	_, ok := challenges.TLSALPNChallenge("tls.example.com")
+	assert.False(t, ok, "Challenges are removed once validated")
+}
+
+func TestIssueFailedValidation(t *testing.T) {
+	ca := newFakeCA(t)
+	_, store := openStore(t)
+	ca.validate = func(kind, token, keyAuth, domain string) error { return errors.New("connection refused") }
+
+	issuer, err := certs.NewIssuer(certs.IssuerConfig{DirectoryURL: ca.directory()}, store, certs.NewChallenges(), testLogger{})
+	require.NoError(t, err)
+	_, err = issuer.Issue(context.Background(), "down.example.com")
+	assert.ErrorContains(t, err, "error validating down.example.com")
+	_, err = store.Load("down.example.com")
+	assert.ErrorIs(t, err, database.ErrCertificateNotFound, "Nothing is stored for a failed order")
+
+	_, err = certs.NewIssuer(certs.IssuerConfig{Challenge: "dns-01"}, store, certs.NewChallenges(), testLogger{})
+	assert.ErrorContains(t, err, "unknown ACME challenge")
+}
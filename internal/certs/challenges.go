@@ -0,0 +1,81 @@
+package certs
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ChallengePath is where the CA fetches the answer to an HTTP-01 challenge
+const ChallengePath = "/.well-known/acme-challenge/"
+
+// Challenges holds the answers to the ACME challenges of certificates being issued. The
+// built-in proxy answers them itself, other routers forward the challenge path to it.
+type Challenges struct {
+	mu sync.RWMutex
+	// http maps HTTP-01 tokens to their key authorization
+	http map[string]string
+	// alpn maps domains to the certificate answering their TLS-ALPN-01 challenge
+	alpn map[string]*tls.Certificate
+}
+
+func NewChallenges() *Challenges {
+	return &Challenges{http: make(map[string]string), alpn: make(map[string]*tls.Certificate)}
+}
+
+// HTTPChallenge returns the key authorization for an HTTP-01 token
+func (c *Challenges) HTTPChallenge(token string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keyAuth, ok := c.http[token]
+	return keyAuth, ok
+}
+
+// TLSALPNChallenge returns the certificate answering a TLS-ALPN-01 challenge for a domain
+func (c *Challenges) TLSALPNChallenge(domain string) (*tls.Certificate, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cert, ok := c.alpn[strings.ToLower(domain)]
+	return cert, ok
+}
+
+func (c *Challenges) setHTTP(token, keyAuth string) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.http[token] = keyAuth
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.http, token)
+	}
+}
+
+func (c *Challenges) setTLSALPN(domain string, cert *tls.Certificate) func() {
+	domain = strings.ToLower(domain)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alpn[domain] = cert
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.alpn, domain)
+	}
+}
+
+// ServeHTTP answers HTTP-01 challenges
+func (c *Challenges) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, ChallengePath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	keyAuth, ok := c.HTTPChallenge(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, keyAuth)
+}
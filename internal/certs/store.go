@@ -0,0 +1,252 @@
+package certs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+)
+
+// Where a certificate came from
+const (
+	SourceACME       = "acme"
+	SourceManual     = "manual"
+	SourceSelfSigned = "self-signed"
+)
+
+// Certificate is the certificate chain and private key of a domain, PEM encoded
+type Certificate struct {
+	Domain   string
+	Source   string
+	CertPEM  []byte
+	KeyPEM   []byte
+	NotAfter time.Time
+}
+
+// Store keeps certificates, ACME account keys and registry logins in the database with
+// their private keys and passwords encrypted by AES-256-GCM
+type Store struct {
+	db   database.Store
+	aead cipher.AEAD
+}
+
+// keyVersion prefixes encrypted keys so the scheme can change later
+const keyVersion = "v1:"
+
+// ParseKey decodes the key of a store, 32 bytes encoded in base64
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding certificate store key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("certificate store key is %d bytes, it must be 32", len(key))
+	}
+	return key, nil
+}
+
+func NewStore(db database.Store, key []byte) (*Store, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating certificate store cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating certificate store cipher: %w", err)
+	}
+	return &Store{db: db, aead: aead}, nil
+}
+
+// encrypt seals a private key. The name it belongs to is authenticated along with it, so
+// a key copied to another row does not decrypt.
+func (s *Store) encrypt(name string, plain []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, plain, []byte(name))
+	return keyVersion + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Store) decrypt(name, data string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(data, keyVersion)
+	if !ok {
+		return nil, errors.New("unknown key encryption")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("malformed encrypted key")
+	}
+	nonce, sealed := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, []byte(name))
+	if err != nil {
+		return nil, errors.New("error decrypting key, was CERT_STORE_KEY changed?")
+	}
+	return plain, nil
+}
+
+// Save checks that the key matches the certificate and stores both for the domain
+func (s *Store) Save(domain, source string, certPEM, keyPEM []byte) (*Certificate, error) {
+	leaf, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := leaf.VerifyHostname(domain); err != nil {
+		return nil, fmt.Errorf("certificate does not cover %s: %w", domain, err)
+	}
+	keyData, err := s.encrypt(domain, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.SaveCertificate(database.Certificate{
+		Domain:   domain,
+		Source:   source,
+		CertPEM:  string(certPEM),
+		KeyData:  keyData,
+		NotAfter: leaf.NotAfter,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error saving certificate: %w", err)
+	}
+	return &Certificate{Domain: domain, Source: source, CertPEM: certPEM, KeyPEM: keyPEM, NotAfter: leaf.NotAfter}, nil
+}
+
+// Load returns the certificate of a domain, database.ErrCertificateNotFound when there is none
+func (s *Store) Load(domain string) (*Certificate, error) {
+	stored, err := s.db.GetCertificate(domain)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(*stored)
+}
+
+// List returns the certificates of all domains
+func (s *Store) List() ([]Certificate, error) {
+	stored, err := s.db.ListCertificates()
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]Certificate, 0, len(stored))
+	for _, c := range stored {
+		cert, err := s.open(c)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, *cert)
+	}
+	return certs, nil
+}
+
+func (s *Store) open(stored database.Certificate) (*Certificate, error) {
+	keyPEM, err := s.decrypt(stored.Domain, stored.KeyData)
+	if err != nil {
+		return nil, fmt.Errorf("error opening certificate of %s: %w", stored.Domain, err)
+	}
+	return &Certificate{
+		Domain:   stored.Domain,
+		Source:   stored.Source,
+		CertPEM:  []byte(stored.CertPEM),
+		KeyPEM:   keyPEM,
+		NotAfter: stored.NotAfter,
+	}, nil
+}
+
+// Restore stores a certificate exported from another host as it is, after checking that
+// its key opens with the key of this store
+func (s *Store) Restore(stored database.Certificate) error {
+	if _, err := s.open(stored); err != nil {
+		return err
+	}
+	return s.db.SaveCertificate(stored)
+}
+
+// RestoreAccount stores an ACME account exported from another host like Restore
+func (s *Store) RestoreAccount(account database.ACMEAccount) error {
+	if _, err := s.decrypt(account.DirectoryURL, account.KeyData); err != nil {
+		return fmt.Errorf("error opening acme account of %s: %w", account.DirectoryURL, err)
+	}
+	return s.db.SaveACMEAccount(account)
+}
+
+// accountKey returns the stored key of the ACME account at a directory, nil if there is none
+func (s *Store) accountKey(directoryURL string) ([]byte, error) {
+	account, err := s.db.GetACMEAccount(directoryURL)
+	if errors.Is(err, database.ErrACMEAccountNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(directoryURL, account.KeyData)
+}
+
+func (s *Store) saveAccount(directoryURL, uri string, keyPEM []byte) error {
+	keyData, err := s.encrypt(directoryURL, keyPEM)
+	if err != nil {
+		return err
+	}
+	return s.db.SaveACMEAccount(database.ACMEAccount{DirectoryURL: directoryURL, KeyData: keyData, URI: uri})
+}
+
+// registryName is what the password of a registry is authenticated with, apart from the
+// domain names of certificates
+func registryName(host string) string {
+	return "registry:" + host
+}
+
+// SaveRegistryLogin stores the login of a registry, replacing the one it had
+func (s *Store) SaveRegistryLogin(host, username, password string) error {
+	passwordData, err := s.encrypt(registryName(host), []byte(password))
+	if err != nil {
+		return err
+	}
+	return s.db.SaveRegistryLogin(database.RegistryLogin{Host: host, Username: username, PasswordData: passwordData})
+}
+
+// RegistryLogin returns the stored login of a registry, database.ErrRegistryLoginNotFound
+// when there is none
+func (s *Store) RegistryLogin(host string) (username, password string, err error) {
+	login, err := s.db.GetRegistryLogin(host)
+	if err != nil {
+		return "", "", err
+	}
+	plain, err := s.decrypt(registryName(host), login.PasswordData)
+	if err != nil {
+		return "", "", fmt.Errorf("error opening registry login of %s: %w", host, err)
+	}
+	return login.Username, string(plain), nil
+}
+
+// RestoreRegistryLogin stores a registry login exported from another host like Restore
+func (s *Store) RestoreRegistryLogin(login database.RegistryLogin) error {
+	if _, err := s.decrypt(registryName(login.Host), login.PasswordData); err != nil {
+		return fmt.Errorf("error opening registry login of %s: %w", login.Host, err)
+	}
+	return s.db.SaveRegistryLogin(login)
+}
+
+// parseKeyPair checks that a PEM certificate chain and key belong together and returns the leaf
+func parseKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate or key: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	return leaf, nil
+}
+
+func encodePEM(kind string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+}
@@ -0,0 +1,283 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// systemActor is recorded for changes nobody asked for, such as automatic rollbacks
+const systemActor = "system"
+
+type actorKey struct{}
+
+// WithActor returns a context whose changes are recorded in the audit log as made by actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return systemActor
+}
+
+type sourceKey struct{}
+
+// WithSource returns a context whose changes are recorded as coming from source, the peer
+// address of the request that asked for them
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func sourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// LocalActor names the user running the cli on this host
+func LocalActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli:" + os.Getenv("USER")
+}
+
+// audit records a change in the audit log. Failing to record it is logged, it does not
+// fail the change that already happened.
+func (cm *ContainerManager) audit(ctx context.Context, action, service string, params any, started time.Time, operationID int64, err error) {
+	if cm.plan != nil {
+		return
+	}
+	entry := database.AuditEntry{
+		Time:        started,
+		Actor:       actorFrom(ctx),
+		Source:      sourceFrom(ctx),
+		Action:      action,
+		ServiceName: service,
+		Params:      auditParams(params),
+		Result:      database.AuditSucceeded,
+		Duration:    time.Since(started),
+		OperationID: operationID,
+	}
+	if err != nil {
+		entry.Result, entry.Error = database.AuditFailed, err.Error()
+	}
+	if _, err := cm.Db.AddAuditEntry(entry); err != nil {
+		cm.Logger.Error("Error writing audit log: %s", err)
+	}
+}
+
+type auditedKey struct{}
+
+// auditedScope marks ctx as carrying out an audited change, operationID is the operation
+// it belongs to or zero
+type auditedScope struct {
+	operationID int64
+}
+
+func withAudited(ctx context.Context, operationID int64) context.Context {
+	return context.WithValue(ctx, auditedKey{}, auditedScope{operationID: operationID})
+}
+
+// beginAudit audits a change made through an entry point of the manager, such as a create
+// or a remove, the returned func records it with the error it ended with. Entry points
+// called while carrying out another audited change, such as the create of a deploy or the
+// remove of a remove with the image, are part of that change and not recorded again.
+func (cm *ContainerManager) beginAudit(ctx context.Context, action, service string, params any) (context.Context, func(error)) {
+	if _, ok := ctx.Value(auditedKey{}).(auditedScope); ok {
+		return ctx, func(error) {}
+	}
+	started := time.Now()
+	return withAudited(ctx, 0), func(err error) {
+		cm.audit(ctx, action, service, params, started, 0, err)
+	}
+}
+
+// auditSecrets records the secrets a new spec of a service adds, changes or removes as a
+// secret change of its own, whichever change carries it. Only their names are recorded.
+func (cm *ContainerManager) auditSecrets(ctx context.Context, service string, old, new *ContainerConfig, started time.Time, err error) {
+	changes := secretChanges(old, new)
+	if len(changes) == 0 {
+		return
+	}
+	scope, _ := ctx.Value(auditedKey{}).(auditedScope)
+	cm.audit(ctx, "secret change", service, changes, started, scope.operationID, err)
+}
+
+// secretChanges lists the basic auth users added, changed and removed on each route,
+// keyed by host and path. old is nil for a new service.
+func secretChanges(old, new *ContainerConfig) map[string]any {
+	users := func(config *ContainerConfig) map[string]map[string]string {
+		routes := make(map[string]map[string]string)
+		if config == nil {
+			return routes
+		}
+		for _, r := range config.Routes {
+			if len(r.Auth.BasicAuth) > 0 {
+				routes[r.key()] = r.Auth.BasicAuth
+			}
+		}
+		return routes
+	}
+	before, after := users(old), users(new)
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	changes := make(map[string]any)
+	for key := range keys {
+		var added, changed, removed []string
+		for user, hash := range after[key] {
+			if previous, ok := before[key][user]; !ok {
+				added = append(added, user)
+			} else if previous != hash {
+				changed = append(changed, user)
+			}
+		}
+		for user := range before[key] {
+			if _, ok := after[key][user]; !ok {
+				removed = append(removed, user)
+			}
+		}
+		if len(added)+len(changed)+len(removed) == 0 {
+			continue
+		}
+		route := make(map[string][]string)
+		for kind, names := range map[string][]string{"added": added, "changed": changed, "removed": removed} {
+			if len(names) > 0 {
+				sort.Strings(names)
+				route[kind] = names
+			}
+		}
+		changes[key] = map[string]any{"users": route}
+	}
+	return changes
+}
+
+// RemoveParams are the audit log params of removing a service
+func RemoveParams(containerName string, removeImage bool) map[string]any {
+	return map[string]any{"container_name": containerName, "remove_image": removeImage}
+}
+
+// secretKey matches the parameters whose values never go into the audit log
+var secretKey = regexp.MustCompile(`(?i)password|secret|token|credential|private_key|basic_auth`)
+
+const redacted = "[redacted]"
+
+// auditParams encodes params as a JSON object. It goes through yaml so the fields have
+// their spec names and the registry credentials, which are not part of a spec, are left out.
+func auditParams(params any) string {
+	if params == nil {
+		return "{}"
+	}
+	encoded, err := yaml.Marshal(params)
+	if err != nil {
+		return "{}"
+	}
+	var generic any
+	if err := yaml.Unmarshal(encoded, &generic); err != nil {
+		return "{}"
+	}
+	if config, ok := params.(*ContainerConfig); ok && config.RegistryPassword != "" {
+		if m, ok := generic.(map[string]any); ok {
+			m["registry_username"] = config.RegistryUsername
+			m["registry_password"] = redacted
+		}
+	}
+	doc, err := json.Marshal(redact(generic))
+	if err != nil {
+		return "{}"
+	}
+	return string(doc)
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if secretKey.MatchString(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redact(value)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
+
+// ParseAuditTime reads a time given as RFC 3339 or as a duration before now, such as 24h
+func ParseAuditTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or a duration such as 24h", s)
+	}
+	return t, nil
+}
+
+// auditRecord is the JSONL form of an audit entry
+type auditRecord struct {
+	ID          int64           `json:"id"`
+	Time        time.Time       `json:"time"`
+	Actor       string          `json:"actor"`
+	Source      string          `json:"source,omitempty"`
+	Action      string          `json:"action"`
+	Service     string          `json:"service"`
+	Params      json.RawMessage `json:"params"`
+	Result      string          `json:"result"`
+	Error       string          `json:"error,omitempty"`
+	DurationMs  int64           `json:"duration_ms"`
+	OperationID int64           `json:"operation_id,omitempty"`
+}
+
+// WriteAuditJSONL writes one JSON object per entry and line
+func WriteAuditJSONL(w io.Writer, entries []database.AuditEntry) error {
+	encoder := json.NewEncoder(w)
+	for _, e := range entries {
+		params := json.RawMessage(e.Params)
+		if !json.Valid(params) {
+			params = json.RawMessage("{}")
+		}
+		err := encoder.Encode(auditRecord{
+			ID:          e.ID,
+			Time:        e.Time.UTC(),
+			Actor:       e.Actor,
+			Source:      e.Source,
+			Action:      e.Action,
+			Service:     e.ServiceName,
+			Params:      params,
+			Result:      e.Result,
+			Error:       e.Error,
+			DurationMs:  e.Duration.Milliseconds(),
+			OperationID: e.OperationID,
+		})
+		if err != nil {
+			return fmt.Errorf("error writing audit log: %w", err)
+		}
+	}
+	return nil
+}
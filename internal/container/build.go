@@ -0,0 +1,84 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/pkg/docker"
+)
+
+// BuildAndDeploy builds an image from a tar build context, tags it with version and then
+// creates the container, or updates it if a container with the same name already exists.
+// config.ImageName is used as the image repository and defaults to the container name.
+func (cm *ContainerManager) BuildAndDeploy(ctx context.Context, buildContext io.Reader, opts docker.BuildOptions, version string, config *ContainerConfig, out io.Writer) error {
+	if config.ContainerName == "" {
+		return errors.New("container name is required")
+	}
+
+	repository := config.ImageName
+	if repository == "" {
+		repository = config.ContainerName
+	}
+	if version == "" {
+		version = time.Now().Format("20060102150405")
+	}
+	config.ImageName = fmt.Sprintf("%s:%s", strings.ToLower(repository), version)
+	opts.Tags = append(opts.Tags, config.ImageName)
+
+	if cm.plan != nil {
+		cm.plan.record("build image", config.ImageName, "from the build context")
+		return cm.deploy(ctx, "deploy", config)
+	}
+	cm.Logger.Info("Building image %s for container %s", config.ImageName, config.ContainerName)
+	if err := cm.DockerClient.BuildImage(ctx, buildContext, opts, out); err != nil {
+		cm.Logger.Error("Error building image: %s", err)
+		return err
+	}
+	if err := cm.recordBuiltImage(ctx, config.ImageName); err != nil {
+		return err
+	}
+
+	return cm.deploy(ctx, "deploy", config)
+}
+
+// recordBuiltImage remembers the ID of an image built here, such images are in no registry
+func (cm *ContainerManager) recordBuiltImage(ctx context.Context, imageName string) error {
+	id, err := cm.DockerClient.ImageID(ctx, imageName)
+	if err == nil {
+		err = cm.Db.AddBuiltImage(id, imageName)
+	}
+	if err != nil {
+		cm.Logger.Error("Error recording built image: %s", err)
+		return fmt.Errorf("error recording built image %s: %w", imageName, err)
+	}
+	return nil
+}
+
+// imageBuiltHere reports whether a local image was built by the orchestrator. Its labels
+// cannot tell, images built FROM it and pushed elsewhere carry them too.
+func (cm *ContainerManager) imageBuiltHere(ctx context.Context, imageName string) (bool, error) {
+	id, err := cm.DockerClient.ImageID(ctx, imageName)
+	if err != nil {
+		return false, err
+	}
+	return cm.Db.IsBuiltImage(id)
+}
+
+// deploy updates the service when it exists and creates it otherwise, as one operation so
+// the check cannot race with another deploy of the same service
+func (cm *ContainerManager) deploy(ctx context.Context, kind string, config *ContainerConfig) error {
+	_, err := cm.RunOperation(ctx, config.ContainerName, kind, config, func(ctx context.Context) (string, error) {
+		var err error
+		if _, specErr := cm.serviceSpec(config.ContainerName); specErr == nil {
+			err = cm.UpdateExistingContainer(ctx, config)
+		} else {
+			err = cm.CreateNewContainer(ctx, config)
+		}
+		return config.ContainerID, err
+	})
+	return err
+}
@@ -0,0 +1,262 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/proxy"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+)
+
+var defaultCanarySteps = []int{5, 25, 50, 100}
+
+const (
+	defaultCanaryInterval     = 5 * time.Minute
+	defaultCanaryMaxErrorRate = 0.05
+	// minCanaryRequests avoids aborting on a single failed request at low traffic
+	minCanaryRequests = 20
+	// maxProbeFailures is how many probes in a row the canary may fail
+	maxProbeFailures = 3
+)
+
+// canaryUpdate runs one instance of config next to the old instances, shifts traffic to it
+// step by step while watching its error rate and probes, then replaces the old instances.
+func (cm *ContainerManager) canaryUpdate(ctx context.Context, r *rollout, d *deployment, config *ContainerConfig, old []database.ContainerInfo) ([]*database.ContainerInfo, error) {
+	steps := config.Rollout.Steps
+	if len(steps) == 0 {
+		steps = defaultCanarySteps
+	}
+	interval := config.Rollout.Interval
+	if interval == 0 {
+		interval = defaultCanaryInterval
+	}
+	suffix := time.Now().Format("20060102150405")
+
+	j, err := cm.beginJournal("canary", config.ContainerName, nil)
+	if err != nil {
+		return nil, err
+	}
+	canary, err := cm.startInstances(ctx, j, config, suffix, []int{0})
+	if err == nil {
+		if err = d.preDeploy(ctx, canary); err != nil {
+			cm.removeInstances(canary)
+		}
+	}
+	if err == nil {
+		err = cm.waitReady(ctx, config, canary)
+	}
+	if err == nil {
+		if err = cm.updateDatabase(j, nil, canary); err != nil {
+			cm.removeInstances(canary)
+			err = fmt.Errorf("error saving canary info to database: %w", err)
+		}
+	}
+	if err != nil {
+		j.undone()
+		r.decide("canary did not start: %s", err)
+		r.set(RolloutAborted, err.Error())
+		return nil, fmt.Errorf("canary of %s aborted: %w", config.ContainerName, err)
+	}
+	j.done()
+	r.setCanaryWeight(steps[0])
+	r.setCanary(canary[0].ContainerID)
+	r.decide("canary %s is ready", canary[0].ContainerName)
+
+	for _, step := range steps {
+		if step == 100 {
+			break
+		}
+		r.setCanaryWeight(step)
+		cm.applyRoutes()
+		r.decide("shifted %d%% of traffic to the canary", step)
+
+		if err := cm.watchCanary(ctx, r, config, canary[0], interval); err != nil {
+			return nil, cm.abortCanary(r, canary[0], err)
+		}
+	}
+
+	r.decide("promoting the canary to %d instances", config.Replicas)
+	var replicas []int
+	for replica := 1; replica < config.Replicas; replica++ {
+		replicas = append(replicas, replica)
+	}
+	j, err = cm.beginJournal("promote", config.ContainerName, old)
+	if err != nil {
+		return nil, cm.abortCanary(r, canary[0], err)
+	}
+	rest, err := cm.startInstances(ctx, j, config, suffix, replicas)
+	if err == nil {
+		err = cm.waitReady(ctx, config, rest)
+	}
+	if err == nil {
+		if err = cm.updateDatabase(j, old, rest); err != nil {
+			cm.removeInstances(rest)
+		}
+	}
+	if err != nil {
+		j.undone()
+		return nil, cm.abortCanary(r, canary[0], fmt.Errorf("error promoting canary: %w", err))
+	}
+	updated := append(canary, rest...)
+	r.clearCanary()
+	r.progress(len(updated))
+	cm.applyRoutes()
+
+	for _, info := range old {
+		if err := cm.stopAndRemoveContainer(ctx, info.ContainerID); err != nil {
+			cm.Logger.Error("Error stopping/removing old container: %s", err)
+		}
+	}
+	j.done()
+	r.decide("canary promoted, old instances removed")
+	return updated, nil
+}
+
+// watchCanary holds a step for interval, failing if the canary's 5xx rate exceeds the
+// threshold or it fails its probe too many times in a row
+func (cm *ContainerManager) watchCanary(ctx context.Context, r *rollout, config *ContainerConfig, canary *database.ContainerInfo, interval time.Duration) error {
+	maxErrorRate := config.Rollout.MaxErrorRate
+	if maxErrorRate == 0 {
+		maxErrorRate = defaultCanaryMaxErrorRate
+	}
+	if cm.plan != nil {
+		cm.plan.record("watch canary", canary.ContainerName, "%d%% of traffic for %s, abort above %.1f%% errors", r.Status().CanaryWeight, interval, maxErrorRate*100)
+		return nil
+	}
+	probeEvery := min(max(interval/10, time.Second), 10*time.Second)
+	ticker := time.NewTicker(probeEvery)
+	defer ticker.Stop()
+
+	start := cm.canaryStats(canary)
+	deadline := time.Now().Add(interval)
+	probeFailures := 0
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		paused := time.Now()
+		if err := r.checkpoint(ctx); err != nil {
+			return err
+		}
+		// Time spent paused does not count towards the step
+		deadline = deadline.Add(time.Since(paused).Truncate(probeEvery))
+
+		ready, err := cm.probeReadiness(ctx, config, canary)
+		if err != nil {
+			return fmt.Errorf("canary failed: %w", err)
+		}
+		if ready {
+			probeFailures = 0
+		} else if probeFailures++; probeFailures >= maxProbeFailures {
+			return fmt.Errorf("canary failed %d probes in a row", probeFailures)
+		}
+
+		if err := checkErrorRate(start, cm.canaryStats(canary), maxErrorRate); err != nil {
+			return err
+		}
+	}
+
+	stats := cm.canaryStats(canary)
+	r.decide("step passed, %d of %d requests failed, probes succeeded", stats.Errors-start.Errors, stats.Requests-start.Requests)
+	return nil
+}
+
+func checkErrorRate(start, current proxy.BackendStats, maxErrorRate float64) error {
+	requests := current.Requests - start.Requests
+	errors := current.Errors - start.Errors
+	if requests < minCanaryRequests {
+		return nil
+	}
+	if rate := float64(errors) / float64(requests); rate > maxErrorRate {
+		return fmt.Errorf("canary error rate %.1f%% (%d of %d requests) is above %.1f%%", rate*100, errors, requests, maxErrorRate*100)
+	}
+	return nil
+}
+
+// canaryStats returns the request counters of the canary, only the built-in proxy sees responses
+func (cm *ContainerManager) canaryStats(canary *database.ContainerInfo) proxy.BackendStats {
+	if cm.Proxy == nil {
+		return proxy.BackendStats{}
+	}
+	return cm.Proxy.BackendStats(backendAddress(*canary))
+}
+
+// abortCanary sends all traffic back to the old instances and removes the canary
+func (cm *ContainerManager) abortCanary(r *rollout, canary *database.ContainerInfo, cause error) error {
+	r.decide("aborting: %s", cause)
+	r.set(RolloutRollingBack, cause.Error())
+	cm.Logger.Warn("Aborting canary of %s: %s", canary.ServiceName, cause)
+
+	r.clearCanary()
+	if err := cm.retire(context.Background(), "abort canary", canary.ServiceName, []database.ContainerInfo{*canary}, nil); err != nil {
+		cm.Logger.Error("Error removing canary: %s", err)
+	}
+
+	r.set(RolloutAborted, cause.Error())
+	return fmt.Errorf("canary of %s aborted: %w", canary.ServiceName, cause)
+}
+
+func (r *rollout) setCanary(containerID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canaries = map[string]bool{containerID: true}
+}
+
+func (r *rollout) setCanaryWeight(weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.CanaryWeight = weight
+	r.status.UpdatedAt = time.Now()
+}
+
+func (r *rollout) clearCanary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canaries = nil
+	r.status.CanaryWeight = 0
+}
+
+// weigh splits the traffic of a service between its canary and stable instances
+func (r *rollout) weigh(backends []routing.Backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.canaries) == 0 {
+		return
+	}
+
+	var canaries, stable int
+	for _, b := range backends {
+		if r.canaries[b.ContainerID] {
+			canaries++
+		} else {
+			stable++
+		}
+	}
+	if canaries == 0 || stable == 0 {
+		return
+	}
+	// Each canary gets weight*stable and each stable instance (100-weight)*canaries,
+	// so the canaries together receive weight percent
+	weight := min(max(r.status.CanaryWeight, 1), 99)
+	canaryWeight := weight * stable
+	stableWeight := (100 - weight) * canaries
+	divisor := gcd(canaryWeight, stableWeight)
+	for i := range backends {
+		if r.canaries[backends[i].ContainerID] {
+			backends[i].Weight = canaryWeight / divisor
+		} else {
+			backends[i].Weight = stableWeight / divisor
+		}
+	}
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,335 @@
+package container
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/config"
+	"github.com/dgunzy/go-container-orchestrator/internal/certs"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/logging"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+)
+
+const (
+	defaultCertCheckInterval = 12 * time.Hour
+	defaultCertWarnDays      = 14
+)
+
+// ErrCertificatesDisabled is returned by certificate operations when there is no store
+var ErrCertificatesDisabled = errors.New("certificates are not enabled, set CERT_STORE_KEY")
+
+// certificates are the TLS certificates served for the domains of services. The issuer is
+// nil unless ACME is configured, certificates are then only served, not requested.
+type certificates struct {
+	store      *certs.Store
+	issuer     *certs.Issuer
+	challenges *certs.Challenges
+	// challengeAddr answers HTTP-01 challenges for routers other than the built-in proxy
+	challengeAddr string
+	interval      time.Duration
+	// renew asks for the certificates to be checked now, after a service was deployed
+	renew chan struct{}
+}
+
+// newCertificates sets up the certificate store when CERT_STORE_KEY is set, and ACME when
+// ACME_EMAIL or ACME_DIRECTORY_URL is. It returns nil when certificates are not used.
+func newCertificates(db database.Store, logger *logging.Logger) (*certificates, error) {
+	email, directory := os.Getenv("ACME_EMAIL"), os.Getenv("ACME_DIRECTORY_URL")
+	encoded := os.Getenv("CERT_STORE_KEY")
+	if encoded == "" {
+		if email != "" || directory != "" {
+			return nil, errors.New("CERT_STORE_KEY must be set to store ACME certificates")
+		}
+		return nil, nil
+	}
+	key, err := certs.ParseKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	store, err := certs.NewStore(db, key)
+	if err != nil {
+		return nil, err
+	}
+	c := &certificates{store: store, renew: make(chan struct{}, 1)}
+	c.interval, err = time.ParseDuration(config.GetEnvOrDefault("CERT_CHECK_INTERVAL", defaultCertCheckInterval.String()))
+	if err != nil {
+		return nil, fmt.Errorf("error reading CERT_CHECK_INTERVAL: %w", err)
+	}
+	if email == "" && directory == "" {
+		return c, nil
+	}
+
+	var renewBefore time.Duration
+	if value := os.Getenv("ACME_RENEW_BEFORE"); value != "" {
+		if renewBefore, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("error reading ACME_RENEW_BEFORE: %w", err)
+		}
+	}
+	client, err := acmeHTTPClient(os.Getenv("ACME_CA_ROOTS"))
+	if err != nil {
+		return nil, err
+	}
+	c.challenges = certs.NewChallenges()
+	c.issuer, err = certs.NewIssuer(certs.IssuerConfig{
+		DirectoryURL: directory,
+		Email:        email,
+		Challenge:    os.Getenv("ACME_CHALLENGE"),
+		RenewBefore:  renewBefore,
+		HTTPClient:   client,
+	}, store, c.challenges, logger)
+	if err != nil {
+		return nil, err
+	}
+	if c.issuer.Challenge() == certs.ChallengeHTTP01 {
+		c.challengeAddr = config.GetEnvOrDefault("ACME_CHALLENGE_ADDR", "127.0.0.1:8402")
+	}
+	return c, nil
+}
+
+// acmeHTTPClient trusts the CA certificates in the PEM file at rootsPath on top of the
+// system roots, for CAs such as a local test CA whose directory is served with their own
+// root. It returns nil for the default client when rootsPath is empty.
+func acmeHTTPClient(rootsPath string) (*http.Client, error) {
+	if rootsPath == "" {
+		return nil, nil
+	}
+	pemData, err := os.ReadFile(rootsPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ACME_CA_ROOTS: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in ACME_CA_ROOTS %s", rootsPath)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	return &http.Client{Transport: transport, Timeout: time.Minute}, nil
+}
+
+// requestCertificates has the certificates of the routed domains checked now instead of at
+// the next interval
+func (cm *ContainerManager) requestCertificates() {
+	if cm.certs == nil || cm.certs.issuer == nil || cm.plan != nil {
+		return
+	}
+	select {
+	case cm.certs.renew <- struct{}{}:
+	default:
+	}
+}
+
+// runCertificates requests and renews the certificates of the routed domains when ACME is
+// configured and warns about certificates close to expiry, every CERT_CHECK_INTERVAL and
+// after each deployment, until ctx is cancelled
+func (cm *ContainerManager) runCertificates(ctx context.Context) {
+	if cm.certs == nil {
+		return
+	}
+	if cm.certs.challengeAddr != "" {
+		go cm.serveChallenges(ctx)
+	}
+	ticker := time.NewTicker(cm.certs.interval)
+	defer ticker.Stop()
+	for {
+		if cm.certs.issuer != nil {
+			cm.renewCertificates(ctx)
+		}
+		cm.warnExpiringCertificates()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cm.certs.renew:
+		}
+	}
+}
+
+// renewCertificates requests a certificate for each routed domain that has none or whose
+// ACME certificate is due for renewal. Certificates from other sources are left alone.
+func (cm *ContainerManager) renewCertificates(ctx context.Context) {
+	table, err := cm.routingTable()
+	if err != nil {
+		cm.Logger.Error("Error building routing table: %s", err)
+		return
+	}
+	issued := false
+	for _, domain := range tableHosts(table.Services) {
+		if !acmeDomain(domain) {
+			continue
+		}
+		cert, err := cm.certs.store.Load(domain)
+		switch {
+		case errors.Is(err, database.ErrCertificateNotFound):
+		case err != nil:
+			cm.Logger.Error("Error loading certificate of %s: %s", domain, err)
+			continue
+		case cert.Source != certs.SourceACME || !cm.certs.issuer.Due(cert):
+			continue
+		}
+		if _, err := cm.certs.issuer.Issue(ctx, domain); err != nil {
+			cm.Logger.Error("Error issuing certificate for %s: %s", domain, err)
+			continue
+		}
+		issued = true
+	}
+	if issued {
+		cm.applyRoutes()
+	}
+}
+
+// acmeDomain reports whether a public CA can issue a certificate for a domain, names on
+// private suffixes and IP addresses cannot be validated
+func acmeDomain(domain string) bool {
+	if !strings.Contains(domain, ".") || net.ParseIP(domain) != nil {
+		return false
+	}
+	for _, suffix := range []string{".internal", ".local", ".localhost"} {
+		if strings.HasSuffix(strings.ToLower(domain), suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// serveChallenges answers HTTP-01 challenges on ACME_CHALLENGE_ADDR, where nginx forwards them
+func (cm *ContainerManager) serveChallenges(ctx context.Context) {
+	server := &http.Server{Addr: cm.certs.challengeAddr, Handler: cm.certs.challenges, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	cm.Logger.Info("Answering ACME challenges on %s", cm.certs.challengeAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		cm.Logger.Error("ACME challenge server stopped: %s", err)
+	}
+}
+
+// tableHosts are the distinct hosts the services of a routing table are routed on
+func tableHosts(services []routing.Service) []string {
+	var hosts []string
+	for _, s := range services {
+		for _, host := range s.Hosts() {
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// tableCertificates are the stored certificates of the services in a routing table
+func (cm *ContainerManager) tableCertificates(services []routing.Service) []routing.Certificate {
+	if cm.certs == nil {
+		return nil
+	}
+	stored, err := cm.certs.store.List()
+	if err != nil {
+		cm.Logger.Error("Error loading certificates: %s", err)
+		return nil
+	}
+	routed := make(map[string]bool, len(services))
+	for _, host := range tableHosts(services) {
+		routed[host] = true
+	}
+	var result []routing.Certificate
+	for _, c := range stored {
+		if routed[strings.ToLower(c.Domain)] {
+			result = append(result, routing.Certificate{Domain: c.Domain, CertPEM: c.CertPEM, KeyPEM: c.KeyPEM})
+		}
+	}
+	return result
+}
+
+// UploadCertificate stores a certificate and key obtained elsewhere for a domain and routes
+// it. The certificate must cover the domain.
+func (cm *ContainerManager) UploadCertificate(ctx context.Context, domain string, certPEM, keyPEM []byte) (cert *certs.Certificate, err error) {
+	started := time.Now()
+	defer func() { cm.audit(ctx, "cert upload", "", map[string]any{"domain": domain}, started, 0, err) }()
+	if cm.certs == nil {
+		return nil, ErrCertificatesDisabled
+	}
+	if domain == "" {
+		return nil, errors.New("domain is required")
+	}
+	cert, err = cm.certs.store.Save(domain, certs.SourceManual, certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error saving certificate for %s: %w", domain, err)
+	}
+	cm.Logger.Info("Uploaded certificate for %s, valid until %s", domain, cert.NotAfter.Format(time.RFC3339))
+	cm.applyRoutes()
+	return cert, nil
+}
+
+// SelfSignedCertificate creates and routes a self-signed certificate for a domain, for
+// internal names ACME cannot serve. A validity of zero is a year.
+func (cm *ContainerManager) SelfSignedCertificate(ctx context.Context, domain string, validity time.Duration) (cert *certs.Certificate, err error) {
+	started := time.Now()
+	params := map[string]any{"domain": domain, "validity": validity.String()}
+	defer func() { cm.audit(ctx, "cert self-signed", "", params, started, 0, err) }()
+	if cm.certs == nil {
+		return nil, ErrCertificatesDisabled
+	}
+	if domain == "" {
+		return nil, errors.New("domain is required")
+	}
+	cert, err = cm.certs.store.SelfSigned(domain, validity)
+	if err != nil {
+		return nil, fmt.Errorf("error creating certificate for %s: %w", domain, err)
+	}
+	cm.Logger.Info("Created self-signed certificate for %s, valid until %s", domain, cert.NotAfter.Format(time.RFC3339))
+	cm.applyRoutes()
+	return cert, nil
+}
+
+// ListCertificates returns the certificate inventory. Keys are left encrypted, listing does
+// not need CERT_STORE_KEY.
+func (cm *ContainerManager) ListCertificates() ([]database.Certificate, error) {
+	return cm.Db.ListCertificates()
+}
+
+// CertWarnBefore is how close to expiry a certificate is warned about, CERT_WARN_DAYS days
+func CertWarnBefore() time.Duration {
+	days, err := strconv.Atoi(config.GetEnvOrDefault("CERT_WARN_DAYS", strconv.Itoa(defaultCertWarnDays)))
+	if err != nil || days < 0 {
+		days = defaultCertWarnDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// CertificateExpiring reports whether a certificate valid until notAfter expires within
+// CertWarnBefore of now
+func CertificateExpiring(notAfter, now time.Time) bool {
+	return notAfter.Sub(now) < CertWarnBefore()
+}
+
+// warnExpiringCertificates logs a warning for each certificate close to expiry or expired
+func (cm *ContainerManager) warnExpiringCertificates() {
+	stored, err := cm.ListCertificates()
+	if err != nil {
+		cm.Logger.Error("Error listing certificates: %s", err)
+		return
+	}
+	now := time.Now()
+	for _, c := range stored {
+		switch {
+		case !now.Before(c.NotAfter):
+			cm.Logger.Warn("Certificate of %s (%s) expired on %s", c.Domain, c.Source, c.NotAfter.Format(time.RFC3339))
+		case CertificateExpiring(c.NotAfter, now):
+			cm.Logger.Warn("Certificate of %s (%s) expires in %d days, on %s", c.Domain, c.Source, int(c.NotAfter.Sub(now).Hours()/24), c.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package container
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/docker/go-connections/nat"
+)
+
+// How the port of a service is routed. HTTP and gRPC ports are routed by domain, at most
+// one per service, TCP ports by the layer 4 proxy and the others not at all.
+const (
+	RoleHTTP = "http"
+	RoleGRPC = "grpc"
+	RoleTCP  = "tcp"
+	RoleNone = "none"
+)
+
+var portNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// PortSpec is a named port a service exposes
+type PortSpec struct {
+	Name string `yaml:"name"`
+	// Protocol is tcp or udp, tcp when empty
+	Protocol      string `yaml:"protocol,omitempty"`
+	ContainerPort int    `yaml:"container_port"`
+	// HostPort publishes the port on a fixed host port instead of one from PORT_RANGES. It
+	// needs a single replica, and updates stop the old instance before starting the new one
+	// whatever MaxSurge says.
+	HostPort int `yaml:"host_port,omitempty"`
+	// Role is http, grpc, tcp or none, none when empty
+	Role string `yaml:"role,omitempty"`
+	// PublicPort is where the built-in proxy listens for a tcp port, which is forwarded to
+	// the instances of the service over the protocol of the port
+	PublicPort int `yaml:"public_port,omitempty"`
+}
+
+func (p PortSpec) protocol() string {
+	if p.Protocol == "" {
+		return "tcp"
+	}
+	return p.Protocol
+}
+
+func (p PortSpec) role() string {
+	if p.Role == "" {
+		return RoleNone
+	}
+	return p.Role
+}
+
+func (p PortSpec) String() string {
+	s := fmt.Sprintf("%s:%d/%s:%s", p.Name, p.ContainerPort, p.protocol(), p.role())
+	if p.HostPort != 0 || p.PublicPort != 0 {
+		s += ":" + strconv.Itoa(p.HostPort)
+	}
+	if p.PublicPort != 0 {
+		s += ":" + strconv.Itoa(p.PublicPort)
+	}
+	return s
+}
+
+// ParsePortSpec reads a port given as name:container_port[/protocol][:role[:host_port[:public_port]]],
+// such as metrics:9090, dns:53/udp:tcp:5353 or db:5432:tcp::5432. An empty or zero host
+// port is allocated.
+func ParsePortSpec(s string) (PortSpec, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) < 2 || len(fields) > 5 {
+		return PortSpec{}, fmt.Errorf("invalid port %q, use name:container_port[/protocol][:role[:host_port[:public_port]]]", s)
+	}
+	p := PortSpec{Name: fields[0]}
+	port, protocol, _ := strings.Cut(fields[1], "/")
+	var err error
+	if p.ContainerPort, err = strconv.Atoi(port); err != nil {
+		return PortSpec{}, fmt.Errorf("invalid container port in %q", s)
+	}
+	p.Protocol = protocol
+	if len(fields) > 2 {
+		p.Role = fields[2]
+	}
+	if len(fields) > 3 && fields[3] != "" {
+		if p.HostPort, err = strconv.Atoi(fields[3]); err != nil {
+			return PortSpec{}, fmt.Errorf("invalid host port in %q", s)
+		}
+	}
+	if len(fields) > 4 {
+		if p.PublicPort, err = strconv.Atoi(fields[4]); err != nil {
+			return PortSpec{}, fmt.Errorf("invalid public port in %q", s)
+		}
+	}
+	return p, p.validate()
+}
+
+func (p PortSpec) validate() error {
+	if !portNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("invalid port name %q, use lowercase letters, digits and dashes", p.Name)
+	}
+	if p.ContainerPort < 1 || p.ContainerPort > 65535 {
+		return fmt.Errorf("container port of %s must be between 1 and 65535, got %d", p.Name, p.ContainerPort)
+	}
+	if p.HostPort < 0 || p.HostPort > 65535 {
+		return fmt.Errorf("host port of %s must be between 1 and 65535, got %d", p.Name, p.HostPort)
+	}
+	if p.PublicPort < 0 || p.PublicPort > 65535 {
+		return fmt.Errorf("public port of %s must be between 1 and 65535, got %d", p.Name, p.PublicPort)
+	}
+	if p.PublicPort != 0 && p.role() != RoleTCP {
+		return fmt.Errorf("port %s has a public port, only tcp ports are proxied on one", p.Name)
+	}
+	switch p.protocol() {
+	case "tcp", "udp":
+	default:
+		return fmt.Errorf("unknown protocol %q of port %s, use tcp or udp", p.Protocol, p.Name)
+	}
+	switch p.role() {
+	case RoleHTTP, RoleGRPC:
+		if p.protocol() != "tcp" {
+			return fmt.Errorf("port %s is routed as %s, it must be a tcp port", p.Name, p.role())
+		}
+	case RoleTCP, RoleNone:
+	default:
+		return fmt.Errorf("unknown role %q of port %s, use http, grpc, tcp or none", p.Role, p.Name)
+	}
+	return nil
+}
+
+func validatePorts(c *ContainerConfig) error {
+	if len(c.Ports) == 0 {
+		return nil
+	}
+	if c.ContainerPort != "" {
+		return fmt.Errorf("container port and ports cannot both be set, list the container port as an http port")
+	}
+	names := make(map[string]bool, len(c.Ports))
+	hostPorts := make(map[string]bool)
+	publicPorts := make(map[string]bool)
+	routed := ""
+	for _, p := range c.Ports {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		if names[p.Name] {
+			return fmt.Errorf("port %s is listed twice", p.Name)
+		}
+		names[p.Name] = true
+		if p.HostPort != 0 {
+			key := fmt.Sprintf("%d/%s", p.HostPort, p.protocol())
+			if hostPorts[key] {
+				return fmt.Errorf("host port %s is used by more than one port", key)
+			}
+			hostPorts[key] = true
+			if c.Replicas > 1 {
+				return fmt.Errorf("port %s has a fixed host port, the service cannot have more than one replica", p.Name)
+			}
+			// The old instance holds the host port until it stops, a canary runs next to
+			// it and could never bind it
+			if c.Rollout.Strategy == StrategyCanary {
+				return fmt.Errorf("port %s has a fixed host port, the service cannot use the %s strategy", p.Name, StrategyCanary)
+			}
+		}
+		if p.PublicPort != 0 {
+			key := fmt.Sprintf("%d/%s", p.PublicPort, p.protocol())
+			if publicPorts[key] {
+				return fmt.Errorf("public port %s is used by more than one port", key)
+			}
+			publicPorts[key] = true
+		}
+		if role := p.role(); role == RoleHTTP || role == RoleGRPC {
+			if routed != "" {
+				return fmt.Errorf("ports %s and %s are both routed by domain, only one can be", routed, p.Name)
+			}
+			routed = p.Name
+		}
+	}
+	return nil
+}
+
+// exposedPorts are the ports of the service. Services that only set ContainerPort have a
+// single port routed by domain.
+func (c *ContainerConfig) exposedPorts() []PortSpec {
+	if len(c.Ports) > 0 || c.ContainerPort == "" {
+		return c.Ports
+	}
+	port := nat.Port(c.ContainerPort)
+	return []PortSpec{{
+		Name:          database.DefaultPortName,
+		Protocol:      port.Proto(),
+		ContainerPort: port.Int(),
+		Role:          RoleHTTP,
+	}}
+}
+
+// fixedHostPort reports whether a port is published on a fixed host port, which only one
+// instance can hold at a time
+func (c *ContainerConfig) fixedHostPort() bool {
+	for _, p := range c.exposedPorts() {
+		if p.HostPort != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// routedPort is the port routed by domain, the one instances are reached at for requests
+// and readiness checks
+func (c *ContainerConfig) routedPort() (PortSpec, bool) {
+	for _, p := range c.exposedPorts() {
+		if role := p.role(); role == RoleHTTP || role == RoleGRPC {
+			return p, true
+		}
+	}
+	return PortSpec{}, false
+}
+
+func mappingPort(p database.PortMapping) nat.Port {
+	return nat.Port(fmt.Sprintf("%d/%s", p.ContainerPort, p.Protocol))
+}
+
+// publicPorts are the public ports of every service
+func (cm *ContainerManager) publicPorts() (map[int]bool, error) {
+	services, err := cm.Db.ListServices()
+	if err != nil {
+		return nil, fmt.Errorf("error listing services: %w", err)
+	}
+	public := make(map[int]bool)
+	for _, service := range services {
+		spec, err := cm.serviceSpec(service.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range spec.Ports {
+			if p.PublicPort != 0 {
+				public[p.PublicPort] = true
+			}
+		}
+	}
+	return public, nil
+}
+
+// routedMapping picks the port routed by domain out of the ports of an instance
+func routedMapping(ports []database.PortMapping) (database.PortMapping, bool) {
+	for _, p := range ports {
+		if p.Role == RoleHTTP || p.Role == RoleGRPC {
+			return p, true
+		}
+	}
+	return database.PortMapping{}, false
+}
+
+// portSpecs describes the ports of an instance, with the host ports they are published on
+func portSpecs(ports []database.PortMapping) []PortSpec {
+	var specs []PortSpec
+	for _, p := range ports {
+		specs = append(specs, PortSpec{
+			Name:          p.Name,
+			Protocol:      p.Protocol,
+			ContainerPort: p.ContainerPort,
+			HostPort:      p.HostPort,
+			Role:          p.Role,
+		})
+	}
+	return specs
+}
+
+// checkPublicPorts makes sure the proxy can listen on the public ports of a service: no
+// other service has them, no instance holds them as its host port and the proxy does not
+// serve HTTP on them. The allocator skips them from then on. The fixed host ports of the
+// service must not be the public port of another service either. It reads the services
+// through db, which may be a transaction.
+func (cm *ContainerManager) checkPublicPorts(db database.Store, config *ContainerConfig) error {
+	services, err := db.ListServices()
+	if err != nil {
+		return fmt.Errorf("error listing services: %w", err)
+	}
+	names := []string{config.ContainerName}
+	specs := map[string]*ContainerConfig{config.ContainerName: config}
+	for _, service := range services {
+		if service.Name == config.ContainerName {
+			continue
+		}
+		spec, err := cm.serviceSpecIn(db, service.Name)
+		if err != nil {
+			return err
+		}
+		names = append(names, service.Name)
+		specs[service.Name] = spec
+	}
+	leases, err := db.ListPortLeases()
+	if err != nil {
+		return fmt.Errorf("error listing port leases: %w", err)
+	}
+	var httpPorts []int
+	if cm.Proxy != nil {
+		httpPorts = cm.Proxy.HTTPPorts()
+	}
+
+	for _, p := range config.Ports {
+		if p.HostPort != 0 {
+			for _, name := range names[1:] {
+				for _, other := range specs[name].Ports {
+					if other.PublicPort == p.HostPort && other.protocol() == p.protocol() {
+						return fmt.Errorf("host port %d/%s of %s is the public port of %s", p.HostPort, p.protocol(), p.Name, name)
+					}
+				}
+			}
+		}
+		if p.PublicPort == 0 {
+			continue
+		}
+		now := time.Now()
+		for _, l := range leases {
+			if l.Port != p.PublicPort || l.Protocol != p.protocol() || (l.State() == database.PortPending && !l.ExpiresAt.After(now)) {
+				continue
+			}
+			if l.ServiceName != config.ContainerName || l.PortName != p.Name {
+				return fmt.Errorf("public port %d/%s of %s is the host port of %s of %s", p.PublicPort, p.protocol(), p.Name, l.PortName, l.ServiceName)
+			}
+		}
+		if p.protocol() == "tcp" && slices.Contains(httpPorts, p.PublicPort) {
+			return fmt.Errorf("public port %d of %s is where the proxy serves HTTP", p.PublicPort, p.Name)
+		}
+		for _, name := range names {
+			for _, other := range specs[name].Ports {
+				if other.HostPort == p.PublicPort && other.protocol() == p.protocol() {
+					return fmt.Errorf("public port %d/%s of %s is the host port of %s of %s", p.PublicPort, p.protocol(), p.Name, other.Name, name)
+				}
+				if name != config.ContainerName && other.PublicPort == p.PublicPort && other.protocol() == p.protocol() {
+					return fmt.Errorf("public port %d/%s of %s is used by %s", p.PublicPort, p.protocol(), p.Name, name)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -4,58 +4,192 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/dgunzy/go-container-orchestrator/internal/database"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/network"
 	"github.com/docker/go-connections/nat"
 )
 
-func (cm *ContainerManager) getContainerInfo(config *ContainerConfig) (*database.ContainerInfo, error) {
-	if config.ContainerID != "" {
-		return cm.Db.GetContainer(config.ContainerID)
+// admitAndPullImage checks the image against the policy, pulls it and then checks the
+// image labels, which are only known once the image is available locally
+func (cm *ContainerManager) admitAndPullImage(ctx context.Context, config *ContainerConfig) error {
+	if err := cm.checkPolicy(config, nil); err != nil {
+		return err
 	}
-	containers, err := cm.Db.GetContainersByPartialName(config.ContainerName)
-	if err != nil || len(containers) == 0 || len(containers) > 1 {
-		return nil, fmt.Errorf("error getting container info: %w", err)
+
+	if err := cm.pullImage(ctx, config); err != nil {
+		return err
+	}
+
+	if cm.Policy == nil || len(cm.Policy.RequiredLabels) == 0 {
+		return nil
+	}
+	if cm.plan != nil && cm.plan.pulls(config.ImageName) {
+		cm.plan.record("check image labels", config.ImageName, "after the pull, against the image policy")
+		return nil
+	}
+	labels, err := cm.DockerClient.ImageLabels(ctx, config.ImageName)
+	if err != nil {
+		cm.Logger.Error("Error inspecting image labels: %s", err)
+		return fmt.Errorf("error inspecting image labels: %w", err)
+	}
+	return cm.checkPolicy(config, labels)
+}
+
+func (cm *ContainerManager) checkPolicy(config *ContainerConfig, labels map[string]string) error {
+	if err := cm.Policy.Check(cm.Environment, config.PolicySubject(labels)); err != nil {
+		cm.Logger.Error("Image policy violation for %s: %s", config.ContainerName, err)
+		return err
+	}
+	return nil
+}
+
+const (
+	PullAlways  = "always"
+	PullMissing = "missing"
+	PullNever   = "never"
+)
+
+func validatePullPolicy(policy string) error {
+	switch policy {
+	case "", PullAlways, PullMissing, PullNever:
+		return nil
 	}
-	return &containers[0], nil
+	return fmt.Errorf("unknown pull policy %q, use always, missing or never", policy)
 }
 
+// pullImage pulls the image as its pull policy says. Images the orchestrator built are
+// in no registry, so they are used as they are.
 func (cm *ContainerManager) pullImage(ctx context.Context, config *ContainerConfig) error {
-	err := cm.DockerClient.PullImageFromPrivateRegistry(ctx,
+	if cm.plan != nil && cm.plan.provides(config.ImageName) {
+		cm.plan.record("use local image", config.ImageName, "built or loaded by this operation")
+		return nil
+	}
+	exists, err := cm.DockerClient.ImageExists(ctx, config.ImageName)
+	if err != nil {
+		cm.Logger.Error("Error checking for image: %s", err)
+		return fmt.Errorf("error checking for image: %w", err)
+	}
+
+	skip := ""
+	switch config.PullPolicy {
+	case PullNever:
+		if !exists {
+			return fmt.Errorf("image %s is not present locally and the pull policy is never", config.ImageName)
+		}
+		skip = "pull policy never"
+	case PullMissing:
+		if exists {
+			skip = "already present locally"
+		}
+	default:
+		if exists {
+			built, err := cm.imageBuiltHere(ctx, config.ImageName)
+			if err != nil {
+				cm.Logger.Error("Error inspecting image: %s", err)
+				return fmt.Errorf("error inspecting image: %w", err)
+			}
+			if built {
+				skip = "built by the orchestrator"
+			}
+		}
+	}
+	if skip != "" {
+		cm.Logger.Info("Not pulling image %s: %s", config.ImageName, skip)
+		if cm.plan != nil {
+			cm.plan.record("use local image", config.ImageName, "%s", skip)
+		}
+		return nil
+	}
+	if cm.plan != nil {
+		cm.plan.record("pull image", config.ImageName, "pull policy %s", pullPolicy(config))
+		return nil
+	}
+
+	username, password := cm.registryLogin(config)
+	err = cm.DockerClient.PullImage(ctx,
 		config.ImageName,
-		config.RegistryUsername,
-		config.RegistryPassword)
+		username,
+		password)
 	if err != nil {
 		cm.Logger.Error("Error pulling image: %s", err)
 		return fmt.Errorf("error pulling image: %w", err)
 	}
+	cm.saveRegistryLogin(config)
 	return nil
 }
 
-func (cm *ContainerManager) createAndStartContainer(ctx context.Context, config *ContainerConfig, hostPort string) (*database.ContainerInfo, error) {
+func pullPolicy(config *ContainerConfig) string {
+	if config.PullPolicy == "" {
+		return PullAlways
+	}
+	return config.PullPolicy
+}
+
+// createAndStartContainer records the container in the journal entry j before creating it.
+// config names the instance, service the service it belongs to, ports are the ports of the
+// instance with the host ports leased for them.
+func (cm *ContainerManager) createAndStartContainer(ctx context.Context, j *journal, service string, config *ContainerConfig, ports []database.PortMapping) (*database.ContainerInfo, error) {
 	containerConfig := &container.Config{
-		Image:      config.ImageName,
-		Domainname: config.DomainName,
-		Cmd:        config.Cmd,
-	}
-	hostConfig := &container.HostConfig{
-		PortBindings: nat.PortMap{
-			nat.Port(config.ContainerPort): []nat.PortBinding{
-				{
-					HostIP:   "0.0.0.0",
-					HostPort: hostPort,
-				},
-			},
-		},
-	}
-	response, err := cm.DockerClient.CreateContainer(ctx, containerConfig, hostConfig, &network.NetworkingConfig{}, config.ContainerName)
+		Image:        config.ImageName,
+		Domainname:   config.DomainName,
+		Cmd:          config.Cmd,
+		ExposedPorts: nat.PortSet{},
+	}
+	resources, err := config.resources()
+	if err != nil {
+		return nil, err
+	}
+	ports = append([]database.PortMapping(nil), ports...)
+	for i := range ports {
+		if cm.publishMode(config) == PublishPrivate {
+			// Instances that moved to the private network give up the ports they had on the host
+			ports[i].HostPort = 0
+		}
+		containerConfig.ExposedPorts[mappingPort(ports[i])] = struct{}{}
+	}
+	hostConfig := &container.HostConfig{Resources: resources}
+	networkingConfig, err := cm.publish(ctx, service, config, ports, hostConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &database.ContainerInfo{
+		ContainerName: config.ContainerName,
+		ImageName:     config.ImageName,
+		DomainName:    config.DomainName,
+		ContainerPort: config.ContainerPort,
+		Status:        "running",
+		Ports:         ports,
+	}
+	if routed, ok := routedMapping(ports); ok {
+		if info.ContainerPort == "" {
+			info.ContainerPort = strconv.Itoa(routed.ContainerPort)
+		}
+		if routed.HostPort != 0 {
+			info.HostPort = strconv.Itoa(routed.HostPort)
+		}
+	}
+
+	if cm.plan != nil {
+		id := plannedIDPrefix + config.ContainerName
+		cm.plan.names[id] = config.ContainerName
+		cm.plan.record("start container", config.ContainerName, "image %s, %s", config.ImageName, cm.describePorts(config, ports))
+		info.ContainerID = id
+		info.Address, _ = cm.instanceAddress(ctx, config, id)
+		return info, nil
+	}
+	if err := j.starting(config.ContainerName); err != nil {
+		return nil, err
+	}
+	response, err := cm.DockerClient.CreateContainer(ctx, containerConfig, hostConfig, networkingConfig, config.ContainerName)
 	if err != nil {
 		cm.Logger.Error("Error creating container: %s", err)
 		return nil, fmt.Errorf("error creating container: %w", err)
 	}
+	j.created(config.ContainerName, response.ID)
 
 	cm.Logger.Info("Created container with ID: %s", response.ID)
 
@@ -67,30 +201,37 @@ func (cm *ContainerManager) createAndStartContainer(ctx context.Context, config
 
 	cm.Logger.Info("Container started successfully")
 
-	return &database.ContainerInfo{
-		ContainerID:   response.ID,
-		ContainerName: config.ContainerName,
-		ImageName:     config.ImageName,
-		DomainName:    config.DomainName,
-		HostPort:      hostPort,
-		ContainerPort: config.ContainerPort,
-		Status:        "running",
-	}, nil
-}
-
-func (cm *ContainerManager) createAndStartNewContainer(ctx context.Context, config *ContainerConfig) (*database.ContainerInfo, error) {
-	newHostPort, err := cm.portFinder.findAvailablePort()
+	info.ContainerID = response.ID
+	info.Address, err = cm.instanceAddress(ctx, config, response.ID)
 	if err != nil {
-		return nil, fmt.Errorf("error finding available port: %w", err)
+		cm.Logger.Error("Error getting container address: %s", err)
+		return nil, err
 	}
+	return info, nil
+}
 
-	newContainerName := fmt.Sprintf("%s_%s", config.ContainerName, time.Now().Format("20060102150405"))
-	config.ContainerName = newContainerName
-
-	return cm.createAndStartContainer(ctx, config, newHostPort)
+// describePorts tells where each port of an instance is published, for plans
+func (cm *ContainerManager) describePorts(config *ContainerConfig, ports []database.PortMapping) string {
+	if len(ports) == 0 {
+		return "no ports"
+	}
+	var described []string
+	for _, p := range ports {
+		if p.HostPort == 0 {
+			described = append(described, fmt.Sprintf("%s %s network -> %s", p.Name, cm.publishing.network, mappingPort(p)))
+			continue
+		}
+		described = append(described, fmt.Sprintf("%s host port %d -> %s", p.Name, p.HostPort, mappingPort(p)))
+	}
+	return strings.Join(described, ", ")
 }
 
 func (cm *ContainerManager) stopAndRemoveContainer(ctx context.Context, containerID string) error {
+	if cm.plan != nil {
+		cm.plan.record("remove container", cm.plan.containerName(containerID), "stop and remove")
+		return nil
+	}
+	cm.drain(ctx, containerID)
 	if err := cm.DockerClient.StopContainer(ctx, containerID, nil); err != nil {
 		cm.Logger.Error("Error stopping container: %s", err)
 		// Continue with removal even if stop fails
@@ -104,16 +245,24 @@ func (cm *ContainerManager) stopAndRemoveContainer(ctx context.Context, containe
 	return nil
 }
 
-func (cm *ContainerManager) updateDatabase(oldInfo *database.ContainerInfo, newInfo *database.ContainerInfo) error {
-	if err := cm.Db.AddContainer(*newInfo); err != nil {
-		return fmt.Errorf("error saving new container info to database: %w", err)
-	}
+// updateDatabase swaps the rows of the old instances for the new ones in one transaction
+// that also commits the journal entry of the swap
+func (cm *ContainerManager) updateDatabase(j *journal, oldInfos []database.ContainerInfo, newInfos []*database.ContainerInfo) error {
+	return j.commit(func(tx database.Store) error {
+		for _, newInfo := range newInfos {
+			if err := tx.AddContainer(*newInfo); err != nil {
+				return fmt.Errorf("error saving new container info to database: %w", err)
+			}
+		}
 
-	if err := cm.Db.DeleteContainer(oldInfo.ContainerID); err != nil {
-		return fmt.Errorf("error removing old container info from database: %w", err)
-	}
+		for _, oldInfo := range oldInfos {
+			if err := tx.DeleteContainer(oldInfo.ContainerID); err != nil {
+				return fmt.Errorf("error removing old container info from database: %w", err)
+			}
+		}
 
-	return nil
+		return nil
+	})
 }
 func getLogPath() string {
 	logPath := os.Getenv("LOG_PATH")
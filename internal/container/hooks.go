@@ -0,0 +1,232 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/pkg/docker"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+)
+
+// Hook is a command run during a deploy. Pre-deploy hooks run once the new version is
+// started but before it receives traffic, and abort the deploy when they fail. Post-deploy
+// hooks run after traffic has switched and only have their failures recorded.
+type Hook struct {
+	Command strslice.StrSlice `yaml:"command"`
+	Timeout time.Duration     `yaml:"timeout,omitempty"`
+	// RunIn is instance (the default) to exec in the first new instance, or oneoff to run
+	// a separate container from the new image
+	RunIn string `yaml:"run_in,omitempty"`
+}
+
+type Hooks struct {
+	PreDeploy  []Hook `yaml:"pre_deploy,omitempty"`
+	PostDeploy []Hook `yaml:"post_deploy,omitempty"`
+}
+
+const (
+	HookRunInInstance = "instance"
+	HookRunInOneOff   = "oneoff"
+
+	HookPreDeploy  = "pre_deploy"
+	HookPostDeploy = "post_deploy"
+)
+
+const (
+	defaultHookTimeout = 10 * time.Minute
+	// Only the end of the output is kept in the deployment history
+	maxHookOutput = 64 * 1024
+)
+
+// HookError is returned when a hook exits with a non-zero code or cannot be run
+type HookError struct {
+	Phase    string
+	Command  string
+	ExitCode int
+	Output   string
+	Err      error
+}
+
+func (e *HookError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s hook %q failed: %s", e.Phase, e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s hook %q exited with code %d", e.Phase, e.Command, e.ExitCode)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+func (h Hooks) validate() error {
+	for _, hook := range append(append([]Hook(nil), h.PreDeploy...), h.PostDeploy...) {
+		if len(hook.Command) == 0 {
+			return errors.New("hook command cannot be empty")
+		}
+		if hook.Timeout < 0 {
+			return errors.New("hook timeout cannot be negative")
+		}
+		switch hook.RunIn {
+		case "", HookRunInInstance, HookRunInOneOff:
+		default:
+			return fmt.Errorf("unknown hook run_in %q, expected %s or %s", hook.RunIn, HookRunInInstance, HookRunInOneOff)
+		}
+	}
+	return nil
+}
+
+func (h Hooks) isZero() bool {
+	return len(h.PreDeploy) == 0 && len(h.PostDeploy) == 0
+}
+
+// deployment records one create or update of a service in the deployment history
+type deployment struct {
+	cm *ContainerManager
+	// id is zero when the deployment could not be recorded, the deploy goes ahead regardless
+	id     int64
+	config *ContainerConfig
+	// postDeployErr is reported in the history without failing the deploy
+	postDeployErr error
+}
+
+func (cm *ContainerManager) startDeployment(config *ContainerConfig, strategy string) *deployment {
+	id, err := cm.Db.StartDeployment(config.ContainerName, config.ImageName, strategy)
+	if err != nil {
+		cm.Logger.Error("Error recording deployment: %s", err)
+	}
+	return &deployment{cm: cm, id: id, config: config}
+}
+
+func (d *deployment) finish(err error) {
+	if d.id == 0 {
+		return
+	}
+	status, message := database.DeploymentSucceeded, ""
+	if err != nil {
+		status, message = database.DeploymentFailed, err.Error()
+	} else if d.postDeployErr != nil {
+		message = d.postDeployErr.Error()
+	}
+	if err := d.cm.Db.FinishDeployment(d.id, status, message); err != nil {
+		d.cm.Logger.Error("Error recording deployment result: %s", err)
+	}
+}
+
+// preDeploy runs the pre-deploy hooks against the first new instance
+func (d *deployment) preDeploy(ctx context.Context, instances []*database.ContainerInfo) error {
+	if len(d.config.Hooks.PreDeploy) == 0 {
+		return nil
+	}
+	return d.runHooks(ctx, HookPreDeploy, d.config.Hooks.PreDeploy, instances[0])
+}
+
+func (d *deployment) postDeploy(ctx context.Context, instances []*database.ContainerInfo) {
+	if len(d.config.Hooks.PostDeploy) == 0 || len(instances) == 0 {
+		return
+	}
+	if err := d.runHooks(ctx, HookPostDeploy, d.config.Hooks.PostDeploy, instances[0]); err != nil {
+		d.cm.Logger.Warn("Post-deploy hook of %s failed: %s", d.config.ContainerName, err)
+		d.postDeployErr = err
+	}
+}
+
+// runHooks runs hooks in order and stops at the first one that fails
+func (d *deployment) runHooks(ctx context.Context, phase string, hooks []Hook, instance *database.ContainerInfo) error {
+	for _, hook := range hooks {
+		if err := d.runHook(ctx, phase, hook, instance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *deployment) runHook(ctx context.Context, phase string, hook Hook, instance *database.ContainerInfo) error {
+	cm := d.cm
+	command := strings.Join(hook.Command, " ")
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if cm.plan != nil {
+		target := instance.ContainerName
+		if hook.RunIn == HookRunInOneOff {
+			target = "one-off container of " + d.config.ImageName
+		}
+		cm.plan.record("run "+phase+" hook", target, "%s, timeout %s", command, timeout)
+		return nil
+	}
+	cm.Logger.Info("Running %s hook for %s: %s", phase, d.config.ContainerName, command)
+	started := time.Now()
+	var result docker.ExecResult
+	var err error
+	if hook.RunIn == HookRunInOneOff {
+		result, err = cm.runOneOff(hookCtx, d.config, hook.Command)
+	} else {
+		result, err = cm.DockerClient.ExecuteContainerCommand(hookCtx, instance.ContainerID, hook.Command)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	run := database.HookRun{
+		Phase:     phase,
+		Command:   command,
+		ExitCode:  result.ExitCode,
+		Output:    tail(result.Output, maxHookOutput),
+		StartedAt: started,
+		Duration:  time.Since(started),
+	}
+	if err != nil {
+		run.ExitCode = -1
+		run.Error = err.Error()
+	}
+	if d.id != 0 {
+		if err := cm.Db.AddHookRun(d.id, run); err != nil {
+			cm.Logger.Error("Error recording hook run: %s", err)
+		}
+	}
+
+	if err != nil || result.ExitCode != 0 {
+		cm.Logger.Error("%s hook %q failed with code %d: %s", phase, command, run.ExitCode, run.Output)
+		return &HookError{Phase: phase, Command: command, ExitCode: run.ExitCode, Output: run.Output, Err: err}
+	}
+	cm.Logger.Info("%s hook %q succeeded", phase, command)
+	return nil
+}
+
+// runOneOff runs command in a container of its own on the networks of the service, so it
+// reaches what the instances reach, such as a database on a private network
+func (cm *ContainerManager) runOneOff(ctx context.Context, config *ContainerConfig, command []string) (docker.ExecResult, error) {
+	resources, err := config.resources()
+	if err != nil {
+		return docker.ExecResult{}, err
+	}
+	hostConfig := &container.HostConfig{Resources: resources}
+	networking, err := cm.publish(ctx, config.ContainerName, config, nil, hostConfig)
+	if err != nil {
+		return docker.ExecResult{}, err
+	}
+	// Other services reach the instances by their aliases, the one-off takes none of that traffic
+	for _, endpoint := range networking.EndpointsConfig {
+		endpoint.Aliases = nil
+	}
+	return cm.DockerClient.RunContainer(ctx, &container.Config{
+		Image: config.ImageName,
+		Cmd:   command,
+	}, hostConfig, networking)
+}
+
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
@@ -0,0 +1,220 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	hostPattern      = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+	routePathPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~%@+=,-]+)*/?$`)
+)
+
+// RouteSpec routes the requests for a host whose path is Path or below it to the service.
+// When several services share a host the longest matching path wins.
+type RouteSpec struct {
+	Host string `yaml:"host"`
+	// Path is a prefix such as /api, / when empty
+	Path string `yaml:"path,omitempty"`
+	// StripPrefix removes Path from requests before they reach the service
+	StripPrefix bool `yaml:"strip_prefix,omitempty"`
+	// Middleware redirects requests and rewrites their headers before they are forwarded
+	routing.Middleware `yaml:",inline"`
+}
+
+// path is the normalized prefix, without a trailing slash unless it is /
+func (r RouteSpec) path() string {
+	path := strings.TrimSuffix(r.Path, "/")
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// key identifies the route across services, two services cannot have the same one
+func (r RouteSpec) key() string {
+	return strings.ToLower(r.Host) + r.path()
+}
+
+func (r RouteSpec) String() string {
+	s := strings.ToLower(r.Host)
+	if r.path() != "/" {
+		s += r.path()
+	}
+	var options []string
+	if r.StripPrefix {
+		options = append(options, "strip")
+	}
+	if r.HTTPSRedirect {
+		options = append(options, "https")
+	}
+	if r.RedirectToApex {
+		options = append(options, "apex")
+	}
+	if len(options) > 0 {
+		s += ":" + strings.Join(options, ",")
+	}
+	return s
+}
+
+// ParseRouteSpec reads a route given as host[/path][:option,...], such as example.com,
+// example.com/api:strip or www.example.com:apex. The options are strip to strip the path
+// prefix, https to redirect plain HTTP to HTTPS and apex to redirect www. to the apex.
+func ParseRouteSpec(s string) (RouteSpec, error) {
+	value, options, _ := strings.Cut(s, ":")
+	host, path, _ := strings.Cut(value, "/")
+	r := RouteSpec{Host: host, Path: "/" + path}
+	if options != "" {
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "strip":
+				r.StripPrefix = true
+			case "https":
+				r.HTTPSRedirect = true
+			case "apex":
+				r.RedirectToApex = true
+			default:
+				return RouteSpec{}, fmt.Errorf("invalid route %q, use host[/path][:option,...] with the options strip, https and apex", s)
+			}
+		}
+	}
+	return r, r.validate()
+}
+
+// LoadRouteSpecs reads a YAML list of routes, the form of the routes of a manifest, for the
+// middleware that does not fit on the command line
+func LoadRouteSpecs(path string) ([]RouteSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening routes file: %w", err)
+	}
+	defer f.Close()
+	var routes []RouteSpec
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&routes); err != nil {
+		return nil, fmt.Errorf("error parsing routes file: %w", err)
+	}
+	for _, r := range routes {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return routes, nil
+}
+
+func (r RouteSpec) validate() error {
+	if !hostPattern.MatchString(r.Host) {
+		return fmt.Errorf("invalid route host %q", r.Host)
+	}
+	if r.Path != "" && (!routePathPattern.MatchString(r.Path) || strings.Contains(r.Path, "//")) {
+		return fmt.Errorf("invalid path %q of route %s, use a prefix such as /api", r.Path, r.Host)
+	}
+	if r.StripPrefix && r.path() == "/" {
+		return fmt.Errorf("route %s strips its prefix, but its path is /", r.Host)
+	}
+	if err := r.validateMiddleware(); err != nil {
+		return fmt.Errorf("route %s: %w", r.key(), err)
+	}
+	return nil
+}
+
+func validateRoutes(c *ContainerConfig) error {
+	seen := make(map[string]bool)
+	for _, r := range c.Routes {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		if seen[r.key()] {
+			return fmt.Errorf("route %s is listed twice", r.key())
+		}
+		seen[r.key()] = true
+	}
+	if len(c.Routes) == 0 {
+		return nil
+	}
+	routed, ok := c.routedPort()
+	if !ok {
+		return fmt.Errorf("service has routes but no http or grpc port")
+	}
+	if routed.role() == RoleGRPC {
+		for _, r := range c.Routes {
+			if r.StripPrefix {
+				return fmt.Errorf("route %s strips its prefix, gRPC routes cannot", r)
+			}
+			if !r.Middleware.IsZero() {
+				return fmt.Errorf("route %s has middleware, gRPC routes cannot", r)
+			}
+		}
+	}
+	return nil
+}
+
+// httpRoutes are the routes of the service, the whole of DomainName when it lists none
+func (c *ContainerConfig) httpRoutes() []RouteSpec {
+	if len(c.Routes) > 0 {
+		return c.Routes
+	}
+	if c.DomainName == "" {
+		return nil
+	}
+	return []RouteSpec{{Host: c.DomainName}}
+}
+
+// tableRoutes converts the routes of a service for the routing table
+func tableRoutes(routes []RouteSpec) []routing.Route {
+	var result []routing.Route
+	for _, r := range routes {
+		result = append(result, routing.Route{Host: strings.ToLower(r.Host), Path: r.path(), StripPrefix: r.StripPrefix, Middleware: r.Middleware})
+	}
+	return result
+}
+
+// checkRoutes makes sure no other service has a route of a service, the same host and path.
+// It reads the services through db, which may be a transaction.
+func (cm *ContainerManager) checkRoutes(db database.Store, config *ContainerConfig) error {
+	routes := config.httpRoutes()
+	if len(routes) == 0 {
+		return nil
+	}
+	services, err := db.ListServices()
+	if err != nil {
+		return fmt.Errorf("error listing services: %w", err)
+	}
+	for _, service := range services {
+		if service.Name == config.ContainerName {
+			continue
+		}
+		spec, err := cm.serviceSpecIn(db, service.Name)
+		if err != nil {
+			return err
+		}
+		for _, other := range spec.httpRoutes() {
+			for _, r := range routes {
+				if r.key() == other.key() {
+					return fmt.Errorf("route %s is used by %s", r.key(), service.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// claimRoutes checks the routes and public ports of a service against the other services
+// in the transaction tx that saves its spec. The services stay locked until it ends, so
+// two services saved at the same time cannot both get the same route or port.
+func (cm *ContainerManager) claimRoutes(tx database.Store, config *ContainerConfig) error {
+	if err := tx.LockServices(); err != nil {
+		return err
+	}
+	if err := cm.checkPublicPorts(tx, config); err != nil {
+		return err
+	}
+	return cm.checkRoutes(tx, config)
+}
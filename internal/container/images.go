@@ -0,0 +1,162 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// An image bundle is a tar archive holding the service manifest and a docker save archive of its images
+const (
+	bundleManifestFile = "manifest.yaml"
+	bundleImagesFile   = "images.tar"
+)
+
+// ExportServices writes an image bundle containing the images and manifest of the named services to w
+func (cm *ContainerManager) ExportServices(ctx context.Context, names []string, w io.Writer) error {
+	cm.Logger.Info("Exporting services: %v", names)
+	if len(names) == 0 {
+		return errors.New("at least one service is required")
+	}
+
+	manifest := &Manifest{}
+	var images []string
+	for _, name := range names {
+		spec, err := cm.serviceSpec(name)
+		if err != nil {
+			cm.Logger.Error("Error getting service spec for %s: %s", name, err)
+			return fmt.Errorf("error getting service spec for %s: %w", name, err)
+		}
+		manifest.Services = append(manifest.Services, *spec)
+		images = append(images, spec.ImageName)
+	}
+
+	var manifestBuf bytes.Buffer
+	if err := manifest.Write(&manifestBuf); err != nil {
+		return err
+	}
+
+	// The tar header needs the archive size up front, so the docker save output is spooled to disk first
+	saved, err := cm.DockerClient.SaveImages(ctx, images)
+	if err != nil {
+		cm.Logger.Error("Error saving images: %s", err)
+		return fmt.Errorf("error saving images: %w", err)
+	}
+	defer saved.Close()
+
+	spool, err := os.CreateTemp("", "image-bundle-*.tar")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, saved)
+	if err != nil {
+		cm.Logger.Error("Error saving images: %s", err)
+		return fmt.Errorf("error saving images: %w", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error saving images: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	if err := writeBundleFile(tw, bundleManifestFile, int64(manifestBuf.Len()), now, &manifestBuf); err != nil {
+		return err
+	}
+	if err := writeBundleFile(tw, bundleImagesFile, size, now, spool); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error writing image bundle: %w", err)
+	}
+
+	cm.Logger.Info("Exported %d images for services: %v", len(images), names)
+	return nil
+}
+
+// ImportServices loads the images from an image bundle and creates or updates every service in its manifest
+func (cm *ContainerManager) ImportServices(ctx context.Context, r io.Reader, out io.Writer) ([]ContainerConfig, error) {
+	cm.Logger.Info("Importing image bundle")
+
+	var manifest *Manifest
+	imagesLoaded := false
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading image bundle: %w", err)
+		}
+
+		switch header.Name {
+		case bundleManifestFile:
+			if manifest, err = ReadManifest(tr); err != nil {
+				return nil, err
+			}
+		case bundleImagesFile:
+			if cm.plan != nil {
+				// The images are recorded once the manifest names them
+				imagesLoaded = true
+				continue
+			}
+			if err := cm.DockerClient.LoadImages(ctx, tr, out); err != nil {
+				cm.Logger.Error("Error loading images: %s", err)
+				return nil, err
+			}
+			imagesLoaded = true
+		default:
+			cm.Logger.Warn("Skipping unknown file in image bundle: %s", header.Name)
+		}
+	}
+	if manifest == nil || !imagesLoaded {
+		return nil, errors.New("image bundle is missing its manifest or images")
+	}
+	if cm.plan != nil {
+		for _, service := range manifest.Services {
+			if !cm.plan.has("load image", service.ImageName) {
+				cm.plan.record("load image", service.ImageName, "from the image bundle")
+			}
+		}
+	}
+
+	var services []ContainerConfig
+	for _, service := range manifest.Services {
+		config := service
+		// The images came with the bundle, a host without registry access cannot pull them again
+		if config.PullPolicy == "" {
+			config.PullPolicy = PullMissing
+		}
+		if err := cm.deploy(ctx, "import", &config); err != nil {
+			return services, fmt.Errorf("error deploying service %s: %w", service.ContainerName, err)
+		}
+		services = append(services, config)
+	}
+
+	cm.Logger.Info("Imported %d services from image bundle", len(services))
+	return services, nil
+}
+
+func writeBundleFile(tw *tar.Writer, name string, size int64, modTime time.Time, content io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("error writing image bundle: %w", err)
+	}
+	if _, err := io.Copy(tw, content); err != nil {
+		return fmt.Errorf("error writing image bundle: %w", err)
+	}
+	return nil
+}
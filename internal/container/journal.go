@@ -0,0 +1,185 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/docker/docker/api/types/container"
+)
+
+// journal tracks a multi-step change to a service. The entry is written before any Docker
+// side effect and committed in the same transaction as the database changes, so after a
+// crash recovery knows whether to complete the change or undo it.
+type journal struct {
+	cm      *ContainerManager
+	id      int64
+	started []database.JournalContainer
+}
+
+// beginJournal records a change that starts new containers and retires the given instances
+func (cm *ContainerManager) beginJournal(kind, service string, retired []database.ContainerInfo) (*journal, error) {
+	cm.startHeartbeat()
+	id, err := cm.Db.BeginJournal(kind, service, cm.ops.holder, retired)
+	if err != nil {
+		cm.Logger.Error("Error writing journal entry: %s", err)
+		return nil, fmt.Errorf("error writing journal entry: %w", err)
+	}
+	return &journal{cm: cm, id: id}, nil
+}
+
+// starting records a container before it is created
+func (j *journal) starting(name string) error {
+	j.started = append(j.started, database.JournalContainer{Name: name})
+	if err := j.cm.Db.SetJournalStarted(j.id, j.started); err != nil {
+		return fmt.Errorf("error writing journal entry: %w", err)
+	}
+	return nil
+}
+
+// created records the ID Docker gave a container, recovery removes it by name until then
+func (j *journal) created(name, id string) {
+	for i := range j.started {
+		if j.started[i].Name == name {
+			j.started[i].ID = id
+		}
+	}
+	if err := j.cm.Db.SetJournalStarted(j.id, j.started); err != nil {
+		j.cm.Logger.Error("Error writing journal entry: %s", err)
+	}
+}
+
+// commit applies the database changes of the entry in one transaction with its commit
+func (j *journal) commit(fn func(tx database.Store) error) error {
+	return j.cm.Db.WithTx(func(tx database.Store) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.SetJournalState(j.id, database.JournalCommitted)
+	})
+}
+
+// done marks the entry complete once the retired containers are gone
+func (j *journal) done() {
+	if err := j.cm.Db.SetJournalState(j.id, database.JournalDone); err != nil {
+		j.cm.Logger.Error("Error finishing journal entry: %s", err)
+	}
+}
+
+// undone marks the entry reverted once the started containers are gone
+func (j *journal) undone() {
+	if err := j.cm.Db.SetJournalState(j.id, database.JournalUndone); err != nil {
+		j.cm.Logger.Error("Error finishing journal entry: %s", err)
+	}
+}
+
+// retire takes instances out of the database and the routes, together with the changes
+// made by inTx, and then removes their containers. If that is interrupted recovery
+// finishes removing them.
+func (cm *ContainerManager) retire(ctx context.Context, kind, service string, instances []database.ContainerInfo, inTx func(tx database.Store) error) error {
+	j, err := cm.beginJournal(kind, service, instances)
+	if err != nil {
+		return err
+	}
+	err = j.commit(func(tx database.Store) error {
+		for _, info := range instances {
+			if err := tx.DeleteContainer(info.ContainerID); err != nil {
+				cm.Logger.Error("Error removing container info from database: %s", err)
+				return fmt.Errorf("error removing container info from database: %w", err)
+			}
+		}
+		if inTx != nil {
+			return inTx(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		j.undone()
+		return err
+	}
+	cm.applyRoutes()
+
+	var errs []error
+	for _, info := range instances {
+		if err := cm.stopAndRemoveContainer(ctx, info.ContainerID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		// The entry stays committed so recovery retries the removal
+		return errors.Join(errs...)
+	}
+	j.done()
+	return nil
+}
+
+// recoverJournal completes the committed changes of processes that stopped in the middle
+// of one and undoes the rest
+func (cm *ContainerManager) recoverJournal(ctx context.Context) {
+	entries, err := cm.Db.UnfinishedJournal(time.Now().Add(-cm.ops.lease))
+	if err != nil {
+		cm.Logger.Error("Error reading journal: %s", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.State == database.JournalCommitted {
+			cm.Logger.Warn("Completing interrupted %s of %s (journal entry %d)", entry.Kind, entry.ServiceName, entry.ID)
+			err = cm.completeEntry(ctx, entry)
+		} else {
+			cm.Logger.Warn("Undoing interrupted %s of %s (journal entry %d)", entry.Kind, entry.ServiceName, entry.ID)
+			err = cm.undoEntry(ctx, entry)
+		}
+		if err != nil {
+			cm.Logger.Error("Error recovering journal entry %d: %s", entry.ID, err)
+		}
+	}
+	if len(entries) > 0 {
+		cm.applyRoutes()
+	}
+}
+
+// completeEntry removes the retired containers, their rows went with the commit
+func (cm *ContainerManager) completeEntry(ctx context.Context, entry database.JournalEntry) error {
+	for _, info := range entry.Retired {
+		if err := cm.DockerClient.RemoveContainer(ctx, info.ContainerID, container.RemoveOptions{Force: true}); err != nil {
+			cm.Logger.Warn("Error removing retired container %s: %s", info.ContainerName, err)
+		}
+	}
+	return cm.Db.SetJournalState(entry.ID, database.JournalDone)
+}
+
+// undoEntry removes the containers the change started and brings back the retired ones,
+// which may have been stopped and taken out of the database to make room
+func (cm *ContainerManager) undoEntry(ctx context.Context, entry database.JournalEntry) error {
+	retiredNames := make(map[string]bool)
+	for _, info := range entry.Retired {
+		retiredNames[info.ContainerName] = true
+	}
+	for _, started := range entry.Started {
+		ref := started.ID
+		if ref == "" {
+			// Never remove an instance that is being replaced by a container of the same name
+			if retiredNames[started.Name] {
+				continue
+			}
+			ref = started.Name
+		}
+		if err := cm.DockerClient.RemoveContainer(ctx, ref, container.RemoveOptions{Force: true}); err != nil {
+			cm.Logger.Warn("Error removing started container %s: %s", started.Name, err)
+		}
+	}
+
+	for _, info := range entry.Retired {
+		if _, err := cm.Db.GetContainer(info.ContainerID); err != nil {
+			if err := cm.Db.AddContainer(info); err != nil {
+				return fmt.Errorf("error restoring container info of %s: %w", info.ContainerName, err)
+			}
+		}
+		if err := cm.DockerClient.StartContainer(ctx, info.ContainerID); err != nil {
+			cm.Logger.Warn("Error restarting retired container %s: %s", info.ContainerName, err)
+		}
+	}
+	return cm.Db.SetJournalState(entry.ID, database.JournalUndone)
+}
@@ -2,13 +2,18 @@ package container
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
+	"github.com/dgunzy/go-container-orchestrator/config"
 	"github.com/dgunzy/go-container-orchestrator/internal/database"
 	"github.com/dgunzy/go-container-orchestrator/internal/health"
 	"github.com/dgunzy/go-container-orchestrator/internal/logging"
+	"github.com/dgunzy/go-container-orchestrator/internal/policy"
+	"github.com/dgunzy/go-container-orchestrator/internal/proxy"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
 	"github.com/dgunzy/go-container-orchestrator/pkg/docker"
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/joho/godotenv"
@@ -16,24 +21,67 @@ import (
 
 type ContainerManager struct {
 	DockerClient  *docker.DockerClient
-	portFinder    *portFinder
-	Db            *database.Database
+	ports         *portAllocator
+	publishing    publishing
+	Db            database.Store
 	Logger        *logging.Logger
 	HealthChecker *health.HealthChecker
-	// TODO add nginx
+	Policy        *policy.Policy
+	Environment   string
+	// Router receives the routing table whenever instances change, nil when routing is disabled
+	Router routing.Router
+	// Proxy is the built-in reverse proxy when it is one of the routers
+	Proxy    *proxy.Proxy
+	applied  *appliedRoutes
+	rollouts *rolloutRegistry
+	ops      *operationQueue
+	// certs are the TLS certificates of service domains, nil when CERT_STORE_KEY is not set
+	certs *certificates
+	// plan is set on the copy of the manager a dry run uses, Docker side effects are
+	// recorded in it instead of being carried out
+	plan *Plan
 }
 
+// ContainerConfig describes a service. Only the declarative fields are part of a service manifest.
 type ContainerConfig struct {
-	DomainName       string
-	ImageName        string
-	ContainerName    string
-	ContainerID      string
-	ContainerPort    string
-	HostPort         string
-	RegistryUsername string
-	RegistryPassword string
-	Cmd              strslice.StrSlice
-	Status           string
+	DomainName       string            `yaml:"domain_name"`
+	ImageName        string            `yaml:"image_name"`
+	ContainerName    string            `yaml:"container_name"`
+	ContainerID      string            `yaml:"-"`
+	ContainerPort    string            `yaml:"container_port"`
+	HostPort         string            `yaml:"-"`
+	RegistryUsername string            `yaml:"-"`
+	RegistryPassword string            `yaml:"-"`
+	Cmd              strslice.StrSlice `yaml:"cmd,omitempty"`
+	Status           string            `yaml:"-"`
+	// MemoryLimit is a size such as "512m", CPULimit a number of CPUs such as "0.5"
+	MemoryLimit string `yaml:"memory_limit,omitempty"`
+	CPULimit    string `yaml:"cpu_limit,omitempty"`
+	// Replicas is the number of instances, LoadBalancer how requests are spread across them
+	Replicas     int           `yaml:"replicas,omitempty"`
+	LoadBalancer string        `yaml:"load_balancer,omitempty"`
+	Rollout      RolloutConfig `yaml:"rollout,omitempty"`
+	Hooks        Hooks         `yaml:"hooks,omitempty"`
+	// StickyPorts keeps the host port of each replica across updates and restarts. An
+	// update can only hand it over when the old instance stops first, see MaxUnavailable.
+	StickyPorts bool `yaml:"sticky_ports,omitempty"`
+	// Publish is public, loopback or private, PUBLISH_MODE when empty
+	Publish string `yaml:"publish,omitempty"`
+	// Networks are the networks the instances join, where other services on them reach
+	// the service by its name
+	Networks []string `yaml:"networks,omitempty"`
+	// Ports are the named ports of the service, services with a single HTTP port may set
+	// ContainerPort instead
+	Ports []PortSpec `yaml:"ports,omitempty"`
+	// Routes are the hosts and paths the service is reached at, DomainName is routed
+	// as a whole when there are none
+	Routes []RouteSpec `yaml:"routes,omitempty"`
+	// MaxConnections caps the requests in flight to each instance, the routes answer the
+	// requests beyond it like requests over their rate limits
+	MaxConnections int `yaml:"max_connections,omitempty"`
+	// PullPolicy is always, missing or never, always when empty. Images built by the
+	// orchestrator are never pulled.
+	PullPolicy string `yaml:"pull_policy,omitempty"`
 }
 
 func NewContainerManager() (*ContainerManager, error) {
@@ -53,13 +101,17 @@ func NewContainerManager() (*ContainerManager, error) {
 		return nil, fmt.Errorf("error creating Docker client: %w", err)
 	}
 
-	DBPath := os.Getenv("DB_PATH")
-	if DBPath == "" {
+	// DB_DSN selects PostgreSQL with a postgres:// URL, DB_PATH is the file of a SQLite database
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		dsn = os.Getenv("DB_PATH")
+	}
+	if dsn == "" {
 		logger.Warn("No DB_PATH environment variable set, using default database path")
-		DBPath = "./container_manager.db"
+		dsn = "./container_manager.db"
 	}
 
-	db, err := database.NewDatabase(DBPath)
+	db, err := database.Open(dsn)
 	if err != nil {
 		return nil, fmt.Errorf("error creating database: %w", err)
 	}
@@ -70,93 +122,308 @@ func NewContainerManager() (*ContainerManager, error) {
 
 	healthChecker := health.NewHealthChecker(dockerClient, db, 5*time.Minute, logger)
 
+	var imagePolicy *policy.Policy
+	if policyPath := os.Getenv("POLICY_PATH"); policyPath != "" {
+		imagePolicy, err = policy.Load(policyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading image policy: %w", err)
+		}
+	}
+
+	ports, err := newPortAllocator()
+	if err != nil {
+		return nil, err
+	}
+	publishing, err := newPublishing()
+	if err != nil {
+		return nil, err
+	}
+
+	router, builtinProxy, err := newRouters(logger)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up routing: %w", err)
+	}
+	certificates, err := newCertificates(db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up certificates: %w", err)
+	}
+	if builtinProxy != nil && certificates != nil {
+		var challenges proxy.Challenges
+		if certificates.challenges != nil {
+			challenges = certificates.challenges
+		}
+		builtinProxy.EnableTLS(config.GetEnvOrDefault("PROXY_TLS_ADDR", ":443"), challenges)
+	}
+
 	cm := &ContainerManager{
 		DockerClient:  dockerClient,
 		Db:            db,
 		Logger:        logger,
 		HealthChecker: healthChecker,
-		portFinder:    newPortFinder(),
+		Policy:        imagePolicy,
+		Environment:   os.Getenv("ENVIRONMENT"),
+		Router:        router,
+		Proxy:         builtinProxy,
+		applied:       &appliedRoutes{},
+		rollouts:      newRolloutRegistry(),
+		ops:           newOperationQueue(),
+		ports:         ports,
+		publishing:    publishing,
+		certs:         certificates,
 	}
+	healthChecker.OnStatusChange = cm.onHealthChange
+	cm.recoverJournal(context.Background())
+	cm.recoverOperations()
 
 	return cm, nil
 }
 
-func (cm *ContainerManager) CreateNewContainer(ctx context.Context, config *ContainerConfig) error {
+func (cm *ContainerManager) CreateNewContainer(ctx context.Context, config *ContainerConfig) (err error) {
 	cm.Logger.Info("Creating new container: %s", config.ContainerName)
-
-	if err := cm.pullImage(ctx, config); err != nil {
+	started := time.Now()
+	ctx, finish := cm.beginAudit(ctx, "create", config.ContainerName, config)
+	defer func() {
+		finish(err)
+		cm.auditSecrets(ctx, config.ContainerName, nil, config, started, err)
+	}()
+
+	if config.Replicas == 0 {
+		config.Replicas = 1
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	// Checked again when the spec is saved, failing early saves starting the instances
+	if err := cm.checkPublicPorts(cm.Db, config); err != nil {
+		return err
+	}
+	if err := cm.checkRoutes(cm.Db, config); err != nil {
 		return err
 	}
 
-	newHostPort, err := cm.portFinder.findAvailablePort()
-	if err != nil {
-		return fmt.Errorf("error finding available port: %w", err)
+	if err := cm.admitAndPullImage(ctx, config); err != nil {
+		return err
 	}
 
-	containerInfo, err := cm.createAndStartContainer(ctx, config, newHostPort)
+	d := cm.startDeployment(config, "create")
+	defer func() { d.finish(err) }()
+
+	replicas := make([]int, config.Replicas)
+	for i := range replicas {
+		replicas[i] = i
+	}
+	j, err := cm.beginJournal("create", config.ContainerName, nil)
 	if err != nil {
 		return err
 	}
-
-	if err := cm.Db.AddContainer(*containerInfo); err != nil {
-		cm.Logger.Error("Error saving container info to database: %s", err)
-		return fmt.Errorf("error saving container info to database: %w", err)
+	instances, err := cm.startInstances(ctx, j, config, "", replicas)
+	if err == nil {
+		err = d.preDeploy(ctx, instances)
+	}
+	if err == nil {
+		err = j.commit(func(tx database.Store) error {
+			if err := cm.claimRoutes(tx, config); err != nil {
+				return err
+			}
+			for _, info := range instances {
+				if err := tx.AddContainer(*info); err != nil {
+					cm.Logger.Error("Error saving container info to database: %s", err)
+					return fmt.Errorf("error saving container info to database: %w", err)
+				}
+			}
+			return cm.saveServiceSpecIn(tx, config)
+		})
+	}
+	if err != nil {
+		cm.removeInstances(instances)
+		j.undone()
+		return err
 	}
+	j.done()
+	config.ContainerID = instances[0].ContainerID
 
-	//TODO add nginx config to expose this internal port to proxy
+	cm.applyRoutes()
+	cm.requestCertificates()
+	d.postDeploy(ctx, instances)
 	return nil
 }
 
-// the config can just contain the container name and the new image name
-func (cm *ContainerManager) UpdateExistingContainer(ctx context.Context, config *ContainerConfig) error {
+// the config can just contain the container name and the new image name, empty fields keep their current value
+func (cm *ContainerManager) UpdateExistingContainer(ctx context.Context, config *ContainerConfig) (err error) {
 	cm.Logger.Info("Updating existing container: %s", config.ContainerName)
+	started := time.Now()
+	ctx, finish := cm.beginAudit(ctx, "update", cm.ServiceName(config), config)
+	var spec *ContainerConfig
+	defer func() {
+		finish(err)
+		if spec != nil {
+			cm.auditSecrets(ctx, config.ContainerName, spec, config, started, err)
+		}
+	}()
 
-	oldContainerInfo, err := cm.getContainerInfo(config)
+	serviceName, err := cm.resolveServiceName(config)
+	if err != nil {
+		return fmt.Errorf("error getting old container info: %w", err)
+	}
+	spec, err = cm.serviceSpec(serviceName)
+	if err != nil {
+		return fmt.Errorf("error getting old container info: %w", err)
+	}
+	config.ContainerName = serviceName
+	mergeSpec(config, spec)
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.Rollout.Strategy == StrategyCanary && cm.Proxy == nil {
+		// Only the built-in proxy sees the responses the canary's error rate is watched on
+		return errors.New("canary updates need the built-in proxy, add proxy to ROUTER")
+	}
+	// Checked again when the spec is saved, failing early saves starting the instances
+	if err := cm.checkPublicPorts(cm.Db, config); err != nil {
+		return err
+	}
+	if err := cm.checkRoutes(cm.Db, config); err != nil {
+		return err
+	}
+
+	oldInstances, err := cm.Db.GetContainersByService(serviceName)
 	if err != nil {
 		return fmt.Errorf("error getting old container info: %w", err)
 	}
 
-	if err := cm.pullImage(ctx, config); err != nil {
+	if err := cm.admitAndPullImage(ctx, config); err != nil {
 		return err
 	}
 
-	newContainerInfo, err := cm.createAndStartNewContainer(ctx, config)
+	r, err := cm.rollouts.start(serviceName, config.ImageName, config.Rollout.Strategy, config.Replicas)
 	if err != nil {
 		return err
 	}
+	d := cm.startDeployment(config, r.Status().Strategy)
+	var newInstances []*database.ContainerInfo
+	if config.Rollout.Strategy == StrategyCanary {
+		newInstances, err = cm.canaryUpdate(ctx, r, d, config, oldInstances)
+	} else {
+		newInstances, err = cm.rollingUpdate(ctx, r, d, config, spec, oldInstances)
+	}
+	if err == nil {
+		err = cm.Db.WithTx(func(tx database.Store) error {
+			if err := cm.claimRoutes(tx, config); err != nil {
+				return err
+			}
+			return cm.saveServiceSpecIn(tx, config)
+		})
+		if err != nil {
+			// Another service took a route or public port during the rollout, the previous
+			// spec is still the saved one so its instances go back in place
+			err = cm.rollback(r, spec, oldInstances, newInstances, err)
+		} else {
+			// The routing table was last built while the previous spec was saved
+			cm.applyRoutes()
+		}
+	}
+	if err != nil {
+		if !r.finished() {
+			r.set(RolloutAborted, err.Error())
+		}
+		d.finish(err)
+		return err
+	}
+	if len(newInstances) > 0 {
+		config.ContainerID = newInstances[0].ContainerID
+	}
+	d.postDeploy(ctx, newInstances)
+	if d.postDeployErr != nil {
+		r.decide("post-deploy hook failed: %s", d.postDeployErr)
+	}
+	r.set(RolloutCompleted, "")
+	d.finish(nil)
+	cm.pruneNetworks(ctx)
+	cm.requestCertificates()
 
-	// TODO: Implement health check
-	cm.Logger.Info("TODO: Implement health check")
+	cm.Logger.Info("Container update completed: %s", serviceName)
+	return nil
+}
 
-	// TODO: Update Nginx config to use newHostPort
-	cm.Logger.Info("TODO: Update Nginx configuration")
+// RemoveContainer removes a single instance, the service goes away with its last instance
+func (cm *ContainerManager) RemoveContainer(ctx context.Context, containerID string) (err error) {
+	cm.Logger.Info("Removing container: %s", containerID)
 
-	if err := cm.stopAndRemoveContainer(ctx, oldContainerInfo.ContainerID); err != nil {
-		cm.Logger.Error("Error stopping/removing old container: %s", err)
-		// Continue with the update process even if this fails
+	containerInfo, err := cm.Db.GetContainer(containerID)
+	if err != nil {
+		cm.Logger.Error("Error getting container info: %s", err)
+		return fmt.Errorf("error getting container info: %w", err)
 	}
-
-	if err := cm.updateDatabase(oldContainerInfo, newContainerInfo); err != nil {
+	ctx, finish := cm.beginAudit(ctx, "remove", containerInfo.ServiceName, map[string]any{"container_id": containerID, "remove_image": false})
+	defer func() { finish(err) }()
+
+	err = cm.retire(ctx, "remove", containerInfo.ServiceName, []database.ContainerInfo{*containerInfo}, func(tx database.Store) error {
+		// The service goes with its last instance
+		remaining, err := tx.GetContainersByService(containerInfo.ServiceName)
+		if err == nil && len(remaining) == 0 {
+			err = tx.DeleteService(containerInfo.ServiceName)
+		}
+		if err != nil {
+			cm.Logger.Error("Error removing service from database: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	cm.Logger.Info("Container update completed: %s", newContainerInfo.ContainerName)
+	cm.pruneNetworks(ctx)
+
+	cm.Logger.Info("Container removed successfully: %s", containerID)
 	return nil
 }
 
-func (cm *ContainerManager) RemoveContainer(ctx context.Context, containerID string) error {
-	cm.Logger.Info("Removing container: %s", containerID)
+// RemoveService removes every instance of a service and optionally their images
+func (cm *ContainerManager) RemoveService(ctx context.Context, name string, removeImage bool) (err error) {
+	cm.Logger.Info("Removing service: %s", name)
+	ctx, finish := cm.beginAudit(ctx, "remove", cm.ServiceName(&ContainerConfig{ContainerName: name}), RemoveParams(name, removeImage))
+	defer func() { finish(err) }()
+
+	serviceName, err := cm.resolveServiceName(&ContainerConfig{ContainerName: name})
+	if err != nil {
+		return fmt.Errorf("error getting service info: %w", err)
+	}
+	instances, err := cm.Db.GetContainersByService(serviceName)
+	if err != nil {
+		return fmt.Errorf("error getting service info: %w", err)
+	}
 
-	if err := cm.stopAndRemoveContainer(ctx, containerID); err != nil {
+	images := make(map[string]bool)
+	for _, instance := range instances {
+		images[instance.ImageName] = true
+	}
+	err = cm.retire(ctx, "remove", serviceName, instances, func(tx database.Store) error {
+		if err := tx.DeleteService(serviceName); err != nil {
+			cm.Logger.Error("Error removing service from database: %s", err)
+			return fmt.Errorf("error removing service from database: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	if err := cm.Db.DeleteContainer(containerID); err != nil {
-		cm.Logger.Error("Error removing container info from database: %s", err)
-		return fmt.Errorf("error removing container info from database: %w", err)
+	if removeImage {
+		for image := range images {
+			if cm.plan != nil {
+				cm.plan.record("remove image", image, "")
+				continue
+			}
+			if err := cm.DockerClient.RemoveImage(ctx, image); err != nil {
+				cm.Logger.Error("Error removing image: %s", err)
+				return fmt.Errorf("error removing image: %w", err)
+			}
+		}
 	}
 
-	cm.Logger.Info("Container removed successfully: %s", containerID)
+	cm.pruneNetworks(ctx)
+
+	cm.Logger.Info("Service removed successfully: %s", serviceName)
 	return nil
 }
 
@@ -174,36 +441,49 @@ func (cm *ContainerManager) LoadAndStartContainers(ctx context.Context, Cmd strs
 	}
 
 	for _, containerInfo := range containers {
-		config := &ContainerConfig{
-			ImageName:     containerInfo.ImageName,
-			DomainName:    containerInfo.DomainName,
-			ContainerName: containerInfo.ContainerName,
-			ContainerPort: containerInfo.ContainerPort,
-			Cmd:           Cmd,
+		config := &ContainerConfig{}
+		if spec, err := cm.serviceSpec(containerInfo.ServiceName); err == nil {
+			config = spec
+		}
+		config.ImageName = containerInfo.ImageName
+		config.DomainName = containerInfo.DomainName
+		config.ContainerName = containerInfo.ContainerName
+		if len(config.Ports) == 0 {
+			config.ContainerPort = containerInfo.ContainerPort
+		}
+		if Cmd != nil {
+			config.Cmd = Cmd
 		}
 
-		newContainerInfo, err := cm.createAndStartContainer(ctx, config, containerInfo.HostPort)
+		j, err := cm.beginJournal("reload", containerInfo.ServiceName, []database.ContainerInfo{containerInfo})
 		if err != nil {
-			cm.Logger.Error("Error creating/starting container %s: %s", containerInfo.ContainerName, err)
-			continue
+			return err
 		}
-
-		if err := cm.Db.DeleteContainer(containerInfo.ContainerID); err != nil {
-			cm.Logger.Error("Error removing old container info from database: %s", err)
+		newContainerInfo, err := cm.createAndStartContainer(ctx, j, containerInfo.ServiceName, config, containerInfo.Ports)
+		if err != nil {
+			cm.Logger.Error("Error creating/starting container %s: %s", containerInfo.ContainerName, err)
+			j.undone()
 			continue
 		}
+		newContainerInfo.ServiceName = containerInfo.ServiceName
+		newContainerInfo.Replica = containerInfo.Replica
 
-		if err := cm.Db.AddContainer(*newContainerInfo); err != nil {
+		// The old row goes in the same transaction, so a crash never leaves both or neither
+		if err := cm.updateDatabase(j, []database.ContainerInfo{containerInfo}, []*database.ContainerInfo{newContainerInfo}); err != nil {
 			cm.Logger.Error("Error saving new container info to database: %s", err)
-			return fmt.Errorf("error saving new container info to database: %w", err)
+			cm.removeInstances([]*database.ContainerInfo{newContainerInfo})
+			j.undone()
+			return err
 		}
+		j.done()
 	}
+	cm.applyRoutes()
 
 	cm.Logger.Info("Finished loading and starting containers")
 	return nil
 }
 
-func (cm *ContainerManager) RemoveContainerAndImage(ctx context.Context, containerID string) error {
+func (cm *ContainerManager) RemoveContainerAndImage(ctx context.Context, containerID string) (err error) {
 	cm.Logger.Info("Removing container and image: %s", containerID)
 
 	containerInfo, err := cm.Db.GetContainer(containerID)
@@ -211,6 +491,8 @@ func (cm *ContainerManager) RemoveContainerAndImage(ctx context.Context, contain
 		cm.Logger.Error("Error getting container info: %s", err)
 		return fmt.Errorf("error getting container info: %w", err)
 	}
+	ctx, finish := cm.beginAudit(ctx, "remove", containerInfo.ServiceName, map[string]any{"container_id": containerID, "remove_image": true})
+	defer func() { finish(err) }()
 
 	if err := cm.RemoveContainer(ctx, containerID); err != nil {
 		return err
@@ -241,6 +523,7 @@ func (cm *ContainerManager) ListContainers() ([]ContainerConfig, error) {
 			ContainerPort: c.ContainerPort,
 			HostPort:      c.HostPort,
 			Status:        cm.ContainerStatus(c.ContainerID),
+			Ports:         portSpecs(c.Ports),
 		})
 	}
 	return containers, nil
@@ -260,13 +543,31 @@ func (cm *ContainerManager) RunAsDaemon(ctx context.Context) error {
 	// Initialize the HealthChecker if it's not already initialized
 	if cm.HealthChecker == nil {
 		cm.HealthChecker = health.NewHealthChecker(cm.DockerClient, cm.Db, 1*time.Minute, cm.Logger)
+		cm.HealthChecker.OnStatusChange = cm.onHealthChange
+	}
+
+	cm.applyRoutes()
+	go cm.syncRoutes(ctx)
+	if cm.Proxy != nil {
+		go func() {
+			if err := cm.Proxy.ListenAndServe(ctx); err != nil {
+				cm.Logger.Error("Proxy stopped: %s", err)
+			}
+		}()
+		go cm.serveMetrics(ctx)
 	}
+	go cm.runCertificates(ctx)
 
 	// Start the health checker in a separate goroutine
 	healthCheckerCtx, healthCheckerCancel := context.WithCancel(ctx)
 	defer healthCheckerCancel()
 	go cm.HealthChecker.Start(healthCheckerCtx)
 
+	if schedule, ok := cm.backupSchedule(); ok {
+		cm.Logger.Info("Backing up the database to %s every %s", schedule.Dir, schedule.Interval)
+		go database.RunBackups(ctx, cm.Db, schedule)
+	}
+
 	// Main daemon loop
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
@@ -282,3 +583,11 @@ func (cm *ContainerManager) RunAsDaemon(ctx context.Context) error {
 		}
 	}
 }
+
+func (cm *ContainerManager) onHealthChange(containerID string, healthy bool) {
+	cm.Logger.Info("Container %s healthy: %t, updating routes", containerID, healthy)
+	if healthy {
+		cm.refreshAddress(context.Background(), containerID)
+	}
+	cm.applyRoutes()
+}
@@ -1,12 +1,21 @@
 package container_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/dgunzy/go-container-orchestrator/internal/container"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
 	"github.com/dgunzy/go-container-orchestrator/tests"
 	"github.com/docker/docker/api/types"
 	docker_container "github.com/docker/docker/api/types/container"
@@ -129,6 +138,263 @@ func TestUpdateExistingContainer(t *testing.T) {
 	_ = cm.DockerClient.RemoveImage(ctx, testImage2)
 }
 
+func TestRollingUpdate(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+	defer tests.CleanupTestResources(cm.DockerClient)
+
+	config := &container.ContainerConfig{
+		DomainName:    "test-rolling.example.com",
+		ImageName:     testImage,
+		ContainerName: "test-rolling-container",
+		Replicas:      3,
+		Cmd:           []string{"tail", "-f", "/dev/null"},
+	}
+	require.NoError(t, cm.CreateNewContainer(ctx, config), "Error creating service")
+	defer func() { _ = cm.RemoveService(ctx, config.ContainerName, false) }()
+
+	err := cm.UpdateExistingContainer(ctx, &container.ContainerConfig{
+		ContainerName: "test-rolling-container",
+		ImageName:     testImage2,
+		Rollout:       container.RolloutConfig{MaxSurge: 1, MaxUnavailable: 1},
+	})
+	require.NoError(t, err, "Error updating service")
+
+	instances, err := cm.Db.GetContainersByService("test-rolling-container")
+	require.NoError(t, err, "Error listing instances")
+	require.Len(t, instances, 3, "Service should keep its replica count")
+	for _, instance := range instances {
+		assert.Equal(t, testImage2, instance.ImageName)
+		assert.Equal(t, "running", cm.ContainerStatus(instance.ContainerID))
+	}
+
+	status, ok := cm.GetRolloutStatus("test-rolling-container")
+	require.True(t, ok, "Expected a rollout status")
+	assert.Equal(t, container.RolloutCompleted, status.Phase)
+	assert.Equal(t, 3, status.Updated)
+}
+
+func TestDeployHooks(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+	defer tests.CleanupTestResources(cm.DockerClient)
+
+	config := &container.ContainerConfig{
+		DomainName:    "test-hooks.example.com",
+		ImageName:     testImage,
+		ContainerName: "test-hooks-container",
+		Replicas:      2,
+		Cmd:           []string{"tail", "-f", "/dev/null"},
+		Hooks: container.Hooks{
+			PostDeploy: []container.Hook{{Command: []string{"sh", "-c", "echo deployed"}}},
+		},
+	}
+	require.NoError(t, cm.CreateNewContainer(ctx, config), "Error creating service")
+	defer func() { _ = cm.RemoveService(ctx, config.ContainerName, false) }()
+	before, err := cm.Db.GetContainersByService("test-hooks-container")
+	require.NoError(t, err, "Error listing instances")
+
+	err = cm.UpdateExistingContainer(ctx, &container.ContainerConfig{
+		ContainerName: "test-hooks-container",
+		ImageName:     testImage2,
+		Hooks: container.Hooks{
+			PreDeploy: []container.Hook{{Command: []string{"sh", "-c", "echo migrating; exit 3"}, RunIn: container.HookRunInOneOff}},
+		},
+	})
+	var hookErr *container.HookError
+	require.ErrorAs(t, err, &hookErr, "A failing pre-deploy hook should abort the update")
+	assert.Equal(t, 3, hookErr.ExitCode)
+
+	after, err := cm.Db.GetContainersByService("test-hooks-container")
+	require.NoError(t, err, "Error listing instances")
+	assert.ElementsMatch(t, before, after, "The old instances should keep serving")
+
+	deployments, err := cm.Db.ListDeployments("test-hooks-container", 2)
+	require.NoError(t, err, "Error listing deployments")
+	require.Len(t, deployments, 2)
+	assert.Equal(t, "failed", deployments[0].Status)
+	require.Len(t, deployments[0].Hooks, 1)
+	assert.Equal(t, "migrating\n", deployments[0].Hooks[0].Output)
+	assert.Equal(t, "succeeded", deployments[1].Status)
+	require.Len(t, deployments[1].Hooks, 1)
+	assert.Equal(t, "deployed\n", deployments[1].Hooks[0].Output)
+}
+
+func TestOperationQueue(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+	service := fmt.Sprintf("test-ops-%d", time.Now().UnixNano())
+
+	// Each operation checks that it is the only one running on the service
+	var running atomic.Int32
+	var order []int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := cm.RunOperation(ctx, service, "update", nil, func(ctx context.Context) (string, error) {
+				if running.Add(1) != 1 {
+					t.Errorf("operation %d ran concurrently with another one", i)
+				}
+				defer running.Add(-1)
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+				return fmt.Sprintf("result-%d", i), nil
+			})
+			assert.NoError(t, err)
+		}(i)
+		// Give each operation time to queue so the order is known
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	assert.Equal(t, []int{0, 1, 2}, order, "Operations should run in the order they were queued")
+
+	id, err := cm.RunOperation(ctx, service, "remove", nil, func(ctx context.Context) (string, error) {
+		return "", errors.New("boom")
+	})
+	require.Error(t, err)
+	op, err := cm.Db.GetOperation(id)
+	require.NoError(t, err, "Error getting operation")
+	assert.Equal(t, "failed", op.Status)
+	assert.Equal(t, "boom", op.Message)
+
+	ops, err := cm.Db.ListOperations(service, "succeeded", 0)
+	require.NoError(t, err, "Error listing operations")
+	require.Len(t, ops, 3)
+	assert.Equal(t, "result-2", ops[0].Result)
+
+	lock, err := cm.Db.GetServiceLock(service)
+	require.NoError(t, err, "Error getting lock")
+	assert.Nil(t, lock, "The lock should be released once the queue is empty")
+}
+
+func TestDryRunRemove(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+	service := fmt.Sprintf("test-dry-run-%d", time.Now().UnixNano())
+	for replica := 0; replica < 2; replica++ {
+		require.NoError(t, cm.Db.AddContainer(database.ContainerInfo{
+			ContainerID:   fmt.Sprintf("%s-id-%d", service, replica),
+			ContainerName: fmt.Sprintf("%s-%d", service, replica),
+			ImageName:     testImage,
+			DomainName:    "test-dry-run.example.com",
+			HostPort:      fmt.Sprintf("1500%d", replica),
+			ContainerPort: "80",
+			Status:        "running",
+			ServiceName:   service,
+			Replica:       replica,
+		}))
+	}
+	defer func() {
+		for replica := 0; replica < 2; replica++ {
+			_ = cm.Db.DeleteContainer(fmt.Sprintf("%s-id-%d", service, replica))
+		}
+	}()
+
+	plan, err := cm.DryRun(ctx, func(dry *container.ContainerManager) error {
+		return dry.RemoveService(ctx, service, true)
+	})
+	require.NoError(t, err, "Error planning remove")
+
+	var actions []string
+	for _, step := range plan.Steps {
+		actions = append(actions, step.Action+" "+step.Target)
+	}
+	assert.Equal(t, []string{
+		"remove container " + service + "-0",
+		"remove container " + service + "-1",
+		"remove image " + testImage,
+	}, actions)
+	assert.Len(t, plan.RowsDeleted, 2)
+	assert.Empty(t, plan.RowsAdded)
+	require.Len(t, plan.RouteDiff, 2)
+	assert.True(t, strings.HasPrefix(plan.RouteDiff[0], "- test-dry-run.example.com -> 127.0.0.1:15000"))
+
+	instances, err := cm.Db.GetContainersByService(service)
+	require.NoError(t, err, "Error listing instances")
+	assert.Len(t, instances, 2, "A dry run should not change the database")
+}
+
+func TestDryRunCreate(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+	defer tests.CleanupTestResources(cm.DockerClient)
+
+	config := &container.ContainerConfig{
+		DomainName:    "test-dry-create.example.com",
+		ImageName:     testImage,
+		ContainerName: "test-dry-create-container",
+		Replicas:      2,
+	}
+	plan, err := cm.DryRun(ctx, func(dry *container.ContainerManager) error {
+		return dry.CreateNewContainer(ctx, config)
+	})
+	require.NoError(t, err, "Error planning create")
+	assert.Len(t, plan.RowsAdded, 2)
+	assert.Len(t, plan.RouteDiff, 2)
+	started := 0
+	for _, step := range plan.Steps {
+		if step.Action == "start container" {
+			started++
+		}
+	}
+	assert.Equal(t, 2, started)
+
+	containers, err := cm.DockerClient.ListContainers(ctx)
+	require.NoError(t, err, "Error listing containers")
+	for _, c := range containers {
+		assert.NotContains(t, c.Names[0], "test-dry-create", "A dry run should not start containers")
+	}
+	_, err = cm.Db.GetService("test-dry-create-container")
+	assert.Error(t, err, "A dry run should not save the service")
+}
+
+func TestCanaryUpdate(t *testing.T) {
+	ctx := context.Background()
+	// The canary's error rate is watched in the built-in proxy
+	t.Setenv("ROUTER", "proxy")
+	t.Setenv("PROXY_ADDR", "127.0.0.1:0")
+	cm := tests.InitTestConfig()
+	defer tests.CleanupTestResources(cm.DockerClient)
+
+	config := &container.ContainerConfig{
+		DomainName:    "test-canary.example.com",
+		ImageName:     testImage,
+		ContainerName: "test-canary-container",
+		Replicas:      2,
+		Cmd:           []string{"tail", "-f", "/dev/null"},
+	}
+	require.NoError(t, cm.CreateNewContainer(ctx, config), "Error creating service")
+	defer func() { _ = cm.RemoveService(ctx, config.ContainerName, false) }()
+
+	err := cm.UpdateExistingContainer(ctx, &container.ContainerConfig{
+		ContainerName: "test-canary-container",
+		ImageName:     testImage2,
+		Rollout: container.RolloutConfig{
+			Strategy: container.StrategyCanary,
+			Steps:    []int{50, 100},
+			Interval: 2 * time.Second,
+		},
+	})
+	require.NoError(t, err, "Error running canary")
+
+	instances, err := cm.Db.GetContainersByService("test-canary-container")
+	require.NoError(t, err, "Error listing instances")
+	require.Len(t, instances, 2)
+	for _, instance := range instances {
+		assert.Equal(t, testImage2, instance.ImageName)
+	}
+
+	status, ok := cm.GetRolloutStatus("test-canary-container")
+	require.True(t, ok, "Expected a deploy status")
+	assert.Equal(t, container.RolloutCompleted, status.Phase)
+	assert.NotEmpty(t, status.Decisions, "Expected the canary steps to be explained")
+}
+
 func TestLoadAndStartContainers(t *testing.T) {
 	ctx := context.Background()
 	cm := tests.InitTestConfig()
@@ -221,3 +487,436 @@ func TestLoadAndStartContainers(t *testing.T) {
 	_ = cm.DockerClient.RemoveImage(ctx, testImage2)
 
 }
+
+func TestManifestRoundTrip(t *testing.T) {
+	manifest := &container.Manifest{
+		Services: []container.ContainerConfig{
+			{
+				DomainName:       "test.example.com",
+				ImageName:        testImage,
+				ContainerName:    "test-manifest-container",
+				ContainerPort:    "8080",
+				RegistryPassword: "secret",
+				Cmd:              []string{"tail", "-f", "/dev/null"},
+				Replicas:         3,
+				Rollout: container.RolloutConfig{
+					MaxSurge:         1,
+					MaxUnavailable:   1,
+					ReadinessPath:    "/healthz",
+					ReadinessTimeout: 30 * time.Second,
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, manifest.Write(&buf), "Error writing manifest")
+	assert.NotContains(t, buf.String(), "secret", "Registry credentials must not be written to a manifest")
+	assert.Contains(t, buf.String(), "readiness_timeout: 30s")
+
+	loaded, err := container.ReadManifest(&buf)
+	require.NoError(t, err, "Error reading manifest")
+	require.Len(t, loaded.Services, 1)
+	assert.Equal(t, "test-manifest-container", loaded.Services[0].ContainerName)
+	assert.Equal(t, "8080", loaded.Services[0].ContainerPort)
+	assert.Equal(t, []string{"tail", "-f", "/dev/null"}, []string(loaded.Services[0].Cmd))
+	assert.Equal(t, 3, loaded.Services[0].Replicas)
+	assert.Equal(t, manifest.Services[0].Rollout, loaded.Services[0].Rollout)
+
+	_, err = container.ReadManifest(strings.NewReader("version: 1\nservices:\n  - image_name: alpine\n"))
+	assert.Error(t, err, "Expected error for a service without a name")
+}
+
+func TestStateExport(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+	service := fmt.Sprintf("test-state-%d", time.Now().UnixNano())
+	require.NoError(t, cm.Db.AddContainer(database.ContainerInfo{
+		ContainerID:   service + "-id",
+		ContainerName: service,
+		ImageName:     testImage,
+		DomainName:    "test-state.example.com",
+		HostPort:      "15100",
+		ContainerPort: "80",
+		Status:        "running",
+		ServiceName:   service,
+	}))
+	defer func() { _ = cm.Db.DeleteContainer(service + "-id") }()
+	id, err := cm.Db.StartDeployment(service, testImage, "create")
+	require.NoError(t, err, "Error starting deployment")
+	require.NoError(t, cm.Db.FinishDeployment(id, database.DeploymentSucceeded, ""))
+
+	state, err := cm.ExportState()
+	require.NoError(t, err, "Error exporting state")
+	assert.Positive(t, state.SchemaVersion)
+
+	for _, format := range []string{"yaml", "json"} {
+		var buf bytes.Buffer
+		require.NoError(t, container.WriteState(&buf, state, format), "Error writing %s state", format)
+		loaded, err := container.ReadState(&buf)
+		require.NoError(t, err, "Error reading %s state", format)
+
+		var spec *container.ContainerConfig
+		for i := range loaded.Services {
+			if loaded.Services[i].ContainerName == service {
+				spec = &loaded.Services[i]
+			}
+		}
+		require.NotNil(t, spec, "Exported %s state should have the service", format)
+		assert.Equal(t, "test-state.example.com", spec.DomainName)
+		assert.Equal(t, 1, spec.Replicas)
+
+		var history []container.StateDeployment
+		for _, d := range loaded.Deployments {
+			if d.ServiceName == service {
+				history = append(history, d)
+			}
+		}
+		require.Len(t, history, 1)
+		assert.Equal(t, database.DeploymentSucceeded, history[0].Status)
+	}
+
+	// Services that exist are left alone, so importing onto the same host changes nothing
+	result, err := cm.ImportState(ctx, state, nil)
+	require.NoError(t, err, "Error importing state")
+	assert.Contains(t, result.Skipped, service)
+	assert.Empty(t, result.Restored)
+	deployments, err := cm.Db.ListDeployments(service, 0)
+	require.NoError(t, err, "Error listing deployments")
+	assert.Len(t, deployments, 1)
+}
+
+func TestAuditLog(t *testing.T) {
+	cm := tests.InitTestConfig()
+	service := fmt.Sprintf("test-audit-%d", time.Now().UnixNano())
+	ctx := container.WithActor(context.Background(), "cli:tester")
+	config := &container.ContainerConfig{
+		ContainerName:    service,
+		ImageName:        testImage,
+		DomainName:       "test-audit.example.com",
+		RegistryUsername: "deployer",
+		RegistryPassword: "hunter2",
+	}
+
+	id, err := cm.RunOperation(ctx, service, "create", config, func(ctx context.Context) (string, error) {
+		return "", errors.New("mock failure")
+	})
+	require.Error(t, err)
+
+	entries, err := cm.Db.ListAuditLog(database.AuditFilter{ServiceName: service})
+	require.NoError(t, err, "Error listing audit log")
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "cli:tester", entry.Actor)
+	assert.Equal(t, "create", entry.Action)
+	assert.Equal(t, database.AuditFailed, entry.Result)
+	assert.Equal(t, "mock failure", entry.Error)
+	assert.Equal(t, id, entry.OperationID)
+	assert.NotContains(t, entry.Params, "hunter2", "Secrets never reach the audit log")
+	assert.Contains(t, entry.Params, `"registry_password":"[redacted]"`)
+	assert.Contains(t, entry.Params, `"image_name":"alpine:latest"`)
+
+	var buf bytes.Buffer
+	require.NoError(t, container.WriteAuditJSONL(&buf, entries), "Error exporting audit log")
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), "Export should be one JSON object per line")
+	assert.Equal(t, service, record["service"])
+	assert.Equal(t, "[redacted]", record["params"].(map[string]any)["registry_password"])
+
+	// Entry points are audited without an operation around them, secret changes by name only
+	ctx = container.WithSource(ctx, "10.0.0.2:51234")
+	err = cm.CreateNewContainer(ctx, &container.ContainerConfig{
+		ContainerName: service + "-auth",
+		ImageName:     testImage,
+		ContainerPort: "80",
+		Routes: []container.RouteSpec{{Host: "test-audit.example.com", Middleware: routing.Middleware{
+			Auth: routing.Auth{BasicAuth: map[string]string{"admin": "not-a-hash"}},
+		}}},
+	})
+	require.Error(t, err)
+	entries, err = cm.Db.ListAuditLog(database.AuditFilter{ServiceName: service + "-auth"})
+	require.NoError(t, err, "Error listing audit log")
+	require.Len(t, entries, 2)
+	assert.Equal(t, "secret change", entries[0].Action)
+	assert.Equal(t, `{"test-audit.example.com/":{"users":{"added":["admin"]}}}`, entries[0].Params)
+	assert.Equal(t, "create", entries[1].Action)
+	assert.NotContains(t, entries[1].Params, "not-a-hash", "Secrets never reach the audit log")
+	for _, e := range entries {
+		assert.Equal(t, "10.0.0.2:51234", e.Source)
+		assert.Equal(t, database.AuditFailed, e.Result)
+		assert.Zero(t, e.OperationID)
+	}
+
+	now := time.Now()
+	since, err := container.ParseAuditTime("24h", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), since)
+	_, err = container.ParseAuditTime("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestCertificates(t *testing.T) {
+	t.Setenv("CERT_STORE_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	t.Setenv("CERT_WARN_DAYS", "30")
+	cm := tests.InitTestConfig()
+	ctx := container.WithActor(context.Background(), "cli:tester")
+	domain := fmt.Sprintf("test-cert-%d.internal", time.Now().UnixNano())
+
+	cert, err := cm.SelfSignedCertificate(ctx, domain, 10*24*time.Hour)
+	require.NoError(t, err)
+	assert.True(t, container.CertificateExpiring(cert.NotAfter, time.Now()), "10 days is within the 30 day warning")
+	assert.False(t, container.CertificateExpiring(time.Now().Add(60*24*time.Hour), time.Now()))
+
+	_, err = cm.UploadCertificate(ctx, "other-"+domain, cert.CertPEM, cert.KeyPEM)
+	assert.ErrorContains(t, err, "does not cover")
+	uploaded, err := cm.UploadCertificate(ctx, domain, cert.CertPEM, cert.KeyPEM)
+	require.NoError(t, err)
+	assert.Equal(t, "manual", uploaded.Source)
+
+	stored, err := cm.ListCertificates()
+	require.NoError(t, err)
+	var found *database.Certificate
+	for i := range stored {
+		if stored[i].Domain == domain {
+			found = &stored[i]
+		}
+	}
+	require.NotNil(t, found, "Certificate is in the inventory")
+	assert.Equal(t, "manual", found.Source)
+	assert.NotContains(t, found.KeyData, "PRIVATE KEY")
+
+	entries, err := cm.Db.ListAuditLog(database.AuditFilter{Actor: "cli:tester"})
+	require.NoError(t, err)
+	var actions []string
+	for _, e := range entries {
+		if strings.Contains(e.Params, domain) {
+			actions = append(actions, e.Action)
+			assert.NotContains(t, e.Params, "PRIVATE KEY", "Keys never reach the audit log")
+		}
+	}
+	assert.ElementsMatch(t, []string{"cert self-signed", "cert upload", "cert upload"}, actions)
+}
+
+func TestParsePortRanges(t *testing.T) {
+	ranges, err := container.ParsePortRanges("20000-20999, 30000-30010,40000")
+	require.NoError(t, err, "Error parsing port ranges")
+	assert.Equal(t, []container.PortRange{{Min: 20000, Max: 20999}, {Min: 30000, Max: 30010}, {Min: 40000, Max: 40000}}, ranges)
+
+	for _, invalid := range []string{"", "20000-", "30000-20000", "0-100", "60000-70000", "http"} {
+		_, err := container.ParsePortRanges(invalid)
+		assert.Error(t, err, "%q should not parse", invalid)
+	}
+}
+
+func TestParsePortSpec(t *testing.T) {
+	port, err := container.ParsePortSpec("metrics:9090")
+	require.NoError(t, err, "Error parsing port")
+	assert.Equal(t, container.PortSpec{Name: "metrics", ContainerPort: 9090}, port)
+
+	port, err = container.ParsePortSpec("dns:53/udp:tcp:5353")
+	require.NoError(t, err, "Error parsing port")
+	assert.Equal(t, container.PortSpec{Name: "dns", Protocol: "udp", ContainerPort: 53, Role: container.RoleTCP, HostPort: 5353}, port)
+
+	for _, invalid := range []string{"9090", "metrics:", "metrics:http", "metrics:9090/sctp", "web:8080/udp:http", "web:8080:proxy", "Web:8080", "web:8080:http:x"} {
+		_, err := container.ParsePortSpec(invalid)
+		assert.Error(t, err, "%q should not parse", invalid)
+	}
+}
+
+func TestNamedPortsValidation(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+
+	for _, tc := range []struct {
+		config *container.ContainerConfig
+		err    string
+	}{
+		{&container.ContainerConfig{ContainerPort: "80", Ports: []container.PortSpec{{Name: "web", ContainerPort: 80, Role: container.RoleHTTP}}}, "cannot both be set"},
+		{&container.ContainerConfig{Ports: []container.PortSpec{{Name: "web", ContainerPort: 80}, {Name: "web", ContainerPort: 81}}}, "listed twice"},
+		{&container.ContainerConfig{Ports: []container.PortSpec{{Name: "web", ContainerPort: 80, Role: container.RoleHTTP}, {Name: "api", ContainerPort: 81, Role: container.RoleGRPC}}}, "only one can be"},
+		{&container.ContainerConfig{Replicas: 2, Ports: []container.PortSpec{{Name: "web", ContainerPort: 80, HostPort: 8000}}}, "fixed host port"},
+		{&container.ContainerConfig{Ports: []container.PortSpec{{Name: "a", ContainerPort: 80, HostPort: 8000}, {Name: "b", ContainerPort: 81, HostPort: 8000}}}, "more than one port"},
+		{&container.ContainerConfig{Ports: []container.PortSpec{{Name: "game", ContainerPort: 25565, Role: container.RoleTCP, HostPort: 25565, PublicPort: 25565}}}, "is the host port of game"},
+		{&container.ContainerConfig{Rollout: container.RolloutConfig{Strategy: container.StrategyCanary}, Ports: []container.PortSpec{{Name: "web", ContainerPort: 80, HostPort: 8000}}}, "cannot use the canary strategy"},
+	} {
+		tc.config.ContainerName = "test-named-ports"
+		tc.config.ImageName = testImage
+		err := cm.CreateNewContainer(ctx, tc.config)
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestParseRouteSpec(t *testing.T) {
+	route, err := container.ParseRouteSpec("example.com")
+	require.NoError(t, err, "Error parsing route")
+	assert.Equal(t, container.RouteSpec{Host: "example.com", Path: "/"}, route)
+
+	route, err = container.ParseRouteSpec("example.com/api/v1:strip")
+	require.NoError(t, err, "Error parsing route")
+	assert.Equal(t, container.RouteSpec{Host: "example.com", Path: "/api/v1", StripPrefix: true}, route)
+	assert.Equal(t, "example.com/api/v1:strip", route.String())
+
+	route, err = container.ParseRouteSpec("www.example.com:https,apex")
+	require.NoError(t, err, "Error parsing route")
+	assert.True(t, route.HTTPSRedirect)
+	assert.True(t, route.RedirectToApex)
+	assert.Equal(t, "www.example.com:https,apex", route.String())
+
+	for _, invalid := range []string{"", "/api", "example.com:8080", "http://example.com", "example.com:strip", "example.com//api", "example.com/a b", "-example.com", "example.com:apex", "example.com:https,"} {
+		_, err := container.ParseRouteSpec(invalid)
+		assert.Error(t, err, "%q should not parse", invalid)
+	}
+}
+
+func TestRoutesValidation(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+
+	// Another service already serves example.com and example.com/api
+	other := fmt.Sprintf("test-routes-other-%d", time.Now().UnixNano())
+	require.NoError(t, cm.Db.SaveService(database.ServiceInfo{
+		Name: other,
+		Spec: "container_name: " + other + "\ncontainer_port: \"80\"\nroutes:\n  - host: example.com\n  - host: Example.com\n    path: /api/\n",
+	}))
+	t.Cleanup(func() { cm.Db.DeleteService(other) })
+
+	for _, tc := range []struct {
+		routes []container.RouteSpec
+		err    string
+	}{
+		{[]container.RouteSpec{{Host: "www.example.com"}, {Host: "WWW.example.com", Path: "/"}}, "listed twice"},
+		{[]container.RouteSpec{{Host: "example.com", StripPrefix: true}}, "its path is /"},
+		{[]container.RouteSpec{{Host: "example.com", Path: "api"}}, "invalid path"},
+		{[]container.RouteSpec{{Host: "example.com", Path: "/api"}}, "used by " + other},
+		{[]container.RouteSpec{{Host: "www.example.com"}, {Host: "example.com"}}, "route example.com/ is used by " + other},
+	} {
+		err := cm.CreateNewContainer(ctx, &container.ContainerConfig{
+			ContainerName: "test-routes",
+			ImageName:     testImage,
+			ContainerPort: "80",
+			Routes:        tc.routes,
+		})
+		assert.ErrorContains(t, err, tc.err)
+	}
+
+	err := cm.CreateNewContainer(ctx, &container.ContainerConfig{ContainerName: "test-routes", ImageName: testImage, DomainName: "example.com"})
+	assert.ErrorContains(t, err, "used by "+other, "A domain conflicts with a route of the whole domain")
+
+	err = cm.CreateNewContainer(ctx, &container.ContainerConfig{
+		ContainerName: "test-routes",
+		ImageName:     testImage,
+		Ports:         []container.PortSpec{{Name: "metrics", ContainerPort: 9090}},
+		Routes:        []container.RouteSpec{{Host: "metrics.example.com"}},
+	})
+	assert.ErrorContains(t, err, "no http or grpc port")
+}
+
+func TestMiddlewareValidation(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+
+	for _, tc := range []struct {
+		middleware routing.Middleware
+		err        string
+	}{
+		{routing.Middleware{RedirectToApex: true}, "only www. hosts"},
+		{routing.Middleware{Redirects: []routing.Redirect{{From: "/api/old", To: "/new"}, {From: "/api/old", To: "/newer"}}}, "listed twice"},
+		{routing.Middleware{Redirects: []routing.Redirect{{From: "/other/old", To: "/new"}}}, "outside the route path"},
+		{routing.Middleware{Redirects: []routing.Redirect{{From: "/api/old", To: "ftp://example.com/"}}}, "invalid redirect target"},
+		{routing.Middleware{Redirects: []routing.Redirect{{From: "/api/old", To: "/new", Status: 307}}}, "301 or 302"},
+		{routing.Middleware{RequestHeaders: routing.HeaderRules{Set: map[string]string{"Host": "other"}}}, "set by the proxy"},
+		{routing.Middleware{RequestHeaders: routing.HeaderRules{Set: map[string]string{"X-Env": "$secret"}}}, "invalid value"},
+		{routing.Middleware{ResponseHeaders: routing.HeaderRules{Set: map[string]string{"x-env": "a"}, Remove: []string{"X-Env"}}}, "more than one rule"},
+		{routing.Middleware{ResponseHeaders: routing.HeaderRules{Remove: []string{"Bad Header"}}}, "invalid response header name"},
+		{routing.Middleware{HSTS: routing.HSTS{Preload: true}}, "hsts needs a max age"},
+		{routing.Middleware{CORS: routing.CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}}, "any origin"},
+		{routing.Middleware{CORS: routing.CORS{AllowedOrigins: []string{"app.example.com"}}}, "invalid cors origin"},
+		{routing.Middleware{CORS: routing.CORS{AllowedMethods: []string{"GET"}}}, "cors needs allowed origins"},
+		{routing.Middleware{Auth: routing.Auth{Allow: []string{"10.0.0.0/33"}}}, "invalid auth network"},
+		{routing.Middleware{Auth: routing.Auth{BasicAuth: map[string]string{"admin": "secret"}}}, "not a bcrypt hash"},
+		{routing.Middleware{Auth: routing.Auth{BasicAuth: map[string]string{"ad:min": "$2a$10$E7n76Af7BrBF3pSSTRA4vOQJW1BVv0gLo9woc.a7p5AFFKaW88xYa"}}}, "invalid basic auth user"},
+		{routing.Middleware{Auth: routing.Auth{Realm: "Admin"}}, "no basic auth users"},
+		{routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{URL: "http://auth.local/verify", Service: "sso"}}}, "use one"},
+		{routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{URL: "http://auth.local/verify?x=1"}}}, "invalid forward auth url"},
+		{routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{ResponseHeaders: []string{"X-User"}}}}, "needs a url or a service"},
+		{routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{Service: "sso", ResponseHeaders: []string{"X-Forwarded-For"}}}}, "set by the proxy"},
+		{routing.Middleware{Limits: routing.Limits{PerClient: routing.RateLimit{Rate: "10/h"}}}, "per client limit: invalid rate"},
+		{routing.Middleware{Limits: routing.Limits{PerRoute: routing.RateLimit{Rate: "0/s"}}}, "per route limit: invalid rate"},
+		{routing.Middleware{Limits: routing.Limits{PerRoute: routing.RateLimit{Burst: 5}}}, "burst but no rate"},
+		{routing.Middleware{Limits: routing.Limits{PerClient: routing.RateLimit{Rate: "10/s"}, Status: 200}}, "4xx or 5xx"},
+		{routing.Middleware{Limits: routing.Limits{PerClient: routing.RateLimit{Rate: "10/s"}, Message: "slow \"down\""}}, "invalid limit message"},
+	} {
+		err := cm.CreateNewContainer(ctx, &container.ContainerConfig{
+			ContainerName: "test-middleware",
+			ImageName:     testImage,
+			ContainerPort: "80",
+			Routes:        []container.RouteSpec{{Host: "middleware.example.com", Path: "/api", Middleware: tc.middleware}},
+		})
+		assert.ErrorContains(t, err, tc.err)
+	}
+
+	err := cm.CreateNewContainer(ctx, &container.ContainerConfig{
+		ContainerName: "test-middleware",
+		ImageName:     testImage,
+		Ports:         []container.PortSpec{{Name: "rpc", ContainerPort: 50051, Role: "grpc"}},
+		Routes:        []container.RouteSpec{{Host: "rpc.example.com", Middleware: routing.Middleware{HTTPSRedirect: true}}},
+	})
+	assert.ErrorContains(t, err, "gRPC routes cannot")
+
+	err = cm.CreateNewContainer(ctx, &container.ContainerConfig{
+		ContainerName:  "test-middleware",
+		ImageName:      testImage,
+		ContainerPort:  "80",
+		MaxConnections: -1,
+	})
+	assert.ErrorContains(t, err, "max connections must not be negative")
+}
+
+func TestPrivatePublishing(t *testing.T) {
+	ctx := context.Background()
+	cm := tests.InitTestConfig()
+	defer tests.CleanupTestResources(cm.DockerClient)
+
+	err := cm.CreateNewContainer(ctx, &container.ContainerConfig{
+		DomainName:    "test-publish.example.com",
+		ImageName:     testImage,
+		ContainerName: "test-publish",
+		Publish:       "everywhere",
+	})
+	assert.ErrorContains(t, err, "unknown publish mode")
+
+	err = cm.CreateNewContainer(ctx, &container.ContainerConfig{
+		DomainName:    "test-publish.example.com",
+		ImageName:     testImage,
+		ContainerName: "test-publish",
+		Networks:      []string{"-backend"},
+	})
+	assert.ErrorContains(t, err, "invalid network name")
+
+	config := &container.ContainerConfig{
+		DomainName:    "test-private.example.com",
+		ImageName:     testImage,
+		ContainerName: "test-private-publishing",
+		ContainerPort: "80",
+		Publish:       container.PublishPrivate,
+		Cmd:           []string{"sh", "-c", "while true; do nc -l -p 80 < /dev/null; done"},
+	}
+	require.NoError(t, cm.CreateNewContainer(ctx, config), "Error creating container")
+	defer func() { _ = cm.RemoveService(ctx, config.ContainerName, false) }()
+
+	instances, err := cm.Db.GetContainersByService(config.ContainerName)
+	require.NoError(t, err, "Error getting instances")
+	require.Len(t, instances, 1)
+	assert.Empty(t, instances[0].HostPort, "Private instances get no host port")
+	assert.NotEmpty(t, instances[0].Address, "Private instances are reached on the network")
+
+	containers, err := cm.DockerClient.ListContainers(ctx)
+	require.NoError(t, err, "Error listing containers")
+	for _, c := range containers {
+		if c.ID == instances[0].ContainerID {
+			for _, p := range c.Ports {
+				assert.Zero(t, p.PublicPort, "Private instances publish nothing on the host")
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/policy"
+	"gopkg.in/yaml.v3"
+)
+
+const manifestVersion = 1
+
+// Manifest is the on-disk description of a set of services
+type Manifest struct {
+	Version  int               `yaml:"version"`
+	Services []ContainerConfig `yaml:"services"`
+}
+
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %w", err)
+	}
+	if manifest.Version > manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	for i, service := range manifest.Services {
+		if service.ContainerName == "" {
+			return nil, fmt.Errorf("service %d in manifest has no container_name", i)
+		}
+	}
+	return &manifest, nil
+}
+
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening manifest: %w", err)
+	}
+	defer f.Close()
+	return ReadManifest(f)
+}
+
+func (m *Manifest) Write(w io.Writer) error {
+	m.Version = manifestVersion
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(m); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return encoder.Close()
+}
+
+// CheckPolicy checks every service against p, returning one error per service (nil if it passes).
+// labels looks up the labels of an image and may return nil when they are unknown.
+func (m *Manifest) CheckPolicy(p *policy.Policy, environment string, labels func(image string) map[string]string) []error {
+	results := make([]error, len(m.Services))
+	for i, service := range m.Services {
+		results[i] = p.Check(environment, service.PolicySubject(labels(service.ImageName)))
+	}
+	return results
+}
@@ -0,0 +1,34 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serveMetrics serves the counters of the built-in proxy at /metrics on METRICS_ADDR, in
+// the Prometheus text format, until ctx is cancelled
+func (cm *ContainerManager) serveMetrics(ctx context.Context) {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" || cm.Proxy == nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := cm.Proxy.WriteMetrics(w); err != nil {
+			cm.Logger.Error("Error writing metrics: %s", err)
+		}
+	})
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	cm.Logger.Info("Serving metrics on %s", addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		cm.Logger.Error("Metrics server stopped: %s", err)
+	}
+}
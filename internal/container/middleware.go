@@ -0,0 +1,252 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	// Values end up in nginx strings, where quotes and $ would change their meaning
+	headerValuePattern = regexp.MustCompile(`^[^"\\$\x00-\x1f\x7f]*$`)
+	methodPattern      = regexp.MustCompile(`^[A-Z]+$`)
+)
+
+// Headers the proxy sets itself or that describe the connection rather than the message
+var (
+	reservedRequestHeaders  = []string{"Host", "X-Real-IP", "X-Forwarded-For", "X-Forwarded-Proto", "Connection", "Content-Length", "Transfer-Encoding"}
+	reservedResponseHeaders = []string{"Connection", "Content-Length", "Transfer-Encoding"}
+)
+
+func (r RouteSpec) validateMiddleware() error {
+	if r.RedirectToApex && routing.ApexHost(r.Host) == r.Host {
+		return errors.New("only www. hosts redirect to the apex")
+	}
+	from := make(map[string]bool)
+	for _, redirect := range r.Redirects {
+		if err := r.validateRedirect(redirect); err != nil {
+			return err
+		}
+		if from[redirect.From] {
+			return fmt.Errorf("redirect from %s is listed twice", redirect.From)
+		}
+		from[redirect.From] = true
+	}
+	if err := validateHeaderRules("request", r.RequestHeaders, reservedRequestHeaders); err != nil {
+		return err
+	}
+	if err := validateHeaderRules("response", r.ResponseHeaders, reservedResponseHeaders); err != nil {
+		return err
+	}
+	if r.HSTS.MaxAge < 0 {
+		return fmt.Errorf("hsts max age must not be negative, got %d", r.HSTS.MaxAge)
+	}
+	if r.HSTS.MaxAge == 0 && (r.HSTS.IncludeSubdomains || r.HSTS.Preload) {
+		return errors.New("hsts needs a max age")
+	}
+	if err := validateCORS(r.CORS); err != nil {
+		return err
+	}
+	if err := validateAuth(r.Auth); err != nil {
+		return err
+	}
+	return validateLimits(r.Limits)
+}
+
+func (r RouteSpec) validateRedirect(redirect routing.Redirect) error {
+	if !strings.HasPrefix(redirect.From, "/") || !routePathPattern.MatchString(redirect.From) {
+		return fmt.Errorf("invalid redirect from %q, use a path such as /old", redirect.From)
+	}
+	if !(routing.Route{Path: r.path()}).Matches(redirect.From) {
+		return fmt.Errorf("redirect from %s is outside the route path %s", redirect.From, r.path())
+	}
+	target, err := url.Parse(redirect.To)
+	valid := err == nil && headerValuePattern.MatchString(redirect.To) && !strings.ContainsAny(redirect.To, " \t")
+	if valid && target.IsAbs() {
+		valid = (target.Scheme == "http" || target.Scheme == "https") && target.Host != ""
+	} else if valid {
+		valid = strings.HasPrefix(redirect.To, "/")
+	}
+	if !valid {
+		return fmt.Errorf("invalid redirect target %q, use a path or an http or https URL", redirect.To)
+	}
+	if status := redirect.StatusCode(); status != http.StatusMovedPermanently && status != http.StatusFound {
+		return fmt.Errorf("redirect status must be 301 or 302, got %d", redirect.Status)
+	}
+	return nil
+}
+
+// validateHeaderRules checks the names and values of header rules. A header may appear in
+// one rule only, the order nginx and the proxy would apply them in could differ otherwise.
+func validateHeaderRules(kind string, rules routing.HeaderRules, reserved []string) error {
+	seen := make(map[string]bool)
+	check := func(name, value string) error {
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid %s header name %q", kind, name)
+		}
+		if !headerValuePattern.MatchString(value) {
+			return fmt.Errorf("invalid value of %s header %s, quotes, backslashes, $ and control characters are not allowed", kind, name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		for _, r := range reserved {
+			if strings.EqualFold(r, canonical) {
+				return fmt.Errorf("%s header %s is set by the proxy and cannot be changed", kind, name)
+			}
+		}
+		if seen[canonical] {
+			return fmt.Errorf("%s header %s is changed by more than one rule", kind, name)
+		}
+		seen[canonical] = true
+		return nil
+	}
+	for _, name := range rules.Remove {
+		if err := check(name, ""); err != nil {
+			return err
+		}
+	}
+	for name, value := range rules.Set {
+		if err := check(name, value); err != nil {
+			return err
+		}
+	}
+	for name, value := range rules.Add {
+		if err := check(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateCORS(cors routing.CORS) error {
+	if !cors.Enabled() {
+		if len(cors.AllowedMethods) > 0 || len(cors.AllowedHeaders) > 0 || len(cors.ExposedHeaders) > 0 || cors.AllowCredentials || cors.MaxAge != 0 {
+			return errors.New("cors needs allowed origins")
+		}
+		return nil
+	}
+	for _, origin := range cors.AllowedOrigins {
+		if origin == "*" {
+			if cors.AllowCredentials {
+				return errors.New("cors cannot allow credentials for any origin, list the origins")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || !headerValuePattern.MatchString(origin) {
+			return fmt.Errorf("invalid cors origin %q, use a scheme and host such as https://app.example.com", origin)
+		}
+	}
+	for _, method := range cors.AllowedMethods {
+		if !methodPattern.MatchString(method) {
+			return fmt.Errorf("invalid cors method %q", method)
+		}
+	}
+	for _, name := range append(append([]string(nil), cors.AllowedHeaders...), cors.ExposedHeaders...) {
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid cors header %q", name)
+		}
+	}
+	if cors.MaxAge < 0 {
+		return fmt.Errorf("cors max age must not be negative, got %d", cors.MaxAge)
+	}
+	return nil
+}
+
+func validateAuth(auth routing.Auth) error {
+	for _, network := range append(append([]string(nil), auth.Allow...), auth.Deny...) {
+		if _, err := routing.ParseNetwork(network); err != nil {
+			return fmt.Errorf("invalid auth network %q, use a CIDR such as 10.0.0.0/8 or an address", network)
+		}
+	}
+	for user, hash := range auth.BasicAuth {
+		if user == "" || strings.ContainsAny(user, ":") || !headerValuePattern.MatchString(user) {
+			return fmt.Errorf("invalid basic auth user %q", user)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("the password of basic auth user %s is not a bcrypt hash", user)
+		}
+	}
+	if auth.Realm != "" && len(auth.BasicAuth) == 0 {
+		return errors.New("auth has a realm but no basic auth users")
+	}
+	if !headerValuePattern.MatchString(auth.Realm) {
+		return errors.New("invalid auth realm, quotes, backslashes, $ and control characters are not allowed")
+	}
+	return validateForwardAuth(auth.ForwardAuth)
+}
+
+func validateForwardAuth(forward routing.ForwardAuth) error {
+	if !forward.Enabled() {
+		if forward.Path != "" || len(forward.ResponseHeaders) > 0 {
+			return errors.New("forward auth needs a url or a service")
+		}
+		return nil
+	}
+	if forward.URL != "" && forward.Service != "" {
+		return errors.New("forward auth has both a url and a service, use one")
+	}
+	if forward.URL != "" {
+		u, err := url.Parse(forward.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" ||
+			!headerValuePattern.MatchString(forward.URL) || strings.ContainsAny(forward.URL, " \t;{}") {
+			return fmt.Errorf("invalid forward auth url %q, use an http or https URL without a query", forward.URL)
+		}
+		if forward.Path != "" {
+			return errors.New("forward auth path is for a service, put it in the url")
+		}
+	} else if !networkNamePattern.MatchString(forward.Service) {
+		return fmt.Errorf("invalid forward auth service %q", forward.Service)
+	}
+	if forward.Path != "" && (!strings.HasPrefix(forward.Path, "/") || !routePathPattern.MatchString(forward.Path)) {
+		return fmt.Errorf("invalid forward auth path %q", forward.Path)
+	}
+	for _, name := range forward.ResponseHeaders {
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid forward auth response header %q", name)
+		}
+		for _, reserved := range reservedRequestHeaders {
+			if strings.EqualFold(reserved, name) {
+				return fmt.Errorf("forward auth response header %s is set by the proxy and cannot be changed", name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateLimits(limits routing.Limits) error {
+	if err := validateRateLimit("per client", limits.PerClient); err != nil {
+		return err
+	}
+	if err := validateRateLimit("per route", limits.PerRoute); err != nil {
+		return err
+	}
+	if limits.Status != 0 && (limits.Status < 400 || limits.Status > 599) {
+		return fmt.Errorf("limit status must be a 4xx or 5xx status, got %d", limits.Status)
+	}
+	if !headerValuePattern.MatchString(limits.Message) {
+		return errors.New("invalid limit message, quotes, backslashes, $ and control characters are not allowed")
+	}
+	return nil
+}
+
+func validateRateLimit(kind string, limit routing.RateLimit) error {
+	if limit.Enabled() {
+		if _, _, err := routing.ParseRate(limit.Rate); err != nil {
+			return fmt.Errorf("%s limit: %w", kind, err)
+		}
+	}
+	if limit.Burst < 0 {
+		return fmt.Errorf("%s limit burst must not be negative, got %d", kind, limit.Burst)
+	}
+	if limit.Burst > 0 && !limit.Enabled() {
+		return fmt.Errorf("%s limit has a burst but no rate", kind)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/docker/docker/api/types/network"
+	"gopkg.in/yaml.v3"
+)
+
+var networkNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func validateNetworks(networks []string) error {
+	seen := make(map[string]bool, len(networks))
+	for _, name := range networks {
+		if !networkNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid network name %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("network %s is listed twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// joinNetworks creates the networks of a service as needed and returns the endpoints of an
+// instance on them. Other services on a network reach the instance by the service name.
+func (cm *ContainerManager) joinNetworks(ctx context.Context, service string, config *ContainerConfig) (map[string]*network.EndpointSettings, error) {
+	endpoints := make(map[string]*network.EndpointSettings, len(config.Networks))
+	for _, name := range config.Networks {
+		if err := cm.ensureNetwork(ctx, name, config.ContainerName); err != nil {
+			return nil, err
+		}
+		endpoints[name] = &network.EndpointSettings{Aliases: []string{service}}
+	}
+	return endpoints, nil
+}
+
+func (cm *ContainerManager) ensureNetwork(ctx context.Context, name, instance string) error {
+	if cm.plan != nil {
+		cm.plan.record("join network", instance, "%s", name)
+		return nil
+	}
+	if _, err := cm.DockerClient.EnsureNetwork(ctx, name); err != nil {
+		cm.Logger.Error("Error creating network %s: %s", name, err)
+		return fmt.Errorf("error creating network %s: %w", name, err)
+	}
+	return nil
+}
+
+// pruneNetworks removes the networks the orchestrator created that no service lists any
+// more once their last container is gone
+func (cm *ContainerManager) pruneNetworks(ctx context.Context) {
+	if cm.plan != nil {
+		return
+	}
+	keep := map[string]bool{cm.publishing.network: true}
+	services, err := cm.Db.ListServices()
+	if err != nil {
+		cm.Logger.Error("Error listing services: %s", err)
+		return
+	}
+	for _, service := range services {
+		var spec ContainerConfig
+		if err := yaml.Unmarshal([]byte(service.Spec), &spec); err != nil {
+			// Keep everything rather than pull a network from under a service
+			cm.Logger.Error("Error parsing spec of service %s: %s", service.Name, err)
+			return
+		}
+		for _, name := range spec.Networks {
+			keep[name] = true
+		}
+	}
+	removed, err := cm.DockerClient.PruneNetworks(ctx, keep)
+	for _, name := range removed {
+		cm.Logger.Info("Removed unused network %s", name)
+	}
+	if err != nil {
+		cm.Logger.Error("Error pruning networks: %s", err)
+	}
+}
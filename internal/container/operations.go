@@ -0,0 +1,190 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/config"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+)
+
+const (
+	defaultLockLease = 30 * time.Second
+	// lockRetryInterval is how often an operation checks whether another process released the lock
+	lockRetryInterval = time.Second
+)
+
+// operationQueue orders the operations of this process per service. The service locks in
+// the database keep other processes, such as the local cli, out while an operation runs.
+type operationQueue struct {
+	mu sync.Mutex
+	// holder identifies this process in the operations and service_locks tables
+	holder string
+	lease  time.Duration
+	// tails holds, per service, a channel closed when the last queued operation is done
+	tails     map[string]chan struct{}
+	heartbeat sync.Once
+}
+
+func newOperationQueue() *operationQueue {
+	hostname, _ := os.Hostname()
+	lease, err := time.ParseDuration(config.GetEnvOrDefault("SERVICE_LOCK_LEASE", defaultLockLease.String()))
+	if err != nil || lease < 3*time.Second {
+		lease = defaultLockLease
+	}
+	return &operationQueue{
+		holder: fmt.Sprintf("%s/%d/%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		lease:  lease,
+		tails:  make(map[string]chan struct{}),
+	}
+}
+
+// enqueue returns a channel closed when the operation ahead is done and the channel to
+// close once this operation is done
+func (q *operationQueue) enqueue(service string) (<-chan struct{}, chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	prev, ok := q.tails[service]
+	if !ok {
+		prev = make(chan struct{})
+		close(prev)
+	}
+	done := make(chan struct{})
+	q.tails[service] = done
+	return prev, done
+}
+
+func (q *operationQueue) dequeue(service string, done chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	close(done)
+	if q.tails[service] == done {
+		delete(q.tails, service)
+	}
+}
+
+// startHeartbeat keeps the leases of this process alive for as long as it runs
+func (cm *ContainerManager) startHeartbeat() {
+	q := cm.ops
+	q.heartbeat.Do(func() {
+		go func() {
+			ticker := time.NewTicker(q.lease / 3)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := cm.Db.RenewLeases(q.holder, time.Now().Add(q.lease)); err != nil {
+					cm.Logger.Error("Error renewing service locks: %s", err)
+				}
+			}
+		}()
+	})
+}
+
+// RunOperation queues fn as an operation on a service and waits for it. Operations on the
+// same service run one at a time in the order they were queued, fn returns the result
+// recorded for the operation. The operation is recorded in the audit log with params, the
+// request it carries out, and the actor of ctx, in place of the entry points fn calls.
+func (cm *ContainerManager) RunOperation(ctx context.Context, service, kind string, params any, fn func(context.Context) (string, error)) (int64, error) {
+	if cm.plan != nil {
+		_, err := fn(ctx)
+		return 0, err
+	}
+	started := time.Now()
+	id, err := cm.Db.CreateOperation(service, kind, cm.ops.holder)
+	if err != nil {
+		cm.Logger.Error("Error queueing operation: %s", err)
+		err = fmt.Errorf("error queueing operation: %w", err)
+		cm.audit(ctx, kind, service, params, started, 0, err)
+		return 0, err
+	}
+	cm.startHeartbeat()
+
+	prev, done := cm.ops.enqueue(service)
+	defer cm.ops.dequeue(service, done)
+
+	result, err := cm.runOperation(withAudited(ctx, id), id, service, prev, fn)
+	status, message := database.OperationSucceeded, ""
+	if err != nil {
+		status, message = database.OperationFailed, err.Error()
+	}
+	if err := cm.Db.FinishOperation(id, status, message, result); err != nil {
+		cm.Logger.Error("Error recording operation result: %s", err)
+	}
+	cm.audit(ctx, kind, service, params, started, id, err)
+	return id, err
+}
+
+func (cm *ContainerManager) runOperation(ctx context.Context, id int64, service string, prev <-chan struct{}, fn func(context.Context) (string, error)) (string, error) {
+	select {
+	case <-prev:
+	case <-ctx.Done():
+		return "", fmt.Errorf("cancelled while queued: %w", ctx.Err())
+	}
+	if err := cm.acquireServiceLock(ctx, id, service); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := cm.Db.ReleaseServiceLock(service, id); err != nil {
+			cm.Logger.Error("Error releasing lock of %s: %s", service, err)
+		}
+	}()
+
+	if err := cm.Db.StartOperation(id); err != nil {
+		cm.Logger.Error("Error recording operation start: %s", err)
+	}
+	cm.Logger.Info("Running operation %d on %s", id, service)
+	return fn(ctx)
+}
+
+// acquireServiceLock waits until no other process holds the lock of the service
+func (cm *ContainerManager) acquireServiceLock(ctx context.Context, id int64, service string) error {
+	waiting := false
+	for {
+		acquired, err := cm.Db.AcquireServiceLock(service, cm.ops.holder, id, time.Now().Add(cm.ops.lease))
+		if err != nil {
+			return fmt.Errorf("error locking service %s: %w", service, err)
+		}
+		if acquired {
+			return nil
+		}
+		if !waiting {
+			waiting = true
+			message := "waiting for the service lock"
+			if lock, err := cm.Db.GetServiceLock(service); err == nil && lock != nil {
+				message = fmt.Sprintf("waiting for operation %d to release the service lock", lock.OperationID)
+			}
+			cm.Logger.Info("Operation %d on %s is %s", id, service, message)
+			if err := cm.Db.SetOperationMessage(id, message); err != nil {
+				cm.Logger.Error("Error recording operation message: %s", err)
+			}
+		}
+		select {
+		case <-time.After(lockRetryInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("cancelled while waiting for the lock of %s: %w", service, ctx.Err())
+		}
+	}
+}
+
+// ServiceName returns the service a config refers to, falling back to its container name
+// when it does not match an existing service
+func (cm *ContainerManager) ServiceName(config *ContainerConfig) string {
+	if name, err := cm.resolveServiceName(config); err == nil {
+		return name
+	}
+	return config.ContainerName
+}
+
+// recoverOperations fails the operations left unfinished by a process that stopped
+func (cm *ContainerManager) recoverOperations() {
+	failed, err := cm.Db.FailStaleOperations(time.Now().Add(-cm.ops.lease))
+	if err != nil {
+		cm.Logger.Error("Error recovering operations: %s", err)
+		return
+	}
+	if failed > 0 {
+		cm.Logger.Warn("Marked %d interrupted operations as failed", failed)
+	}
+}
@@ -0,0 +1,152 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+)
+
+// plannedIDPrefix marks the IDs given to containers a dry run would start
+const plannedIDPrefix = "planned-"
+
+// Plan is what an operation would do. It is recorded by running the operation itself
+// against a snapshot of the database with its Docker side effects skipped.
+type Plan struct {
+	Steps []PlanStep
+	// RowsAdded and RowsDeleted are the changes to the containers table
+	RowsAdded   []database.ContainerInfo
+	RowsDeleted []database.ContainerInfo
+	// RouteDiff lists the routes that would be removed (-) and added (+)
+	RouteDiff []string
+	// names maps container IDs to names so steps can name the containers they touch
+	names map[string]string
+}
+
+type PlanStep struct {
+	Action string
+	Target string
+	Detail string
+}
+
+func (p *Plan) record(action, target, format string, args ...any) {
+	p.Steps = append(p.Steps, PlanStep{Action: action, Target: target, Detail: fmt.Sprintf(format, args...)})
+}
+
+// pulls reports whether the plan pulls, builds or loads an image, it is not available
+// to inspect yet
+func (p *Plan) pulls(image string) bool {
+	return p.has("pull image", image) || p.provides(image)
+}
+
+// provides reports whether the plan builds or loads an image, which is then used as it is
+func (p *Plan) provides(image string) bool {
+	return p.has("build image", image) || p.has("load image", image)
+}
+
+func (p *Plan) has(action, target string) bool {
+	for _, step := range p.Steps {
+		if step.Action == action && step.Target == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Plan) containerName(id string) string {
+	if name, ok := p.names[id]; ok {
+		return name
+	}
+	return id
+}
+
+// DryRun runs op against a copy of the manager that records what would happen instead of
+// changing anything. The plan is returned along with the error op would fail with.
+func (cm *ContainerManager) DryRun(ctx context.Context, op func(dry *ContainerManager) error) (*Plan, error) {
+	snapshot, err := cm.Db.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("error creating dry run snapshot: %w", err)
+	}
+	defer snapshot.Close()
+
+	before, err := cm.Db.ListContainers()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers from database: %w", err)
+	}
+	routesBefore, err := cm.routingTable()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{names: make(map[string]string)}
+	for _, c := range before {
+		plan.names[c.ContainerID] = c.ContainerName
+	}
+	dry := *cm
+	dry.Db = snapshot
+	dry.plan = plan
+	dry.rollouts = newRolloutRegistry()
+	opErr := op(&dry)
+
+	after, err := snapshot.ListContainers()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers from database: %w", err)
+	}
+	plan.RowsAdded, plan.RowsDeleted = diffContainers(before, after)
+	routesAfter, err := dry.routingTable()
+	if err != nil {
+		return nil, err
+	}
+	plan.RouteDiff = diffLines(routesBefore.Lines(), routesAfter.Lines())
+	return plan, opErr
+}
+
+func diffContainers(before, after []database.ContainerInfo) (added, deleted []database.ContainerInfo) {
+	seen := make(map[string]bool)
+	for _, c := range before {
+		seen[c.ContainerID] = true
+	}
+	kept := make(map[string]bool)
+	for _, c := range after {
+		if seen[c.ContainerID] {
+			kept[c.ContainerID] = true
+		} else {
+			added = append(added, c)
+		}
+	}
+	for _, c := range before {
+		if !kept[c.ContainerID] {
+			deleted = append(deleted, c)
+		}
+	}
+	return added, deleted
+}
+
+func diffLines(before, after []string) []string {
+	count := make(map[string]int)
+	for _, line := range before {
+		count[line]--
+	}
+	for _, line := range after {
+		count[line]++
+	}
+	var diff []string
+	for line, n := range count {
+		for ; n < 0; n++ {
+			diff = append(diff, "- "+line)
+		}
+		for ; n > 0; n-- {
+			diff = append(diff, "+ "+line)
+		}
+	}
+	// Group the changes of each route, removals first
+	sort.Slice(diff, func(i, j int) bool {
+		if diff[i][2:] != diff[j][2:] {
+			return diff[i][2:] < diff[j][2:]
+		}
+		return strings.HasPrefix(diff[i], "-")
+	})
+	return diff
+}
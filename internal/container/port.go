@@ -4,46 +4,269 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/config"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
 )
 
 const (
-	minPort = 10000
-	maxPort = 65535
+	defaultPortRanges    = "10000-65535"
+	defaultReservedPorts = "22,80,443,8080"
+	// portLeaseTimeout is how long a port is held for an instance that is not recorded yet,
+	// long enough for its hooks and readiness check
+	portLeaseTimeout = time.Hour
 )
 
-var reservedPorts = map[int]bool{
-	22:   true, // SSH
-	80:   true, // HTTP
-	443:  true, // HTTPS
-	8080: true, // Alternative HTTP
-	// Add any ports that should not be used here
+// PortRange is an inclusive range of host ports
+type PortRange struct {
+	Min, Max int
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// portAllocator hands out host ports from the configured ranges. The ports are leased in
+// the database so creates in flight and other processes sharing it never get the same port.
+type portAllocator struct {
+	ranges   []PortRange
+	reserved map[int]bool
+	mu       sync.Mutex
+	rng      *rand.Rand
+}
+
+// newPortAllocator reads the ranges from PORT_RANGES, such as 20000-20999,30000-30999, and
+// the ports never handed out from RESERVED_PORTS
+func newPortAllocator() (*portAllocator, error) {
+	ranges, err := ParsePortRanges(config.GetEnvOrDefault("PORT_RANGES", defaultPortRanges))
+	if err != nil {
+		return nil, fmt.Errorf("error reading PORT_RANGES: %w", err)
+	}
+	reserved := make(map[int]bool)
+	for _, field := range strings.Split(config.GetEnvOrDefault("RESERVED_PORTS", defaultReservedPorts), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		port, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("error reading RESERVED_PORTS: invalid port %q", field)
+		}
+		reserved[port] = true
+	}
+	return &portAllocator{
+		ranges:   ranges,
+		reserved: reserved,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// ParsePortRanges reads comma separated ranges such as 20000-20999, a single port is a range too
+func ParsePortRanges(s string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		low, high, found := strings.Cut(field, "-")
+		if !found {
+			high = low
+		}
+		r := PortRange{}
+		var err error
+		if r.Min, err = strconv.Atoi(strings.TrimSpace(low)); err != nil {
+			return nil, fmt.Errorf("invalid port range %q", field)
+		}
+		if r.Max, err = strconv.Atoi(strings.TrimSpace(high)); err != nil {
+			return nil, fmt.Errorf("invalid port range %q", field)
+		}
+		if r.Min < 1 || r.Max > 65535 || r.Min > r.Max {
+			return nil, fmt.Errorf("invalid port range %q, ports go from 1 to 65535", field)
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no port ranges in %q", s)
+	}
+	return ranges, nil
+}
+
+func (pa *portAllocator) size() int {
+	size := 0
+	for _, r := range pa.ranges {
+		size += r.Max - r.Min + 1
+	}
+	return size
+}
+
+// at returns the port at offset i across all ranges
+func (pa *portAllocator) at(i int) int {
+	for _, r := range pa.ranges {
+		if n := r.Max - r.Min + 1; i >= n {
+			i -= n
+		} else {
+			return r.Min + i
+		}
+	}
+	return 0
 }
 
-type portFinder struct {
-	rng *rand.Rand
+func (pa *portAllocator) start() int {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	return pa.rng.Intn(pa.size())
 }
 
-func newPortFinder() *portFinder {
-	return &portFinder{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+// allocatePorts leases the host ports of a replica of a service, one for each of its
+// ports. Private instances publish nothing on the host.
+func (cm *ContainerManager) allocatePorts(config *ContainerConfig, replica int) ([]database.PortMapping, error) {
+	var ports []database.PortMapping
+	for _, p := range config.exposedPorts() {
+		mapping := database.PortMapping{Name: p.Name, Protocol: p.protocol(), ContainerPort: p.ContainerPort, Role: p.role()}
+		if cm.publishMode(config) != PublishPrivate {
+			hostPort, err := cm.allocatePort(config, p, replica)
+			if err != nil {
+				cm.releasePorts(ports)
+				return nil, fmt.Errorf("error allocating host port for %s: %w", p.Name, err)
+			}
+			mapping.HostPort = hostPort
+		}
+		ports = append(ports, mapping)
 	}
+	return ports, nil
 }
-func (pf *portFinder) findAvailablePort() (string, error) {
-	for i := 0; i < 100; i++ { // Try up to 100 times
-		port := pf.rng.Intn(maxPort-minPort+1) + minPort
-		addr := fmt.Sprintf(":%d", port)
 
-		if reservedPorts[port] {
+// allocatePort leases a host port for a port of a replica of a service. Ports with a fixed
+// host port get that one or fail, services with sticky ports get the port the replica had
+// before when it is free. The public ports of services are never handed out, the proxy
+// listens on them.
+func (cm *ContainerManager) allocatePort(config *ContainerConfig, p PortSpec, replica int) (int, error) {
+	leases, err := cm.Db.ListPortLeases()
+	if err != nil {
+		return 0, fmt.Errorf("error listing port leases: %w", err)
+	}
+	public, err := cm.publicPorts()
+	if err != nil {
+		return 0, err
+	}
+	// The spec of a new service is saved once its instances run
+	for _, other := range config.Ports {
+		if other.PublicPort != 0 {
+			public[other.PublicPort] = true
+		}
+	}
+	lease := database.PortLease{
+		ServiceName: config.ContainerName,
+		Replica:     replica,
+		PortName:    p.Name,
+		Protocol:    p.protocol(),
+		Sticky:      config.StickyPorts,
+		ExpiresAt:   time.Now().Add(portLeaseTimeout),
+	}
+
+	if p.HostPort != 0 {
+		lease.Port = p.HostPort
+		ok, err := cm.leasePort(lease)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("host port %d/%s is in use", p.HostPort, p.protocol())
+		}
+		return p.HostPort, nil
+	}
+
+	now := time.Now()
+	leased := make(map[int]bool, len(leases))
+	for _, l := range leases {
+		if l.Protocol != p.protocol() {
 			continue
 		}
+		// Expired leases of instances that were never recorded are taken over by LeasePort
+		leased[l.Port] = l.State() != database.PortPending || l.ExpiresAt.After(now)
+		if config.StickyPorts && l.State() == database.PortReserved && l.ServiceName == config.ContainerName && l.Replica == replica && l.PortName == p.Name && !public[l.Port] {
+			lease.Port = l.Port
+			if ok, err := cm.leasePort(lease); err != nil || ok {
+				return l.Port, err
+			}
+			cm.Logger.Warn("Sticky port %d of %s is in use, allocating another", l.Port, config.ContainerName)
+		}
+	}
+
+	pa := cm.ports
+	size, start := pa.size(), pa.start()
+	for i := 0; i < size; i++ {
+		port := pa.at((start + i) % size)
+		if pa.reserved[port] || leased[port] || public[port] {
+			continue
+		}
+		lease.Port = port
+		ok, err := cm.leasePort(lease)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free host port in %s", pa.describe())
+}
+
+// leasePort leases a port that nothing on this host is listening on
+func (cm *ContainerManager) leasePort(lease database.PortLease) (bool, error) {
+	if !portFree(lease.Protocol, lease.Port) {
+		return false, nil
+	}
+	ok, err := cm.Db.LeasePort(lease)
+	if err != nil {
+		return false, fmt.Errorf("error leasing port %d: %w", lease.Port, err)
+	}
+	return ok, nil
+}
+
+func portFree(protocol string, port int) bool {
+	address := fmt.Sprintf(":%d", port)
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
 
-		listener, err := net.Listen("tcp", addr)
-		if err == nil {
-			listener.Close()
-			return fmt.Sprintf("%d", port), nil
+// releasePorts gives back the host ports of an instance that was never recorded
+func (cm *ContainerManager) releasePorts(ports []database.PortMapping) {
+	for _, p := range ports {
+		if p.HostPort == 0 {
+			continue
+		}
+		if err := cm.Db.ReleasePort(p.HostPort, p.Protocol); err != nil {
+			cm.Logger.Error("Error releasing port %d: %s", p.HostPort, err)
 		}
 	}
+}
+
+// PortRanges are the ranges host ports are allocated from
+func (cm *ContainerManager) PortRanges() []PortRange {
+	return cm.ports.ranges
+}
 
-	return "", fmt.Errorf("could not find an available port after 100 attempts")
+func (pa *portAllocator) describe() string {
+	var ranges []string
+	for _, r := range pa.ranges {
+		ranges = append(ranges, r.String())
+	}
+	return strings.Join(ranges, ",")
 }
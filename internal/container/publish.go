@@ -0,0 +1,166 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/dgunzy/go-container-orchestrator/config"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// How the port of an instance is published. Public binds it on every interface of the
+// host, loopback only where the routers run and private does not publish it at all: the
+// routers reach the instance on a Docker bridge network instead.
+const (
+	PublishPublic   = "public"
+	PublishLoopback = "loopback"
+	PublishPrivate  = "private"
+)
+
+const defaultPrivateNetwork = "orchestrator"
+
+// Instances published on the host are reached over loopback
+const backendHost = "127.0.0.1"
+
+// publishing is the default publishing mode of services and the network private instances join
+type publishing struct {
+	mode    string
+	network string
+}
+
+// newPublishing reads PUBLISH_MODE, public unless set, and PRIVATE_NETWORK
+func newPublishing() (publishing, error) {
+	p := publishing{
+		mode:    config.GetEnvOrDefault("PUBLISH_MODE", PublishPublic),
+		network: config.GetEnvOrDefault("PRIVATE_NETWORK", defaultPrivateNetwork),
+	}
+	if err := validatePublish(p.mode); err != nil {
+		return p, fmt.Errorf("error reading PUBLISH_MODE: %w", err)
+	}
+	return p, nil
+}
+
+func validatePublish(mode string) error {
+	switch mode {
+	case "", PublishPublic, PublishLoopback, PublishPrivate:
+		return nil
+	}
+	return fmt.Errorf("unknown publish mode %q, use public, loopback or private", mode)
+}
+
+// publishMode is how the instances of a service are published, the default mode unless its spec says
+func (cm *ContainerManager) publishMode(config *ContainerConfig) string {
+	if config.Publish != "" {
+		return config.Publish
+	}
+	return cm.publishing.mode
+}
+
+// publish sets up how an instance is reached: port bindings on the host, or an endpoint on
+// the private network. The instance joins the networks of its service in either case.
+func (cm *ContainerManager) publish(ctx context.Context, service string, config *ContainerConfig, ports []database.PortMapping, hostConfig *container.HostConfig) (*network.NetworkingConfig, error) {
+	endpoints, err := cm.joinNetworks(ctx, service, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(config.Networks[0])
+	}
+
+	mode := cm.publishMode(config)
+	if mode != PublishPrivate {
+		hostIP := "0.0.0.0"
+		if mode == PublishLoopback {
+			hostIP = backendHost
+		}
+		hostConfig.PortBindings = nat.PortMap{}
+		for _, p := range ports {
+			if p.HostPort != 0 {
+				hostConfig.PortBindings[mappingPort(p)] = []nat.PortBinding{{HostIP: hostIP, HostPort: strconv.Itoa(p.HostPort)}}
+			}
+		}
+		return &network.NetworkingConfig{EndpointsConfig: endpoints}, nil
+	}
+
+	name := cm.publishing.network
+	if cm.plan != nil {
+		cm.plan.record("join network", config.ContainerName, "%s, no host port", name)
+	} else if _, err := cm.DockerClient.EnsureNetwork(ctx, name); err != nil {
+		cm.Logger.Error("Error creating network %s: %s", name, err)
+		return nil, fmt.Errorf("error creating network %s: %w", name, err)
+	}
+	hostConfig.NetworkMode = container.NetworkMode(name)
+	endpoints[name] = &network.EndpointSettings{Aliases: []string{config.ContainerName, service}}
+	return &network.NetworkingConfig{EndpointsConfig: endpoints}, nil
+}
+
+// instanceAddress is where the routers reach a started instance, empty for instances
+// published on the host
+func (cm *ContainerManager) instanceAddress(ctx context.Context, config *ContainerConfig, containerID string) (string, error) {
+	ports := config.exposedPorts()
+	if cm.publishMode(config) != PublishPrivate || len(ports) == 0 {
+		return "", nil
+	}
+	// Services without a port routed by domain are reached at their first port
+	routed, ok := config.routedPort()
+	if !ok {
+		routed = ports[0]
+	}
+	port := strconv.Itoa(routed.ContainerPort)
+	if cm.plan != nil {
+		return net.JoinHostPort(config.ContainerName, port), nil
+	}
+	ip, err := cm.DockerClient.ContainerIP(ctx, containerID, cm.publishing.network)
+	if err != nil {
+		return "", fmt.Errorf("error getting address of container: %w", err)
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+func backendAddress(info database.ContainerInfo) string {
+	if info.Address != "" {
+		return info.Address
+	}
+	return net.JoinHostPort(backendHost, info.HostPort)
+}
+
+// mappingAddress is where a port of an instance is reached: its host port over loopback,
+// or for private instances the container port at their address on the network
+func mappingAddress(info database.ContainerInfo, p database.PortMapping) string {
+	if p.HostPort != 0 {
+		return net.JoinHostPort(backendHost, strconv.Itoa(p.HostPort))
+	}
+	host, _, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p.ContainerPort))
+}
+
+// refreshAddress records the address of a private instance again, a restart may have
+// given it another one
+func (cm *ContainerManager) refreshAddress(ctx context.Context, containerID string) {
+	info, err := cm.Db.GetContainer(containerID)
+	if err != nil || info.Address == "" {
+		return
+	}
+	ip, err := cm.DockerClient.ContainerIP(ctx, containerID, cm.publishing.network)
+	if err != nil {
+		cm.Logger.Error("Error getting address of container %s: %s", containerID, err)
+		return
+	}
+	_, port, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return
+	}
+	if address := net.JoinHostPort(ip, port); address != info.Address {
+		if err := cm.Db.UpdateContainerAddress(containerID, address); err != nil {
+			cm.Logger.Error("Error updating address of container %s: %s", containerID, err)
+		}
+	}
+}
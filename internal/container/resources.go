@@ -0,0 +1,39 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/policy"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// PolicySubject describes the config for an image policy check, labels may be nil if unknown
+func (c *ContainerConfig) PolicySubject(labels map[string]string) policy.Subject {
+	return policy.Subject{
+		Image:       c.ImageName,
+		Labels:      labels,
+		MemoryLimit: c.MemoryLimit,
+		CPULimit:    c.CPULimit,
+	}
+}
+
+func (c *ContainerConfig) resources() (container.Resources, error) {
+	var resources container.Resources
+	if c.MemoryLimit != "" {
+		memory, err := units.RAMInBytes(c.MemoryLimit)
+		if err != nil {
+			return resources, fmt.Errorf("invalid memory limit %q: %w", c.MemoryLimit, err)
+		}
+		resources.Memory = memory
+	}
+	if c.CPULimit != "" {
+		cpus, err := strconv.ParseFloat(c.CPULimit, 64)
+		if err != nil || cpus <= 0 {
+			return resources, fmt.Errorf("invalid CPU limit %q", c.CPULimit)
+		}
+		resources.NanoCPUs = int64(cpus * 1e9)
+	}
+	return resources, nil
+}
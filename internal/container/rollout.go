@@ -0,0 +1,596 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/docker/docker/api/types"
+)
+
+// RolloutConfig controls how an update replaces the instances of a service. Instances are
+// replaced in batches of MaxSurge+MaxUnavailable, each batch has to become ready before
+// the next one starts.
+type RolloutConfig struct {
+	// Strategy is rolling (the default) or canary
+	Strategy string `yaml:"strategy,omitempty"`
+	// MaxSurge is how many instances may run above the replica count, MaxUnavailable how
+	// many may be missing. Both zero means a surge of one. Services with a fixed host port
+	// stop each batch before starting its replacements whatever these say.
+	MaxSurge       int `yaml:"max_surge,omitempty"`
+	MaxUnavailable int `yaml:"max_unavailable,omitempty"`
+	// ReadinessPath is requested over HTTP on new instances, without it a TCP connect to the port is enough
+	ReadinessPath    string        `yaml:"readiness_path,omitempty"`
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout,omitempty"`
+	// OnFailure is pause (the default) to wait for the operator or abort to roll back right away
+	OnFailure string `yaml:"on_failure,omitempty"`
+
+	// Steps are the percentages of traffic shifted to a canary, each held for Interval while
+	// the canary is watched. The canary is promoted after the last step.
+	Steps    []int         `yaml:"steps,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// MaxErrorRate is the share of canary requests that may fail with a 5xx before it is aborted
+	MaxErrorRate float64 `yaml:"max_error_rate,omitempty"`
+}
+
+const (
+	StrategyRolling = "rolling"
+	StrategyCanary  = "canary"
+)
+
+const (
+	OnFailurePause = "pause"
+	OnFailureAbort = "abort"
+)
+
+const defaultReadinessTimeout = time.Minute
+
+func (r RolloutConfig) validate() error {
+	if r.MaxSurge < 0 || r.MaxUnavailable < 0 {
+		return errors.New("max surge and max unavailable cannot be negative")
+	}
+	if r.ReadinessTimeout < 0 {
+		return errors.New("readiness timeout cannot be negative")
+	}
+	switch r.Strategy {
+	case "", StrategyRolling, StrategyCanary:
+	default:
+		return fmt.Errorf("unknown update strategy %q, expected %s or %s", r.Strategy, StrategyRolling, StrategyCanary)
+	}
+	for i, step := range r.Steps {
+		if step < 1 || step > 100 || (i > 0 && step <= r.Steps[i-1]) {
+			return fmt.Errorf("canary steps must be increasing percentages between 1 and 100, got %v", r.Steps)
+		}
+	}
+	if r.Interval < 0 || r.MaxErrorRate < 0 || r.MaxErrorRate > 1 {
+		return errors.New("canary interval cannot be negative and the error rate must be between 0 and 1")
+	}
+	switch r.OnFailure {
+	case "", OnFailurePause, OnFailureAbort:
+		return nil
+	}
+	return fmt.Errorf("unknown failure action %q, expected %s or %s", r.OnFailure, OnFailurePause, OnFailureAbort)
+}
+
+func (r RolloutConfig) isZero() bool {
+	return reflect.ValueOf(r).IsZero()
+}
+
+// batch returns how many instances are replaced at once and how many of those are
+// stopped before their replacements are ready
+func (r RolloutConfig) batch(replicas int) (size, unavailable int) {
+	surge, unavailable := r.MaxSurge, r.MaxUnavailable
+	if surge == 0 && unavailable == 0 {
+		surge = 1
+	}
+	size = min(surge+unavailable, max(replicas, 1))
+	return size, min(unavailable, size)
+}
+
+type RolloutPhase string
+
+const (
+	RolloutProgressing RolloutPhase = "progressing"
+	RolloutPaused      RolloutPhase = "paused"
+	RolloutRollingBack RolloutPhase = "rolling_back"
+	RolloutCompleted   RolloutPhase = "completed"
+	RolloutAborted     RolloutPhase = "aborted"
+)
+
+// RolloutStatus is the progress of the current or last update of a service
+type RolloutStatus struct {
+	Service   string
+	Image     string
+	Strategy  string
+	Phase     RolloutPhase
+	Updated   int
+	Total     int
+	Message   string
+	StartedAt time.Time
+	UpdatedAt time.Time
+	// CanaryWeight is the percentage of traffic sent to the canary
+	CanaryWeight int
+	// Decisions explains each step the orchestrator took, oldest first
+	Decisions []string
+}
+
+var errRolloutAborted = errors.New("aborted by operator")
+
+type rolloutRegistry struct {
+	mu       sync.Mutex
+	rollouts map[string]*rollout
+}
+
+func newRolloutRegistry() *rolloutRegistry {
+	return &rolloutRegistry{rollouts: make(map[string]*rollout)}
+}
+
+type rollout struct {
+	mu     sync.Mutex
+	status RolloutStatus
+	pause  bool
+	abort  bool
+	// wake is signalled whenever the operator pauses, resumes or aborts
+	wake chan struct{}
+	// canaries are the container IDs receiving CanaryWeight percent of the traffic
+	canaries map[string]bool
+}
+
+func (rr *rolloutRegistry) start(service, image, strategy string, total int) (*rollout, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if r, ok := rr.rollouts[service]; ok && !r.finished() {
+		return nil, fmt.Errorf("an update of %s is already in progress", service)
+	}
+	if strategy == "" {
+		strategy = StrategyRolling
+	}
+	now := time.Now()
+	r := &rollout{
+		status: RolloutStatus{
+			Service:   service,
+			Image:     image,
+			Strategy:  strategy,
+			Phase:     RolloutProgressing,
+			Total:     total,
+			StartedAt: now,
+			UpdatedAt: now,
+		},
+		wake: make(chan struct{}, 1),
+	}
+	rr.rollouts[service] = r
+	return r, nil
+}
+
+func (rr *rolloutRegistry) get(service string) (*rollout, bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	r, ok := rr.rollouts[service]
+	return r, ok
+}
+
+func (r *rollout) Status() RolloutStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status
+	status.Decisions = append([]string(nil), r.status.Decisions...)
+	return status
+}
+
+func (r *rollout) finished() bool {
+	phase := r.Status().Phase
+	return phase == RolloutCompleted || phase == RolloutAborted
+}
+
+func (r *rollout) set(phase RolloutPhase, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Phase = phase
+	r.status.Message = message
+	r.status.UpdatedAt = time.Now()
+}
+
+func (r *rollout) progress(updated int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Updated = updated
+	r.status.UpdatedAt = time.Now()
+}
+
+// decide records why the rollout did what it did
+func (r *rollout) decide(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Decisions = append(r.status.Decisions, time.Now().Format("15:04:05")+" "+fmt.Sprintf(format, args...))
+	r.status.UpdatedAt = time.Now()
+}
+
+// fail pauses the rollout until the operator resumes or aborts it
+func (r *rollout) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pause = true
+	r.status.Phase = RolloutPaused
+	r.status.Message = err.Error()
+	r.status.UpdatedAt = time.Now()
+}
+
+// control applies an operator action, rollouts that already finished cannot be changed
+func (r *rollout) control(apply func()) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Phase == RolloutCompleted || r.status.Phase == RolloutAborted || r.status.Phase == RolloutRollingBack {
+		return fmt.Errorf("update of %s is %s", r.status.Service, r.status.Phase)
+	}
+	apply()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// checkpoint blocks while the rollout is paused and fails once it is aborted
+func (r *rollout) checkpoint(ctx context.Context) error {
+	for {
+		r.mu.Lock()
+		abort, pause := r.abort, r.pause
+		if !abort && pause && r.status.Phase != RolloutPaused {
+			r.status.Phase = RolloutPaused
+			r.status.UpdatedAt = time.Now()
+		}
+		if !abort && !pause && r.status.Phase == RolloutPaused {
+			r.status.Phase = RolloutProgressing
+			r.status.Message = ""
+			r.status.UpdatedAt = time.Now()
+		}
+		r.mu.Unlock()
+
+		if abort {
+			return errRolloutAborted
+		}
+		if !pause {
+			return nil
+		}
+		select {
+		case <-r.wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (cm *ContainerManager) GetRolloutStatus(service string) (RolloutStatus, bool) {
+	r, ok := cm.rollouts.get(service)
+	if !ok {
+		return RolloutStatus{}, false
+	}
+	return r.Status(), true
+}
+
+// PauseRollout stops an update after the batch in progress
+func (cm *ContainerManager) PauseRollout(ctx context.Context, service string) error {
+	return cm.controlRollout(ctx, "pause", service, func(r *rollout) { r.pause = true })
+}
+
+// ResumeRollout continues a paused update, retrying the batch that failed if any
+func (cm *ContainerManager) ResumeRollout(ctx context.Context, service string) error {
+	return cm.controlRollout(ctx, "resume", service, func(r *rollout) { r.pause = false })
+}
+
+// AbortRollout rolls an update back to the previous spec
+func (cm *ContainerManager) AbortRollout(ctx context.Context, service string) error {
+	return cm.controlRollout(ctx, "abort", service, func(r *rollout) { r.abort = true })
+}
+
+func (cm *ContainerManager) controlRollout(ctx context.Context, action, service string, apply func(r *rollout)) (err error) {
+	started := time.Now()
+	defer func() { cm.audit(ctx, "rollout "+action, service, nil, started, 0, err) }()
+	r, ok := cm.rollouts.get(service)
+	if !ok {
+		return fmt.Errorf("no update of %s in progress", service)
+	}
+	return r.control(func() { apply(r) })
+}
+
+// rollingUpdate replaces the old instances with instances of config batch by batch and
+// returns the new instances. On failure the update pauses or rolls back to previous.
+func (cm *ContainerManager) rollingUpdate(ctx context.Context, r *rollout, d *deployment, config, previous *ContainerConfig, old []database.ContainerInfo) ([]*database.ContainerInfo, error) {
+	suffix := time.Now().Format("20060102150405")
+	positions := max(config.Replicas, len(old))
+	size, unavailable := config.Rollout.batch(positions)
+	if config.fixedHostPort() {
+		// The replacement cannot bind the host port while the old instance holds it
+		unavailable = size
+	}
+
+	var updated []*database.ContainerInfo
+	for next := 0; next < positions; next += size {
+		end := min(next+size, positions)
+		replaced := old[min(next, len(old)):min(end, len(old))]
+		var replicas []int
+		for replica := next; replica < min(end, config.Replicas); replica++ {
+			replicas = append(replicas, replica)
+		}
+
+		for {
+			if err := r.checkpoint(ctx); err != nil {
+				return nil, cm.rollback(r, previous, old[:min(next, len(old))], updated, err)
+			}
+
+			// Pre-deploy hooks run once, against the first batch
+			var prepare func([]*database.ContainerInfo) error
+			if next == 0 {
+				prepare = func(started []*database.ContainerInfo) error { return d.preDeploy(ctx, started) }
+			}
+			started, err := cm.replaceBatch(ctx, config, suffix, replicas, replaced, unavailable, prepare)
+			if err == nil {
+				updated = append(updated, started...)
+				break
+			}
+			cm.Logger.Error("Rolling update of %s failed: %s", config.ContainerName, err)
+			var hookErr *HookError
+			if config.Rollout.OnFailure == OnFailureAbort || errors.As(err, &hookErr) {
+				return nil, cm.rollback(r, previous, old[:min(next, len(old))], updated, err)
+			}
+			r.fail(err)
+		}
+
+		r.progress(len(updated))
+		cm.Logger.Info("Rolling update of %s: %d/%d instances updated", config.ContainerName, len(updated), config.Replicas)
+	}
+	return updated, nil
+}
+
+// replaceBatch starts the given replicas and replaces the old instances with them once
+// they are prepared and ready. Up to unavailable old instances are stopped first to make room.
+func (cm *ContainerManager) replaceBatch(ctx context.Context, config *ContainerConfig, suffix string, replicas []int, old []database.ContainerInfo, unavailable int, prepare func([]*database.ContainerInfo) error) ([]*database.ContainerInfo, error) {
+	j, err := cm.beginJournal("replace", config.ContainerName, old)
+	if err != nil {
+		return nil, err
+	}
+	drained := old[:min(unavailable, len(old))]
+	for _, info := range drained {
+		if err := cm.Db.DeleteContainer(info.ContainerID); err != nil {
+			return nil, fmt.Errorf("error removing old container info from database: %w", err)
+		}
+	}
+	if len(drained) > 0 {
+		cm.applyRoutes()
+	}
+	for _, info := range drained {
+		if cm.plan != nil {
+			cm.plan.record("stop container", info.ContainerName, "to make room for the new instances")
+			continue
+		}
+		if err := cm.DockerClient.StopContainer(ctx, info.ContainerID, nil); err != nil {
+			cm.Logger.Error("Error stopping old container: %s", err)
+		}
+	}
+
+	started, err := cm.startInstances(ctx, j, config, suffix, replicas)
+	if err == nil && prepare != nil && len(started) > 0 {
+		if err = prepare(started); err != nil {
+			cm.removeInstances(started)
+		}
+	}
+	if err == nil {
+		err = cm.waitReady(ctx, config, started)
+	}
+	if err == nil {
+		if err = cm.updateDatabase(j, old[len(drained):], started); err != nil {
+			cm.removeInstances(started)
+		}
+	}
+	if err != nil {
+		// Bring the stopped instances back so the service is at the capacity it started with
+		for _, info := range drained {
+			if cm.plan != nil {
+				cm.plan.record("restart container", info.ContainerName, "the new instances failed")
+			} else if err := cm.DockerClient.StartContainer(context.Background(), info.ContainerID); err != nil {
+				cm.Logger.Error("Error restarting old container: %s", err)
+			}
+			if err := cm.Db.AddContainer(info); err != nil {
+				cm.Logger.Error("Error restoring old container info to database: %s", err)
+			}
+		}
+		cm.applyRoutes()
+		j.undone()
+		return nil, err
+	}
+	// Route to the new instances before the old ones go away
+	cm.applyRoutes()
+
+	for _, info := range old {
+		if err := cm.stopAndRemoveContainer(ctx, info.ContainerID); err != nil {
+			cm.Logger.Error("Error stopping/removing old container: %s", err)
+			// Continue with the update process even if this fails
+		}
+	}
+	j.done()
+	return started, nil
+}
+
+// rollback puts instances of the previous spec back in place of the updated instances
+func (cm *ContainerManager) rollback(r *rollout, previous *ContainerConfig, replaced []database.ContainerInfo, updated []*database.ContainerInfo, cause error) error {
+	// The update context may be what got cancelled, the rollback has to finish regardless
+	ctx := context.Background()
+	r.set(RolloutRollingBack, cause.Error())
+	cm.Logger.Warn("Rolling back update of %s: %s", previous.ContainerName, cause)
+	started := time.Now()
+	params := map[string]string{"image_name": previous.ImageName, "cause": cause.Error()}
+
+	var replicas []int
+	for i := range replaced {
+		replicas = append(replicas, i)
+	}
+	var current []database.ContainerInfo
+	for _, info := range updated {
+		current = append(current, *info)
+	}
+	j, err := cm.beginJournal("rollback", previous.ContainerName, current)
+	if err != nil {
+		r.set(RolloutAborted, fmt.Sprintf("%s, rollback failed: %s", cause, err))
+		cm.audit(ctx, "rollback", previous.ContainerName, params, started, 0, err)
+		return err
+	}
+	// The updated instances hold a fixed host port until they stop
+	stopFirst := previous.fixedHostPort()
+	if stopFirst {
+		for _, info := range updated {
+			if cm.plan != nil {
+				cm.plan.record("stop container", info.ContainerName, "to free its host ports for the previous instances")
+			} else if err := cm.DockerClient.StopContainer(ctx, info.ContainerID, nil); err != nil {
+				cm.Logger.Error("Error stopping updated container: %s", err)
+			}
+		}
+	}
+	restored, err := cm.startInstances(ctx, j, previous, time.Now().Format("20060102150405"), replicas)
+	if err == nil {
+		if err = cm.updateDatabase(j, current, restored); err != nil {
+			cm.removeInstances(restored)
+		}
+	}
+	if err != nil {
+		if stopFirst {
+			for _, info := range updated {
+				if cm.plan != nil {
+					cm.plan.record("restart container", info.ContainerName, "the previous instances failed")
+				} else if err := cm.DockerClient.StartContainer(ctx, info.ContainerID); err != nil {
+					cm.Logger.Error("Error restarting updated container: %s", err)
+				}
+			}
+		}
+		j.undone()
+		r.set(RolloutAborted, fmt.Sprintf("%s, rollback failed: %s", cause, err))
+		cm.audit(ctx, "rollback", previous.ContainerName, params, started, 0, err)
+		return fmt.Errorf("error rolling back update of %s: %w", previous.ContainerName, err)
+	}
+	cm.applyRoutes()
+	for _, info := range updated {
+		if err := cm.stopAndRemoveContainer(ctx, info.ContainerID); err != nil {
+			cm.Logger.Error("Error stopping/removing updated container: %s", err)
+		}
+	}
+	j.done()
+	cm.audit(ctx, "rollback", previous.ContainerName, params, started, 0, nil)
+
+	r.set(RolloutAborted, cause.Error())
+	return fmt.Errorf("update of %s rolled back: %w", previous.ContainerName, cause)
+}
+
+// waitReady waits for every instance to pass its readiness check, removing them all if one does not
+func (cm *ContainerManager) waitReady(ctx context.Context, config *ContainerConfig, instances []*database.ContainerInfo) error {
+	timeout := config.Rollout.ReadinessTimeout
+	if timeout == 0 {
+		timeout = defaultReadinessTimeout
+	}
+	if cm.plan != nil {
+		for _, info := range instances {
+			cm.plan.record("wait until ready", info.ContainerName, "up to %s", timeout)
+		}
+		return nil
+	}
+	readyCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, info := range instances {
+		if err := cm.waitInstanceReady(readyCtx, config, info); err != nil {
+			cm.removeInstances(instances)
+			return fmt.Errorf("instance %s is not ready: %w", info.ContainerName, err)
+		}
+	}
+	return nil
+}
+
+func (cm *ContainerManager) waitInstanceReady(ctx context.Context, config *ContainerConfig, info *database.ContainerInfo) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		ready, err := cm.probeReadiness(ctx, config, info)
+		if err != nil {
+			return err
+		}
+		if ready {
+			cm.Logger.Info("Instance %s is ready", info.ContainerName)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeReadiness reports whether an instance is ready, an error means it will never be
+func (cm *ContainerManager) probeReadiness(ctx context.Context, config *ContainerConfig, info *database.ContainerInfo) (bool, error) {
+	state, err := cm.DockerClient.HealthCheck(ctx, info.ContainerID)
+	if err != nil {
+		cm.Logger.Warn("Error checking instance %s: %s", info.ContainerName, err)
+		return false, nil
+	}
+	if state.Status == "exited" || state.Status == "dead" {
+		return false, fmt.Errorf("container %s with exit code %d", state.Status, state.ExitCode)
+	}
+	if state.Status != "running" {
+		return false, nil
+	}
+	if state.Health != nil && state.Health.Status != types.Healthy {
+		return false, nil
+	}
+	if _, ok := config.routedPort(); !ok {
+		return true, nil
+	}
+
+	if config.Rollout.ReadinessPath == "" {
+		address, err := cm.containerAddress(ctx, config, info)
+		if err != nil {
+			cm.Logger.Warn("Error getting address of instance %s: %s", info.ContainerName, err)
+			return false, nil
+		}
+		conn, err := net.DialTimeout("tcp", address, 2*time.Second)
+		if err != nil {
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	}
+
+	address := backendAddress(*info)
+	probeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(probeCtx, http.MethodGet, "http://"+address+config.Rollout.ReadinessPath, nil)
+	if err != nil {
+		return false, fmt.Errorf("invalid readiness path: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, nil
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusBadRequest, nil
+}
+
+// containerAddress is the routed port of an instance on its own network. docker-proxy
+// accepts connections on the host port as soon as the container starts, only the
+// application listens on this one.
+func (cm *ContainerManager) containerAddress(ctx context.Context, config *ContainerConfig, info *database.ContainerInfo) (string, error) {
+	if info.Address != "" {
+		return info.Address, nil
+	}
+	routed, _ := config.routedPort()
+	ip, err := cm.DockerClient.ContainerIP(ctx, info.ContainerID, "")
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(routed.ContainerPort)), nil
+}
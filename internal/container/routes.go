@@ -0,0 +1,207 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/config"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/health"
+	"github.com/dgunzy/go-container-orchestrator/internal/logging"
+	"github.com/dgunzy/go-container-orchestrator/internal/nginx"
+	"github.com/dgunzy/go-container-orchestrator/internal/proxy"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+)
+
+// newRouters sets up the routers named in ROUTER, a comma separated list of nginx and proxy
+func newRouters(logger *logging.Logger) (routing.Router, *proxy.Proxy, error) {
+	var routers []routing.Router
+	var builtin *proxy.Proxy
+	for _, name := range strings.Split(os.Getenv("ROUTER"), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "nginx":
+			routers = append(routers, nginx.NewGenerator(
+				config.GetEnvOrDefault("NGINX_CONFIG_PATH", "/etc/nginx/conf.d/orchestrator.conf"),
+				strings.Fields(config.GetEnvOrDefault("NGINX_RELOAD_CMD", "nginx -s reload")),
+				logger))
+		case "proxy":
+			builtin = proxy.New(config.GetEnvOrDefault("PROXY_ADDR", ":80"), logger)
+			routers = append(routers, builtin)
+		default:
+			return nil, nil, fmt.Errorf("unknown router %q", name)
+		}
+	}
+	if len(routers) == 0 {
+		return nil, nil, nil
+	}
+	return routing.NewGroup(routers...), builtin, nil
+}
+
+// routingTable builds the routing table from the instances in the database
+func (cm *ContainerManager) routingTable() (routing.Table, error) {
+	containers, err := cm.Db.ListContainers()
+	if err != nil {
+		return routing.Table{}, fmt.Errorf("error listing containers from database: %w", err)
+	}
+	instances := make(map[string][]database.ContainerInfo)
+	for _, c := range containers {
+		instances[c.ServiceName] = append(instances[c.ServiceName], c)
+	}
+	names := make([]string, 0, len(instances))
+	for name := range instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var table routing.Table
+	for _, name := range names {
+		spec, err := cm.serviceSpec(name)
+		if err != nil {
+			return routing.Table{}, err
+		}
+		table.Streams = append(table.Streams, serviceStreams(name, spec, instances[name], cm.HealthChecker)...)
+		routed, ok := spec.routedPort()
+		if !ok {
+			// Services without a port routed by domain take no HTTP traffic
+			continue
+		}
+		strategy, err := routing.ParseStrategy(spec.LoadBalancer)
+		if err != nil {
+			cm.Logger.Error("Service %s: %s, using round robin", name, err)
+		}
+
+		service := routing.Service{
+			Name:           name,
+			Domain:         spec.DomainName,
+			Routes:         tableRoutes(spec.Routes),
+			Strategy:       strategy,
+			GRPC:           routed.role() == RoleGRPC,
+			MaxConnections: spec.MaxConnections,
+		}
+		for _, c := range instances[name] {
+			service.Backends = append(service.Backends, routing.Backend{
+				ContainerID: c.ContainerID,
+				Address:     backendAddress(c),
+				Healthy:     cm.HealthChecker == nil || cm.HealthChecker.IsHealthy(c.ContainerID),
+			})
+		}
+		if r, ok := cm.rollouts.get(name); ok {
+			r.weigh(service.Backends)
+		}
+		table.Services = append(table.Services, service)
+	}
+	table.Certificates = cm.tableCertificates(table.Services)
+	if cm.certs != nil {
+		table.ChallengeAddr = cm.certs.challengeAddr
+	}
+	return table, nil
+}
+
+// serviceStreams are the streams of the tcp ports of a service that have a public port
+func serviceStreams(name string, spec *ContainerConfig, instances []database.ContainerInfo, checker *health.HealthChecker) []routing.Stream {
+	var streams []routing.Stream
+	for _, p := range spec.Ports {
+		if p.role() != RoleTCP || p.PublicPort == 0 {
+			continue
+		}
+		stream := routing.Stream{Service: name, PortName: p.Name, Protocol: p.protocol(), Port: p.PublicPort}
+		for _, c := range instances {
+			for _, mapping := range c.Ports {
+				if mapping.Name != p.Name {
+					continue
+				}
+				if address := mappingAddress(c, mapping); address != "" {
+					stream.Backends = append(stream.Backends, routing.Backend{
+						ContainerID: c.ContainerID,
+						Address:     address,
+						Healthy:     checker == nil || checker.IsHealthy(c.ContainerID),
+					})
+				}
+			}
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// appliedRoutes is the table last applied to the routers, a table that did not change is
+// not applied again
+type appliedRoutes struct {
+	mu    sync.Mutex
+	table *routing.Table
+}
+
+// applyRoutes pushes the current instances to the routers, failures are logged since
+// the containers themselves are already in the desired state
+func (cm *ContainerManager) applyRoutes() {
+	// A dry run reports the routes it would end up with instead
+	if cm.Router == nil || cm.plan != nil {
+		return
+	}
+	table, err := cm.routingTable()
+	if err != nil {
+		cm.Logger.Error("Error building routing table: %s", err)
+		return
+	}
+	if cm.applied != nil {
+		cm.applied.mu.Lock()
+		defer cm.applied.mu.Unlock()
+		if cm.applied.table != nil && reflect.DeepEqual(*cm.applied.table, table) {
+			return
+		}
+	}
+	if err := cm.Router.Apply(table); err != nil {
+		cm.Logger.Error("Error applying routes: %s", err)
+		return
+	}
+	if cm.applied != nil {
+		cm.applied.table = &table
+	}
+}
+
+const defaultRoutesInterval = 5 * time.Second
+
+// syncRoutes rebuilds the routing table from the database every ROUTES_INTERVAL. Other
+// processes sharing the database, such as the CLI or a second host, change instances
+// and certificates without the routers of the daemon hearing about it, and instances
+// that turn healthy change no row at all.
+func (cm *ContainerManager) syncRoutes(ctx context.Context) {
+	interval, err := time.ParseDuration(config.GetEnvOrDefault("ROUTES_INTERVAL", defaultRoutesInterval.String()))
+	if err != nil || interval <= 0 {
+		interval = defaultRoutesInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cm.applyRoutes()
+		}
+	}
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+// drain waits for the stream connections still open to an instance to finish. Once the
+// routes without it are applied it gets no new ones. DRAIN_TIMEOUT bounds the wait.
+func (cm *ContainerManager) drain(ctx context.Context, containerID string) {
+	if cm.Proxy == nil {
+		return
+	}
+	timeout, err := time.ParseDuration(config.GetEnvOrDefault("DRAIN_TIMEOUT", defaultDrainTimeout.String()))
+	if err != nil {
+		timeout = defaultDrainTimeout
+	}
+	if open := cm.Proxy.Drain(ctx, containerID, timeout); open > 0 {
+		cm.Logger.Warn("Stopping container %s with %d connections still open", containerID, open)
+	}
+}
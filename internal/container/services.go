@@ -0,0 +1,252 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	"gopkg.in/yaml.v3"
+)
+
+func (c *ContainerConfig) validate() error {
+	if c.ContainerName == "" {
+		return errors.New("container name is required")
+	}
+	if c.Replicas < 1 {
+		return fmt.Errorf("replicas must be at least 1, got %d", c.Replicas)
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("max connections must not be negative, got %d", c.MaxConnections)
+	}
+	if _, err := routing.ParseStrategy(c.LoadBalancer); err != nil {
+		return err
+	}
+	if err := c.Rollout.validate(); err != nil {
+		return err
+	}
+	if err := c.Hooks.validate(); err != nil {
+		return err
+	}
+	if err := validatePullPolicy(c.PullPolicy); err != nil {
+		return err
+	}
+	if err := validatePublish(c.Publish); err != nil {
+		return err
+	}
+	if err := validateNetworks(c.Networks); err != nil {
+		return err
+	}
+	if err := validatePorts(c); err != nil {
+		return err
+	}
+	if err := validateRoutes(c); err != nil {
+		return err
+	}
+	_, err := c.resources()
+	return err
+}
+
+// serviceSpec returns the stored spec of a service. Services created before specs were
+// stored are described from their instances instead.
+func (cm *ContainerManager) serviceSpec(name string) (*ContainerConfig, error) {
+	return cm.serviceSpecIn(cm.Db, name)
+}
+
+// serviceSpecIn reads the spec through db, which may be a transaction
+func (cm *ContainerManager) serviceSpecIn(db database.Store, name string) (*ContainerConfig, error) {
+	info, err := db.GetService(name)
+	if err == nil {
+		var spec ContainerConfig
+		if err := yaml.Unmarshal([]byte(info.Spec), &spec); err != nil {
+			return nil, fmt.Errorf("error parsing spec of service %s: %w", name, err)
+		}
+		return &spec, nil
+	}
+	if !errors.Is(err, database.ErrServiceNotFound) {
+		return nil, err
+	}
+
+	instances, err := db.GetContainersByService(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("%w: %s", database.ErrServiceNotFound, name)
+	}
+	return &ContainerConfig{
+		DomainName:    instances[0].DomainName,
+		ImageName:     instances[0].ImageName,
+		ContainerName: name,
+		ContainerPort: instances[0].ContainerPort,
+		Replicas:      len(instances),
+	}, nil
+}
+
+func (cm *ContainerManager) saveServiceSpec(config *ContainerConfig) error {
+	return cm.saveServiceSpecIn(cm.Db, config)
+}
+
+// saveServiceSpecIn saves the spec through db, which may be a transaction
+func (cm *ContainerManager) saveServiceSpecIn(db database.Store, config *ContainerConfig) error {
+	spec, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("error encoding spec of service %s: %w", config.ContainerName, err)
+	}
+	if err := db.SaveService(database.ServiceInfo{Name: config.ContainerName, Spec: string(spec)}); err != nil {
+		cm.Logger.Error("Error saving service spec to database: %s", err)
+		return fmt.Errorf("error saving service spec to database: %w", err)
+	}
+	return nil
+}
+
+// resolveServiceName finds the service a config refers to, by the ID of one of its
+// instances, by service name or by a unique partial container name
+func (cm *ContainerManager) resolveServiceName(config *ContainerConfig) (string, error) {
+	if config.ContainerID != "" {
+		info, err := cm.Db.GetContainer(config.ContainerID)
+		if err != nil {
+			return "", err
+		}
+		return info.ServiceName, nil
+	}
+	if _, err := cm.serviceSpec(config.ContainerName); err == nil {
+		return config.ContainerName, nil
+	}
+
+	containers, err := cm.Db.GetContainersByPartialName(config.ContainerName)
+	if err != nil {
+		return "", err
+	}
+	if len(containers) == 0 {
+		return "", fmt.Errorf("%w: %s", database.ErrServiceNotFound, config.ContainerName)
+	}
+	for _, c := range containers[1:] {
+		if c.ServiceName != containers[0].ServiceName {
+			return "", fmt.Errorf("%q matches more than one service", config.ContainerName)
+		}
+	}
+	return containers[0].ServiceName, nil
+}
+
+// mergeSpec fills the fields left empty in an update from the current spec of the service
+func mergeSpec(config *ContainerConfig, spec *ContainerConfig) {
+	if config.DomainName == "" {
+		config.DomainName = spec.DomainName
+	}
+	if config.ImageName == "" {
+		config.ImageName = spec.ImageName
+	}
+	// The port is given either way, an update setting one replaces the other
+	if config.ContainerPort == "" && config.Ports == nil {
+		config.ContainerPort = spec.ContainerPort
+		config.Ports = spec.Ports
+	}
+	if config.Cmd == nil {
+		config.Cmd = spec.Cmd
+	}
+	if config.MemoryLimit == "" {
+		config.MemoryLimit = spec.MemoryLimit
+	}
+	if config.CPULimit == "" {
+		config.CPULimit = spec.CPULimit
+	}
+	if config.Replicas == 0 {
+		config.Replicas = spec.Replicas
+	}
+	if config.Replicas == 0 {
+		config.Replicas = 1
+	}
+	if config.LoadBalancer == "" {
+		config.LoadBalancer = spec.LoadBalancer
+	}
+	if config.Rollout.isZero() {
+		config.Rollout = spec.Rollout
+	}
+	if config.Hooks.isZero() {
+		config.Hooks = spec.Hooks
+	}
+	if !config.StickyPorts {
+		config.StickyPorts = spec.StickyPorts
+	}
+	if config.Publish == "" {
+		config.Publish = spec.Publish
+	}
+	if config.Networks == nil {
+		config.Networks = spec.Networks
+	}
+	if config.Routes == nil {
+		config.Routes = spec.Routes
+	}
+	if config.MaxConnections == 0 {
+		config.MaxConnections = spec.MaxConnections
+	}
+	if config.PullPolicy == "" {
+		config.PullPolicy = spec.PullPolicy
+	}
+}
+
+// instanceName names the container of a replica. The first replica keeps the plain name
+// so single instance services look the same as before replicas existed.
+func instanceName(service string, replica int, suffix string) string {
+	name := service
+	if suffix != "" {
+		name = fmt.Sprintf("%s_%s", name, suffix)
+	}
+	if replica > 0 {
+		name = fmt.Sprintf("%s-%d", name, replica)
+	}
+	return name
+}
+
+func (cm *ContainerManager) startInstance(ctx context.Context, j *journal, config *ContainerConfig, replica int, suffix string) (*database.ContainerInfo, error) {
+	name := instanceName(config.ContainerName, replica, suffix)
+	ports, err := cm.allocatePorts(config, replica)
+	if err != nil {
+		return nil, fmt.Errorf("error finding available port: %w", err)
+	}
+	if cm.plan != nil {
+		for _, p := range ports {
+			if p.HostPort != 0 {
+				cm.plan.record("allocate port", strconv.Itoa(p.HostPort), "for %s of %s", p.Name, name)
+			}
+		}
+	}
+
+	instance := *config
+	instance.ContainerName = name
+	info, err := cm.createAndStartContainer(ctx, j, config.ContainerName, &instance, ports)
+	if err != nil {
+		cm.releasePorts(ports)
+		return nil, err
+	}
+	info.ServiceName = config.ContainerName
+	info.Replica = replica
+	return info, nil
+}
+
+// startInstances starts the given replicas of a service, removing the ones already started if any fails
+func (cm *ContainerManager) startInstances(ctx context.Context, j *journal, config *ContainerConfig, suffix string, replicas []int) ([]*database.ContainerInfo, error) {
+	var instances []*database.ContainerInfo
+	for _, replica := range replicas {
+		info, err := cm.startInstance(ctx, j, config, replica, suffix)
+		if err != nil {
+			cm.removeInstances(instances)
+			return nil, err
+		}
+		instances = append(instances, info)
+	}
+	return instances, nil
+}
+
+// removeInstances cleans up instances that never made it into the database
+func (cm *ContainerManager) removeInstances(instances []*database.ContainerInfo) {
+	for _, info := range instances {
+		if err := cm.stopAndRemoveContainer(context.Background(), info.ContainerID); err != nil {
+			cm.Logger.Error("Error cleaning up instance %s: %s", info.ContainerName, err)
+		}
+		cm.releasePorts(info.Ports)
+	}
+}
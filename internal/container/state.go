@@ -0,0 +1,508 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/config"
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+const stateVersion = 1
+
+// State is what is needed to rebuild the orchestrator on another host: the spec of every
+// service, the deployment history, the certificates and the registry logins. The keys of
+// certificates and ACME accounts and the registry passwords stay encrypted with
+// CERT_STORE_KEY, the host they are imported on needs the same key.
+type State struct {
+	Version int `yaml:"version"`
+	// SchemaVersion is the database schema the state was exported from
+	SchemaVersion int                `yaml:"schema_version"`
+	ExportedAt    time.Time          `yaml:"exported_at"`
+	Services      []ContainerConfig  `yaml:"services"`
+	Deployments   []StateDeployment  `yaml:"deployments,omitempty"`
+	Certificates  []StateCertificate `yaml:"certificates,omitempty"`
+	ACMEAccounts  []StateACMEAccount `yaml:"acme_accounts,omitempty"`
+	// RegistryLogins are the logins images were pulled with, by registry host
+	RegistryLogins []StateRegistryLogin `yaml:"registry_logins,omitempty"`
+}
+
+type StateDeployment struct {
+	ServiceName string         `yaml:"service_name"`
+	ImageName   string         `yaml:"image_name"`
+	Strategy    string         `yaml:"strategy"`
+	Status      string         `yaml:"status"`
+	Message     string         `yaml:"message,omitempty"`
+	StartedAt   time.Time      `yaml:"started_at"`
+	FinishedAt  time.Time      `yaml:"finished_at,omitempty"`
+	Hooks       []StateHookRun `yaml:"hooks,omitempty"`
+}
+
+type StateHookRun struct {
+	Phase     string        `yaml:"phase"`
+	Command   string        `yaml:"command"`
+	ExitCode  int           `yaml:"exit_code"`
+	Output    string        `yaml:"output,omitempty"`
+	Error     string        `yaml:"error,omitempty"`
+	StartedAt time.Time     `yaml:"started_at"`
+	Duration  time.Duration `yaml:"duration"`
+}
+
+// StateCertificate is a stored certificate, KeyData is its key encrypted with CERT_STORE_KEY
+type StateCertificate struct {
+	Domain   string    `yaml:"domain"`
+	Source   string    `yaml:"source"`
+	CertPEM  string    `yaml:"cert_pem"`
+	KeyData  string    `yaml:"key_data"`
+	NotAfter time.Time `yaml:"not_after"`
+}
+
+// StateACMEAccount is an account registered with a CA, KeyData is encrypted like the keys
+// of certificates
+type StateACMEAccount struct {
+	DirectoryURL string    `yaml:"directory_url"`
+	KeyData      string    `yaml:"key_data"`
+	URI          string    `yaml:"uri"`
+	CreatedAt    time.Time `yaml:"created_at"`
+}
+
+// StateRegistryLogin is a stored registry login, PasswordData is its password encrypted
+// with CERT_STORE_KEY
+type StateRegistryLogin struct {
+	Host         string `yaml:"host"`
+	Username     string `yaml:"username"`
+	PasswordData string `yaml:"password_data"`
+}
+
+// RegistryLogin is the username and password of a registry
+type RegistryLogin struct {
+	Username string
+	Password string
+}
+
+// RegistryCredentials are the logins of registries by host, such as registry.example.com,
+// or docker.io for Docker Hub
+type RegistryCredentials map[string]RegistryLogin
+
+// ParseRegistryLogin parses host=username:password
+func ParseRegistryLogin(s string) (string, RegistryLogin, error) {
+	host, login, ok := strings.Cut(s, "=")
+	if !ok || host == "" {
+		return "", RegistryLogin{}, fmt.Errorf("invalid registry login %q, expected host=username:password", s)
+	}
+	username, password, ok := strings.Cut(login, ":")
+	if !ok || username == "" {
+		return "", RegistryLogin{}, fmt.Errorf("invalid registry login for %s, expected host=username:password", host)
+	}
+	return host, RegistryLogin{Username: username, Password: password}, nil
+}
+
+// apply gives config the login of the registry its image is pulled from
+func (c RegistryCredentials) apply(config *ContainerConfig) {
+	host, ok := registryHost(config.ImageName)
+	if !ok {
+		return
+	}
+	if login, ok := c[host]; ok {
+		config.RegistryUsername = login.Username
+		config.RegistryPassword = login.Password
+	}
+}
+
+// registryHost is the host of the registry an image is pulled from, docker.io for Docker Hub
+func registryHost(image string) (string, bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", false
+	}
+	return reference.Domain(named), true
+}
+
+// registryLogin returns the login of config when it has one and the stored login of the
+// registry of its image otherwise
+func (cm *ContainerManager) registryLogin(config *ContainerConfig) (username, password string) {
+	if config.RegistryUsername != "" || cm.certs == nil {
+		return config.RegistryUsername, config.RegistryPassword
+	}
+	host, ok := registryHost(config.ImageName)
+	if !ok {
+		return "", ""
+	}
+	username, password, err := cm.certs.store.RegistryLogin(host)
+	if err != nil && !errors.Is(err, database.ErrRegistryLoginNotFound) {
+		cm.Logger.Error("Error loading registry login: %s", err)
+	}
+	return username, password
+}
+
+// saveRegistryLogin stores the login config pulled its image with, so updates, restarts and
+// other hosts importing the state pull without it
+func (cm *ContainerManager) saveRegistryLogin(config *ContainerConfig) {
+	host, ok := registryHost(config.ImageName)
+	if config.RegistryUsername == "" || !ok {
+		return
+	}
+	if cm.certs == nil {
+		cm.Logger.Warn("Not storing the login of %s, set CERT_STORE_KEY to keep registry logins", host)
+		return
+	}
+	if err := cm.certs.store.SaveRegistryLogin(host, config.RegistryUsername, config.RegistryPassword); err != nil {
+		cm.Logger.Error("Error saving registry login: %s", err)
+	}
+}
+
+// StateImport is what ImportState did with each service of a state
+type StateImport struct {
+	Restored []string
+	// Skipped are services that already exist on this host, they are left alone
+	Skipped []string
+	Failed  map[string]error
+}
+
+// ExportState collects the state of every service, the deployment history is oldest first
+func (cm *ContainerManager) ExportState() (*State, error) {
+	cm.Logger.Info("Exporting state")
+	names, err := cm.serviceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{Version: stateVersion, ExportedAt: time.Now().UTC()}
+	migrations, err := cm.Db.MigrationStatus()
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema version: %w", err)
+	}
+	for _, m := range migrations {
+		if m.Applied {
+			state.SchemaVersion = m.Version
+		}
+	}
+
+	for _, name := range names {
+		spec, err := cm.serviceSpec(name)
+		if err != nil {
+			cm.Logger.Error("Error getting service spec for %s: %s", name, err)
+			return nil, fmt.Errorf("error getting service spec for %s: %w", name, err)
+		}
+		state.Services = append(state.Services, *spec)
+
+		deployments, err := cm.Db.ListDeployments(name, 0)
+		if err != nil {
+			return nil, fmt.Errorf("error listing deployments of %s: %w", name, err)
+		}
+		for i := len(deployments) - 1; i >= 0; i-- {
+			state.Deployments = append(state.Deployments, stateDeployment(deployments[i]))
+		}
+	}
+
+	certificates, err := cm.Db.ListCertificates()
+	if err != nil {
+		return nil, fmt.Errorf("error listing certificates: %w", err)
+	}
+	for _, c := range certificates {
+		state.Certificates = append(state.Certificates, StateCertificate{
+			Domain:   c.Domain,
+			Source:   c.Source,
+			CertPEM:  c.CertPEM,
+			KeyData:  c.KeyData,
+			NotAfter: c.NotAfter.UTC(),
+		})
+	}
+	accounts, err := cm.Db.ListACMEAccounts()
+	if err != nil {
+		return nil, fmt.Errorf("error listing acme accounts: %w", err)
+	}
+	for _, a := range accounts {
+		state.ACMEAccounts = append(state.ACMEAccounts, StateACMEAccount{
+			DirectoryURL: a.DirectoryURL,
+			KeyData:      a.KeyData,
+			URI:          a.URI,
+			CreatedAt:    a.CreatedAt.UTC(),
+		})
+	}
+	logins, err := cm.Db.ListRegistryLogins()
+	if err != nil {
+		return nil, fmt.Errorf("error listing registry logins: %w", err)
+	}
+	for _, l := range logins {
+		state.RegistryLogins = append(state.RegistryLogins, StateRegistryLogin{
+			Host:         l.Host,
+			Username:     l.Username,
+			PasswordData: l.PasswordData,
+		})
+	}
+	return state, nil
+}
+
+// serviceNames lists the services with a stored spec and those known only by their instances
+func (cm *ContainerManager) serviceNames() ([]string, error) {
+	seen := make(map[string]bool)
+	services, err := cm.Db.ListServices()
+	if err != nil {
+		return nil, fmt.Errorf("error listing services: %w", err)
+	}
+	for _, s := range services {
+		seen[s.Name] = true
+	}
+	containers, err := cm.Db.ListContainers()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+	for _, c := range containers {
+		seen[c.ServiceName] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ImportState restores the services of a state that do not exist on this host yet: their
+// deployment history is recorded and their containers are created again, with images from
+// private registries pulled with the logins in credentials or else those of the state
+func (cm *ContainerManager) ImportState(ctx context.Context, state *State, credentials RegistryCredentials) (*StateImport, error) {
+	if state.Version > stateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", state.Version)
+	}
+	cm.Logger.Info("Importing state exported at %s", state.ExportedAt)
+
+	result := &StateImport{Failed: make(map[string]error)}
+	restore := make(map[string]bool)
+	for _, service := range state.Services {
+		if service.ContainerName == "" {
+			return nil, errors.New("state has a service without container_name")
+		}
+		if _, err := cm.serviceSpec(service.ContainerName); err == nil {
+			cm.Logger.Warn("Service %s already exists, skipping it", service.ContainerName)
+			result.Skipped = append(result.Skipped, service.ContainerName)
+			continue
+		}
+		restore[service.ContainerName] = true
+	}
+
+	// Certificates and logins go before the services so their domains are served over TLS
+	// right away and their images can be pulled
+	if err := cm.importSecrets(state); err != nil {
+		return nil, err
+	}
+
+	// The history goes first so the deployments that re-create the services follow it
+	for _, dep := range state.Deployments {
+		if !restore[dep.ServiceName] {
+			continue
+		}
+		if _, err := cm.Db.ImportDeployment(dep.deployment()); err != nil {
+			cm.Logger.Error("Error importing deployment history: %s", err)
+			return nil, fmt.Errorf("error importing deployment history: %w", err)
+		}
+	}
+
+	for _, service := range state.Services {
+		if !restore[service.ContainerName] {
+			continue
+		}
+		config := service
+		credentials.apply(&config)
+		if err := cm.deploy(ctx, "restore", &config); err != nil {
+			cm.Logger.Error("Error restoring service %s: %s", service.ContainerName, err)
+			result.Failed[service.ContainerName] = err
+			continue
+		}
+		result.Restored = append(result.Restored, service.ContainerName)
+	}
+	return result, nil
+}
+
+// importSecrets restores the certificates, ACME accounts and registry logins of a state
+// that this host does not have. Their keys and passwords must open with the CERT_STORE_KEY
+// of this host.
+func (cm *ContainerManager) importSecrets(state *State) error {
+	if len(state.Certificates) == 0 && len(state.ACMEAccounts) == 0 && len(state.RegistryLogins) == 0 {
+		return nil
+	}
+	if cm.certs == nil {
+		cm.Logger.Warn("Skipping %d certificates, %d ACME accounts and %d registry logins, set CERT_STORE_KEY to the key of the exporting host to import them",
+			len(state.Certificates), len(state.ACMEAccounts), len(state.RegistryLogins))
+		return nil
+	}
+	for _, c := range state.Certificates {
+		if _, err := cm.Db.GetCertificate(c.Domain); err == nil {
+			cm.Logger.Warn("Certificate of %s already exists, skipping it", c.Domain)
+			continue
+		} else if !errors.Is(err, database.ErrCertificateNotFound) {
+			return fmt.Errorf("error importing certificate of %s: %w", c.Domain, err)
+		}
+		err := cm.certs.store.Restore(database.Certificate{
+			Domain:   c.Domain,
+			Source:   c.Source,
+			CertPEM:  c.CertPEM,
+			KeyData:  c.KeyData,
+			NotAfter: c.NotAfter,
+		})
+		if err != nil {
+			cm.Logger.Error("Error importing certificate of %s: %s", c.Domain, err)
+			return fmt.Errorf("error importing certificate of %s: %w", c.Domain, err)
+		}
+	}
+	for _, a := range state.ACMEAccounts {
+		if _, err := cm.Db.GetACMEAccount(a.DirectoryURL); err == nil {
+			cm.Logger.Warn("ACME account of %s already exists, skipping it", a.DirectoryURL)
+			continue
+		} else if !errors.Is(err, database.ErrACMEAccountNotFound) {
+			return fmt.Errorf("error importing acme account of %s: %w", a.DirectoryURL, err)
+		}
+		err := cm.certs.store.RestoreAccount(database.ACMEAccount{
+			DirectoryURL: a.DirectoryURL,
+			KeyData:      a.KeyData,
+			URI:          a.URI,
+			CreatedAt:    a.CreatedAt,
+		})
+		if err != nil {
+			cm.Logger.Error("Error importing ACME account of %s: %s", a.DirectoryURL, err)
+			return fmt.Errorf("error importing acme account of %s: %w", a.DirectoryURL, err)
+		}
+	}
+	for _, l := range state.RegistryLogins {
+		if _, err := cm.Db.GetRegistryLogin(l.Host); err == nil {
+			cm.Logger.Warn("Registry login of %s already exists, skipping it", l.Host)
+			continue
+		} else if !errors.Is(err, database.ErrRegistryLoginNotFound) {
+			return fmt.Errorf("error importing registry login of %s: %w", l.Host, err)
+		}
+		err := cm.certs.store.RestoreRegistryLogin(database.RegistryLogin{
+			Host:         l.Host,
+			Username:     l.Username,
+			PasswordData: l.PasswordData,
+		})
+		if err != nil {
+			cm.Logger.Error("Error importing registry login of %s: %s", l.Host, err)
+			return fmt.Errorf("error importing registry login of %s: %w", l.Host, err)
+		}
+	}
+	return nil
+}
+
+// backupSchedule reads the schedule of database backups from the environment, BACKUP_DIR
+// turns them on
+func (cm *ContainerManager) backupSchedule() (database.BackupSchedule, bool) {
+	schedule := database.BackupSchedule{Dir: os.Getenv("BACKUP_DIR"), Interval: 6 * time.Hour, Retain: 14}
+	if schedule.Dir == "" {
+		return schedule, false
+	}
+	if !database.SupportsBackup(cm.Db) {
+		cm.Logger.Warn("BACKUP_DIR is ignored with PostgreSQL, back it up with pg_dump")
+		return schedule, false
+	}
+	if interval, err := time.ParseDuration(config.GetEnvOrDefault("BACKUP_INTERVAL", schedule.Interval.String())); err == nil && interval >= time.Minute {
+		schedule.Interval = interval
+	} else {
+		cm.Logger.Warn("Invalid BACKUP_INTERVAL, backing up every %s", schedule.Interval)
+	}
+	if retain, err := strconv.Atoi(config.GetEnvOrDefault("BACKUP_RETAIN", strconv.Itoa(schedule.Retain))); err == nil {
+		schedule.Retain = retain
+	} else {
+		cm.Logger.Warn("Invalid BACKUP_RETAIN, keeping %d backups", schedule.Retain)
+	}
+	return schedule, true
+}
+
+// WriteState encodes a state as yaml or json
+func WriteState(w io.Writer, state *State, format string) error {
+	var doc []byte
+	encoded, err := yaml.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	switch format {
+	case "", "yaml":
+		doc = encoded
+	case "json":
+		// The yaml field names apply to json too, so it goes through a generic document
+		var generic any
+		if err := yaml.Unmarshal(encoded, &generic); err != nil {
+			return fmt.Errorf("error encoding state: %w", err)
+		}
+		if doc, err = json.MarshalIndent(generic, "", "  "); err != nil {
+			return fmt.Errorf("error encoding state: %w", err)
+		}
+		doc = append(doc, '\n')
+	default:
+		return fmt.Errorf("unknown state format %q, use yaml or json", format)
+	}
+	_, err = w.Write(doc)
+	return err
+}
+
+// ReadState decodes a state written by WriteState, json being a subset of yaml
+func ReadState(r io.Reader) (*State, error) {
+	var state State
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&state); err != nil {
+		return nil, fmt.Errorf("error parsing state: %w", err)
+	}
+	return &state, nil
+}
+
+func stateDeployment(d database.Deployment) StateDeployment {
+	dep := StateDeployment{
+		ServiceName: d.ServiceName,
+		ImageName:   d.ImageName,
+		Strategy:    d.Strategy,
+		Status:      d.Status,
+		Message:     d.Message,
+		StartedAt:   d.StartedAt.UTC(),
+	}
+	if !d.FinishedAt.IsZero() {
+		dep.FinishedAt = d.FinishedAt.UTC()
+	}
+	for _, h := range d.Hooks {
+		dep.Hooks = append(dep.Hooks, StateHookRun{
+			Phase:     h.Phase,
+			Command:   h.Command,
+			ExitCode:  h.ExitCode,
+			Output:    h.Output,
+			Error:     h.Error,
+			StartedAt: h.StartedAt.UTC(),
+			Duration:  h.Duration,
+		})
+	}
+	return dep
+}
+
+func (d StateDeployment) deployment() database.Deployment {
+	dep := database.Deployment{
+		ServiceName: d.ServiceName,
+		ImageName:   d.ImageName,
+		Strategy:    d.Strategy,
+		Status:      d.Status,
+		Message:     d.Message,
+		StartedAt:   d.StartedAt,
+		FinishedAt:  d.FinishedAt,
+	}
+	for _, h := range d.Hooks {
+		dep.Hooks = append(dep.Hooks, database.HookRun{
+			Phase:     h.Phase,
+			Command:   h.Command,
+			ExitCode:  h.ExitCode,
+			Output:    h.Output,
+			Error:     h.Error,
+			StartedAt: h.StartedAt,
+			Duration:  h.Duration,
+		})
+	}
+	return dep
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	AuditSucceeded = "succeeded"
+	AuditFailed    = "failed"
+)
+
+// AuditEntry records who changed what and how it went. The audit_log table is append-only.
+type AuditEntry struct {
+	ID   int64
+	Time time.Time
+	// Actor is who asked for the change. Actors of gRPC requests are what the client
+	// claims, Source is where the request really came from.
+	Actor       string
+	Source      string
+	Action      string
+	ServiceName string
+	// Params is a JSON object of the request parameters with secrets redacted
+	Params   string
+	Result   string
+	Error    string
+	Duration time.Duration
+	// OperationID is the operation the entry belongs to, zero when there is none
+	OperationID int64
+}
+
+// AuditFilter selects audit entries, zero values match everything
+type AuditFilter struct {
+	ServiceName string
+	Actor       string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+func (d *Database) AddAuditEntry(entry AuditEntry) (int64, error) {
+	if entry.Params == "" {
+		entry.Params = "{}"
+	}
+	var id int64
+	err := d.q.QueryRow(`
+		INSERT INTO audit_log (created_at, actor, source, action, service_name, params, result, error, duration_ms, operation_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id
+	`, entry.Time.Unix(), entry.Actor, entry.Source, entry.Action, entry.ServiceName, entry.Params, entry.Result, entry.Error,
+		entry.Duration.Milliseconds(), entry.OperationID).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return id, nil
+}
+
+// ListAuditLog returns the entries matching filter, newest first
+func (d *Database) ListAuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		// PostgreSQL has no LIMIT -1 for no limit
+		limit = math.MaxInt32
+	}
+	var since, until int64 = 0, math.MaxInt64
+	if !filter.Since.IsZero() {
+		since = filter.Since.Unix()
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until.Unix()
+	}
+	rows, err := d.q.Query(`
+		SELECT id, created_at, actor, source, action, service_name, params, result, error, duration_ms, operation_id
+		FROM audit_log
+		WHERE (? = '' OR service_name = ?) AND (? = '' OR actor = ?) AND created_at >= ? AND created_at <= ?
+		ORDER BY id DESC LIMIT ?
+	`, filter.ServiceName, filter.ServiceName, filter.Actor, filter.Actor, since, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var createdAt, durationMs int64
+		if err := rows.Scan(&entry.ID, &createdAt, &entry.Actor, &entry.Source, &entry.Action, &entry.ServiceName, &entry.Params,
+			&entry.Result, &entry.Error, &durationMs, &entry.OperationID); err != nil {
+			return nil, fmt.Errorf("failed to scan audit row: %w", err)
+		}
+		entry.Time = time.Unix(createdAt, 0)
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit rows: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/logging"
+)
+
+// backupPrefix starts the names of scheduled backups, pruning only touches those files
+const backupPrefix = "container_manager-"
+
+// SupportsBackup reports whether Backup works on a store, PostgreSQL is backed up with
+// pg_dump instead
+func SupportsBackup(store Store) bool {
+	d, ok := store.(*Database)
+	return !ok || d.dialect != dialectPostgres
+}
+
+// Backup writes a consistent copy of a SQLite database to path while it stays in use.
+// PostgreSQL is backed up with its own tools.
+func (d *Database) Backup(path string) error {
+	if d.dialect == dialectPostgres {
+		return errors.New("backups of a PostgreSQL database are taken with pg_dump")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	}
+	d.logger.Info("Backing up database to %s", path)
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// BackupSchedule takes a backup into Dir every Interval and keeps the latest Retain of them
+type BackupSchedule struct {
+	Dir      string
+	Interval time.Duration
+	Retain   int
+}
+
+// RunBackups backs up the store on schedule until ctx is done
+func RunBackups(ctx context.Context, store Store, schedule BackupSchedule) {
+	ticker := time.NewTicker(schedule.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := BackupNow(store, schedule); err != nil {
+			logging.GetLogger().Error("Scheduled backup failed: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// BackupNow takes one scheduled backup and prunes the old ones, returning its path
+func BackupNow(store Store, schedule BackupSchedule) (string, error) {
+	if err := os.MkdirAll(schedule.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	path := filepath.Join(schedule.Dir, backupPrefix+time.Now().UTC().Format("20060102T150405.000")+".db")
+	if err := store.Backup(path); err != nil {
+		return "", err
+	}
+	return path, pruneBackups(schedule.Dir, schedule.Retain)
+}
+
+// pruneBackups removes all but the newest retain backups in dir, a retain below one keeps all
+func pruneBackups(dir string, retain int) error {
+	if retain < 1 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), backupPrefix) && strings.HasSuffix(entry.Name(), ".db") {
+			backups = append(backups, entry.Name())
+		}
+	}
+	// The timestamps in the names sort oldest first
+	sort.Strings(backups)
+	for len(backups) > retain {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
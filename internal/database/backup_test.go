@@ -0,0 +1,49 @@
+package database_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackups(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.NewDatabase(filepath.Join(dir, "orchestrator.db"))
+	require.NoError(t, err, "Error opening database")
+	defer db.Close()
+	require.NoError(t, db.InitSchema())
+	require.NoError(t, db.AddContainer(database.ContainerInfo{
+		ContainerID:   "backup-id",
+		ContainerName: "backup",
+		ImageName:     "mock-image:latest",
+		DomainName:    "backup.example.com",
+		HostPort:      "8084",
+		ContainerPort: "80",
+		Status:        "running",
+	}))
+
+	schedule := database.BackupSchedule{Dir: filepath.Join(dir, "backups"), Interval: time.Hour, Retain: 2}
+	var latest string
+	for i := 0; i < 3; i++ {
+		latest, err = database.BackupNow(db, schedule)
+		require.NoError(t, err, "Error backing up")
+		time.Sleep(5 * time.Millisecond)
+	}
+	entries, err := os.ReadDir(schedule.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "Only the newest backups should be retained")
+
+	backup, err := database.NewDatabase(latest)
+	require.NoError(t, err, "Error opening backup")
+	defer backup.Close()
+	info, err := backup.GetContainer("backup-id")
+	require.NoError(t, err, "Backup should hold the data")
+	assert.Equal(t, "backup", info.ContainerName)
+
+	assert.Error(t, db.Backup(latest), "An existing backup should not be overwritten")
+}
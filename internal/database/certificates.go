@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Certificate is the certificate served for a domain. KeyData is the private key as the
+// orchestrator encrypted it, the database never sees the key in the clear.
+type Certificate struct {
+	Domain string
+	// Source is where the certificate came from, such as acme
+	Source    string
+	CertPEM   string
+	KeyData   string
+	NotAfter  time.Time
+	UpdatedAt time.Time
+}
+
+// ACMEAccount is the account registered with the CA at DirectoryURL, KeyData is encrypted
+// like the keys of certificates
+type ACMEAccount struct {
+	DirectoryURL string
+	KeyData      string
+	URI          string
+	CreatedAt    time.Time
+}
+
+var (
+	ErrCertificateNotFound = errors.New("certificate not found")
+	ErrACMEAccountNotFound = errors.New("acme account not found")
+)
+
+// SaveCertificate stores the certificate of a domain, replacing the one it had
+func (d *Database) SaveCertificate(cert Certificate) error {
+	if cert.Domain == "" {
+		return errors.New("certificate domain cannot be empty")
+	}
+	if cert.UpdatedAt.IsZero() {
+		cert.UpdatedAt = time.Now()
+	}
+
+	d.logger.Info("Saving certificate for %s", cert.Domain)
+	_, err := d.q.Exec(`
+		INSERT INTO certificates (domain, source, cert_pem, key_data, not_after, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET source = excluded.source, cert_pem = excluded.cert_pem,
+			key_data = excluded.key_data, not_after = excluded.not_after, updated_at = excluded.updated_at
+	`, cert.Domain, cert.Source, cert.CertPEM, cert.KeyData, cert.NotAfter.Unix(), cert.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetCertificate(domain string) (*Certificate, error) {
+	row := d.q.QueryRow(`
+		SELECT domain, source, cert_pem, key_data, not_after, updated_at FROM certificates WHERE domain = ?
+	`, domain)
+	cert, err := scanCertificate(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrCertificateNotFound, domain)
+		}
+		return nil, fmt.Errorf("failed to get certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// ListCertificates returns the certificates ordered by domain
+func (d *Database) ListCertificates() ([]Certificate, error) {
+	rows, err := d.q.Query(`
+		SELECT domain, source, cert_pem, key_data, not_after, updated_at FROM certificates ORDER BY domain
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certs []Certificate
+	for rows.Next() {
+		cert, err := scanCertificate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan certificate row: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating certificate rows: %w", err)
+	}
+	return certs, nil
+}
+
+func (d *Database) DeleteCertificate(domain string) error {
+	d.logger.Info("Deleting certificate for %s", domain)
+	if _, err := d.q.Exec("DELETE FROM certificates WHERE domain = ?", domain); err != nil {
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+	return nil
+}
+
+func scanCertificate(row rowScanner) (Certificate, error) {
+	var cert Certificate
+	var notAfter, updatedAt int64
+	if err := row.Scan(&cert.Domain, &cert.Source, &cert.CertPEM, &cert.KeyData, &notAfter, &updatedAt); err != nil {
+		return Certificate{}, err
+	}
+	cert.NotAfter = time.Unix(notAfter, 0)
+	cert.UpdatedAt = time.Unix(updatedAt, 0)
+	return cert, nil
+}
+
+// SaveACMEAccount stores the account of a CA, replacing the one it had
+func (d *Database) SaveACMEAccount(account ACMEAccount) error {
+	if account.CreatedAt.IsZero() {
+		account.CreatedAt = time.Now()
+	}
+	_, err := d.q.Exec(`
+		INSERT INTO acme_accounts (directory_url, key_data, uri, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(directory_url) DO UPDATE SET key_data = excluded.key_data, uri = excluded.uri
+	`, account.DirectoryURL, account.KeyData, account.URI, account.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save acme account: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetACMEAccount(directoryURL string) (*ACMEAccount, error) {
+	var account ACMEAccount
+	var createdAt int64
+	err := d.q.QueryRow(`
+		SELECT directory_url, key_data, uri, created_at FROM acme_accounts WHERE directory_url = ?
+	`, directoryURL).Scan(&account.DirectoryURL, &account.KeyData, &account.URI, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrACMEAccountNotFound, directoryURL)
+		}
+		return nil, fmt.Errorf("failed to get acme account: %w", err)
+	}
+	account.CreatedAt = time.Unix(createdAt, 0)
+	return &account, nil
+}
+
+// ListACMEAccounts returns the accounts ordered by directory
+func (d *Database) ListACMEAccounts() ([]ACMEAccount, error) {
+	rows, err := d.q.Query(`
+		SELECT directory_url, key_data, uri, created_at FROM acme_accounts ORDER BY directory_url
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query acme accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []ACMEAccount
+	for rows.Next() {
+		var account ACMEAccount
+		var createdAt int64
+		if err := rows.Scan(&account.DirectoryURL, &account.KeyData, &account.URI, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan acme account row: %w", err)
+		}
+		account.CreatedAt = time.Unix(createdAt, 0)
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating acme account rows: %w", err)
+	}
+	return accounts, nil
+}
@@ -2,8 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/dgunzy/go-container-orchestrator/internal/logging"
 	_ "github.com/mattn/go-sqlite3"
@@ -18,46 +24,279 @@ type ContainerInfo struct {
 	HostPort      string
 	ContainerPort string
 	Status        string
+	ServiceName   string
+	Replica       int
+	// Address is where routers reach the instance, host and port. It is empty for instances
+	// published on a host port, they are reached over loopback.
+	Address string
+	// Ports are the named ports of the instance. HostPort and ContainerPort are the ones of
+	// the port routed by domain.
+	Ports []PortMapping
+}
+
+// PortMapping is a named port of an instance and the host port it is published on, zero
+// when it is not published
+type PortMapping struct {
+	Name          string `json:"name"`
+	Protocol      string `json:"protocol"`
+	ContainerPort int    `json:"container_port"`
+	HostPort      int    `json:"host_port,omitempty"`
+	Role          string `json:"role,omitempty"`
+}
+
+// DefaultPortName names the port of containers recorded before they had named ports
+const DefaultPortName = "http"
+
+const containerColumns = "id, container_id, container_name, image_name, domain_name, host_port, container_port, status, service_name, replica, address, ports"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanContainer(row rowScanner) (ContainerInfo, error) {
+	var info ContainerInfo
+	var ports string
+	err := row.Scan(&info.ID, &info.ContainerID, &info.ContainerName, &info.ImageName,
+		&info.DomainName, &info.HostPort, &info.ContainerPort, &info.Status,
+		&info.ServiceName, &info.Replica, &info.Address, &ports)
+	if err != nil {
+		return info, err
+	}
+	if ports == "" {
+		info.Ports = singlePort(info)
+		return info, nil
+	}
+	if err := json.Unmarshal([]byte(ports), &info.Ports); err != nil {
+		return info, fmt.Errorf("failed to decode ports of container %s: %w", info.ContainerID, err)
+	}
+	return info, nil
+}
+
+// singlePort describes the port of a container recorded with just HostPort and ContainerPort
+func singlePort(info ContainerInfo) []PortMapping {
+	if info.ContainerPort == "" {
+		return nil
+	}
+	port, protocol, _ := strings.Cut(info.ContainerPort, "/")
+	containerPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	hostPort, _ := strconv.Atoi(info.HostPort)
+	return []PortMapping{{
+		Name:          DefaultPortName,
+		Protocol:      protocol,
+		ContainerPort: containerPort,
+		HostPort:      hostPort,
+		Role:          "http",
+	}}
+}
+
+func encodePorts(ports []PortMapping) (string, error) {
+	if len(ports) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(ports)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode ports: %w", err)
+	}
+	return string(encoded), nil
 }
 
 type Database struct {
-	db     *sql.DB
+	db      *sql.DB
+	dialect string
+	// path is the file of a SQLite database, backups before migrating are written next to it
+	path string
+	// q runs the queries, it is the transaction inside WithTx and db otherwise
+	q querier
+	// tx is the transaction in progress inside WithTx
+	tx     *sql.Tx
 	logger *logging.Logger
+	// tempDir is removed on Close, it holds the file of a snapshot
+	tempDir string
+	// discard is rolled back on Close, it holds the changes made to a PostgreSQL snapshot
+	discard *sql.Tx
+}
+
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+)
+
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
 func NewDatabase(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Transactions hold a write lock, wait for it instead of failing right away
+	dsn := dbPath
+	if !strings.Contains(dsn, "?") {
+		dsn += "?_busy_timeout=5000"
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 	return &Database{
-		db:     db,
-		logger: logging.GetLogger(),
+		db:      db,
+		dialect: dialectSQLite,
+		path:    strings.SplitN(dbPath, "?", 2)[0],
+		q:       db,
+		logger:  logging.GetLogger(),
 	}, nil
 }
 
+// WithTx runs fn in a transaction, committing it when fn succeeds and rolling it back
+// otherwise. The Store passed to fn runs its queries inside the transaction, nested
+// calls join the transaction already in progress.
+func (d *Database) WithTx(fn func(tx Store) error) error {
+	return d.withTx(func(tx *Database) error { return fn(tx) })
+}
+
+func (d *Database) withTx(fn func(tx *Database) error) error {
+	if d.tx != nil {
+		return fn(d)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(d.inTx(tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			d.logger.Error("Failed to roll back transaction: %s", rbErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// inTx returns a Database that runs its queries in tx
+func (d *Database) inTx(tx *sql.Tx) *Database {
+	var q querier = tx
+	if d.dialect == dialectPostgres {
+		q = postgresQuerier{tx}
+	}
+	return &Database{db: d.db, dialect: d.dialect, path: d.path, q: q, tx: tx, logger: d.logger}
+}
+
 func (d *Database) Close() error {
+	if d.discard != nil {
+		return d.discard.Rollback()
+	}
 	d.logger.Info("Closing database connection")
-	return d.db.Close()
+	err := d.db.Close()
+	if d.tempDir != "" {
+		if rmErr := os.RemoveAll(d.tempDir); rmErr != nil && err == nil {
+			err = fmt.Errorf("failed to remove snapshot: %w", rmErr)
+		}
+	}
+	return err
 }
 
+// Snapshot copies a SQLite database to a temporary file. Changes to the snapshot do not
+// touch the original and Close removes it again. A PostgreSQL snapshot is a transaction
+// that Close rolls back.
+func (d *Database) Snapshot() (Store, error) {
+	if d.dialect == dialectPostgres {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot database: %w", err)
+		}
+		snapshot := d.inTx(tx)
+		snapshot.discard = tx
+		return snapshot, nil
+	}
+
+	dir, err := os.MkdirTemp("", "orchestrator-snapshot-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	path := filepath.Join(dir, "snapshot.db")
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	snapshot, err := NewDatabase(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	snapshot.tempDir = dir
+	return snapshot, nil
+}
+
+// InitSchema brings the schema up to date by applying the pending migrations
 func (d *Database) InitSchema() error {
 	d.logger.Info("Initializing database schema")
-	_, err := d.db.Exec(`
-		CREATE TABLE IF NOT EXISTS containers (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			container_id TEXT NOT NULL,
-			container_name TEXT NOT NULL,
-			image_name TEXT NOT NULL,
-			domain_name TEXT NOT NULL,
-			host_port INTEGER NOT NULL,
-			container_port INTEGER NOT NULL,
-			status TEXT NOT NULL
-		);
-	`)
+	report, err := d.Migrate()
 	if err != nil {
 		return fmt.Errorf("failed to initialize schema: %w", err)
 	}
+	if len(report.Applied) > 0 {
+		d.logger.Info("Applied %d migrations", len(report.Applied))
+	}
+	return nil
+}
+
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+	rows, err := d.q.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+
+	d.logger.Info("Adding column %s to table %s", column, table)
+	if _, err := d.q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s to table %s: %w", column, table, err)
+	}
+	return nil
+}
+
+// updateSuffix matches the timestamp appended to the names of updated containers
+var updateSuffix = regexp.MustCompile(`_\d{14}$`)
+
+func (d *Database) backfillServiceNames() error {
+	rows, err := d.q.Query("SELECT container_id, container_name FROM containers WHERE service_name = ''")
+	if err != nil {
+		return fmt.Errorf("failed to query containers without a service: %w", err)
+	}
+	names := make(map[string]string)
+	for rows.Next() {
+		var containerID, containerName string
+		if err := rows.Scan(&containerID, &containerName); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan container row: %w", err)
+		}
+		names[containerID] = updateSuffix.ReplaceAllString(containerName, "")
+	}
+	rows.Close()
+
+	for containerID, serviceName := range names {
+		if _, err := d.q.Exec("UPDATE containers SET service_name = ? WHERE container_id = ?", serviceName, containerID); err != nil {
+			return fmt.Errorf("failed to set service name: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -66,20 +305,31 @@ func (d *Database) AddContainer(info ContainerInfo) error {
 		return fmt.Errorf("invalid container info: %w", err)
 	}
 
-	d.logger.Info("Adding container: %s", info.ContainerName)
-	_, err := d.db.Exec(`
-		INSERT INTO containers (container_id, container_name, image_name, domain_name, host_port, container_port, status)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, info.ContainerID, info.ContainerName, info.ImageName, info.DomainName, info.HostPort, info.ContainerPort, info.Status)
+	if info.ServiceName == "" {
+		info.ServiceName = info.ContainerName
+	}
+
+	ports, err := encodePorts(info.Ports)
 	if err != nil {
-		return fmt.Errorf("failed to add container: %w", err)
+		return err
 	}
-	return nil
+
+	d.logger.Info("Adding container: %s", info.ContainerName)
+	return d.withTx(func(tx *Database) error {
+		_, err := tx.q.Exec(`
+			INSERT INTO containers (container_id, container_name, image_name, domain_name, host_port, container_port, status, service_name, replica, address, ports)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, info.ContainerID, info.ContainerName, info.ImageName, info.DomainName, info.HostPort, info.ContainerPort, info.Status, info.ServiceName, info.Replica, info.Address, ports)
+		if err != nil {
+			return fmt.Errorf("failed to add container: %w", err)
+		}
+		return tx.bindPorts(info)
+	})
 }
 
 func (d *Database) UpdateContainerStatus(containerID, status string) error {
 	d.logger.Info("Updating status for container %s to %s", containerID, status)
-	result, err := d.db.Exec("UPDATE containers SET status = ? WHERE container_id = ?", status, containerID)
+	result, err := d.q.Exec("UPDATE containers SET status = ? WHERE container_id = ?", status, containerID)
 	if err != nil {
 		return fmt.Errorf("failed to update container status: %w", err)
 	}
@@ -93,12 +343,27 @@ func (d *Database) UpdateContainerStatus(containerID, status string) error {
 	return nil
 }
 
+// UpdateContainerAddress records where an instance is reached after it moved, as it may
+// when it is restarted on a private network
+func (d *Database) UpdateContainerAddress(containerID, address string) error {
+	d.logger.Info("Updating address of container %s to %s", containerID, address)
+	result, err := d.q.Exec("UPDATE containers SET address = ? WHERE container_id = ?", address, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to update container address: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("no container found with the given ID")
+	}
+	return nil
+}
+
 func (d *Database) GetContainer(containerID string) (*ContainerInfo, error) {
 	d.logger.Info("Fetching container: %s", containerID)
-	var info ContainerInfo
-	err := d.db.QueryRow("SELECT * FROM containers WHERE container_id = ?", containerID).Scan(
-		&info.ID, &info.ContainerID, &info.ContainerName, &info.ImageName,
-		&info.DomainName, &info.HostPort, &info.ContainerPort, &info.Status)
+	info, err := scanContainer(d.q.QueryRow("SELECT "+containerColumns+" FROM containers WHERE container_id = ?", containerID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no container found with ID %s", containerID)
@@ -113,18 +378,15 @@ func (d *Database) GetContainersByPartialName(partialName string) ([]ContainerIn
 	var containers []ContainerInfo
 
 	// Use LIKE with % wildcards for partial matching
-	query := "SELECT * FROM containers WHERE container_name LIKE ?"
-	rows, err := d.db.Query(query, "%"+partialName+"%")
+	query := "SELECT " + containerColumns + " FROM containers WHERE container_name LIKE ?"
+	rows, err := d.q.Query(query, "%"+partialName+"%")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query containers by name: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var info ContainerInfo
-		err := rows.Scan(
-			&info.ID, &info.ContainerID, &info.ContainerName, &info.ImageName,
-			&info.DomainName, &info.HostPort, &info.ContainerPort, &info.Status)
+		info, err := scanContainer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan container row: %w", err)
 		}
@@ -144,16 +406,14 @@ func (d *Database) GetContainersByPartialName(partialName string) ([]ContainerIn
 
 func (d *Database) ListContainers() ([]ContainerInfo, error) {
 	d.logger.Info("Listing all containers")
-	rows, err := d.db.Query("SELECT * FROM containers")
+	rows, err := d.q.Query("SELECT " + containerColumns + " FROM containers")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query containers: %w", err)
 	}
 	defer rows.Close()
 	var containers []ContainerInfo
 	for rows.Next() {
-		var info ContainerInfo
-		err := rows.Scan(&info.ID, &info.ContainerID, &info.ContainerName, &info.ImageName,
-			&info.DomainName, &info.HostPort, &info.ContainerPort, &info.Status)
+		info, err := scanContainer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan container row: %w", err)
 		}
@@ -168,18 +428,20 @@ func (d *Database) ListContainers() ([]ContainerInfo, error) {
 
 func (d *Database) DeleteContainer(containerID string) error {
 	d.logger.Info("Deleting container: %s", containerID)
-	result, err := d.db.Exec("DELETE FROM containers WHERE container_id = ?", containerID)
-	if err != nil {
-		return fmt.Errorf("failed to delete container: %w", err)
-	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
-	if rowsAffected == 0 {
-		return errors.New("no container found with the given ID")
-	}
-	return nil
+	return d.withTx(func(tx *Database) error {
+		result, err := tx.q.Exec("DELETE FROM containers WHERE container_id = ?", containerID)
+		if err != nil {
+			return fmt.Errorf("failed to delete container: %w", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return errors.New("no container found with the given ID")
+		}
+		return tx.releaseContainerPorts(containerID)
+	})
 }
 
 func validateContainerInfo(info ContainerInfo) error {
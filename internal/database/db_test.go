@@ -1,19 +1,76 @@
 package database_test
 
 import (
+	"database/sql"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/dgunzy/go-container-orchestrator/internal/logging"
 	"github.com/dgunzy/go-container-orchestrator/tests"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// TestMain sets up the logger for the tests that open a database of their own, it is
+// otherwise set up by NewContainerManager
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "orchestrator-database-test-*")
+	if err != nil {
+		fmt.Printf("Failed to create log directory: %v\n", err)
+		os.Exit(1)
+	}
+	if err := logging.Setup(logDir); err != nil {
+		fmt.Printf("Failed to set up logging: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
 func TestDatabaseOperations(t *testing.T) {
 	cm := tests.InitTestConfig()
 	defer tests.CleanupTestResources(cm.DockerClient)
-	db := *cm.Db
+
+	t.Run("SQLite", func(t *testing.T) { testStore(t, cm.Db) })
+	t.Run("Postgres", func(t *testing.T) { testStore(t, openPostgres(t)) })
+}
+
+// openPostgres returns a store in a fresh schema of the PostgreSQL instance TEST_POSTGRES_DSN
+// points to, the schema is dropped again when the test is done
+func openPostgres(t *testing.T) database.Store {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	require.NoError(t, err, "Error connecting to PostgreSQL")
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err, "Error creating schema")
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	db, err := database.Open(dsn + sep + "search_path=" + schema)
+	require.NoError(t, err, "Error opening PostgreSQL store")
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.InitSchema(), "Error initializing schema")
+	return db
+}
+
+// testStore runs against every Store implementation
+func testStore(t *testing.T, db database.Store) {
 
 	t.Run("AddAndGetContainer", func(t *testing.T) {
 		containerInfo := database.ContainerInfo{
@@ -177,4 +234,513 @@ func TestDatabaseOperations(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("Services", func(t *testing.T) {
+		err := db.SaveService(database.ServiceInfo{Name: "mock-service", Spec: "replicas: 1\n"})
+		require.NoError(t, err, "Error saving service")
+		err = db.SaveService(database.ServiceInfo{Name: "mock-service", Spec: "replicas: 2\n"})
+		require.NoError(t, err, "Error updating service")
+
+		service, err := db.GetService("mock-service")
+		require.NoError(t, err, "Error getting service")
+		assert.Equal(t, "replicas: 2\n", service.Spec)
+
+		for i, id := range []string{"mock-replica-1", "mock-replica-0"} {
+			err := db.AddContainer(database.ContainerInfo{
+				ContainerID:   id,
+				ContainerName: fmt.Sprintf("mock-service-%d", i),
+				ImageName:     "mock-image:latest",
+				DomainName:    "mock-service.example.com",
+				HostPort:      fmt.Sprintf("900%d", i),
+				ContainerPort: "80",
+				Status:        "running",
+				ServiceName:   "mock-service",
+				Replica:       1 - i,
+			})
+			require.NoError(t, err, "Error adding replica")
+		}
+
+		instances, err := db.GetContainersByService("mock-service")
+		require.NoError(t, err, "Error getting service instances")
+		require.Len(t, instances, 2)
+		assert.Equal(t, "mock-replica-0", instances[0].ContainerID)
+		assert.Equal(t, 1, instances[1].Replica)
+
+		for _, instance := range instances {
+			require.NoError(t, db.DeleteContainer(instance.ContainerID))
+		}
+		require.NoError(t, db.DeleteService("mock-service"))
+		_, err = db.GetService("mock-service")
+		assert.ErrorIs(t, err, database.ErrServiceNotFound)
+	})
+
+	t.Run("LockServices", func(t *testing.T) {
+		assert.Error(t, db.LockServices(), "Services are locked only in a transaction")
+
+		name := fmt.Sprintf("mock-locked-%d", time.Now().UnixNano())
+		locked, saved := make(chan struct{}), make(chan error, 1)
+		go func() {
+			<-locked
+			saved <- db.WithTx(func(tx database.Store) error {
+				if err := tx.LockServices(); err != nil {
+					return err
+				}
+				// The first transaction committed before this one got the lock
+				if _, err := tx.GetService(name); err != nil {
+					return err
+				}
+				return tx.DeleteService(name)
+			})
+		}()
+		err := db.WithTx(func(tx database.Store) error {
+			if err := tx.LockServices(); err != nil {
+				return err
+			}
+			close(locked)
+			time.Sleep(100 * time.Millisecond)
+			return tx.SaveService(database.ServiceInfo{Name: name, Spec: "replicas: 1\n"})
+		})
+		require.NoError(t, err, "Error saving service with services locked")
+		require.NoError(t, <-saved, "The second transaction waits for the first")
+	})
+
+	t.Run("Deployments", func(t *testing.T) {
+		service := fmt.Sprintf("mock-deploy-%d", time.Now().UnixNano())
+		id, err := db.StartDeployment(service, "mock-image:v1", "create")
+		require.NoError(t, err, "Error starting deployment")
+		require.NoError(t, db.AddHookRun(id, database.HookRun{
+			Phase:     "pre_deploy",
+			Command:   "sh -c migrate",
+			ExitCode:  3,
+			Output:    "migration failed\n",
+			StartedAt: time.Now(),
+			Duration:  1500 * time.Millisecond,
+		}))
+		require.NoError(t, db.FinishDeployment(id, database.DeploymentFailed, "pre_deploy hook failed"))
+
+		second, err := db.StartDeployment(service, "mock-image:v2", "rolling")
+		require.NoError(t, err, "Error starting deployment")
+
+		deployments, err := db.ListDeployments(service, 0)
+		require.NoError(t, err, "Error listing deployments")
+		require.Len(t, deployments, 2)
+		assert.Equal(t, second, deployments[0].ID)
+		assert.Equal(t, database.DeploymentRunning, deployments[0].Status)
+		assert.True(t, deployments[0].FinishedAt.IsZero())
+
+		failed := deployments[1]
+		assert.Equal(t, database.DeploymentFailed, failed.Status)
+		assert.Equal(t, "pre_deploy hook failed", failed.Message)
+		require.Len(t, failed.Hooks, 1)
+		assert.Equal(t, 3, failed.Hooks[0].ExitCode)
+		assert.Equal(t, "migration failed\n", failed.Hooks[0].Output)
+		assert.Equal(t, 1500*time.Millisecond, failed.Hooks[0].Duration)
+
+		latest, err := db.ListDeployments(service, 1)
+		require.NoError(t, err, "Error listing deployments")
+		assert.Len(t, latest, 1)
+
+		imported, err := db.ImportDeployment(database.Deployment{
+			ServiceName: service,
+			ImageName:   "mock-image:v0",
+			Strategy:    "create",
+			Status:      database.DeploymentSucceeded,
+			StartedAt:   time.Unix(1700000000, 0),
+			FinishedAt:  time.Unix(1700000060, 0),
+			Hooks:       failed.Hooks,
+		})
+		require.NoError(t, err, "Error importing deployment")
+		deployments, err = db.ListDeployments(service, 0)
+		require.NoError(t, err, "Error listing deployments")
+		require.Len(t, deployments, 3)
+		assert.Equal(t, imported, deployments[0].ID)
+		assert.Equal(t, time.Unix(1700000000, 0), deployments[0].StartedAt)
+		assert.Len(t, deployments[0].Hooks, 1)
+	})
+
+	t.Run("Operations", func(t *testing.T) {
+		service := fmt.Sprintf("mock-ops-%d", time.Now().UnixNano())
+		first, err := db.CreateOperation(service, "update", "holder-a")
+		require.NoError(t, err, "Error queueing operation")
+		second, err := db.CreateOperation(service, "remove", "holder-b")
+		require.NoError(t, err, "Error queueing operation")
+
+		acquired, err := db.AcquireServiceLock(service, "holder-a", first, time.Now().Add(time.Minute))
+		require.NoError(t, err, "Error acquiring lock")
+		assert.True(t, acquired)
+		acquired, err = db.AcquireServiceLock(service, "holder-b", second, time.Now().Add(time.Minute))
+		require.NoError(t, err, "Error acquiring lock")
+		assert.False(t, acquired, "A held lock should not be acquired")
+
+		lock, err := db.GetServiceLock(service)
+		require.NoError(t, err, "Error getting lock")
+		require.NotNil(t, lock)
+		assert.Equal(t, first, lock.OperationID)
+
+		require.NoError(t, db.StartOperation(first))
+		require.NoError(t, db.FinishOperation(first, database.OperationSucceeded, "", "container-id"))
+		require.NoError(t, db.ReleaseServiceLock(service, first))
+		acquired, err = db.AcquireServiceLock(service, "holder-b", second, time.Now().Add(-time.Minute))
+		require.NoError(t, err, "Error acquiring lock")
+		assert.True(t, acquired, "A released lock should be acquired")
+		acquired, err = db.AcquireServiceLock(service, "holder-a", first, time.Now().Add(time.Minute))
+		require.NoError(t, err, "Error acquiring lock")
+		assert.True(t, acquired, "An expired lock should be taken over")
+		require.NoError(t, db.ReleaseServiceLock(service, first))
+
+		op, err := db.GetOperation(first)
+		require.NoError(t, err, "Error getting operation")
+		assert.Equal(t, database.OperationSucceeded, op.Status)
+		assert.Equal(t, "container-id", op.Result)
+		assert.False(t, op.FinishedAt.IsZero())
+
+		failed, err := db.FailStaleOperations(time.Now().Add(time.Minute))
+		require.NoError(t, err, "Error failing stale operations")
+		assert.GreaterOrEqual(t, failed, int64(1))
+		ops, err := db.ListOperations(service, "", 0)
+		require.NoError(t, err, "Error listing operations")
+		require.Len(t, ops, 2)
+		assert.Equal(t, second, ops[0].ID)
+		assert.Equal(t, database.OperationFailed, ops[0].Status)
+
+		ops, err = db.ListOperations(service, database.OperationSucceeded, 0)
+		require.NoError(t, err, "Error listing operations")
+		require.Len(t, ops, 1)
+		assert.Equal(t, first, ops[0].ID)
+
+		_, err = db.GetOperation(-1)
+		assert.ErrorIs(t, err, database.ErrOperationNotFound)
+	})
+
+	t.Run("Journal", func(t *testing.T) {
+		service := fmt.Sprintf("mock-journal-%d", time.Now().UnixNano())
+		retired := database.ContainerInfo{
+			ContainerID:   service + "-old",
+			ContainerName: service,
+			ServiceName:   service,
+			ImageName:     "mock-image:1",
+			DomainName:    "journal.example.com",
+			HostPort:      "8083",
+			ContainerPort: "80",
+			Status:        "running",
+		}
+		require.NoError(t, db.AddContainer(retired))
+
+		id, err := db.BeginJournal("replace", service, "holder-a", []database.ContainerInfo{retired})
+		require.NoError(t, err, "Error writing journal entry")
+		require.NoError(t, db.SetJournalStarted(id, []database.JournalContainer{{Name: service + "-new", ID: service + "-new"}}))
+
+		// A failed transaction leaves both the rows and the entry untouched
+		err = db.WithTx(func(tx database.Store) error {
+			require.NoError(t, tx.DeleteContainer(retired.ContainerID))
+			require.NoError(t, tx.SetJournalState(id, database.JournalCommitted))
+			return fmt.Errorf("mock failure")
+		})
+		assert.Error(t, err)
+		_, err = db.GetContainer(retired.ContainerID)
+		assert.NoError(t, err, "Rolled back delete should keep the row")
+
+		entries, err := db.UnfinishedJournal(time.Now().Add(time.Minute))
+		require.NoError(t, err, "Error reading journal")
+		var entry *database.JournalEntry
+		for i := range entries {
+			if entries[i].ID == id {
+				entry = &entries[i]
+			}
+		}
+		require.NotNil(t, entry)
+		assert.Equal(t, database.JournalPending, entry.State)
+		assert.Equal(t, service+"-new", entry.Started[0].ID)
+		require.Len(t, entry.Retired, 1)
+		assert.Equal(t, retired.ContainerID, entry.Retired[0].ContainerID)
+
+		err = db.WithTx(func(tx database.Store) error {
+			if err := tx.DeleteContainer(retired.ContainerID); err != nil {
+				return err
+			}
+			return tx.SetJournalState(id, database.JournalCommitted)
+		})
+		require.NoError(t, err, "Error committing transaction")
+		_, err = db.GetContainer(retired.ContainerID)
+		assert.Error(t, err, "Committed delete should remove the row")
+
+		entries, err = db.UnfinishedJournal(time.Now().Add(-time.Minute))
+		require.NoError(t, err, "Error reading journal")
+		for _, e := range entries {
+			assert.NotEqual(t, id, e.ID, "Entries renewed recently are not stale")
+		}
+
+		require.NoError(t, db.SetJournalState(id, database.JournalDone))
+		entries, err = db.UnfinishedJournal(time.Now().Add(time.Minute))
+		require.NoError(t, err, "Error reading journal")
+		for _, e := range entries {
+			assert.NotEqual(t, id, e.ID, "Finished entries are not recovered")
+		}
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		service := fmt.Sprintf("mock-audit-%d", time.Now().UnixNano())
+		now := time.Now()
+		entries := []database.AuditEntry{
+			{Time: now.Add(-2 * time.Hour), Actor: "cli:alice", Action: "create", ServiceName: service, Params: `{"image_name":"mock-image:1"}`, Result: database.AuditSucceeded, Duration: 1500 * time.Millisecond, OperationID: 7},
+			{Time: now.Add(-time.Hour), Actor: "grpc:bob", Source: "10.0.0.2:51234", Action: "update", ServiceName: service, Result: database.AuditFailed, Error: "mock failure"},
+			{Time: now, Actor: "cli:alice", Action: "remove", ServiceName: service + "-other", Result: database.AuditSucceeded},
+		}
+		for _, entry := range entries {
+			_, err := db.AddAuditEntry(entry)
+			require.NoError(t, err, "Error adding audit entry")
+		}
+
+		found, err := db.ListAuditLog(database.AuditFilter{ServiceName: service})
+		require.NoError(t, err, "Error listing audit log")
+		require.Len(t, found, 2)
+		assert.Equal(t, "update", found[0].Action, "Entries are newest first")
+		assert.Equal(t, "mock failure", found[0].Error)
+		assert.Equal(t, "10.0.0.2:51234", found[0].Source)
+		assert.Equal(t, "{}", found[0].Params)
+		assert.Equal(t, `{"image_name":"mock-image:1"}`, found[1].Params)
+		assert.Equal(t, 1500*time.Millisecond, found[1].Duration)
+		assert.Equal(t, int64(7), found[1].OperationID)
+
+		found, err = db.ListAuditLog(database.AuditFilter{ServiceName: service, Actor: "cli:alice"})
+		require.NoError(t, err, "Error listing audit log")
+		require.Len(t, found, 1)
+		assert.Equal(t, "create", found[0].Action)
+
+		found, err = db.ListAuditLog(database.AuditFilter{ServiceName: service, Since: now.Add(-90 * time.Minute), Until: now})
+		require.NoError(t, err, "Error listing audit log")
+		require.Len(t, found, 1)
+		assert.Equal(t, "update", found[0].Action)
+
+		found, err = db.ListAuditLog(database.AuditFilter{Actor: "cli:alice", Limit: 1})
+		require.NoError(t, err, "Error listing audit log")
+		require.Len(t, found, 1)
+		assert.Equal(t, "remove", found[0].Action)
+	})
+
+	t.Run("Ports", func(t *testing.T) {
+		service := fmt.Sprintf("mock-ports-%d", time.Now().UnixNano())
+		port := 20000 + int(time.Now().UnixNano()%10000)
+		leaseFor := func(port, replica int, sticky bool) database.PortLease {
+			return database.PortLease{Port: port, ServiceName: service, Replica: replica, PortName: database.DefaultPortName, Sticky: sticky, ExpiresAt: time.Now().Add(time.Hour)}
+		}
+		leaseOf := func(port int) *database.PortLease {
+			leases, err := db.ListPortLeases()
+			require.NoError(t, err, "Error listing port leases")
+			for i := range leases {
+				if leases[i].Port == port {
+					return &leases[i]
+				}
+			}
+			return nil
+		}
+
+		ok, err := db.LeasePort(leaseFor(port, 0, true))
+		require.NoError(t, err, "Error leasing port")
+		assert.True(t, ok)
+		ok, err = db.LeasePort(database.PortLease{Port: port, ServiceName: service + "-other", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err, "Error leasing port")
+		assert.False(t, ok, "A pending lease keeps the port from other services")
+		assert.Equal(t, database.PortPending, leaseOf(port).State())
+		assert.Equal(t, "tcp", leaseOf(port).Protocol)
+		ok, err = db.LeasePort(database.PortLease{Port: port, Protocol: "udp", ServiceName: service + "-other", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err, "Error leasing port")
+		assert.True(t, ok, "Ports are leased per protocol")
+		require.NoError(t, db.ReleasePort(port, "udp"))
+
+		info := database.ContainerInfo{
+			ContainerID:   service + "-1",
+			ContainerName: service,
+			ServiceName:   service,
+			ImageName:     "mock-image:1",
+			DomainName:    "ports.example.com",
+			HostPort:      fmt.Sprint(port),
+			ContainerPort: "80",
+			Status:        "running",
+		}
+		require.NoError(t, db.AddContainer(info))
+		assert.Equal(t, database.PortInUse, leaseOf(port).State())
+		assert.Equal(t, info.ContainerID, leaseOf(port).ContainerID)
+
+		// A sticky port outlives its container and goes back to the same replica only
+		require.NoError(t, db.DeleteContainer(info.ContainerID))
+		require.NotNil(t, leaseOf(port))
+		assert.Equal(t, database.PortReserved, leaseOf(port).State())
+		ok, err = db.LeasePort(leaseFor(port, 1, true))
+		require.NoError(t, err, "Error leasing port")
+		assert.False(t, ok, "Sticky ports are kept for their replica")
+		ok, err = db.LeasePort(leaseFor(port, 0, true))
+		require.NoError(t, err, "Error leasing port")
+		assert.True(t, ok)
+		require.NoError(t, db.ReleasePort(port, "tcp"))
+		assert.Equal(t, database.PortReserved, leaseOf(port).State(), "Releasing a sticky port keeps it for the replica")
+
+		// Once the replica holds another port the old one is not kept
+		info.ContainerID, info.HostPort = service+"-2", fmt.Sprint(port+1)
+		require.NoError(t, db.AddContainer(info))
+		require.NoError(t, db.DeleteService(service))
+		assert.Nil(t, leaseOf(port), "Removing the service frees its sticky ports")
+		require.NoError(t, db.DeleteContainer(info.ContainerID))
+		assert.Nil(t, leaseOf(port+1), "Ports that are not sticky are freed with their container")
+
+		ok, err = db.LeasePort(database.PortLease{Port: port, ServiceName: service, ExpiresAt: time.Now().Add(-time.Minute)})
+		require.NoError(t, err, "Error leasing port")
+		require.True(t, ok)
+		ok, err = db.LeasePort(database.PortLease{Port: port, ServiceName: service + "-other", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err, "Error leasing port")
+		assert.True(t, ok, "Expired pending leases are taken over")
+		require.NoError(t, db.ReleasePort(port, "tcp"))
+		assert.Nil(t, leaseOf(port))
+	})
+
+	t.Run("NamedPorts", func(t *testing.T) {
+		service := fmt.Sprintf("mock-named-ports-%d", time.Now().UnixNano())
+		port := 30000 + int(time.Now().UnixNano()%10000)
+		info := database.ContainerInfo{
+			ContainerID:   service + "-1",
+			ContainerName: service,
+			ServiceName:   service,
+			ImageName:     "mock-image:1",
+			DomainName:    "named-ports.example.com",
+			HostPort:      fmt.Sprint(port),
+			ContainerPort: "8080",
+			Status:        "running",
+			Ports: []database.PortMapping{
+				{Name: "web", Protocol: "tcp", ContainerPort: 8080, HostPort: port, Role: "http"},
+				{Name: "metrics", Protocol: "tcp", ContainerPort: 9090, HostPort: port + 1, Role: "none"},
+				{Name: "debug", Protocol: "udp", ContainerPort: 7000},
+			},
+		}
+		require.NoError(t, db.AddContainer(info))
+
+		saved, err := db.GetContainer(info.ContainerID)
+		require.NoError(t, err, "Error getting container from database")
+		assert.Equal(t, info.Ports, saved.Ports)
+
+		names := make(map[int]string)
+		leases, err := db.ListPortLeases()
+		require.NoError(t, err, "Error listing port leases")
+		for _, lease := range leases {
+			if lease.ContainerID == info.ContainerID {
+				names[lease.Port] = lease.PortName
+			}
+		}
+		assert.Equal(t, map[int]string{port: "web", port + 1: "metrics"}, names, "Every published port is leased")
+
+		require.NoError(t, db.DeleteContainer(info.ContainerID))
+		leases, err = db.ListPortLeases()
+		require.NoError(t, err, "Error listing port leases")
+		for _, lease := range leases {
+			assert.NotEqual(t, info.ServiceName, lease.ServiceName, "Ports are freed with their container")
+		}
+
+		// Containers recorded with a single port describe it as a named one
+		legacy := database.ContainerInfo{
+			ContainerID:   service + "-legacy",
+			ContainerName: service,
+			ImageName:     "mock-image:1",
+			DomainName:    "named-ports.example.com",
+			ContainerPort: "53/udp",
+			Status:        "running",
+		}
+		require.NoError(t, db.AddContainer(legacy))
+		defer func() { _ = db.DeleteContainer(legacy.ContainerID) }()
+		saved, err = db.GetContainer(legacy.ContainerID)
+		require.NoError(t, err, "Error getting container from database")
+		assert.Equal(t, []database.PortMapping{{Name: database.DefaultPortName, Protocol: "udp", ContainerPort: 53, Role: "http"}}, saved.Ports)
+	})
+
+	t.Run("ContainerAddress", func(t *testing.T) {
+		info := database.ContainerInfo{
+			ContainerID:   fmt.Sprintf("mock-private-%d", time.Now().UnixNano()),
+			ContainerName: "mock-private",
+			ImageName:     "mock-image:latest",
+			DomainName:    "private.example.com",
+			ContainerPort: "80",
+			Status:        "running",
+			Address:       "172.18.0.2:80",
+		}
+		require.NoError(t, db.AddContainer(info))
+		defer func() { _ = db.DeleteContainer(info.ContainerID) }()
+
+		saved, err := db.GetContainer(info.ContainerID)
+		require.NoError(t, err, "Error getting container from database")
+		assert.Equal(t, "172.18.0.2:80", saved.Address)
+		assert.Empty(t, saved.HostPort)
+
+		require.NoError(t, db.UpdateContainerAddress(info.ContainerID, "172.18.0.3:80"))
+		saved, err = db.GetContainer(info.ContainerID)
+		require.NoError(t, err, "Error getting container from database")
+		assert.Equal(t, "172.18.0.3:80", saved.Address)
+		assert.Error(t, db.UpdateContainerAddress("mock-missing", "172.18.0.4:80"))
+	})
+
+	t.Run("Certificates", func(t *testing.T) {
+		domain := fmt.Sprintf("mock-%d.example.com", time.Now().UnixNano())
+		notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+		cert := database.Certificate{Domain: domain, Source: "acme", CertPEM: "cert 1", KeyData: "v1:key 1", NotAfter: notAfter}
+		require.NoError(t, db.SaveCertificate(cert))
+		defer func() { _ = db.DeleteCertificate(domain) }()
+
+		saved, err := db.GetCertificate(domain)
+		require.NoError(t, err, "Error getting certificate")
+		assert.Equal(t, "cert 1", saved.CertPEM)
+		assert.Equal(t, "v1:key 1", saved.KeyData)
+		assert.True(t, saved.NotAfter.Equal(notAfter))
+		assert.False(t, saved.UpdatedAt.IsZero())
+
+		cert.CertPEM, cert.KeyData = "cert 2", "v1:key 2"
+		require.NoError(t, db.SaveCertificate(cert), "Saving a certificate again replaces it")
+		certs, err := db.ListCertificates()
+		require.NoError(t, err, "Error listing certificates")
+		var found []database.Certificate
+		for _, c := range certs {
+			if c.Domain == domain {
+				found = append(found, c)
+			}
+		}
+		require.Len(t, found, 1)
+		assert.Equal(t, "cert 2", found[0].CertPEM)
+
+		require.NoError(t, db.DeleteCertificate(domain))
+		_, err = db.GetCertificate(domain)
+		assert.ErrorIs(t, err, database.ErrCertificateNotFound)
+
+		directory := fmt.Sprintf("https://ca-%d.example.com/directory", time.Now().UnixNano())
+		_, err = db.GetACMEAccount(directory)
+		assert.ErrorIs(t, err, database.ErrACMEAccountNotFound)
+		require.NoError(t, db.SaveACMEAccount(database.ACMEAccount{DirectoryURL: directory, KeyData: "v1:account", URI: directory + "/acct/1"}))
+		account, err := db.GetACMEAccount(directory)
+		require.NoError(t, err, "Error getting ACME account")
+		assert.Equal(t, "v1:account", account.KeyData)
+		assert.Equal(t, directory+"/acct/1", account.URI)
+		accounts, err := db.ListACMEAccounts()
+		require.NoError(t, err, "Error listing ACME accounts")
+		assert.True(t, slices.ContainsFunc(accounts, func(a database.ACMEAccount) bool { return a.DirectoryURL == directory }))
+
+		host := fmt.Sprintf("registry-%d.example.com", time.Now().UnixNano())
+		_, err = db.GetRegistryLogin(host)
+		assert.ErrorIs(t, err, database.ErrRegistryLoginNotFound)
+		require.NoError(t, db.SaveRegistryLogin(database.RegistryLogin{Host: host, Username: "deploy", PasswordData: "v1:password 1"}))
+		require.NoError(t, db.SaveRegistryLogin(database.RegistryLogin{Host: host, Username: "deploy", PasswordData: "v1:password 2"}), "Saving a login again replaces it")
+		login, err := db.GetRegistryLogin(host)
+		require.NoError(t, err, "Error getting registry login")
+		assert.Equal(t, "deploy", login.Username)
+		assert.Equal(t, "v1:password 2", login.PasswordData)
+		logins, err := db.ListRegistryLogins()
+		require.NoError(t, err, "Error listing registry logins")
+		assert.True(t, slices.ContainsFunc(logins, func(l database.RegistryLogin) bool { return l.Host == host }))
+	})
+
+	t.Run("BuiltImages", func(t *testing.T) {
+		id := fmt.Sprintf("sha256:mock-%d", time.Now().UnixNano())
+		built, err := db.IsBuiltImage(id)
+		require.NoError(t, err, "Error checking built image")
+		assert.False(t, built)
+		require.NoError(t, db.AddBuiltImage(id, "web:1"))
+		require.NoError(t, db.AddBuiltImage(id, "web:2"), "Tagging the same image again is fine")
+		built, err = db.IsBuiltImage(id)
+		require.NoError(t, err, "Error checking built image")
+		assert.True(t, built)
+	})
 }
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	DeploymentRunning   = "running"
+	DeploymentSucceeded = "succeeded"
+	DeploymentFailed    = "failed"
+)
+
+// Deployment is one create or update of a service and the hooks it ran
+type Deployment struct {
+	ID          int64
+	ServiceName string
+	ImageName   string
+	Strategy    string
+	Status      string
+	Message     string
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	Hooks       []HookRun
+}
+
+type HookRun struct {
+	Phase    string
+	Command  string
+	ExitCode int
+	Output   string
+	// Error is set when the hook could not be run or timed out
+	Error     string
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+func (d *Database) StartDeployment(serviceName, imageName, strategy string) (int64, error) {
+	d.logger.Info("Recording deployment of %s", serviceName)
+	var id int64
+	err := d.q.QueryRow(`
+		INSERT INTO deployments (service_name, image_name, strategy, status, started_at)
+		VALUES (?, ?, ?, ?, ?) RETURNING id
+	`, serviceName, imageName, strategy, DeploymentRunning, time.Now().Unix()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert deployment: %w", err)
+	}
+	return id, nil
+}
+
+// ImportDeployment records a finished deployment with its hook runs as it happened, for
+// restoring the history of another host. Its ID is not kept.
+func (d *Database) ImportDeployment(dep Deployment) (int64, error) {
+	var id int64
+	err := d.withTx(func(tx *Database) error {
+		var finishedAt int64
+		if !dep.FinishedAt.IsZero() {
+			finishedAt = dep.FinishedAt.Unix()
+		}
+		err := tx.q.QueryRow(`
+			INSERT INTO deployments (service_name, image_name, strategy, status, message, started_at, finished_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id
+		`, dep.ServiceName, dep.ImageName, dep.Strategy, dep.Status, dep.Message, dep.StartedAt.Unix(), finishedAt).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to insert deployment: %w", err)
+		}
+		for _, run := range dep.Hooks {
+			if err := tx.AddHookRun(id, run); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return id, err
+}
+
+func (d *Database) FinishDeployment(id int64, status, message string) error {
+	d.logger.Info("Deployment %d finished: %s", id, status)
+	_, err := d.q.Exec("UPDATE deployments SET status = ?, message = ?, finished_at = ? WHERE id = ?",
+		status, message, time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update deployment: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) AddHookRun(deploymentID int64, run HookRun) error {
+	_, err := d.q.Exec(`
+		INSERT INTO hook_runs (deployment_id, phase, command, exit_code, output, error, started_at, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, deploymentID, run.Phase, run.Command, run.ExitCode, run.Output, run.Error, run.StartedAt.Unix(), run.Duration.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to insert hook run: %w", err)
+	}
+	return nil
+}
+
+// ListDeployments returns the latest deployments of a service, newest first, with their hook runs.
+// A limit of zero or less returns all of them.
+func (d *Database) ListDeployments(serviceName string, limit int) ([]Deployment, error) {
+	d.logger.Info("Listing deployments of %s", serviceName)
+	if limit <= 0 {
+		// PostgreSQL has no LIMIT -1 for no limit
+		limit = math.MaxInt32
+	}
+	rows, err := d.q.Query(`
+		SELECT id, service_name, image_name, strategy, status, message, started_at, finished_at
+		FROM deployments WHERE service_name = ? ORDER BY id DESC LIMIT ?
+	`, serviceName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deployments: %w", err)
+	}
+	defer rows.Close()
+
+	var deployments []Deployment
+	for rows.Next() {
+		var dep Deployment
+		var startedAt, finishedAt int64
+		if err := rows.Scan(&dep.ID, &dep.ServiceName, &dep.ImageName, &dep.Strategy, &dep.Status, &dep.Message, &startedAt, &finishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan deployment row: %w", err)
+		}
+		dep.StartedAt = time.Unix(startedAt, 0)
+		if finishedAt != 0 {
+			dep.FinishedAt = time.Unix(finishedAt, 0)
+		}
+		deployments = append(deployments, dep)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deployment rows: %w", err)
+	}
+
+	for i := range deployments {
+		hooks, err := d.hookRuns(deployments[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		deployments[i].Hooks = hooks
+	}
+	return deployments, nil
+}
+
+func (d *Database) hookRuns(deploymentID int64) ([]HookRun, error) {
+	rows, err := d.q.Query(`
+		SELECT phase, command, exit_code, output, error, started_at, duration_ms
+		FROM hook_runs WHERE deployment_id = ? ORDER BY id
+	`, deploymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hook runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []HookRun
+	for rows.Next() {
+		var run HookRun
+		var startedAt, durationMs int64
+		if err := rows.Scan(&run.Phase, &run.Command, &run.ExitCode, &run.Output, &run.Error, &startedAt, &durationMs); err != nil {
+			return nil, fmt.Errorf("failed to scan hook run row: %w", err)
+		}
+		run.StartedAt = time.Unix(startedAt, 0)
+		run.Duration = time.Duration(durationMs) * time.Millisecond
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AddBuiltImage records that the orchestrator built the image with an ID, under the name it
+// was tagged with
+func (d *Database) AddBuiltImage(imageID, imageName string) error {
+	if imageID == "" {
+		return errors.New("image ID cannot be empty")
+	}
+	_, err := d.q.Exec(`
+		INSERT INTO built_images (image_id, image_name, built_at) VALUES (?, ?, ?)
+		ON CONFLICT(image_id) DO UPDATE SET image_name = excluded.image_name, built_at = excluded.built_at
+	`, imageID, imageName, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to record built image: %w", err)
+	}
+	return nil
+}
+
+// IsBuiltImage reports whether the orchestrator built the image with an ID
+func (d *Database) IsBuiltImage(imageID string) (bool, error) {
+	var id string
+	err := d.q.QueryRow("SELECT image_id FROM built_images WHERE image_id = ?", imageID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query built images: %w", err)
+	}
+	return true, nil
+}
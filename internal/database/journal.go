@@ -0,0 +1,110 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A journal entry is pending while its Docker side effects are under way, committed once
+// its database changes are, and done or undone when it has been completed or reverted
+const (
+	JournalPending   = "pending"
+	JournalCommitted = "committed"
+	JournalDone      = "done"
+	JournalUndone    = "undone"
+)
+
+// JournalEntry records a multi-step change to a service before any of it happens, so a
+// change interrupted by a crash can be completed or undone on startup
+type JournalEntry struct {
+	ID          int64
+	Kind        string
+	ServiceName string
+	State       string
+	// Started are the containers the change creates, each recorded before it is created
+	Started []JournalContainer
+	// Retired are the instances the change replaces or removes once it is committed
+	Retired   []ContainerInfo
+	Holder    string
+	CreatedAt time.Time
+}
+
+// JournalContainer is a container being started, ID is empty until Docker has created it
+type JournalContainer struct {
+	Name string `json:"name"`
+	ID   string `json:"id,omitempty"`
+}
+
+func (d *Database) BeginJournal(kind, serviceName, holder string, retired []ContainerInfo) (int64, error) {
+	encoded, err := json.Marshal(retired)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	now := time.Now().Unix()
+	var id int64
+	err = d.q.QueryRow(`
+		INSERT INTO journal (kind, service_name, state, retired, holder, created_at, heartbeat_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id
+	`, kind, serviceName, JournalPending, string(encoded), holder, now, now).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+	return id, nil
+}
+
+func (d *Database) SetJournalStarted(id int64, started []JournalContainer) error {
+	encoded, err := json.Marshal(started)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	if _, err := d.q.Exec("UPDATE journal SET started = ? WHERE id = ?", string(encoded), id); err != nil {
+		return fmt.Errorf("failed to update journal entry: %w", err)
+	}
+	return nil
+}
+
+// SetJournalState moves an entry on. Committing is done in the transaction of the changes
+// it commits.
+func (d *Database) SetJournalState(id int64, state string) error {
+	var finishedAt int64
+	if state == JournalDone || state == JournalUndone {
+		finishedAt = time.Now().Unix()
+	}
+	if _, err := d.q.Exec("UPDATE journal SET state = ?, finished_at = ? WHERE id = ?", state, finishedAt, id); err != nil {
+		return fmt.Errorf("failed to update journal entry: %w", err)
+	}
+	return nil
+}
+
+// UnfinishedJournal returns the pending and committed entries whose process stopped
+// renewing them before staleBefore, oldest first
+func (d *Database) UnfinishedJournal(staleBefore time.Time) ([]JournalEntry, error) {
+	rows, err := d.q.Query(`
+		SELECT id, kind, service_name, state, started, retired, holder, created_at FROM journal
+		WHERE state IN (?, ?) AND heartbeat_at < ? ORDER BY id
+	`, JournalPending, JournalCommitted, staleBefore.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query journal: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []JournalEntry
+	for rows.Next() {
+		var entry JournalEntry
+		var started, retired string
+		var createdAt int64
+		if err := rows.Scan(&entry.ID, &entry.Kind, &entry.ServiceName, &entry.State, &started, &retired, &entry.Holder, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan journal row: %w", err)
+		}
+		if err := json.Unmarshal([]byte(started), &entry.Started); err != nil {
+			return nil, fmt.Errorf("failed to decode journal entry %d: %w", entry.ID, err)
+		}
+		if err := json.Unmarshal([]byte(retired), &entry.Retired); err != nil {
+			return nil, fmt.Errorf("failed to decode journal entry %d: %w", entry.ID, err)
+		}
+		entry.CreatedAt = time.Unix(createdAt, 0)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
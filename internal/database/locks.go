@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ServiceLock is held by the operation currently changing a service. It expires unless its
+// holder keeps renewing it, so a crashed process does not block the service forever.
+type ServiceLock struct {
+	ServiceName string
+	Holder      string
+	OperationID int64
+	ExpiresAt   time.Time
+}
+
+// AcquireServiceLock takes the lock of a service for an operation, reporting false while
+// another holder has an unexpired lease on it
+func (d *Database) AcquireServiceLock(serviceName, holder string, operationID int64, expiresAt time.Time) (bool, error) {
+	result, err := d.q.Exec(`
+		INSERT INTO service_locks (service_name, holder, operation_id, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(service_name) DO UPDATE SET
+			holder = excluded.holder, operation_id = excluded.operation_id, expires_at = excluded.expires_at
+		WHERE service_locks.expires_at < ?
+	`, serviceName, holder, operationID, expiresAt.Unix(), time.Now().Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire service lock: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire service lock: %w", err)
+	}
+	return rows > 0, nil
+}
+
+func (d *Database) ReleaseServiceLock(serviceName string, operationID int64) error {
+	_, err := d.q.Exec("DELETE FROM service_locks WHERE service_name = ? AND operation_id = ?", serviceName, operationID)
+	if err != nil {
+		return fmt.Errorf("failed to release service lock: %w", err)
+	}
+	return nil
+}
+
+// GetServiceLock returns the lock of a service, nil when nobody holds it
+func (d *Database) GetServiceLock(serviceName string) (*ServiceLock, error) {
+	var lock ServiceLock
+	var expiresAt int64
+	err := d.q.QueryRow("SELECT service_name, holder, operation_id, expires_at FROM service_locks WHERE service_name = ?", serviceName).
+		Scan(&lock.ServiceName, &lock.Holder, &lock.OperationID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service lock: %w", err)
+	}
+	lock.ExpiresAt = time.Unix(expiresAt, 0)
+	return &lock, nil
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered step of the schema. It either runs the SQL of an embedded
+// migrations/<dialect>/NNNN_name.sql file or, for changes SQL cannot express, a Go
+// function. Both dialects have the same versions.
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+	up      func(tx *Database) error
+}
+
+// goMigrations are the migrations written in Go per dialect, they are ordered with the SQL
+// files by version
+var goMigrations = map[string][]Migration{
+	dialectSQLite: {
+		{Version: 2, Name: "service_replicas", up: migrateServiceReplicas},
+	},
+}
+
+// MigrationState is a migration and whether it has been applied to the database
+type MigrationState struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// MigrationReport is what Migrate did, Backup is empty when no copy was needed
+type MigrationReport struct {
+	Applied []MigrationState
+	Backup  string
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+func loadMigrations(dialect string) ([]Migration, error) {
+	migrations := append([]Migration(nil), goMigrations[dialect]...)
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: match[2], sql: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d_%s is out of sequence, expected version %d", m.Version, m.Name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+func (d *Database) initMigrationsTable() error {
+	_, err := d.q.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at BIGINT NOT NULL
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// MigrationStatus lists every migration known to this build and whether it was applied
+func (d *Database) MigrationStatus() ([]MigrationState, error) {
+	migrations, err := loadMigrations(d.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.initMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := d.q.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration row: %w", err)
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migration rows: %w", err)
+	}
+
+	var states []MigrationState
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		states = append(states, MigrationState{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: at})
+	}
+	// A newer build migrated this database further than this one knows how to
+	for version := range applied {
+		if version > len(migrations) {
+			return states, fmt.Errorf("database is at schema version %d, newer than the latest known version %d", version, len(migrations))
+		}
+	}
+	return states, nil
+}
+
+// Migrate applies the pending migrations in order, each in its own transaction. A database
+// that already holds data is copied next to its file first.
+func (d *Database) Migrate() (*MigrationReport, error) {
+	migrations, err := loadMigrations(d.dialect)
+	if err != nil {
+		return nil, err
+	}
+	states, err := d.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	report := &MigrationReport{}
+	var pending []Migration
+	for i, state := range states {
+		if !state.Applied {
+			pending = append(pending, migrations[i])
+		}
+	}
+	if len(pending) == 0 {
+		return report, nil
+	}
+
+	report.Backup, err = d.backupBeforeMigrating(pending[0].Version)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		applied, err := d.applyMigration(m)
+		if err != nil {
+			return report, err
+		}
+		if applied {
+			report.Applied = append(report.Applied, MigrationState{Version: m.Version, Name: m.Name, Applied: true, AppliedAt: time.Now()})
+		}
+	}
+	return report, nil
+}
+
+// applyMigration reports false when another process applied the migration first
+func (d *Database) applyMigration(m Migration) (bool, error) {
+	applied := false
+	err := d.withTx(func(tx *Database) error {
+		// Recording the migration first takes the write lock, so two processes starting at
+		// once never both apply it
+		result, err := tx.q.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			m.Version, m.Name, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		d.logger.Info("Applying migration %d_%s", m.Version, m.Name)
+		if m.up != nil {
+			err = m.up(tx)
+		} else {
+			_, err = tx.q.Exec(m.sql)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		applied = true
+		return nil
+	})
+	return applied, err
+}
+
+// backupBeforeMigrating copies a SQLite database that holds tables to
+// <path>.v<version>-<time>.bak, fresh and in-memory databases have nothing to lose
+func (d *Database) backupBeforeMigrating(version int) (string, error) {
+	if d.dialect == dialectPostgres {
+		d.logger.Warn("Migrating PostgreSQL schema from version %d, back it up with pg_dump beforehand to be able to restore it", version-1)
+		return "", nil
+	}
+	if d.path == "" || d.path == ":memory:" {
+		return "", nil
+	}
+	var tables int
+	err := d.q.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')").Scan(&tables)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if tables == 0 {
+		return "", nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", d.path, version-1, time.Now().Format("20060102150405"))
+	if _, err := os.Stat(backup); err == nil {
+		return backup, nil
+	}
+	d.logger.Info("Backing up database to %s before migrating", backup)
+	if _, err := d.db.Exec("VACUUM INTO ?", backup); err != nil {
+		return "", fmt.Errorf("failed to back up database before migrating: %w", err)
+	}
+	return backup, nil
+}
+
+// migrateServiceReplicas adds the columns of services with replicas to databases created
+// before them and derives the service of each existing container from its name
+func migrateServiceReplicas(tx *Database) error {
+	if err := tx.addColumnIfMissing("containers", "service_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := tx.addColumnIfMissing("containers", "replica", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return tx.backfillServiceNames()
+}
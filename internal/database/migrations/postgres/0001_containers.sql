@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS containers (
+	id BIGSERIAL PRIMARY KEY,
+	container_id TEXT NOT NULL,
+	container_name TEXT NOT NULL,
+	image_name TEXT NOT NULL,
+	domain_name TEXT NOT NULL,
+	host_port INTEGER NOT NULL,
+	container_port INTEGER NOT NULL,
+	status TEXT NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE containers ADD COLUMN IF NOT EXISTS service_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE containers ADD COLUMN IF NOT EXISTS replica INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS deployments (
+	id BIGSERIAL PRIMARY KEY,
+	service_name TEXT NOT NULL,
+	image_name TEXT NOT NULL,
+	strategy TEXT NOT NULL,
+	status TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	started_at BIGINT NOT NULL,
+	finished_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS hook_runs (
+	id BIGSERIAL PRIMARY KEY,
+	deployment_id BIGINT NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+	phase TEXT NOT NULL,
+	command TEXT NOT NULL,
+	exit_code INTEGER NOT NULL,
+	output TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	started_at BIGINT NOT NULL,
+	duration_ms BIGINT NOT NULL
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS operations (
+	id BIGSERIAL PRIMARY KEY,
+	service_name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL DEFAULT '',
+	holder TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	started_at BIGINT NOT NULL DEFAULT 0,
+	finished_at BIGINT NOT NULL DEFAULT 0,
+	heartbeat_at BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS service_locks (
+	service_name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	operation_id BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS journal (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	service_name TEXT NOT NULL,
+	state TEXT NOT NULL,
+	started TEXT NOT NULL DEFAULT '[]',
+	retired TEXT NOT NULL DEFAULT '[]',
+	holder TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	heartbeat_at BIGINT NOT NULL,
+	finished_at BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	created_at BIGINT NOT NULL,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	service_name TEXT NOT NULL,
+	params TEXT NOT NULL DEFAULT '{}',
+	result TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	duration_ms BIGINT NOT NULL,
+	operation_id BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS audit_log_service ON audit_log (service_name, created_at);
+-- The audit log is append-only
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,2 @@
+-- The address routers reach an instance at, empty for instances published on a host port
+ALTER TABLE containers ADD COLUMN IF NOT EXISTS address TEXT NOT NULL DEFAULT '';
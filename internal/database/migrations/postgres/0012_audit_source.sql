@@ -0,0 +1,2 @@
+-- Where a change came from, the peer address of a gRPC request and empty for local ones
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
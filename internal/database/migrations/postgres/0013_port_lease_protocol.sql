@@ -0,0 +1,6 @@
+-- Host ports are leased per protocol, a service may publish 53/tcp and 53/udp on the same
+-- port. Leases so far are kept as tcp, which at worst holds a port back from a tcp port the
+-- udp instance does not use.
+ALTER TABLE port_leases ADD COLUMN IF NOT EXISTS protocol TEXT NOT NULL DEFAULT 'tcp';
+ALTER TABLE port_leases DROP CONSTRAINT IF EXISTS port_leases_pkey;
+ALTER TABLE port_leases ADD PRIMARY KEY (port, protocol);
@@ -0,0 +1,8 @@
+-- Logins of private registries by host, the password is encrypted by the orchestrator like
+-- certificate keys
+CREATE TABLE IF NOT EXISTS registry_logins (
+	host TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	password_data TEXT NOT NULL,
+	updated_at BIGINT NOT NULL
+);
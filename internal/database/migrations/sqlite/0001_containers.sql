@@ -0,0 +1,11 @@
+-- The schema from before migrations were tracked, so existing databases adopt it as is
+CREATE TABLE IF NOT EXISTS containers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	container_id TEXT NOT NULL,
+	container_name TEXT NOT NULL,
+	image_name TEXT NOT NULL,
+	domain_name TEXT NOT NULL,
+	host_port INTEGER NOT NULL,
+	container_port INTEGER NOT NULL,
+	status TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS services (
+	name TEXT PRIMARY KEY,
+	spec TEXT NOT NULL
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS operations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	service_name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL DEFAULT '',
+	holder TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	started_at INTEGER NOT NULL DEFAULT 0,
+	finished_at INTEGER NOT NULL DEFAULT 0,
+	heartbeat_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS service_locks (
+	service_name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	operation_id INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
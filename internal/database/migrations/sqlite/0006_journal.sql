@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS journal (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	service_name TEXT NOT NULL,
+	state TEXT NOT NULL,
+	started TEXT NOT NULL DEFAULT '[]',
+	retired TEXT NOT NULL DEFAULT '[]',
+	holder TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	heartbeat_at INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL DEFAULT 0
+);
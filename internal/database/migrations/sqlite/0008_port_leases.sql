@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS port_leases (
+	port INTEGER PRIMARY KEY,
+	service_name TEXT NOT NULL,
+	replica INTEGER NOT NULL DEFAULT 0,
+	container_id TEXT NOT NULL DEFAULT '',
+	sticky BOOLEAN NOT NULL DEFAULT FALSE,
+	leased_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS port_leases_container ON port_leases (container_id);
+
+-- Containers created before leases existed keep their ports
+INSERT INTO port_leases (port, service_name, replica, container_id, leased_at)
+SELECT CAST(host_port AS INTEGER), service_name, replica, container_id, CAST(strftime('%s', 'now') AS INTEGER)
+FROM containers WHERE host_port <> '' AND host_port NOT GLOB '*[^0-9]*'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+-- The named ports of an instance, JSON encoded, empty for containers recorded with a single port
+ALTER TABLE containers ADD COLUMN ports TEXT NOT NULL DEFAULT '';
+
+-- Leases so far are all of the single port of a service, which is named http
+ALTER TABLE port_leases ADD COLUMN port_name TEXT NOT NULL DEFAULT '';
+UPDATE port_leases SET port_name = 'http' WHERE port_name = '';
@@ -0,0 +1,18 @@
+-- Certificates served for domains. The private key is encrypted by the orchestrator
+-- before it is stored, the certificate itself is public.
+CREATE TABLE IF NOT EXISTS certificates (
+	domain TEXT PRIMARY KEY,
+	source TEXT NOT NULL,
+	cert_pem TEXT NOT NULL,
+	key_data TEXT NOT NULL,
+	not_after INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+-- ACME accounts by the directory URL of their CA, the key is encrypted like certificate keys
+CREATE TABLE IF NOT EXISTS acme_accounts (
+	directory_url TEXT PRIMARY KEY,
+	key_data TEXT NOT NULL,
+	uri TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL
+);
@@ -0,0 +1,20 @@
+-- Host ports are leased per protocol, a service may publish 53/tcp and 53/udp on the same
+-- port. SQLite cannot change a primary key, the table is copied. Leases so far are kept as
+-- tcp, which at worst holds a port back from a tcp port the udp instance does not use.
+CREATE TABLE port_leases_protocol (
+	port INTEGER NOT NULL,
+	protocol TEXT NOT NULL DEFAULT 'tcp',
+	service_name TEXT NOT NULL,
+	replica INTEGER NOT NULL DEFAULT 0,
+	port_name TEXT NOT NULL DEFAULT '',
+	container_id TEXT NOT NULL DEFAULT '',
+	sticky BOOLEAN NOT NULL DEFAULT FALSE,
+	leased_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (port, protocol)
+);
+INSERT INTO port_leases_protocol (port, service_name, replica, port_name, container_id, sticky, leased_at, expires_at)
+SELECT port, service_name, replica, port_name, container_id, sticky, leased_at, expires_at FROM port_leases;
+DROP TABLE port_leases;
+ALTER TABLE port_leases_protocol RENAME TO port_leases;
+CREATE INDEX IF NOT EXISTS port_leases_container ON port_leases (container_id);
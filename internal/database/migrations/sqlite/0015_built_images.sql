@@ -0,0 +1,7 @@
+-- Images the orchestrator built, by image ID. Images built FROM them inherit their labels,
+-- so the ID is what tells them apart.
+CREATE TABLE IF NOT EXISTS built_images (
+	image_id TEXT PRIMARY KEY,
+	image_name TEXT NOT NULL,
+	built_at INTEGER NOT NULL
+);
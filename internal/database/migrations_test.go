@@ -0,0 +1,122 @@
+package database_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openFixture creates a database from one of the SQL dumps in testdata
+func openFixture(t *testing.T, fixture string) (*database.Database, string) {
+	dump, err := os.ReadFile(filepath.Join("testdata", fixture))
+	require.NoError(t, err, "Error reading fixture")
+	path := filepath.Join(t.TempDir(), "fixture.db")
+	raw, err := sql.Open("sqlite3", path)
+	require.NoError(t, err, "Error opening fixture database")
+	_, err = raw.Exec(string(dump))
+	require.NoError(t, err, "Error loading fixture")
+	require.NoError(t, raw.Close())
+
+	db, err := database.NewDatabase(path)
+	require.NoError(t, err, "Error opening database")
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func assertFullyMigrated(t *testing.T, db database.Store) {
+	states, err := db.MigrationStatus()
+	require.NoError(t, err, "Error reading migration status")
+	require.NotEmpty(t, states)
+	for _, state := range states {
+		assert.True(t, state.Applied, "Migration %d_%s should be applied", state.Version, state.Name)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	t.Run("FreshDatabase", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "fresh.db")
+		db, err := database.NewDatabase(path)
+		require.NoError(t, err, "Error opening database")
+		defer db.Close()
+
+		states, err := db.MigrationStatus()
+		require.NoError(t, err, "Error reading migration status")
+		for _, state := range states {
+			assert.False(t, state.Applied)
+		}
+
+		report, err := db.Migrate()
+		require.NoError(t, err, "Error migrating")
+		assert.Len(t, report.Applied, len(states))
+		assert.Empty(t, report.Backup, "A fresh database needs no backup")
+		assertFullyMigrated(t, db)
+
+		report, err = db.Migrate()
+		require.NoError(t, err, "Error migrating again")
+		assert.Empty(t, report.Applied, "A migrated database has nothing pending")
+
+		// The audit log rejects changes to its entries, whoever makes them
+		_, err = db.AddAuditEntry(database.AuditEntry{Time: time.Now(), Actor: "test", Action: "create", Result: database.AuditSucceeded})
+		require.NoError(t, err, "Error adding audit entry")
+		raw, err := sql.Open("sqlite3", path)
+		require.NoError(t, err, "Error opening database")
+		defer raw.Close()
+		_, err = raw.Exec("UPDATE audit_log SET actor = 'someone else'")
+		assert.ErrorContains(t, err, "append-only")
+		_, err = raw.Exec("DELETE FROM audit_log")
+		assert.ErrorContains(t, err, "append-only")
+	})
+
+	t.Run("LegacyDatabase", func(t *testing.T) {
+		db, path := openFixture(t, "legacy.sql")
+		report, err := db.Migrate()
+		require.NoError(t, err, "Error migrating")
+		assertFullyMigrated(t, db)
+
+		require.NotEmpty(t, report.Backup)
+		assert.Equal(t, path, filepath.Join(filepath.Dir(report.Backup), "fixture.db"), "Backup should be next to the database")
+		backup, err := database.NewDatabase(report.Backup)
+		require.NoError(t, err, "Error opening backup")
+		defer backup.Close()
+		states, err := backup.MigrationStatus()
+		require.NoError(t, err, "Error reading backup migration status")
+		assert.False(t, states[0].Applied, "Backup should be taken before migrating")
+
+		info, err := db.GetContainer("legacy-id-1")
+		require.NoError(t, err, "Legacy rows should survive the upgrade")
+		assert.Equal(t, "web", info.ServiceName, "Service names should be backfilled")
+		assert.Equal(t, "nginx:1.25", info.ImageName)
+	})
+
+	t.Run("UnversionedDatabase", func(t *testing.T) {
+		db, _ := openFixture(t, "unversioned.sql")
+		report, err := db.Migrate()
+		require.NoError(t, err, "Error migrating")
+		assert.NotEmpty(t, report.Backup)
+		assertFullyMigrated(t, db)
+
+		info, err := db.GetContainer("current-id-1")
+		require.NoError(t, err, "Existing rows should survive the upgrade")
+		assert.Equal(t, "api", info.ServiceName)
+		service, err := db.GetService("api")
+		require.NoError(t, err, "Existing services should survive the upgrade")
+		assert.Contains(t, service.Spec, "api")
+		deployments, err := db.ListDeployments("api", 0)
+		require.NoError(t, err, "Existing deployments should survive the upgrade")
+		assert.Len(t, deployments, 1)
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		db := openPostgres(t)
+		assertFullyMigrated(t, db)
+		report, err := db.Migrate()
+		require.NoError(t, err, "Error migrating again")
+		assert.Empty(t, report.Applied)
+	})
+}
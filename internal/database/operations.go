@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	OperationQueued    = "queued"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// Operation is a mutating request against a service. Operations on the same service run
+// one at a time in the order they were queued.
+type Operation struct {
+	ID          int64
+	ServiceName string
+	Kind        string
+	Status      string
+	Message     string
+	// Result is what the operation produced, such as the ID of the container it started
+	Result string
+	// Holder identifies the orchestrator process that queued the operation
+	Holder     string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+var ErrOperationNotFound = errors.New("operation not found")
+
+const operationColumns = "id, service_name, kind, status, message, result, holder, created_at, started_at, finished_at"
+
+func scanOperation(row interface{ Scan(...any) error }) (Operation, error) {
+	var op Operation
+	var createdAt, startedAt, finishedAt int64
+	err := row.Scan(&op.ID, &op.ServiceName, &op.Kind, &op.Status, &op.Message, &op.Result, &op.Holder, &createdAt, &startedAt, &finishedAt)
+	if err != nil {
+		return op, err
+	}
+	op.CreatedAt = time.Unix(createdAt, 0)
+	if startedAt != 0 {
+		op.StartedAt = time.Unix(startedAt, 0)
+	}
+	if finishedAt != 0 {
+		op.FinishedAt = time.Unix(finishedAt, 0)
+	}
+	return op, nil
+}
+
+func (d *Database) CreateOperation(serviceName, kind, holder string) (int64, error) {
+	d.logger.Info("Queueing %s operation on %s", kind, serviceName)
+	now := time.Now().Unix()
+	// RETURNING works on both dialects, PostgreSQL has no LastInsertId
+	var id int64
+	err := d.q.QueryRow(`
+		INSERT INTO operations (service_name, kind, status, holder, created_at, heartbeat_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id
+	`, serviceName, kind, OperationQueued, holder, now, now).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert operation: %w", err)
+	}
+	return id, nil
+}
+
+func (d *Database) StartOperation(id int64) error {
+	_, err := d.q.Exec("UPDATE operations SET status = ?, message = '', started_at = ? WHERE id = ?",
+		OperationRunning, time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update operation: %w", err)
+	}
+	return nil
+}
+
+// SetOperationMessage records what a queued or running operation is doing
+func (d *Database) SetOperationMessage(id int64, message string) error {
+	_, err := d.q.Exec("UPDATE operations SET message = ? WHERE id = ?", message, id)
+	if err != nil {
+		return fmt.Errorf("failed to update operation: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) FinishOperation(id int64, status, message, result string) error {
+	d.logger.Info("Operation %d finished: %s", id, status)
+	_, err := d.q.Exec("UPDATE operations SET status = ?, message = ?, result = ?, finished_at = ? WHERE id = ?",
+		status, message, result, time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update operation: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetOperation(id int64) (*Operation, error) {
+	op, err := scanOperation(d.q.QueryRow("SELECT "+operationColumns+" FROM operations WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %d", ErrOperationNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get operation: %w", err)
+	}
+	return &op, nil
+}
+
+// ListOperations returns the latest operations, newest first. An empty service or status
+// matches all of them and a limit of zero or less returns everything.
+func (d *Database) ListOperations(serviceName, status string, limit int) ([]Operation, error) {
+	if limit <= 0 {
+		// PostgreSQL has no LIMIT -1 for no limit
+		limit = math.MaxInt32
+	}
+	rows, err := d.q.Query(`
+		SELECT `+operationColumns+` FROM operations
+		WHERE (? = '' OR service_name = ?) AND (? = '' OR status = ?)
+		ORDER BY id DESC LIMIT ?
+	`, serviceName, serviceName, status, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operations: %w", err)
+	}
+	defer rows.Close()
+
+	var ops []Operation
+	for rows.Next() {
+		op, err := scanOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan operation row: %w", err)
+		}
+		ops = append(ops, op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating operation rows: %w", err)
+	}
+	return ops, nil
+}
+
+// RenewLeases extends the service locks of a holder and marks its unfinished operations and
+// journal entries alive
+func (d *Database) RenewLeases(holder string, expiresAt time.Time) error {
+	if _, err := d.q.Exec("UPDATE service_locks SET expires_at = ? WHERE holder = ?", expiresAt.Unix(), holder); err != nil {
+		return fmt.Errorf("failed to renew service locks: %w", err)
+	}
+	_, err := d.q.Exec("UPDATE operations SET heartbeat_at = ? WHERE holder = ? AND status IN (?, ?)",
+		time.Now().Unix(), holder, OperationQueued, OperationRunning)
+	if err != nil {
+		return fmt.Errorf("failed to renew operations: %w", err)
+	}
+	_, err = d.q.Exec("UPDATE journal SET heartbeat_at = ? WHERE holder = ? AND state IN (?, ?)",
+		time.Now().Unix(), holder, JournalPending, JournalCommitted)
+	if err != nil {
+		return fmt.Errorf("failed to renew journal entries: %w", err)
+	}
+	return nil
+}
+
+// FailStaleOperations marks operations whose process stopped renewing them before staleBefore
+// as failed, they were interrupted by a crash or restart
+func (d *Database) FailStaleOperations(staleBefore time.Time) (int64, error) {
+	result, err := d.q.Exec(`
+		UPDATE operations SET status = ?, message = 'interrupted, the orchestrator stopped before it finished', finished_at = ?
+		WHERE status IN (?, ?) AND heartbeat_at < ?
+	`, OperationFailed, time.Now().Unix(), OperationQueued, OperationRunning, staleBefore.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail stale operations: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PortLease is a host port held for a named port of an instance of a service, for one
+// protocol: the same port can be leased for tcp and for udp. A lease
+// without a container is pending while the instance is created, until ExpiresAt, or with a
+// zero ExpiresAt it is a sticky port kept for the replica between instances.
+type PortLease struct {
+	Port        int
+	Protocol    string
+	ServiceName string
+	Replica     int
+	PortName    string
+	ContainerID string
+	Sticky      bool
+	LeasedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+const (
+	PortInUse    = "in_use"
+	PortPending  = "pending"
+	PortReserved = "reserved"
+)
+
+// State tells whether a container holds the port, an instance being created or, for a
+// sticky port no instance uses at the moment, the replica
+func (l PortLease) State() string {
+	switch {
+	case l.ContainerID != "":
+		return PortInUse
+	case l.ExpiresAt.IsZero():
+		return PortReserved
+	default:
+		return PortPending
+	}
+}
+
+// LeasePort takes a port for an instance that is about to be created and reports whether
+// it got it. Ports of pending leases that expired and ports kept for the same port of the
+// same replica of the service can be taken, any other lease of the port cannot.
+func (d *Database) LeasePort(lease PortLease) (bool, error) {
+	if lease.Protocol == "" {
+		lease.Protocol = "tcp"
+	}
+	acquired := false
+	err := d.withTx(func(tx *Database) error {
+		now := time.Now()
+		_, err := tx.q.Exec("DELETE FROM port_leases WHERE port = ? AND protocol = ? AND container_id = '' AND expires_at > 0 AND expires_at < ?",
+			lease.Port, lease.Protocol, now.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to delete expired port lease: %w", err)
+		}
+
+		result, err := tx.q.Exec(`
+			UPDATE port_leases SET sticky = ?, leased_at = ?, expires_at = ?
+			WHERE port = ? AND protocol = ? AND container_id = '' AND expires_at = 0 AND service_name = ? AND replica = ? AND port_name = ?
+		`, lease.Sticky, now.Unix(), lease.ExpiresAt.Unix(), lease.Port, lease.Protocol, lease.ServiceName, lease.Replica, lease.PortName)
+		if err != nil {
+			return fmt.Errorf("failed to claim sticky port: %w", err)
+		}
+		if acquired, err = affected(result); err != nil || acquired {
+			return err
+		}
+
+		result, err = tx.q.Exec(`
+			INSERT INTO port_leases (port, protocol, service_name, replica, port_name, sticky, leased_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (port, protocol) DO NOTHING
+		`, lease.Port, lease.Protocol, lease.ServiceName, lease.Replica, lease.PortName, lease.Sticky, now.Unix(), lease.ExpiresAt.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to insert port lease: %w", err)
+		}
+		acquired, err = affected(result)
+		return err
+	})
+	return acquired, err
+}
+
+// ReleasePort gives back the port of an instance that was never recorded. A sticky port
+// stays with its replica.
+func (d *Database) ReleasePort(port int, protocol string) error {
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return d.withTx(func(tx *Database) error {
+		if _, err := tx.q.Exec("DELETE FROM port_leases WHERE port = ? AND protocol = ? AND container_id = '' AND NOT sticky", port, protocol); err != nil {
+			return fmt.Errorf("failed to delete port lease: %w", err)
+		}
+		if _, err := tx.q.Exec("UPDATE port_leases SET expires_at = 0 WHERE port = ? AND protocol = ? AND container_id = '' AND sticky", port, protocol); err != nil {
+			return fmt.Errorf("failed to update port lease: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListPortLeases returns every lease ordered by port and protocol
+func (d *Database) ListPortLeases() ([]PortLease, error) {
+	rows, err := d.q.Query(`
+		SELECT port, protocol, service_name, replica, port_name, container_id, sticky, leased_at, expires_at
+		FROM port_leases ORDER BY port, protocol
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query port leases: %w", err)
+	}
+	defer rows.Close()
+
+	var leases []PortLease
+	for rows.Next() {
+		var lease PortLease
+		var leasedAt, expiresAt int64
+		if err := rows.Scan(&lease.Port, &lease.Protocol, &lease.ServiceName, &lease.Replica, &lease.PortName, &lease.ContainerID, &lease.Sticky, &leasedAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan port lease row: %w", err)
+		}
+		lease.LeasedAt = time.Unix(leasedAt, 0)
+		if expiresAt != 0 {
+			lease.ExpiresAt = time.Unix(expiresAt, 0)
+		}
+		leases = append(leases, lease)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating port lease rows: %w", err)
+	}
+	return leases, nil
+}
+
+// bindPorts hands the leases of the host ports of a container to it, whoever held them before
+func (d *Database) bindPorts(info ContainerInfo) error {
+	ports := info.Ports
+	if len(ports) == 0 {
+		ports = singlePort(info)
+	}
+	for _, p := range ports {
+		// Ports that are not published hold no lease
+		if p.HostPort == 0 {
+			continue
+		}
+		_, err := d.q.Exec(`
+			INSERT INTO port_leases (port, protocol, service_name, replica, port_name, container_id, leased_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (port, protocol) DO UPDATE SET
+				sticky = port_leases.sticky AND port_leases.service_name = excluded.service_name,
+				service_name = excluded.service_name, replica = excluded.replica, port_name = excluded.port_name,
+				container_id = excluded.container_id, expires_at = 0
+		`, p.HostPort, portProtocol(p), info.ServiceName, info.Replica, p.Name, info.ContainerID, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("failed to bind port lease: %w", err)
+		}
+	}
+	return nil
+}
+
+// releaseContainerPorts frees the ports of a removed container. A sticky port is kept for
+// its replica unless the replica already holds another host port for the same named port,
+// as it does once an update has started the instance replacing the container.
+func (d *Database) releaseContainerPorts(containerID string) error {
+	_, err := d.q.Exec(`
+		DELETE FROM port_leases WHERE container_id = ? AND (NOT sticky OR EXISTS (
+			SELECT 1 FROM port_leases other
+			WHERE other.service_name = port_leases.service_name AND other.replica = port_leases.replica
+				AND other.port_name = port_leases.port_name
+				AND (other.port <> port_leases.port OR other.protocol <> port_leases.protocol)
+		))
+	`, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to delete port lease: %w", err)
+	}
+	if _, err := d.q.Exec("UPDATE port_leases SET container_id = '' WHERE container_id = ?", containerID); err != nil {
+		return fmt.Errorf("failed to update port lease: %w", err)
+	}
+	return nil
+}
+
+// releaseServicePorts frees the sticky ports kept for a service that is gone
+func (d *Database) releaseServicePorts(serviceName string) error {
+	if _, err := d.q.Exec("DELETE FROM port_leases WHERE service_name = ? AND container_id = '' AND expires_at = 0", serviceName); err != nil {
+		return fmt.Errorf("failed to delete port leases: %w", err)
+	}
+	return nil
+}
+
+// portProtocol is the protocol the host port of a mapping is leased for, tcp when the
+// mapping does not say
+func portProtocol(p PortMapping) string {
+	if p.Protocol == "" {
+		return "tcp"
+	}
+	return p.Protocol
+}
+
+func affected(result sql.Result) (bool, error) {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
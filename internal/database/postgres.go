@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/logging"
+	_ "github.com/lib/pq"
+)
+
+// NewPostgresDatabase connects to PostgreSQL. The queries are shared with SQLite, written
+// with ? placeholders that are rewritten to $1, $2, ... before they are sent.
+func NewPostgresDatabase(dsn string) (*Database, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &Database{
+		db:      db,
+		dialect: dialectPostgres,
+		q:       postgresQuerier{db},
+		logger:  logging.GetLogger(),
+	}, nil
+}
+
+// postgresQuerier rewrites the placeholders of the queries it runs
+type postgresQuerier struct {
+	q querier
+}
+
+func (p postgresQuerier) Exec(query string, args ...any) (sql.Result, error) {
+	return p.q.Exec(rebind(query), args...)
+}
+
+func (p postgresQuerier) Query(query string, args ...any) (*sql.Rows, error) {
+	return p.q.Query(rebind(query), args...)
+}
+
+func (p postgresQuerier) QueryRow(query string, args ...any) *sql.Row {
+	return p.q.QueryRow(rebind(query), args...)
+}
+
+// rebind numbers the ? placeholders of a query, leaving those in string literals alone
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
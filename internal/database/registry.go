@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RegistryLogin is the login of a private registry. PasswordData is the password as the
+// orchestrator encrypted it, like the keys of certificates.
+type RegistryLogin struct {
+	Host         string
+	Username     string
+	PasswordData string
+	UpdatedAt    time.Time
+}
+
+var ErrRegistryLoginNotFound = errors.New("registry login not found")
+
+// SaveRegistryLogin stores the login of a registry, replacing the one it had
+func (d *Database) SaveRegistryLogin(login RegistryLogin) error {
+	if login.Host == "" {
+		return errors.New("registry host cannot be empty")
+	}
+	if login.UpdatedAt.IsZero() {
+		login.UpdatedAt = time.Now()
+	}
+	_, err := d.q.Exec(`
+		INSERT INTO registry_logins (host, username, password_data, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(host) DO UPDATE SET username = excluded.username,
+			password_data = excluded.password_data, updated_at = excluded.updated_at
+	`, login.Host, login.Username, login.PasswordData, login.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save registry login: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetRegistryLogin(host string) (*RegistryLogin, error) {
+	login, err := scanRegistryLogin(d.q.QueryRow(`
+		SELECT host, username, password_data, updated_at FROM registry_logins WHERE host = ?
+	`, host))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrRegistryLoginNotFound, host)
+		}
+		return nil, fmt.Errorf("failed to get registry login: %w", err)
+	}
+	return &login, nil
+}
+
+// ListRegistryLogins returns the logins ordered by host
+func (d *Database) ListRegistryLogins() ([]RegistryLogin, error) {
+	rows, err := d.q.Query(`
+		SELECT host, username, password_data, updated_at FROM registry_logins ORDER BY host
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query registry logins: %w", err)
+	}
+	defer rows.Close()
+
+	var logins []RegistryLogin
+	for rows.Next() {
+		login, err := scanRegistryLogin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan registry login row: %w", err)
+		}
+		logins = append(logins, login)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating registry login rows: %w", err)
+	}
+	return logins, nil
+}
+
+func scanRegistryLogin(row rowScanner) (RegistryLogin, error) {
+	var login RegistryLogin
+	var updatedAt int64
+	if err := row.Scan(&login.Host, &login.Username, &login.PasswordData, &updatedAt); err != nil {
+		return RegistryLogin{}, err
+	}
+	login.UpdatedAt = time.Unix(updatedAt, 0)
+	return login, nil
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ServiceInfo holds the declarative spec of a service, the containers table holds its instances
+type ServiceInfo struct {
+	Name string
+	Spec string
+}
+
+var ErrServiceNotFound = errors.New("service not found")
+
+func (d *Database) SaveService(info ServiceInfo) error {
+	if info.Name == "" {
+		return errors.New("service name cannot be empty")
+	}
+
+	d.logger.Info("Saving service: %s", info.Name)
+	_, err := d.q.Exec(`
+		INSERT INTO services (name, spec) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET spec = excluded.spec
+	`, info.Name, info.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to save service: %w", err)
+	}
+	return nil
+}
+
+// LockServices keeps other transactions from saving services, or locking them, until the
+// transaction it is called in ends. What the transaction read of the other services then
+// stays true until it commits.
+func (d *Database) LockServices() error {
+	if d.tx == nil {
+		return errors.New("services can only be locked in a transaction")
+	}
+	if d.discard != nil {
+		// Nothing saved in a snapshot outlives it, other transactions need not wait for it
+		return nil
+	}
+	// SQLite takes the write lock of the whole database with the first write of a transaction
+	query := "UPDATE services SET name = name WHERE 1 = 0"
+	if d.dialect == dialectPostgres {
+		// Conflicts with itself and with writers, readers go on
+		query = "LOCK TABLE services IN SHARE ROW EXCLUSIVE MODE"
+	}
+	if _, err := d.q.Exec(query); err != nil {
+		return fmt.Errorf("failed to lock services: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetService(name string) (*ServiceInfo, error) {
+	d.logger.Info("Fetching service: %s", name)
+	var info ServiceInfo
+	err := d.q.QueryRow("SELECT name, spec FROM services WHERE name = ?", name).Scan(&info.Name, &info.Spec)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+		}
+		return nil, fmt.Errorf("failed to get service: %w", err)
+	}
+	return &info, nil
+}
+
+func (d *Database) ListServices() ([]ServiceInfo, error) {
+	d.logger.Info("Listing all services")
+	rows, err := d.q.Query("SELECT name, spec FROM services ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query services: %w", err)
+	}
+	defer rows.Close()
+
+	var services []ServiceInfo
+	for rows.Next() {
+		var info ServiceInfo
+		if err := rows.Scan(&info.Name, &info.Spec); err != nil {
+			return nil, fmt.Errorf("failed to scan service row: %w", err)
+		}
+		services = append(services, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating service rows: %w", err)
+	}
+	return services, nil
+}
+
+func (d *Database) DeleteService(name string) error {
+	d.logger.Info("Deleting service: %s", name)
+	return d.withTx(func(tx *Database) error {
+		if _, err := tx.q.Exec("DELETE FROM services WHERE name = ?", name); err != nil {
+			return fmt.Errorf("failed to delete service: %w", err)
+		}
+		return tx.releaseServicePorts(name)
+	})
+}
+
+// GetContainersByService returns the instances of a service ordered by replica
+func (d *Database) GetContainersByService(serviceName string) ([]ContainerInfo, error) {
+	d.logger.Info("Fetching containers of service: %s", serviceName)
+	rows, err := d.q.Query("SELECT "+containerColumns+" FROM containers WHERE service_name = ? ORDER BY replica, id", serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query containers by service: %w", err)
+	}
+	defer rows.Close()
+
+	var containers []ContainerInfo
+	for rows.Next() {
+		info, err := scanContainer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan container row: %w", err)
+		}
+		containers = append(containers, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating container rows: %w", err)
+	}
+	return containers, nil
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// Store is the state of the orchestrator. Database implements it on SQLite, the default,
+// and on PostgreSQL so several hosts can share their state.
+type Store interface {
+	// WithTx runs fn in a transaction, see Database.WithTx
+	WithTx(fn func(tx Store) error) error
+	// Snapshot returns a copy of the state that changes can be made to without touching the
+	// original, Close discards it
+	Snapshot() (Store, error)
+	Close() error
+	// Backup writes a consistent copy of the state to path while it stays in use
+	Backup(path string) error
+
+	InitSchema() error
+	Migrate() (*MigrationReport, error)
+	MigrationStatus() ([]MigrationState, error)
+
+	AddContainer(info ContainerInfo) error
+	UpdateContainerStatus(containerID, status string) error
+	UpdateContainerAddress(containerID, address string) error
+	GetContainer(containerID string) (*ContainerInfo, error)
+	GetContainersByPartialName(partialName string) ([]ContainerInfo, error)
+	GetContainersByService(serviceName string) ([]ContainerInfo, error)
+	ListContainers() ([]ContainerInfo, error)
+	DeleteContainer(containerID string) error
+
+	SaveService(info ServiceInfo) error
+	// LockServices keeps other transactions from saving services until the transaction it
+	// runs in ends, see Database.LockServices
+	LockServices() error
+	GetService(name string) (*ServiceInfo, error)
+	ListServices() ([]ServiceInfo, error)
+	DeleteService(name string) error
+
+	StartDeployment(serviceName, imageName, strategy string) (int64, error)
+	FinishDeployment(id int64, status, message string) error
+	AddHookRun(deploymentID int64, run HookRun) error
+	ImportDeployment(dep Deployment) (int64, error)
+	ListDeployments(serviceName string, limit int) ([]Deployment, error)
+
+	CreateOperation(serviceName, kind, holder string) (int64, error)
+	StartOperation(id int64) error
+	SetOperationMessage(id int64, message string) error
+	FinishOperation(id int64, status, message, result string) error
+	GetOperation(id int64) (*Operation, error)
+	ListOperations(serviceName, status string, limit int) ([]Operation, error)
+	RenewLeases(holder string, expiresAt time.Time) error
+	FailStaleOperations(staleBefore time.Time) (int64, error)
+
+	AcquireServiceLock(serviceName, holder string, operationID int64, expiresAt time.Time) (bool, error)
+	ReleaseServiceLock(serviceName string, operationID int64) error
+	GetServiceLock(serviceName string) (*ServiceLock, error)
+
+	BeginJournal(kind, serviceName, holder string, retired []ContainerInfo) (int64, error)
+	SetJournalStarted(id int64, started []JournalContainer) error
+	SetJournalState(id int64, state string) error
+	UnfinishedJournal(staleBefore time.Time) ([]JournalEntry, error)
+
+	LeasePort(lease PortLease) (bool, error)
+	ReleasePort(port int, protocol string) error
+	ListPortLeases() ([]PortLease, error)
+
+	AddAuditEntry(entry AuditEntry) (int64, error)
+	ListAuditLog(filter AuditFilter) ([]AuditEntry, error)
+
+	SaveCertificate(cert Certificate) error
+	GetCertificate(domain string) (*Certificate, error)
+	ListCertificates() ([]Certificate, error)
+	DeleteCertificate(domain string) error
+	SaveACMEAccount(account ACMEAccount) error
+	GetACMEAccount(directoryURL string) (*ACMEAccount, error)
+	ListACMEAccounts() ([]ACMEAccount, error)
+
+	SaveRegistryLogin(login RegistryLogin) error
+	GetRegistryLogin(host string) (*RegistryLogin, error)
+	ListRegistryLogins() ([]RegistryLogin, error)
+
+	AddBuiltImage(imageID, imageName string) error
+	IsBuiltImage(imageID string) (bool, error)
+}
+
+var _ Store = (*Database)(nil)
+
+// Open opens the store a DSN refers to: a postgres:// or postgresql:// URL selects
+// PostgreSQL, anything else is the path of a SQLite database
+func Open(dsn string) (Store, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return NewPostgresDatabase(dsn)
+	}
+	return NewDatabase(dsn)
+}
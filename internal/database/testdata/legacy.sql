@@ -0,0 +1,13 @@
+-- A database from before services had replicas
+CREATE TABLE containers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	container_id TEXT NOT NULL,
+	container_name TEXT NOT NULL,
+	image_name TEXT NOT NULL,
+	domain_name TEXT NOT NULL,
+	host_port INTEGER NOT NULL,
+	container_port INTEGER NOT NULL,
+	status TEXT NOT NULL
+);
+INSERT INTO containers (container_id, container_name, image_name, domain_name, host_port, container_port, status)
+VALUES ('legacy-id-1', 'web_20240101120000', 'nginx:1.25', 'web.example.com', 8081, 80, 'running');
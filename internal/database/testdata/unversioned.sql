@@ -0,0 +1,73 @@
+-- A database created by InitSchema before migrations were tracked
+CREATE TABLE containers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	container_id TEXT NOT NULL,
+	container_name TEXT NOT NULL,
+	image_name TEXT NOT NULL,
+	domain_name TEXT NOT NULL,
+	host_port INTEGER NOT NULL,
+	container_port INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	service_name TEXT NOT NULL DEFAULT '',
+	replica INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE services (
+	name TEXT PRIMARY KEY,
+	spec TEXT NOT NULL
+);
+CREATE TABLE deployments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	service_name TEXT NOT NULL,
+	image_name TEXT NOT NULL,
+	strategy TEXT NOT NULL,
+	status TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	started_at INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE hook_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	deployment_id INTEGER NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+	phase TEXT NOT NULL,
+	command TEXT NOT NULL,
+	exit_code INTEGER NOT NULL,
+	output TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	started_at INTEGER NOT NULL,
+	duration_ms INTEGER NOT NULL
+);
+CREATE TABLE operations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	service_name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL DEFAULT '',
+	holder TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	started_at INTEGER NOT NULL DEFAULT 0,
+	finished_at INTEGER NOT NULL DEFAULT 0,
+	heartbeat_at INTEGER NOT NULL
+);
+CREATE TABLE journal (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	service_name TEXT NOT NULL,
+	state TEXT NOT NULL,
+	started TEXT NOT NULL DEFAULT '[]',
+	retired TEXT NOT NULL DEFAULT '[]',
+	holder TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	heartbeat_at INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE service_locks (
+	service_name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	operation_id INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+INSERT INTO containers (container_id, container_name, image_name, domain_name, host_port, container_port, status, service_name, replica)
+VALUES ('current-id-1', 'api', 'api:2', 'api.example.com', 8082, 8080, 'running', 'api', 0);
+INSERT INTO services (name, spec) VALUES ('api', '{"ContainerName":"api"}');
+INSERT INTO deployments (service_name, image_name, strategy, status, started_at) VALUES ('api', 'api:2', 'rolling', 'succeeded', 1700000000);
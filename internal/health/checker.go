@@ -31,6 +31,12 @@ type HealthChecker struct {
 	db           Database
 	interval     time.Duration
 	logger       Logger
+
+	// OnStatusChange is called when an instance becomes healthy or unhealthy
+	OnStatusChange func(containerID string, healthy bool)
+
+	mu      sync.RWMutex
+	healthy map[string]bool
 }
 
 func NewHealthChecker(dockerClient DockerClient, db Database, interval time.Duration, logger Logger) *HealthChecker {
@@ -39,6 +45,29 @@ func NewHealthChecker(dockerClient DockerClient, db Database, interval time.Dura
 		db:           db,
 		interval:     interval,
 		logger:       logger,
+		healthy:      make(map[string]bool),
+	}
+}
+
+// IsHealthy reports the last known health of an instance, instances that
+// have not been checked yet are assumed to be healthy
+func (hc *HealthChecker) IsHealthy(containerID string) bool {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	healthy, ok := hc.healthy[containerID]
+	return !ok || healthy
+}
+
+func (hc *HealthChecker) setHealthy(containerID string, healthy bool) {
+	hc.mu.Lock()
+	previous, known := hc.healthy[containerID]
+	hc.healthy[containerID] = healthy
+	hc.mu.Unlock()
+
+	if (!known && !healthy) || (known && previous != healthy) {
+		if hc.OnStatusChange != nil {
+			hc.OnStatusChange(containerID, healthy)
+		}
 	}
 }
 
@@ -64,6 +93,8 @@ func (hc *HealthChecker) checkContainers(ctx context.Context) {
 		hc.logger.Error("Error listing containers: %s", err)
 		return
 	}
+	hc.forgetRemoved(containers)
+
 	var wg sync.WaitGroup
 	for _, c := range containers {
 		wg.Add(1)
@@ -80,6 +111,20 @@ func (hc *HealthChecker) checkContainers(ctx context.Context) {
 	wg.Wait()
 }
 
+func (hc *HealthChecker) forgetRemoved(containers []database.ContainerInfo) {
+	current := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		current[c.ContainerID] = true
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for containerID := range hc.healthy {
+		if !current[containerID] {
+			delete(hc.healthy, containerID)
+		}
+	}
+}
+
 func (hc *HealthChecker) checkContainer(ctx context.Context, container *database.ContainerInfo) error {
 	context, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
@@ -87,17 +132,26 @@ func (hc *HealthChecker) checkContainer(ctx context.Context, container *database
 	state, err := hc.dockerClient.HealthCheck(context, container.ContainerID)
 	if err != nil {
 		hc.logger.Error("Error checking container %s: %s", container.ContainerName, err)
+		hc.setHealthy(container.ContainerID, false)
 		return err
 	}
 	if state.Status != "running" {
 		hc.logger.Warn("Container %s is not running, starting.. ", container.ContainerName)
+		hc.setHealthy(container.ContainerID, false)
 		return hc.dockerClient.StartContainer(ctx, container.ContainerID)
 	}
+	// A container still in its start period is neither healthy nor restarted yet
+	if state.Health != nil && state.Health.Status == types.Starting {
+		hc.setHealthy(container.ContainerID, false)
+		return nil
+	}
 	if state.Health != nil && state.Health.Status != types.Healthy {
 		hc.logger.Warn("Container %s is unhealthy. Attempting to restart...", container.ContainerName)
+		hc.setHealthy(container.ContainerID, false)
 		return hc.dockerClient.RestartContainer(ctx, container.ContainerID, nil)
 	}
 
 	hc.logger.Info("Container %s is healthy and running", container.ContainerName)
+	hc.setHealthy(container.ContainerID, true)
 	return nil
 }
@@ -1 +1,725 @@
 package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+)
+
+type Logger interface {
+	Info(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// Generator renders the routing table into an nginx config file and reloads nginx
+type Generator struct {
+	configPath string
+	reloadCmd  []string
+	logger     Logger
+}
+
+func NewGenerator(configPath string, reloadCmd []string, logger Logger) *Generator {
+	return &Generator{
+		configPath: configPath,
+		reloadCmd:  reloadCmd,
+		logger:     logger,
+	}
+}
+
+func (g *Generator) Apply(table routing.Table) error {
+	certsChanged, err := g.writeCertificates(table)
+	if err != nil {
+		return err
+	}
+	usersChanged, err := g.writeUsers(table)
+	if err != nil {
+		return err
+	}
+	config, err := Render(table, g.certDir(), g.authDir())
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(g.configPath)
+	if err == nil && bytes.Equal(current, config) && !certsChanged && !usersChanged {
+		return nil
+	}
+	if err != nil || !bytes.Equal(current, config) {
+		if err := writeFile(g.configPath, config, 0o644); err != nil {
+			return fmt.Errorf("error writing nginx config: %w", err)
+		}
+		g.logger.Info("Wrote nginx config to %s", g.configPath)
+	}
+
+	if len(g.reloadCmd) == 0 {
+		return nil
+	}
+	out, err := exec.Command(g.reloadCmd[0], g.reloadCmd[1:]...).CombinedOutput()
+	if err != nil {
+		g.logger.Error("Error reloading nginx: %s: %s", err, out)
+		return fmt.Errorf("error reloading nginx: %w", err)
+	}
+	return nil
+}
+
+// certDir holds the certificates nginx serves, next to the config
+func (g *Generator) certDir() string {
+	return filepath.Join(filepath.Dir(g.configPath), "orchestrator-certs")
+}
+
+// authDir holds the basic auth users of routes, next to the config
+func (g *Generator) authDir() string {
+	return filepath.Join(filepath.Dir(g.configPath), "orchestrator-auth")
+}
+
+// writeCertificates writes the certificates of the routed domains to the certificate
+// directory and removes the ones no longer routed. It reports whether any file changed.
+func (g *Generator) writeCertificates(table routing.Table) (bool, error) {
+	wanted := make(map[string][]byte)
+	for _, service := range table.Services {
+		if len(service.Backends) == 0 {
+			continue
+		}
+		for _, host := range service.Hosts() {
+			if cert, ok := table.Certificate(host); ok {
+				wanted[certFileName(host, ".crt")] = cert.CertPEM
+				wanted[certFileName(host, ".key")] = cert.KeyPEM
+			}
+		}
+	}
+	changed, err := syncDir(g.certDir(), wanted, 0o700, 0o600)
+	if err != nil {
+		return false, fmt.Errorf("error writing nginx certificates: %w", err)
+	}
+	return changed, nil
+}
+
+// writeUsers writes a password file per route with basic auth users and removes the
+// ones of routes that no longer have any. nginx workers read them on each request, so
+// unlike the certificates they are readable by others.
+func (g *Generator) writeUsers(table routing.Table) (bool, error) {
+	wanted := make(map[string][]byte)
+	for _, service := range table.Services {
+		if len(service.Backends) == 0 {
+			continue
+		}
+		for _, route := range service.EffectiveRoutes() {
+			if len(route.Auth.BasicAuth) > 0 {
+				wanted[usersFileName(route)] = usersFile(route.Auth.BasicAuth)
+			}
+		}
+	}
+	changed, err := syncDir(g.authDir(), wanted, 0o755, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("error writing nginx password files: %w", err)
+	}
+	return changed, nil
+}
+
+// usersFile is a password file in the htpasswd format nginx reads
+func usersFile(users map[string]string) []byte {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s:%s\n", name, users[name])
+	}
+	return buf.Bytes()
+}
+
+// syncDir makes the files of dir the wanted ones, creating it when there are any. It
+// reports whether any file changed.
+func syncDir(dir string, wanted map[string][]byte, dirPerm, filePerm os.FileMode) (bool, error) {
+	existing, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if len(wanted) == 0 && len(existing) == 0 {
+		return false, nil
+	}
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return false, err
+	}
+
+	changed := false
+	for name, content := range wanted {
+		path := filepath.Join(dir, name)
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, content) {
+			continue
+		}
+		if err := writeFile(path, content, filePerm); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	for _, entry := range existing {
+		if _, ok := wanted[entry.Name()]; !ok && !entry.IsDir() {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// writeFile writes to a temporary file first so nginx never reads a partially written one
+func writeFile(path string, content []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".orchestrator-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var invalidUpstreamChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func upstreamName(service string) string {
+	return "orchestrator_" + invalidUpstreamChars.ReplaceAllString(service, "_")
+}
+
+var invalidFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func certFileName(domain, ext string) string {
+	return invalidFileChars.ReplaceAllString(strings.ToLower(domain), "_") + ext
+}
+
+func usersFileName(route routing.Route) string {
+	return certFileName(route.Host+route.PathPrefix(), ".htpasswd")
+}
+
+// server is a host as it is rendered, with its locations and the files of its
+// certificate when it has one
+type server struct {
+	Host      string
+	Locations []location
+	CertFile  string
+	KeyFile   string
+}
+
+// location is a route of a host to the upstream of a service, with the names of the
+// variables its middleware is rendered with
+type location struct {
+	routing.Route
+	Service string
+	GRPC    bool
+	// CORSOrigin is the allowed origin of a request or empty, CORSPreflight whether the
+	// request is an allowed or a forbidden preflight
+	CORSOrigin    string
+	CORSPreflight string
+	// HeaderAdds are the request headers with a value appended
+	HeaderAdds []headerAdd
+	// UsersFile holds the basic auth users
+	UsersFile string
+	// AuthLocation is the internal location asking the forward auth endpoint AuthTarget,
+	// AuthHeaders the identity headers taken from its response
+	AuthLocation string
+	AuthTarget   string
+	AuthHeaders  []headerAdd
+	// LimitReqs are the rate limits of the route, LimitLocation the named location
+	// answering the requests over them with LimitBody when the route has a message
+	LimitReqs     []limitReq
+	LimitLocation string
+	LimitBody     string
+}
+
+// limitZone is a limit_req_zone, the shared state of a rate limit
+type limitZone struct {
+	Key  string
+	Name string
+	Size string
+	Rate string
+}
+
+type limitReq struct {
+	Zone  string
+	Burst int
+}
+
+type headerAdd struct {
+	Name string
+	Var  string
+}
+
+// listener is a server listening on port 80 or, with TLS, on 443
+type listener struct {
+	server
+	TLS bool
+}
+
+// locationBlock is a location in the block it is rendered in
+type locationBlock struct {
+	location
+	TLS    bool
+	Secure bool
+}
+
+// GRPC reports whether any location of the server speaks gRPC, which needs HTTP/2
+func (s server) GRPC() bool {
+	for _, l := range s.Locations {
+		if l.GRPC {
+			return true
+		}
+	}
+	return false
+}
+
+// nginxMap is a map block, the variables middleware decides with
+type nginxMap struct {
+	Source  string
+	Var     string
+	Entries [][2]string
+}
+
+type configData struct {
+	Maps          []nginxMap
+	Zones         []limitZone
+	Upstreams     []routing.Service
+	Servers       []server
+	ChallengeAddr string
+	authDir       string
+	upstreams     map[string]bool
+	authLocations int
+	// redirectArgs is set once the map joining query strings with & is added
+	redirectArgs bool
+}
+
+// redirectArgsVar holds the query string of a request prefixed with &, empty without one
+const redirectArgsVar = "orchestrator_redirect_args"
+
+// redirectTarget is the target of a redirect with the query string of the request, joined
+// with & when the target has a query of its own
+func redirectTarget(r routing.Redirect) string {
+	base, fragment := r.SplitFragment()
+	args := "$is_args$args"
+	if r.QuerySeparator() == "&" {
+		args = "$" + redirectArgsVar
+	}
+	return quote(base + args + fragment)
+}
+
+// headerVariable is the variable nginx keeps a request header in, such as http_x_user.
+// The header of an upstream response is in the same variable prefixed with upstream_.
+func headerVariable(name string) string {
+	return "http_" + strings.ReplaceAll(strings.ToLower(name), "-", "_")
+}
+
+// quote makes a string an nginx string, values are validated not to contain variables
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+var configTemplate = template.Must(template.New("nginx").Funcs(template.FuncMap{
+	"upstream": upstreamName,
+	"quote":    quote,
+	"variable": headerVariable,
+	"apex":     routing.ApexHost,
+	"redirect": redirectTarget,
+	"join":     strings.Join,
+	"listener": func(s server, tls bool) listener { return listener{server: s, TLS: tls} },
+	"in": func(l location, b listener) locationBlock {
+		return locationBlock{location: l, TLS: b.TLS, Secure: b.CertFile != ""}
+	},
+}).Parse(`# Generated by go-container-orchestrator, changes will be overwritten
+{{- $challengeAddr := .ChallengeAddr }}
+{{- range .Maps }}
+
+map {{ .Source }} ${{ .Var }} {
+{{- range .Entries }}
+    {{ index . 0 }} {{ index . 1 }};
+{{- end }}
+}
+{{- end }}
+{{- if .Zones }}
+{{ range .Zones }}
+limit_req_zone {{ .Key }} zone={{ .Name }}:{{ .Size }} rate={{ .Rate }};
+{{- end }}
+{{- end }}
+{{- range .Upstreams }}
+{{- $maxConns := .MaxConnections }}
+
+upstream {{ upstream .Name }} {
+{{- if $maxConns }}
+    zone {{ upstream .Name }} 64k;
+{{- end }}
+{{- if eq .Strategy "least_connections" }}
+    least_conn;
+{{- end }}
+{{- range .Backends }}
+    server {{ .Address }}{{ if gt .Weight 1 }} weight={{ .Weight }}{{ end }}{{ if $maxConns }} max_conns={{ $maxConns }}{{ end }}{{ if not .Healthy }} down{{ end }};
+{{- end }}
+}
+{{- end }}
+{{- range .Servers }}
+
+server {
+    listen 80;
+    server_name {{ .Host }};
+{{- if $challengeAddr }}
+
+    location /.well-known/acme-challenge/ {
+        proxy_pass http://{{ $challengeAddr }};
+    }
+{{- end }}
+{{- template "locations" (listener . false) }}
+}
+{{- if .CertFile }}
+
+server {
+    listen 443 ssl;
+    server_name {{ .Host }};
+    ssl_certificate {{ .CertFile }};
+    ssl_certificate_key {{ .KeyFile }};
+{{- template "locations" (listener . true) }}
+}
+{{- end }}
+{{- end }}
+{{- define "locations" }}
+{{- if .GRPC }}
+    http2 on;
+{{- end }}
+{{- range .Locations }}
+{{- if eq .PathPrefix "/" }}
+
+    location / {
+{{- template "location" (in . $) }}
+    }
+{{- else }}
+
+    location = {{ .Path }} {
+{{- template "location" (in . $) }}
+    }
+
+    location {{ .Path }}/ {
+{{- template "location" (in . $) }}
+    }
+{{- end }}
+{{- end }}
+{{- range .Locations }}
+{{- if .AuthLocation }}
+
+    location = {{ .AuthLocation }} {
+        internal;
+{{- if .AuthTarget }}
+        proxy_pass {{ .AuthTarget }};
+        proxy_pass_request_body off;
+        proxy_set_header Content-Length "";
+        proxy_set_header X-Forwarded-Method $request_method;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_set_header X-Forwarded-Host $host;
+        proxy_set_header X-Forwarded-Uri $request_uri;
+        proxy_set_header X-Forwarded-For $remote_addr;
+{{- else }}
+        return 503;
+{{- end }}
+    }
+{{- end }}
+{{- if .LimitLocation }}
+
+    location {{ .LimitLocation }} {
+        default_type text/plain;
+        return {{ .Limits.StatusCode }} {{ .LimitBody }};
+    }
+{{- end }}
+{{- end }}
+{{- end }}
+{{- define "location" }}
+{{- if .RedirectToApex }}
+        return 301 $scheme://{{ apex .Host }}$request_uri;
+{{- else if and .HTTPSRedirect .Secure (not .TLS) }}
+        return 301 https://$host$request_uri;
+{{- else if .GRPC }}
+        grpc_pass grpc://{{ upstream .Service }};
+        grpc_set_header X-Real-IP $remote_addr;
+        grpc_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        grpc_next_upstream error timeout unavailable;
+{{- else }}
+{{- range .Redirects }}
+        if ($uri = {{ quote .From }}) {
+            return {{ .StatusCode }} {{ redirect . }};
+        }
+{{- end }}
+{{- if .CORS.Enabled }}
+        if (${{ .CORSPreflight }} = forbidden) {
+            return 403;
+        }
+        if (${{ .CORSPreflight }} = allowed) {
+            add_header Access-Control-Allow-Origin $http_origin always;
+            add_header Access-Control-Allow-Methods {{ quote .CORS.Methods }} always;
+{{- if .CORS.AllowedHeaders }}
+            add_header Access-Control-Allow-Headers {{ quote (join .CORS.AllowedHeaders ", ") }} always;
+{{- else }}
+            add_header Access-Control-Allow-Headers $http_access_control_request_headers always;
+{{- end }}
+{{- if .CORS.AllowCredentials }}
+            add_header Access-Control-Allow-Credentials true always;
+{{- end }}
+{{- if .CORS.MaxAge }}
+            add_header Access-Control-Max-Age {{ .CORS.MaxAge }} always;
+{{- end }}
+            add_header Vary Origin always;
+            return 204;
+        }
+{{- end }}
+{{- range .LimitReqs }}
+        limit_req zone={{ .Zone }}{{ if .Burst }} burst={{ .Burst }}{{ end }} nodelay;
+{{- end }}
+{{- if .LimitReqs }}
+        limit_req_status {{ .Limits.StatusCode }};
+{{- if .LimitLocation }}
+        error_page {{ .Limits.StatusCode }} {{ .LimitLocation }};
+{{- end }}
+{{- end }}
+{{- range .Auth.Deny }}
+        deny {{ . }};
+{{- end }}
+{{- range .Auth.Allow }}
+        allow {{ . }};
+{{- end }}
+{{- if .Auth.Allow }}
+        deny all;
+{{- end }}
+{{- if .UsersFile }}
+        auth_basic {{ quote .Auth.RealmName }};
+        auth_basic_user_file {{ .UsersFile }};
+{{- end }}
+{{- if .AuthLocation }}
+        auth_request {{ .AuthLocation }};
+{{- range .AuthHeaders }}
+        auth_request_set ${{ .Var }} $upstream_{{ variable .Name }};
+{{- end }}
+{{- end }}
+        proxy_pass http://{{ upstream .Service }}{{ if and .StripPrefix (ne .PathPrefix "/") }}/{{ end }};
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_next_upstream error timeout http_502 http_503;
+{{- range .RequestHeaders.Remove }}
+        proxy_set_header {{ . }} "";
+{{- end }}
+{{- range $name, $value := .RequestHeaders.Set }}
+        proxy_set_header {{ $name }} {{ quote $value }};
+{{- end }}
+{{- range .HeaderAdds }}
+        proxy_set_header {{ .Name }} ${{ .Var }};
+{{- end }}
+{{- if .UsersFile }}
+        proxy_set_header Authorization "";
+{{- end }}
+{{- range .AuthHeaders }}
+        proxy_set_header {{ .Name }} ${{ .Var }};
+{{- end }}
+{{- range .ResponseHeaders.Remove }}
+        proxy_hide_header {{ . }};
+{{- end }}
+{{- range $name, $value := .ResponseHeaders.Set }}
+        proxy_hide_header {{ $name }};
+        add_header {{ $name }} {{ quote $value }} always;
+{{- end }}
+{{- range $name, $value := .ResponseHeaders.Add }}
+        add_header {{ $name }} {{ quote $value }} always;
+{{- end }}
+{{- if and .TLS .HSTS.MaxAge }}
+        add_header Strict-Transport-Security {{ quote .HSTS.Header }} always;
+{{- end }}
+{{- if .CORS.Enabled }}
+        add_header Access-Control-Allow-Origin ${{ .CORSOrigin }} always;
+{{- if .CORS.AllowCredentials }}
+        add_header Access-Control-Allow-Credentials true always;
+{{- end }}
+{{- if .CORS.ExposedHeaders }}
+        add_header Access-Control-Expose-Headers {{ quote (join .CORS.ExposedHeaders ", ") }} always;
+{{- end }}
+        add_header Vary Origin always;
+{{- end }}
+{{- end }}
+{{- end }}
+`))
+
+// Render returns the nginx config for a routing table, a server per routed host with a
+// location per route. nginx picks the longest matching prefix itself. Hosts with a
+// certificate are also served over HTTPS with the certificate files in certDir, the basic
+// auth users of routes are in authDir. Once every backend of a service is at its
+// max_conns nginx answers 502, where the built-in proxy answers like a rate limit.
+func Render(table routing.Table, certDir, authDir string) ([]byte, error) {
+	data := configData{ChallengeAddr: table.ChallengeAddr, authDir: authDir, upstreams: make(map[string]bool)}
+	for _, service := range table.Services {
+		if len(service.Backends) > 0 {
+			data.upstreams[service.Name] = true
+		}
+	}
+	servers := make(map[string]*server)
+	var hosts []string
+	for _, service := range table.Services {
+		// Services without instances are left out, an empty upstream is invalid
+		if len(service.Backends) == 0 {
+			continue
+		}
+		// nginx refuses an upstream where every server is down, so mark them all up in that case
+		service.Backends = append([]routing.Backend(nil), service.Backends...)
+		if len(service.ActiveBackends()) == len(service.Backends) {
+			for j := range service.Backends {
+				service.Backends[j].Healthy = true
+			}
+		}
+		data.Upstreams = append(data.Upstreams, service)
+
+		for _, route := range service.EffectiveRoutes() {
+			host := strings.ToLower(route.Host)
+			s, ok := servers[host]
+			if !ok {
+				s = &server{Host: host}
+				if _, ok := table.Certificate(host); ok {
+					s.CertFile = filepath.Join(certDir, certFileName(host, ".crt"))
+					s.KeyFile = filepath.Join(certDir, certFileName(host, ".key"))
+				}
+				servers[host] = s
+				hosts = append(hosts, host)
+			}
+			// Two services on the same path would be a duplicate location, the first keeps it
+			if slices.ContainsFunc(s.Locations, func(l location) bool { return l.PathPrefix() == route.PathPrefix() }) {
+				continue
+			}
+			s.Locations = append(s.Locations, data.location(route, service))
+		}
+	}
+	for _, host := range hosts {
+		s := servers[host]
+		sort.SliceStable(s.Locations, func(i, j int) bool {
+			return s.Locations[i].PathPrefix() < s.Locations[j].PathPrefix()
+		})
+		data.Servers = append(data.Servers, *s)
+	}
+
+	var buf bytes.Buffer
+	if err := configTemplate.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("error rendering nginx config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// location adds the maps the middleware of a route needs, nginx cannot append to a request
+// header, compare origins against a list or join query strings any other way
+func (data *configData) location(route routing.Route, service routing.Service) location {
+	l := location{Route: route, Service: service.Name, GRPC: service.GRPC}
+	names := make([]string, 0, len(route.RequestHeaders.Add))
+	for name := range route.RequestHeaders.Add {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := fmt.Sprintf("orchestrator_header_%d", len(data.Maps))
+		incoming := "$" + headerVariable(name)
+		value := route.RequestHeaders.Add[name]
+		data.Maps = append(data.Maps, nginxMap{Source: incoming, Var: v, Entries: [][2]string{
+			{`""`, quote(value)},
+			{"default", quote(incoming + ", " + value)},
+		}})
+		l.HeaderAdds = append(l.HeaderAdds, headerAdd{Name: name, Var: v})
+	}
+
+	if len(route.Auth.BasicAuth) > 0 {
+		l.UsersFile = filepath.Join(data.authDir, usersFileName(route))
+	}
+	if forward := route.Auth.ForwardAuth; forward.Enabled() {
+		id := data.authLocations
+		data.authLocations++
+		l.AuthLocation = fmt.Sprintf("/_orchestrator_auth_%d", id)
+		// auth_request passes on 401 and 403, any other refusal becomes a 500
+		if forward.Service != "" {
+			if data.upstreams[forward.Service] {
+				l.AuthTarget = "http://" + upstreamName(forward.Service) + forward.ServicePath()
+			}
+		} else {
+			l.AuthTarget = forward.URL
+			if u, err := url.Parse(forward.URL); err == nil && u.Path == "" {
+				l.AuthTarget += "/"
+			}
+		}
+		for i, name := range forward.ResponseHeaders {
+			l.AuthHeaders = append(l.AuthHeaders, headerAdd{Name: name, Var: fmt.Sprintf("orchestrator_auth_%d_%d", id, i)})
+		}
+	}
+
+	if limits := route.Limits; limits.PerClient.Enabled() || limits.PerRoute.Enabled() {
+		id := len(data.Zones)
+		if limits.PerClient.Enabled() {
+			l.LimitReqs = append(l.LimitReqs, data.limitZone("client", "$binary_remote_addr", "10m", limits.PerClient))
+		}
+		// Every request of a route has the same key, the host of its server
+		if limits.PerRoute.Enabled() {
+			l.LimitReqs = append(l.LimitReqs, data.limitZone("route", "$server_name", "64k", limits.PerRoute))
+		}
+		if limits.Message != "" {
+			l.LimitLocation = fmt.Sprintf("@orchestrator_limited_%d", id)
+			l.LimitBody = strings.TrimSuffix(quote(limits.Message), `"`) + `\n"`
+		}
+	}
+
+	if route.CORS.Enabled() {
+		id := len(data.Maps)
+		l.CORSOrigin = fmt.Sprintf("orchestrator_cors_origin_%d", id)
+		l.CORSPreflight = fmt.Sprintf("orchestrator_cors_preflight_%d", id)
+		origins := nginxMap{Source: "$http_origin", Var: l.CORSOrigin, Entries: [][2]string{{"default", `""`}}}
+		for _, origin := range route.CORS.AllowedOrigins {
+			if origin == "*" {
+				origins.Entries = [][2]string{{"default", "$http_origin"}}
+				break
+			}
+			origins.Entries = append(origins.Entries, [2]string{quote(origin), "$http_origin"})
+		}
+		data.Maps = append(data.Maps, origins, nginxMap{
+			Source: quote("$request_method:$http_access_control_request_method:$" + l.CORSOrigin),
+			Var:    l.CORSPreflight,
+			Entries: [][2]string{
+				{"default", `""`},
+				{`"~^OPTIONS:.+:$"`, "forbidden"},
+				{`"~^OPTIONS:.+:.+"`, "allowed"},
+			},
+		})
+	}
+	for _, r := range route.Redirects {
+		if r.QuerySeparator() == "&" && !data.redirectArgs {
+			data.redirectArgs = true
+			data.Maps = append(data.Maps, nginxMap{Source: "$args", Var: redirectArgsVar, Entries: [][2]string{
+				{`""`, `""`},
+				{"default", `"&$args"`},
+			}})
+		}
+	}
+	return l
+}
+
+// limitZone adds the zone of a rate limit. Zones of a kind have the same size, nginx
+// refuses a reload that changes the size of a zone.
+func (data *configData) limitZone(kind, key, size string, limit routing.RateLimit) limitReq {
+	name := fmt.Sprintf("orchestrator_%s_%d", kind, len(data.Zones))
+	data.Zones = append(data.Zones, limitZone{Key: key, Name: name, Size: size, Rate: strings.Replace(limit.Rate, "/", "r/", 1)})
+	return limitReq{Zone: name, Burst: limit.Burst}
+}
@@ -1 +1,284 @@
-package nginx
+package nginx_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/nginx"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testLogger struct{}
+
+func (testLogger) Info(format string, args ...interface{})  {}
+func (testLogger) Error(format string, args ...interface{}) {}
+
+func TestRender(t *testing.T) {
+	table := routing.Table{Services: []routing.Service{
+		{
+			Name:     "web.app",
+			Domain:   "web.example.com",
+			Strategy: routing.LeastConnections,
+			Backends: []routing.Backend{
+				{Address: "127.0.0.1:8001", Healthy: true},
+				{Address: "127.0.0.1:8002", Healthy: false},
+			},
+		},
+		{
+			Name:     "api",
+			Domain:   "api.example.com",
+			Strategy: routing.RoundRobin,
+			Backends: []routing.Backend{
+				{Address: "127.0.0.1:9001", Healthy: false, Weight: 19},
+				{Address: "127.0.0.1:9002", Healthy: false, Weight: 1},
+			},
+		},
+		{
+			Name:     "rpc",
+			Domain:   "rpc.example.com",
+			Strategy: routing.RoundRobin,
+			Backends: []routing.Backend{{Address: "127.0.0.1:7001", Healthy: true}},
+			GRPC:     true,
+		},
+		{Name: "empty", Domain: "empty.example.com"},
+	}}
+
+	config, err := nginx.Render(table, "/etc/nginx/certs", "/etc/nginx/auth")
+	require.NoError(t, err)
+	out := string(config)
+
+	assert.Contains(t, out, "upstream orchestrator_web_app {\n    least_conn;\n    server 127.0.0.1:8001;\n    server 127.0.0.1:8002 down;\n}")
+	assert.Contains(t, out, "server_name web.example.com;")
+	assert.Contains(t, out, "proxy_pass http://orchestrator_web_app;")
+
+	// A service with no healthy instance keeps all of them in rotation
+	assert.Contains(t, out, "upstream orchestrator_api {\n    server 127.0.0.1:9001 weight=19;\n    server 127.0.0.1:9002;\n}")
+	assert.Contains(t, out, "grpc_pass grpc://orchestrator_rpc;")
+	assert.Equal(t, 1, strings.Count(out, "http2 on;"), "Only gRPC services speak HTTP/2")
+	assert.NotContains(t, out, "empty.example.com")
+	assert.False(t, table.Services[1].Backends[0].Healthy, "Render should not modify the table")
+	assert.NotContains(t, out, "listen 443", "Services without a certificate are served over HTTP only")
+	assert.NotContains(t, out, "acme-challenge")
+}
+
+func TestRenderTLS(t *testing.T) {
+	table := routing.Table{
+		Services: []routing.Service{
+			{Name: "web", Domain: "web.example.com", Backends: []routing.Backend{{Address: "127.0.0.1:8001", Healthy: true}}},
+			{Name: "api", Domain: "api.example.com", Backends: []routing.Backend{{Address: "127.0.0.1:9001", Healthy: true}}},
+		},
+		Certificates:  []routing.Certificate{{Domain: "Web.example.com", CertPEM: []byte("cert"), KeyPEM: []byte("key")}},
+		ChallengeAddr: "127.0.0.1:8402",
+	}
+
+	config, err := nginx.Render(table, "/etc/nginx/certs", "/etc/nginx/auth")
+	require.NoError(t, err)
+	out := string(config)
+
+	assert.Contains(t, out, "server {\n    listen 443 ssl;\n    server_name web.example.com;\n    ssl_certificate /etc/nginx/certs/web.example.com.crt;\n    ssl_certificate_key /etc/nginx/certs/web.example.com.key;\n\n    location / {\n        proxy_pass http://orchestrator_web;")
+	assert.Equal(t, 1, strings.Count(out, "listen 443 ssl;"), "Only services with a certificate are served over HTTPS")
+	assert.Equal(t, 2, strings.Count(out, "location /.well-known/acme-challenge/ {\n        proxy_pass http://127.0.0.1:8402;\n    }"),
+		"Challenges are forwarded on port 80 of every service")
+}
+
+func TestRenderRoutes(t *testing.T) {
+	table := routing.Table{
+		Services: []routing.Service{
+			{
+				Name:     "site",
+				Routes:   []routing.Route{{Host: "example.com", Path: "/"}, {Host: "www.example.com", Path: "/"}},
+				Backends: []routing.Backend{{Address: "127.0.0.1:8001", Healthy: true}},
+			},
+			{
+				Name:     "api",
+				Routes:   []routing.Route{{Host: "example.com", Path: "/api", StripPrefix: true}},
+				Backends: []routing.Backend{{Address: "127.0.0.1:9001", Healthy: true}},
+			},
+		},
+		Certificates: []routing.Certificate{{Domain: "www.example.com", CertPEM: []byte("cert"), KeyPEM: []byte("key")}},
+	}
+
+	config, err := nginx.Render(table, "/etc/nginx/certs", "/etc/nginx/auth")
+	require.NoError(t, err)
+	out := string(config)
+
+	assert.Equal(t, 1, strings.Count(out, "server_name example.com;"), "Routes of a host share one server")
+	assert.Equal(t, 2, strings.Count(out, "server_name www.example.com;"), "A host with a certificate is also served over HTTPS")
+	assert.Equal(t, 1, strings.Count(out, "upstream orchestrator_site {"))
+	assert.Contains(t, out, "    location = /api {\n        proxy_pass http://orchestrator_api/;")
+	assert.Contains(t, out, "    location /api/ {\n        proxy_pass http://orchestrator_api/;", "A stripped prefix is replaced by /")
+	assert.Contains(t, out, "    location / {\n        proxy_pass http://orchestrator_site;")
+	assert.Less(t, strings.Index(out, "server_name example.com;"), strings.Index(out, "server_name www.example.com;"))
+}
+
+func TestRenderMiddleware(t *testing.T) {
+	middleware := routing.Middleware{
+		HTTPSRedirect:   true,
+		Redirects:       []routing.Redirect{{From: "/old", To: "/new"}, {From: "/search", To: "/find?src=old#results"}},
+		RequestHeaders:  routing.HeaderRules{Set: map[string]string{"X-Env": "prod"}, Add: map[string]string{"X-Via": "edge"}, Remove: []string{"Cookie"}},
+		ResponseHeaders: routing.HeaderRules{Set: map[string]string{"X-Frame-Options": "DENY"}, Remove: []string{"Server"}},
+		HSTS:            routing.HSTS{MaxAge: 600},
+		CORS:            routing.CORS{AllowedOrigins: []string{"https://app.example.com"}},
+	}
+	table := routing.Table{
+		Services: []routing.Service{{
+			Name: "site",
+			Routes: []routing.Route{
+				{Host: "example.com", Path: "/", Middleware: middleware},
+				{Host: "www.example.com", Path: "/", Middleware: routing.Middleware{RedirectToApex: true}},
+			},
+			Backends: []routing.Backend{{Address: "127.0.0.1:8001", Healthy: true}},
+		}},
+		Certificates: []routing.Certificate{{Domain: "example.com", CertPEM: []byte("cert"), KeyPEM: []byte("key")}},
+	}
+
+	config, err := nginx.Render(table, "/etc/nginx/certs", "/etc/nginx/auth")
+	require.NoError(t, err)
+	out := string(config)
+
+	assert.Contains(t, out, "    listen 80;\n    server_name example.com;\n\n    location / {\n        return 301 https://$host$request_uri;")
+	assert.Contains(t, out, "    location / {\n        return 301 $scheme://example.com$request_uri;")
+	assert.Contains(t, out, "if ($uri = \"/old\") {\n            return 301 \"/new$is_args$args\";")
+	assert.Contains(t, out, "return 301 \"/find?src=old$orchestrator_redirect_args#results\";", "A target with a query joins the request's with &")
+	assert.Contains(t, out, "map $args $orchestrator_redirect_args {\n    \"\" \"\";\n    default \"&$args\";")
+	assert.Contains(t, out, "proxy_set_header Cookie \"\";")
+	assert.Contains(t, out, "proxy_set_header X-Env \"prod\";")
+	assert.Contains(t, out, "map $http_x_via $orchestrator_header_0 {\n    \"\" \"edge\";\n    default \"$http_x_via, edge\";")
+	assert.Contains(t, out, "proxy_hide_header Server;")
+	assert.Contains(t, out, "add_header X-Frame-Options \"DENY\" always;")
+	assert.Equal(t, 1, strings.Count(out, "Strict-Transport-Security"), "HSTS is only sent over HTTPS")
+	assert.Contains(t, out, "\"https://app.example.com\" $http_origin;")
+	assert.Contains(t, out, "add_header Access-Control-Allow-Origin $orchestrator_cors_origin_1 always;")
+}
+
+func TestRenderAuth(t *testing.T) {
+	table := routing.Table{
+		Services: []routing.Service{
+			{
+				Name: "site",
+				Routes: []routing.Route{
+					{Host: "example.com", Path: "/admin", Middleware: routing.Middleware{Auth: routing.Auth{
+						Allow:     []string{"10.0.0.0/8"},
+						Deny:      []string{"10.0.0.1"},
+						BasicAuth: map[string]string{"admin": "$2a$10$hash"},
+						Realm:     "Admin",
+					}}},
+					{Host: "example.com", Path: "/", Middleware: routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{
+						Service:         "sso",
+						Path:            "/verify",
+						ResponseHeaders: []string{"X-User"},
+					}}}},
+					{Host: "other.example.com", Path: "/", Middleware: routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{Service: "missing"}}}},
+				},
+				Backends: []routing.Backend{{Address: "127.0.0.1:8001", Healthy: true}},
+			},
+			{Name: "sso", Backends: []routing.Backend{{Address: "127.0.0.1:9001", Healthy: true}}},
+		},
+	}
+
+	config, err := nginx.Render(table, "/etc/nginx/certs", "/etc/nginx/auth")
+	require.NoError(t, err)
+	out := string(config)
+
+	assert.Contains(t, out, "        deny 10.0.0.1;\n        allow 10.0.0.0/8;\n        deny all;\n", "Denied networks come first, nginx stops at the first match")
+	assert.Contains(t, out, "auth_basic \"Admin\";\n        auth_basic_user_file /etc/nginx/auth/example.com_admin.htpasswd;")
+	assert.Contains(t, out, "proxy_set_header Authorization \"\";")
+	assert.Contains(t, out, "auth_request /_orchestrator_auth_0;\n        auth_request_set $orchestrator_auth_0_0 $upstream_http_x_user;")
+	assert.Contains(t, out, "proxy_set_header X-User $orchestrator_auth_0_0;")
+	assert.Contains(t, out, "    location = /_orchestrator_auth_0 {\n        internal;\n        proxy_pass http://orchestrator_sso/verify;")
+	assert.Contains(t, out, "    location = /_orchestrator_auth_1 {\n        internal;\n        return 503;", "An auth service without instances has no upstream")
+}
+
+func TestRenderLimits(t *testing.T) {
+	table := routing.Table{Services: []routing.Service{{
+		Name:           "site",
+		MaxConnections: 20,
+		Routes: []routing.Route{
+			{Host: "example.com", Path: "/api", Middleware: routing.Middleware{Limits: routing.Limits{
+				PerClient: routing.RateLimit{Rate: "10/s", Burst: 20},
+				PerRoute:  routing.RateLimit{Rate: "300/m"},
+				Status:    503,
+				Message:   "slow down",
+			}}},
+			{Host: "example.com", Path: "/", Middleware: routing.Middleware{Limits: routing.Limits{PerClient: routing.RateLimit{Rate: "1/s"}}}},
+		},
+		Backends: []routing.Backend{{Address: "127.0.0.1:8001", Healthy: true}},
+	}}}
+
+	config, err := nginx.Render(table, "/etc/nginx/certs", "/etc/nginx/auth")
+	require.NoError(t, err)
+	out := string(config)
+
+	assert.Contains(t, out, "limit_req_zone $binary_remote_addr zone=orchestrator_client_0:10m rate=10r/s;")
+	assert.Contains(t, out, "limit_req_zone $server_name zone=orchestrator_route_1:64k rate=300r/m;")
+	assert.Contains(t, out, "    zone orchestrator_site 64k;\n    server 127.0.0.1:8001 max_conns=20;", "max_conns needs the upstream in shared memory to hold across workers")
+	assert.Contains(t, out, "        limit_req zone=orchestrator_client_0 burst=20 nodelay;\n        limit_req zone=orchestrator_route_1 nodelay;\n        limit_req_status 503;\n        error_page 503 @orchestrator_limited_0;")
+	assert.Contains(t, out, "    location @orchestrator_limited_0 {\n        default_type text/plain;\n        return 503 \"slow down\\n\";")
+	assert.Contains(t, out, "        limit_req zone=orchestrator_client_2 nodelay;\n        limit_req_status 429;\n        proxy_pass")
+}
+
+func TestGeneratorApply(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orchestrator.conf")
+	marker := filepath.Join(dir, "reloaded")
+	generator := nginx.NewGenerator(path, []string{"touch", marker}, testLogger{})
+
+	table := routing.Table{Services: []routing.Service{{
+		Name:     "web",
+		Domain:   "web.example.com",
+		Backends: []routing.Backend{{Address: "127.0.0.1:8001", Healthy: true}},
+	}}}
+	require.NoError(t, generator.Apply(table))
+
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(written), "# Generated by go-container-orchestrator"))
+	_, err = os.Stat(marker)
+	require.NoError(t, err, "nginx should have been reloaded")
+
+	// Applying the same table again must not reload nginx
+	require.NoError(t, os.Remove(marker))
+	require.NoError(t, generator.Apply(table))
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err), "nginx should not be reloaded for an unchanged config")
+
+	certDir := filepath.Join(dir, "orchestrator-certs")
+	table.Certificates = []routing.Certificate{{Domain: "web.example.com", CertPEM: []byte("cert 1"), KeyPEM: []byte("key 1")}}
+	require.NoError(t, generator.Apply(table))
+	key, err := os.ReadFile(filepath.Join(certDir, "web.example.com.key"))
+	require.NoError(t, err)
+	assert.Equal(t, "key 1", string(key))
+	info, err := os.Stat(filepath.Join(certDir, "web.example.com.key"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "Keys are readable by their owner only")
+	_, err = os.Stat(marker)
+	require.NoError(t, err, "nginx should have been reloaded for the new certificate")
+
+	// A renewed certificate leaves the config as it is but still needs a reload
+	require.NoError(t, os.Remove(marker))
+	table.Certificates[0].CertPEM = []byte("cert 2")
+	require.NoError(t, generator.Apply(table))
+	_, err = os.Stat(marker)
+	require.NoError(t, err, "nginx should have been reloaded for the renewed certificate")
+
+	table.Certificates = nil
+	require.NoError(t, generator.Apply(table))
+	entries, err := os.ReadDir(certDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "Certificates no longer served are removed")
+
+	table.Services[0].Routes = []routing.Route{{Host: "web.example.com", Path: "/admin", Middleware: routing.Middleware{
+		Auth: routing.Auth{BasicAuth: map[string]string{"bob": "$2a$10$hash2", "alice": "$2a$10$hash1"}},
+	}}}
+	require.NoError(t, generator.Apply(table))
+	users, err := os.ReadFile(filepath.Join(dir, "orchestrator-auth", "web.example.com_admin.htpasswd"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice:$2a$10$hash1\nbob:$2a$10$hash2\n", string(users))
+	written, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(written), "auth_basic_user_file "+filepath.Join(dir, "orchestrator-auth", "web.example.com_admin.htpasswd")+";")
+}
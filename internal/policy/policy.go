@@ -0,0 +1,162 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule names reported in violations
+const (
+	RuleAllowedRegistries = "allowed_registries"
+	RuleBannedTags        = "banned_tags"
+	RuleRequiredLabels    = "required_labels"
+	RuleResourceLimits    = "require_resource_limits"
+)
+
+// Policy decides which images may be deployed. The zero value allows everything.
+type Policy struct {
+	// AllowedRegistries lists registries, optionally with a repository prefix
+	// such as "docker.io/library". An empty list allows every registry.
+	AllowedRegistries     []string    `yaml:"allowed_registries"`
+	BannedTags            []BannedTag `yaml:"banned_tags"`
+	RequiredLabels        []string    `yaml:"required_labels"`
+	RequireResourceLimits bool        `yaml:"require_resource_limits"`
+}
+
+// BannedTag forbids a tag in the listed environments, or everywhere when none are listed
+type BannedTag struct {
+	Tag          string   `yaml:"tag"`
+	Environments []string `yaml:"environments"`
+}
+
+// Subject is a deployment being checked against a policy
+type Subject struct {
+	Image string
+	// Labels of the image, nil if the image has not been inspected yet
+	Labels      map[string]string
+	MemoryLimit string
+	CPULimit    string
+}
+
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// ViolationError lists every rule a subject broke
+type ViolationError struct {
+	Image      string
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return fmt.Sprintf("image %s rejected by policy: %s", e.Image, strings.Join(messages, "; "))
+}
+
+func Load(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy: %w", err)
+	}
+	defer f.Close()
+
+	// A misspelled rule would otherwise be dropped silently, turning the rule off
+	var p Policy
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing policy: %w", err)
+	}
+	return &p, nil
+}
+
+// Check returns a *ViolationError if the subject breaks any rule in the given environment
+func (p *Policy) Check(environment string, s Subject) error {
+	if p == nil {
+		return nil
+	}
+
+	var violations []Violation
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	named, err := reference.ParseNormalizedNamed(s.Image)
+	if err != nil {
+		return &ViolationError{Image: s.Image, Violations: []Violation{
+			{Rule: "image_reference", Message: fmt.Sprintf("invalid image reference: %s", err)},
+		}}
+	}
+
+	if len(p.AllowedRegistries) > 0 && !p.registryAllowed(named) {
+		add(RuleAllowedRegistries, "registry %s is not in the allowed registries", reference.Domain(named))
+	}
+
+	// A digest pins the image, so only tag references can be mutable
+	if _, pinned := named.(reference.Digested); !pinned {
+		tag := "latest"
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+		for _, banned := range p.BannedTags {
+			if banned.Tag == tag && banned.appliesTo(environment) {
+				add(RuleBannedTags, "tag %q is not allowed%s", tag, inEnvironment(environment))
+			}
+		}
+	}
+
+	if s.Labels != nil {
+		for _, label := range p.RequiredLabels {
+			if s.Labels[label] == "" {
+				add(RuleRequiredLabels, "required label %s is missing", label)
+			}
+		}
+	}
+
+	if p.RequireResourceLimits {
+		if s.MemoryLimit == "" {
+			add(RuleResourceLimits, "a memory limit is required")
+		}
+		if s.CPULimit == "" {
+			add(RuleResourceLimits, "a CPU limit is required")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ViolationError{Image: s.Image, Violations: violations}
+	}
+	return nil
+}
+
+func (p *Policy) registryAllowed(named reference.Named) bool {
+	name := named.Name()
+	for _, allowed := range p.AllowedRegistries {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if reference.Domain(named) == allowed || strings.HasPrefix(name, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (b BannedTag) appliesTo(environment string) bool {
+	return len(b.Environments) == 0 || slices.Contains(b.Environments, environment)
+}
+
+func inEnvironment(environment string) string {
+	if environment == "" {
+		return ""
+	}
+	return " in " + environment
+}
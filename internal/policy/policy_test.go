@@ -0,0 +1,105 @@
+package policy_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	p := &policy.Policy{
+		AllowedRegistries: []string{"registry.example.com", "docker.io/library"},
+		BannedTags: []policy.BannedTag{
+			{Tag: "latest", Environments: []string{"production"}},
+			{Tag: "dev"},
+		},
+		RequiredLabels:        []string{"org.opencontainers.image.source"},
+		RequireResourceLimits: true,
+	}
+	limits := func(s policy.Subject) policy.Subject {
+		s.MemoryLimit = "512m"
+		s.CPULimit = "1"
+		return s
+	}
+
+	testCases := []struct {
+		name          string
+		environment   string
+		subject       policy.Subject
+		expectedRules []string
+	}{
+		{"AllowedImage", "production", limits(policy.Subject{Image: "registry.example.com/app:1.2.3"}), nil},
+		{"OfficialImage", "production", limits(policy.Subject{Image: "alpine:3.12"}), nil},
+		{"DisallowedRegistry", "production", limits(policy.Subject{Image: "ghcr.io/someone/app:1.0"}), []string{policy.RuleAllowedRegistries}},
+		{"DisallowedDockerHubUser", "", limits(policy.Subject{Image: "someone/app:1.0"}), []string{policy.RuleAllowedRegistries}},
+		{"LatestInProduction", "production", limits(policy.Subject{Image: "alpine:latest"}), []string{policy.RuleBannedTags}},
+		{"ImplicitLatestInProduction", "production", limits(policy.Subject{Image: "alpine"}), []string{policy.RuleBannedTags}},
+		{"LatestInStaging", "staging", limits(policy.Subject{Image: "alpine:latest"}), nil},
+		{"BannedEverywhere", "staging", limits(policy.Subject{Image: "alpine:dev"}), []string{policy.RuleBannedTags}},
+		{"PinnedDigest", "production", limits(policy.Subject{Image: "alpine@sha256:" + sixtyFourHex}), nil},
+		{"MissingLabel", "", limits(policy.Subject{Image: "alpine:3.12", Labels: map[string]string{}}), []string{policy.RuleRequiredLabels}},
+		{"PresentLabel", "", limits(policy.Subject{Image: "alpine:3.12", Labels: map[string]string{"org.opencontainers.image.source": "https://example.com"}}), nil},
+		{"MissingLimits", "", policy.Subject{Image: "alpine:3.12"}, []string{policy.RuleResourceLimits, policy.RuleResourceLimits}},
+		{
+			"EveryRuleBroken", "production",
+			policy.Subject{Image: "ghcr.io/someone/app:latest", Labels: map[string]string{}},
+			[]string{policy.RuleAllowedRegistries, policy.RuleBannedTags, policy.RuleRequiredLabels, policy.RuleResourceLimits, policy.RuleResourceLimits},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := p.Check(tc.environment, tc.subject)
+			if tc.expectedRules == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var violation *policy.ViolationError
+			require.True(t, errors.As(err, &violation), "Expected a policy violation")
+			var rules []string
+			for _, v := range violation.Violations {
+				rules = append(rules, v.Rule)
+			}
+			assert.Equal(t, tc.expectedRules, rules)
+		})
+	}
+}
+
+func TestNilPolicyAllowsEverything(t *testing.T) {
+	var p *policy.Policy
+	assert.NoError(t, p.Check("production", policy.Subject{Image: "anything:latest"}))
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	content := `
+allowed_registries:
+  - registry.example.com
+banned_tags:
+  - tag: latest
+    environments: [production]
+required_labels:
+  - org.opencontainers.image.revision
+require_resource_limits: true
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	p, err := policy.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry.example.com"}, p.AllowedRegistries)
+	assert.Equal(t, []policy.BannedTag{{Tag: "latest", Environments: []string{"production"}}}, p.BannedTags)
+	assert.Equal(t, []string{"org.opencontainers.image.revision"}, p.RequiredLabels)
+	assert.True(t, p.RequireResourceLimits)
+
+	require.NoError(t, os.WriteFile(path, []byte("allowed_registry:\n  - registry.example.com\n"), 0o644))
+	_, err = policy.Load(path)
+	assert.Error(t, err, "unknown keys must not be ignored")
+}
+
+const sixtyFourHex = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
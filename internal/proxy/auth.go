@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// forwardAuthTimeout bounds how long a forward auth endpoint may take to decide
+const forwardAuthTimeout = 10 * time.Second
+
+// Headers that describe the connection to the proxy, not the request
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// refuse answers the requests the auth of a route does not let through and reports
+// whether it did
+func (p *Proxy) refuse(w http.ResponseWriter, r *http.Request, rt route) bool {
+	if len(rt.allow) > 0 || len(rt.deny) > 0 {
+		addr, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil || !permits(rt.allow, rt.deny, addr.Addr().Unmap()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return true
+		}
+	}
+	if len(rt.Auth.BasicAuth) > 0 {
+		user, password, ok := r.BasicAuth()
+		hash, known := rt.Auth.BasicAuth[user]
+		if !ok || !known || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			w.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(rt.Auth.RealmName()))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return true
+		}
+		// The password is for the proxy, the service never sees it
+		r.Header.Del("Authorization")
+	}
+	if rt.Auth.ForwardAuth.Enabled() {
+		return p.forwardAuth(w, r, rt)
+	}
+	return false
+}
+
+// permits reports whether an address is in none of the denied networks and, when there
+// are allowed ones, in one of them
+func permits(allow, deny []netip.Prefix, addr netip.Addr) bool {
+	for _, network := range deny {
+		if network.Contains(addr) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, network := range allow {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardAuth asks the forward auth endpoint of a route about a request. It answers the
+// request with the response of the endpoint unless it approves, in which case the identity
+// headers of the response are set on the request.
+func (p *Proxy) forwardAuth(w http.ResponseWriter, r *http.Request, rt route) bool {
+	auth := rt.Auth.ForwardAuth
+	target := auth.URL
+	if auth.Service != "" {
+		p.mu.RLock()
+		svc, ok := p.services[auth.Service]
+		p.mu.RUnlock()
+		if !ok {
+			p.logger.Error("Error authorizing %s%s: auth service %s has no instances", r.Host, r.URL.Path, auth.Service)
+			http.Error(w, "auth service unavailable", http.StatusServiceUnavailable)
+			return true
+		}
+		b := svc.pick()
+		if b == nil {
+			svc.limited.Add(1)
+			http.Error(w, "auth service unavailable", http.StatusServiceUnavailable)
+			return true
+		}
+		b.requests.Add(1)
+		defer b.active.Add(-1)
+		target = "http://" + b.address + auth.ServicePath()
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		p.logger.Error("Error authorizing %s%s: %s", r.Host, r.URL.Path, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return true
+	}
+	req.Header = r.Header.Clone()
+	for _, name := range hopHeaders {
+		req.Header.Del(name)
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", scheme)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		req.Header.Set("X-Forwarded-For", addr.Addr().Unmap().String())
+	}
+
+	resp, err := p.authClient.Do(req)
+	if err != nil {
+		p.logger.Error("Error authorizing %s%s: %s", r.Host, r.URL.Path, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return true
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		for _, name := range auth.ResponseHeaders {
+			r.Header.Del(name)
+			if values := resp.Header.Values(name); len(values) > 0 {
+				r.Header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		return false
+	}
+	// Pass the refusal on as it is, a login redirect or a challenge for credentials
+	h := w.Header()
+	for name, values := range resp.Header {
+		h[name] = values
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return true
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+)
+
+// limiter holds the token buckets of a route. Like backends it outlives table updates, so
+// a deploy does not hand every client a full bucket.
+type limiter struct {
+	key     string
+	service string
+	host    string
+	path    string
+	limits  routing.Limits
+	client  rate
+	route   rate
+
+	mu      sync.Mutex
+	all     bucket
+	clients map[netip.Addr]*bucket
+	swept   time.Time
+
+	clientLimited atomic.Uint64
+	routeLimited  atomic.Uint64
+}
+
+// rate is a parsed rate limit, it is off when perSecond is zero
+type rate struct {
+	perSecond float64
+	capacity  float64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func parseRate(r routing.RateLimit) rate {
+	n, period, err := routing.ParseRate(r.Rate)
+	if err != nil {
+		return rate{}
+	}
+	return rate{perSecond: float64(n) / period.Seconds(), capacity: float64(r.Burst + 1)}
+}
+
+func limiterKey(service string, r routing.Route) string {
+	return service + " " + r.Host + r.PathPrefix()
+}
+
+func newLimiter(service string, r routing.Route) *limiter {
+	return &limiter{
+		key:     limiterKey(service, r),
+		service: service,
+		host:    r.Host,
+		path:    r.PathPrefix(),
+		limits:  r.Limits,
+		client:  parseRate(r.Limits.PerClient),
+		route:   parseRate(r.Limits.PerRoute),
+		clients: make(map[netip.Addr]*bucket),
+	}
+}
+
+// refill adds the tokens earned since the last request, a new bucket starts full
+func (b *bucket) refill(r rate, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = r.capacity
+	} else {
+		b.tokens = math.Min(r.capacity, b.tokens+now.Sub(b.last).Seconds()*r.perSecond)
+	}
+	b.last = now
+}
+
+// ready reports whether there is a token for a request, without taking it. When there is
+// none it returns how long until there is.
+func (b *bucket) ready(r rate, now time.Time) (bool, time.Duration) {
+	b.refill(r, now)
+	if b.tokens >= 1 {
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / r.perSecond * float64(time.Second))
+}
+
+// allow takes a token from the bucket of the client and the one of the route, from neither
+// unless both have one. When either is empty it returns how long the client should wait.
+func (l *limiter) allow(client netip.Addr, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var clientBucket *bucket
+	if l.client.perSecond > 0 {
+		if now.Sub(l.swept) > time.Minute {
+			l.sweep(now)
+		}
+		b, ok := l.clients[client]
+		if !ok {
+			b = &bucket{}
+			l.clients[client] = b
+		}
+		if ok, wait := b.ready(l.client, now); !ok {
+			l.clientLimited.Add(1)
+			return false, wait
+		}
+		clientBucket = b
+	}
+	if l.route.perSecond > 0 {
+		if ok, wait := l.all.ready(l.route, now); !ok {
+			l.routeLimited.Add(1)
+			return false, wait
+		}
+		l.all.tokens--
+	}
+	if clientBucket != nil {
+		clientBucket.tokens--
+	}
+	return true, 0
+}
+
+// sweep forgets the clients whose buckets have filled up again, a new bucket is the same
+func (l *limiter) sweep(now time.Time) {
+	for addr, b := range l.clients {
+		b.refill(l.client, now)
+		if b.tokens >= l.client.capacity {
+			delete(l.clients, addr)
+		}
+	}
+	l.swept = now
+}
+
+// limit answers the requests over the rate limits of a route and reports whether it did
+func limit(w http.ResponseWriter, r *http.Request, rt route) bool {
+	if rt.limiter == nil {
+		return false
+	}
+	addr, _ := netip.ParseAddrPort(r.RemoteAddr)
+	ok, wait := rt.limiter.allow(addr.Addr().Unmap(), time.Now())
+	if ok {
+		return false
+	}
+	overLimit(w, rt.Limits, wait)
+	return true
+}
+
+// overLimit answers a request over a limit, with the time to wait when it is known
+func overLimit(w http.ResponseWriter, limits routing.Limits, wait time.Duration) {
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	message := limits.Message
+	if message == "" {
+		message = http.StatusText(limits.StatusCode())
+	}
+	http.Error(w, message, limits.StatusCode())
+}
+
+// acquire counts a request as in flight on the backend unless it has max in flight
+// already, there is no max when it is zero
+func (b *backend) acquire(max int64) bool {
+	for {
+		active := b.active.Load()
+		if max > 0 && active >= max {
+			return false
+		}
+		if b.active.CompareAndSwap(active, active+1) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteMetrics writes the counters of the proxy in the Prometheus text format
+func (p *Proxy) WriteMetrics(w io.Writer) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	out := bufio.NewWriter(w)
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	sample := func(name string, labels [][2]string, value any) {
+		var pairs []string
+		for _, l := range labels {
+			pairs = append(pairs, l[0]+"="+strconv.Quote(l[1]))
+		}
+		fmt.Fprintf(out, "%s{%s} %v\n", name, strings.Join(pairs, ","), value)
+	}
+
+	type httpBackend struct {
+		service string
+		backend *backend
+	}
+	var backends []httpBackend
+	for _, svc := range p.services {
+		for _, b := range svc.backends {
+			backends = append(backends, httpBackend{svc.name, b})
+		}
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		if backends[i].service != backends[j].service {
+			return backends[i].service < backends[j].service
+		}
+		return backends[i].backend.address < backends[j].backend.address
+	})
+	httpLabels := func(b httpBackend) [][2]string {
+		return [][2]string{{"service", b.service}, {"backend", b.backend.address}}
+	}
+	metric("orchestrator_proxy_requests_total", "counter", "Requests proxied to a backend.")
+	for _, b := range backends {
+		sample("orchestrator_proxy_requests_total", httpLabels(b), b.backend.requests.Load())
+	}
+	metric("orchestrator_proxy_request_errors_total", "counter", "Requests to a backend that failed or answered with a 5xx.")
+	for _, b := range backends {
+		sample("orchestrator_proxy_request_errors_total", httpLabels(b), b.backend.errors.Load())
+	}
+	metric("orchestrator_proxy_active_requests", "gauge", "Requests in flight to a backend.")
+	for _, b := range backends {
+		sample("orchestrator_proxy_active_requests", httpLabels(b), b.backend.active.Load())
+	}
+	metric("orchestrator_proxy_backend_max_connections", "gauge", "Requests a backend may have in flight, for services with a cap.")
+	for _, b := range backends {
+		if max := p.services[b.service].maxConnections; max > 0 {
+			sample("orchestrator_proxy_backend_max_connections", httpLabels(b), max)
+		}
+	}
+
+	names := make([]string, 0, len(p.services))
+	for name := range p.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metric("orchestrator_proxy_connection_limited_total", "counter", "Requests refused because every backend of a service had as many in flight as it may.")
+	for _, name := range names {
+		if p.services[name].maxConnections > 0 {
+			sample("orchestrator_proxy_connection_limited_total", [][2]string{{"service", name}}, p.services[name].limited.Load())
+		}
+	}
+
+	limiters := make([]*limiter, 0, len(p.limiters))
+	for _, l := range p.limiters {
+		limiters = append(limiters, l)
+	}
+	sort.Slice(limiters, func(i, j int) bool {
+		return limiters[i].key < limiters[j].key
+	})
+	limitLabels := func(l *limiter, kind string) [][2]string {
+		return [][2]string{{"service", l.service}, {"host", l.host}, {"path", l.path}, {"limit", kind}}
+	}
+	metric("orchestrator_proxy_rate_limit_per_second", "gauge", "Requests per second a route takes from each client or from all of them.")
+	for _, l := range limiters {
+		if l.client.perSecond > 0 {
+			sample("orchestrator_proxy_rate_limit_per_second", limitLabels(l, "client"), l.client.perSecond)
+		}
+		if l.route.perSecond > 0 {
+			sample("orchestrator_proxy_rate_limit_per_second", limitLabels(l, "route"), l.route.perSecond)
+		}
+	}
+	metric("orchestrator_proxy_rate_limited_total", "counter", "Requests refused for going over the rate limit of a route.")
+	for _, l := range limiters {
+		if l.client.perSecond > 0 {
+			sample("orchestrator_proxy_rate_limited_total", limitLabels(l, "client"), l.clientLimited.Load())
+		}
+		if l.route.perSecond > 0 {
+			sample("orchestrator_proxy_rate_limited_total", limitLabels(l, "route"), l.routeLimited.Load())
+		}
+	}
+
+	streams := make([]*stream, 0, len(p.streams))
+	for _, s := range p.streams {
+		streams = append(streams, s)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].port != streams[j].port {
+			return streams[i].port < streams[j].port
+		}
+		return streams[i].protocol < streams[j].protocol
+	})
+	streamLabels := func(s *stream) [][2]string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return [][2]string{{"service", s.service}, {"port", s.portName}, {"protocol", s.protocol}, {"listen", strconv.Itoa(s.port)}}
+	}
+	metric("orchestrator_stream_connections_active", "gauge", "Connections open on a stream, UDP clients count as connections.")
+	for _, s := range streams {
+		sample("orchestrator_stream_connections_active", streamLabels(s), s.active.Load())
+	}
+	metric("orchestrator_stream_connections_total", "counter", "Connections forwarded by a stream.")
+	for _, s := range streams {
+		sample("orchestrator_stream_connections_total", streamLabels(s), s.total.Load())
+	}
+
+	keys := make([]string, 0, len(p.streamBackends))
+	for key := range p.streamBackends {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	backendLabels := func(key string) [][2]string {
+		b := p.streamBackends[key]
+		protocol, _, _ := strings.Cut(key, "/")
+		return [][2]string{{"protocol", protocol}, {"backend", b.address}, {"container_id", b.containerID}}
+	}
+	metric("orchestrator_stream_backend_connections_active", "gauge", "Stream connections open to an instance, including ones being drained.")
+	for _, key := range keys {
+		sample("orchestrator_stream_backend_connections_active", backendLabels(key), p.streamBackends[key].active.Load())
+	}
+	metric("orchestrator_stream_backend_connections_total", "counter", "Stream connections forwarded to an instance.")
+	for _, key := range keys {
+		sample("orchestrator_stream_backend_connections_total", backendLabels(key), p.streamBackends[key].total.Load())
+	}
+	return out.Flush()
+}
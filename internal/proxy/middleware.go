@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+)
+
+// redirect answers the requests a route redirects and reports whether it did. Plain HTTP
+// is only redirected once the host has a certificate to serve HTTPS with, to tlsPort.
+func redirect(w http.ResponseWriter, r *http.Request, rt route, host string, secure bool, tlsPort string) bool {
+	scheme := "http"
+	if r.TLS != nil || rt.HTTPSRedirect && secure {
+		scheme = "https"
+	}
+	target := host
+	if rt.RedirectToApex {
+		target = routing.ApexHost(host)
+	}
+	if target != host || scheme == "https" && r.TLS == nil {
+		if scheme == "https" && r.TLS == nil {
+			if tlsPort != "" && tlsPort != "443" {
+				target = net.JoinHostPort(target, tlsPort)
+			}
+		} else if _, port, err := net.SplitHostPort(r.Host); err == nil {
+			// Same listener, the client keeps the port it came on
+			target = net.JoinHostPort(target, port)
+		}
+		http.Redirect(w, r, scheme+"://"+target+r.URL.RequestURI(), http.StatusMovedPermanently)
+		return true
+	}
+	for _, rule := range rt.Redirects {
+		if r.URL.Path == rule.From {
+			w.Header().Set("Location", rule.Target(r.URL.RawQuery))
+			w.WriteHeader(rule.StatusCode())
+			return true
+		}
+	}
+	return false
+}
+
+// preflight answers a CORS preflight request and reports whether the request was one
+func preflight(w http.ResponseWriter, r *http.Request, cors routing.CORS) bool {
+	if !cors.Enabled() || r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if !cors.Allows(origin) {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Methods", cors.Methods())
+	if len(cors.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	}
+	if cors.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if cors.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func applyHeaders(h http.Header, rules routing.HeaderRules) {
+	for _, name := range rules.Remove {
+		h.Del(name)
+	}
+	for name, value := range rules.Set {
+		h.Set(name, value)
+	}
+	for name, value := range rules.Add {
+		h.Add(name, value)
+	}
+}
+
+// responseWriter applies the response middleware of a route to the headers of the
+// response just before they are written
+type responseWriter struct {
+	http.ResponseWriter
+	rt     route
+	origin string
+	tls    bool
+	wrote  bool
+}
+
+// wrapResponse returns w as it is when the route changes no response header
+func wrapResponse(w http.ResponseWriter, r *http.Request, rt route) http.ResponseWriter {
+	if rt.ResponseHeaders.IsZero() && rt.HSTS.MaxAge == 0 && !rt.CORS.Enabled() {
+		return w
+	}
+	return &responseWriter{ResponseWriter: w, rt: rt, origin: r.Header.Get("Origin"), tls: r.TLS != nil}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		h := w.Header()
+		applyHeaders(h, w.rt.ResponseHeaders)
+		if w.tls && w.rt.HSTS.MaxAge > 0 {
+			h.Set("Strict-Transport-Security", w.rt.HSTS.Header())
+		}
+		if cors := w.rt.CORS; cors.Enabled() {
+			h.Add("Vary", "Origin")
+			if cors.Allows(w.origin) {
+				h.Set("Access-Control-Allow-Origin", w.origin)
+				if cors.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+				if len(cors.ExposedHeaders) > 0 {
+					h.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+				}
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController flush streamed responses
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,412 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	"golang.org/x/crypto/acme"
+)
+
+type Logger interface {
+	Info(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// Proxy is the built-in HTTP reverse proxy, routing requests by Host header and path
+// and balancing them across the healthy instances of a service
+type Proxy struct {
+	addr   string
+	logger Logger
+
+	mu sync.RWMutex
+	// services are keyed by name, hosts holds the routes of each host longest path first
+	services map[string]*service
+	hosts    map[string][]route
+	// backends outlive table updates so connection counts stay accurate
+	backends map[string]*backend
+	// limiters are keyed by service, host and path and outlive table updates too
+	limiters map[string]*limiter
+	// streams are keyed by protocol and port, streamBackends by protocol and address
+	streams        map[string]*stream
+	streamBackends map[string]*streamBackend
+	// ctx is set while the proxy serves, streams are listened on only then
+	ctx context.Context
+	// certificates are served on tlsAddr by domain, tlsAddr is empty when HTTPS is not served
+	certificates map[string]*tls.Certificate
+	tlsAddr      string
+	challenges   Challenges
+	// authClient asks forward auth endpoints, redirects are passed on to clients
+	authClient *http.Client
+}
+
+type service struct {
+	name     string
+	strategy routing.Strategy
+	backends []*backend
+	weights  []int
+	// maxConnections caps the requests in flight to each backend, limited counts the
+	// requests refused because every backend was at the cap
+	maxConnections int64
+	limited        *atomic.Uint64
+
+	mu sync.Mutex
+	// current holds the smooth weighted round robin state, the same algorithm nginx uses
+	current []int
+}
+
+type route struct {
+	routing.Route
+	service *service
+	// allow and deny are the networks of the auth of the route
+	allow, deny []netip.Prefix
+	// limiter is nil when the route has no rate limits
+	limiter *limiter
+}
+
+type backend struct {
+	address  string
+	active   atomic.Int64
+	requests atomic.Uint64
+	errors   atomic.Uint64
+	proxy    *httputil.ReverseProxy
+}
+
+// BackendStats counts the requests sent to a backend and how many of them failed with a 5xx
+type BackendStats struct {
+	Requests uint64
+	Errors   uint64
+}
+
+func New(addr string, logger Logger) *Proxy {
+	return &Proxy{
+		addr:           addr,
+		logger:         logger,
+		services:       make(map[string]*service),
+		hosts:          make(map[string][]route),
+		backends:       make(map[string]*backend),
+		limiters:       make(map[string]*limiter),
+		streams:        make(map[string]*stream),
+		streamBackends: make(map[string]*streamBackend),
+		authClient: &http.Client{
+			Timeout: forwardAuthTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// HTTPPorts are the ports the proxy serves HTTP and HTTPS on, streams cannot have them
+func (p *Proxy) HTTPPorts() []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var ports []int
+	for _, addr := range []string{p.addr, p.tlsAddr} {
+		if addr == "" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			if n, err := strconv.Atoi(port); err == nil && n != 0 {
+				ports = append(ports, n)
+			}
+		}
+	}
+	return ports
+}
+
+func (p *Proxy) Apply(table routing.Table) error {
+	services := make(map[string]*service, len(table.Services))
+	hosts := make(map[string][]route)
+	backends := make(map[string]*backend)
+	limiters := make(map[string]*limiter)
+
+	// Services asked by forward auth need no routes of their own
+	authServices := make(map[string]bool)
+	for _, s := range table.Services {
+		for _, r := range s.EffectiveRoutes() {
+			if r.Auth.ForwardAuth.Service != "" {
+				authServices[r.Auth.ForwardAuth.Service] = true
+			}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range table.Services {
+		svc := &service{name: s.Name, strategy: s.Strategy, maxConnections: int64(s.MaxConnections), limited: new(atomic.Uint64)}
+		if existing, ok := p.services[s.Name]; ok {
+			svc.limited = existing.limited
+		}
+		for _, b := range s.ActiveBackends() {
+			existing, ok := p.backends[b.Address]
+			if !ok {
+				existing = p.newBackend(b.Address)
+			}
+			backends[b.Address] = existing
+			svc.backends = append(svc.backends, existing)
+			svc.weights = append(svc.weights, b.EffectiveWeight())
+		}
+		svc.current = make([]int, len(svc.backends))
+		routes := s.EffectiveRoutes()
+		if (len(routes) == 0 && !authServices[s.Name]) || len(svc.backends) == 0 {
+			continue
+		}
+		services[s.Name] = svc
+		for _, r := range routes {
+			host := strings.ToLower(r.Host)
+			rt := route{Route: r, service: svc, allow: networks(r.Auth.Allow), deny: networks(r.Auth.Deny)}
+			if r.Limits.PerClient.Enabled() || r.Limits.PerRoute.Enabled() {
+				key := limiterKey(s.Name, r)
+				existing, ok := p.limiters[key]
+				if !ok || existing.limits != r.Limits {
+					existing = newLimiter(s.Name, r)
+				}
+				limiters[key] = existing
+				rt.limiter = existing
+			}
+			hosts[host] = append(hosts[host], rt)
+		}
+	}
+	for _, routes := range hosts {
+		sort.SliceStable(routes, func(i, j int) bool {
+			return len(routes[i].PathPrefix()) > len(routes[j].PathPrefix())
+		})
+	}
+
+	p.services = services
+	p.hosts = hosts
+	p.backends = backends
+	p.limiters = limiters
+	p.applyStreams(table.Streams)
+	p.applyCertificates(table.Certificates)
+	return nil
+}
+
+// networks parses the networks of an auth, they are validated with the service spec
+func networks(values []string) []netip.Prefix {
+	var result []netip.Prefix
+	for _, v := range values {
+		if network, err := routing.ParseNetwork(v); err == nil {
+			result = append(result, network)
+		}
+	}
+	return result
+}
+
+func (p *Proxy) newBackend(address string) *backend {
+	target := &url.URL{Scheme: "http", Host: address}
+	b := &backend{address: address}
+	b.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			r.Out.Host = r.In.Host
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if resp.StatusCode >= http.StatusInternalServerError {
+				b.errors.Add(1)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.logger.Error("Error proxying %s to %s: %s", r.Host, address, err)
+			b.errors.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return b
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.serveChallenge(w, r) {
+		return
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+	p.mu.RLock()
+	rt, ok := match(p.hosts[host], r.URL.Path)
+	_, secure := p.certificates[host]
+	_, tlsPort, _ := net.SplitHostPort(p.tlsAddr)
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, "no service for host", http.StatusNotFound)
+		return
+	}
+	if redirect(w, r, rt, host, secure, tlsPort) || preflight(w, r, rt.CORS) || limit(w, r, rt) || p.refuse(w, r, rt) {
+		return
+	}
+	applyHeaders(r.Header, rt.RequestHeaders)
+	w = wrapResponse(w, r, rt)
+
+	b := rt.service.pick()
+	if b == nil {
+		rt.service.limited.Add(1)
+		overLimit(w, rt.Limits, 0)
+		return
+	}
+	b.requests.Add(1)
+	defer b.active.Add(-1)
+	if rt.StripPrefix && rt.PathPrefix() != "/" {
+		http.StripPrefix(rt.Path, b.proxy).ServeHTTP(w, r)
+		return
+	}
+	b.proxy.ServeHTTP(w, r)
+}
+
+// match returns the route with the longest path matching a request, routes are sorted
+// longest path first
+func match(routes []route, path string) (route, bool) {
+	for _, r := range routes {
+		if r.Matches(path) {
+			return r, true
+		}
+	}
+	return route{}, false
+}
+
+// BackendStats returns the counters of a backend, they start from zero when it is added
+func (p *Proxy) BackendStats(address string) BackendStats {
+	p.mu.RLock()
+	b, ok := p.backends[address]
+	p.mu.RUnlock()
+	if !ok {
+		return BackendStats{}
+	}
+	return BackendStats{Requests: b.requests.Load(), Errors: b.errors.Load()}
+}
+
+// pick chooses the backend of a request and counts the request as in flight on it, the
+// caller ends it by decrementing active. It returns nil when every backend has
+// maxConnections requests in flight.
+func (s *service) pick() *backend {
+	// Another request may take the last slot of the chosen backend first, so try again
+	for range s.backends {
+		b := s.choose()
+		if b == nil {
+			return nil
+		}
+		if b.acquire(s.maxConnections) {
+			return b
+		}
+	}
+	return nil
+}
+
+// full reports whether a backend has as many requests in flight as it may
+func (s *service) full(b *backend) bool {
+	return s.maxConnections > 0 && b.active.Load() >= s.maxConnections
+}
+
+// choose picks a backend with room for another request by the strategy of the service.
+// Backends take turns by smooth weighted round robin, with least connections only those
+// tied for the fewest requests in flight for their weight do, like nginx least_conn.
+func (s *service) choose() *backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := make([]int64, len(s.backends))
+	least := -1
+	for i, b := range s.backends {
+		if s.full(b) {
+			active[i] = -1
+			continue
+		}
+		active[i] = b.active.Load()
+		// Compare active/weight without dividing
+		if least < 0 || active[i]*int64(s.weights[least]) < active[least]*int64(s.weights[i]) {
+			least = i
+		}
+	}
+	if least < 0 {
+		return nil
+	}
+
+	total, best := 0, -1
+	for i, weight := range s.weights {
+		if active[i] < 0 {
+			continue
+		}
+		if s.strategy == routing.LeastConnections && active[i]*int64(s.weights[least]) != active[least]*int64(weight) {
+			continue
+		}
+		s.current[i] += weight
+		total += weight
+		if best < 0 || s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	s.current[best] -= total
+	return s.backends[best]
+}
+
+// ListenAndServe serves the proxy, HTTPS when enabled and the streams until ctx is cancelled
+func (p *Proxy) ListenAndServe(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              p.addr,
+		Handler:           p,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	var tlsServer *http.Server
+	p.mu.Lock()
+	p.ctx = ctx
+	for _, s := range p.streams {
+		p.listen(s)
+	}
+	if p.tlsAddr != "" {
+		tlsServer = &http.Server{
+			Addr:              p.tlsAddr,
+			Handler:           p,
+			ReadHeaderTimeout: 10 * time.Second,
+			TLSConfig: &tls.Config{
+				GetCertificate: p.getCertificate,
+				NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+			},
+		}
+		go p.serveTLS(tlsServer)
+	}
+	p.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		p.mu.Lock()
+		p.ctx = nil
+		for _, s := range p.streams {
+			s.close()
+		}
+		p.mu.Unlock()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			p.logger.Error("Proxy shutdown error: %s", err)
+		}
+		if tlsServer != nil {
+			if err := tlsServer.Shutdown(shutdownCtx); err != nil {
+				p.logger.Error("TLS proxy shutdown error: %s", err)
+			}
+		}
+	}()
+
+	p.logger.Info("Starting proxy on %s", p.addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
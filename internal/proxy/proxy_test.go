@@ -0,0 +1,723 @@
+package proxy_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/proxy"
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type testLogger struct{}
+
+func (testLogger) Info(format string, args ...interface{})  {}
+func (testLogger) Error(format string, args ...interface{}) {}
+
+func newBackend(t *testing.T, name string, handler func()) routing.Backend {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler != nil {
+			handler()
+		}
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(server.Close)
+	return routing.Backend{ContainerID: name, Address: strings.TrimPrefix(server.URL, "http://"), Healthy: true}
+}
+
+func get(t *testing.T, p *proxy.Proxy, host string) (int, string) {
+	return getPath(t, p, host, "/")
+}
+
+func getPath(t *testing.T, p *proxy.Proxy, host, path string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Host = host
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestRoundRobin(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	sick := newBackend(t, "sick", nil)
+	sick.Healthy = false
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{{
+		Name:     "web",
+		Domain:   "web.example.com",
+		Strategy: routing.RoundRobin,
+		Backends: []routing.Backend{newBackend(t, "a", nil), newBackend(t, "b", nil), sick},
+	}}}))
+
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		code, body := get(t, p, "Web.Example.com:80")
+		require.Equal(t, http.StatusOK, code)
+		counts[body]++
+	}
+	assert.Equal(t, map[string]int{"a": 5, "b": 5}, counts, "Requests should alternate between healthy backends")
+}
+
+func TestLeastConnections(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := newBackend(t, "slow", func() {
+		started <- struct{}{}
+		<-release
+	})
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{{
+		Name:     "web",
+		Domain:   "web.example.com",
+		Strategy: routing.LeastConnections,
+		Backends: []routing.Backend{slow, newBackend(t, "fast", nil)},
+	}}}))
+
+	// The first request goes to slow and stays open
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		get(t, p, "web.example.com")
+	}()
+	<-started
+
+	for i := 0; i < 3; i++ {
+		_, body := get(t, p, "web.example.com")
+		assert.Equal(t, "fast", body)
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestLeastConnectionsTies(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	stable, canary := newBackend(t, "stable", nil), newBackend(t, "canary", nil)
+	stable.Weight, canary.Weight = 3, 1
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{{
+		Name:     "web",
+		Domain:   "web.example.com",
+		Strategy: routing.LeastConnections,
+		Backends: []routing.Backend{stable, canary},
+	}}}))
+
+	// Requests finish before the next one, every backend is idle and tied
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		_, body := get(t, p, "web.example.com")
+		counts[body]++
+	}
+	assert.Equal(t, map[string]int{"stable": 6, "canary": 2}, counts, "Ties should be broken by weight")
+}
+
+func TestUnknownHost(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	require.NoError(t, p.Apply(routing.Table{}))
+
+	code, _ := get(t, p, "missing.example.com")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHTTPPorts(t *testing.T) {
+	p := proxy.New(":8080", testLogger{})
+	assert.Equal(t, []int{8080}, p.HTTPPorts())
+	p.EnableTLS("127.0.0.1:8443", nil)
+	assert.Equal(t, []int{8080, 8443}, p.HTTPPorts())
+	assert.Empty(t, proxy.New(":0", testLogger{}).HTTPPorts(), "Port 0 is picked by the system")
+}
+
+func TestPathRouting(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	echoPath := func(name string) routing.Backend {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.Path)
+		}))
+		t.Cleanup(server.Close)
+		return routing.Backend{Address: strings.TrimPrefix(server.URL, "http://"), Healthy: true}
+	}
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{
+		{
+			Name:     "site",
+			Routes:   []routing.Route{{Host: "example.com", Path: "/"}, {Host: "www.example.com"}},
+			Backends: []routing.Backend{echoPath("site")},
+		},
+		{
+			Name:     "api",
+			Routes:   []routing.Route{{Host: "example.com", Path: "/api", StripPrefix: true}},
+			Backends: []routing.Backend{echoPath("api")},
+		},
+		{
+			Name:     "v2",
+			Routes:   []routing.Route{{Host: "example.com", Path: "/api/v2"}},
+			Backends: []routing.Backend{echoPath("v2")},
+		},
+	}}))
+
+	for path, want := range map[string]string{
+		"/":              "site /",
+		"/about":         "site /about",
+		"/apis":          "site /apis",
+		"/api":           "api /",
+		"/api/users":     "api /users",
+		"/api/v2/users":  "v2 /api/v2/users",
+		"/api/v2":        "v2 /api/v2",
+		"/api/v20/items": "api /v20/items",
+	} {
+		code, body := getPath(t, p, "example.com", path)
+		assert.Equal(t, http.StatusOK, code, path)
+		assert.Equal(t, want, body, "The longest matching prefix wins for %s", path)
+	}
+	_, body := getPath(t, p, "WWW.example.com", "/about")
+	assert.Equal(t, "site /about", body)
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	canary := newBackend(t, "canary", nil)
+	canary.Weight = 1
+	stable := newBackend(t, "stable", nil)
+	stable.Weight = 3
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{{
+		Name:     "web",
+		Domain:   "web.example.com",
+		Backends: []routing.Backend{canary, stable},
+	}}}))
+
+	counts := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		_, body := get(t, p, "web.example.com")
+		counts[body]++
+	}
+	assert.Equal(t, map[string]int{"canary": 5, "stable": 15}, counts)
+}
+
+func TestBackendStats(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer failing.Close()
+	address := strings.TrimPrefix(failing.URL, "http://")
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{{
+		Name:     "web",
+		Domain:   "web.example.com",
+		Backends: []routing.Backend{{Address: address, Healthy: true}},
+	}}}))
+
+	for _, path := range []string{"/", "/fail", "/", "/fail", "/fail"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "web.example.com"
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, proxy.BackendStats{Requests: 5, Errors: 3}, p.BackendStats(address))
+}
+
+// echoServer answers every line with its name and the line
+func echoServer(t *testing.T, name string) routing.Backend {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					fmt.Fprintf(conn, "%s %s\n", name, scanner.Text())
+				}
+			}()
+		}
+	}()
+	return routing.Backend{ContainerID: name, Address: listener.Addr().String(), Healthy: true}
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serve(t *testing.T, p *proxy.Proxy) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = p.ListenAndServe(ctx) }()
+}
+
+func dialStream(t *testing.T, port int) (net.Conn, *bufio.Reader) {
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		var err error
+		conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		return err == nil
+	}, 2*time.Second, 20*time.Millisecond, "The stream should be listening")
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func exchange(t *testing.T, conn net.Conn, reader *bufio.Reader, line string) string {
+	_, err := fmt.Fprintln(conn, line)
+	require.NoError(t, err)
+	reply, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSpace(reply)
+}
+
+func TestTCPStream(t *testing.T) {
+	p := proxy.New("127.0.0.1:0", testLogger{})
+	port := freePort(t)
+	blue := echoServer(t, "blue")
+	table := func(backend routing.Backend) routing.Table {
+		return routing.Table{Streams: []routing.Stream{{
+			Service: "db", PortName: "sql", Protocol: "tcp", Port: port, Backends: []routing.Backend{backend},
+		}}}
+	}
+	require.NoError(t, p.Apply(table(blue)))
+	serve(t, p)
+
+	old, oldReader := dialStream(t, port)
+	assert.Equal(t, "blue ping", exchange(t, old, oldReader, "ping"))
+
+	// New connections go to green, the open one stays with blue until it closes
+	require.NoError(t, p.Apply(table(echoServer(t, "green"))))
+	conn, reader := dialStream(t, port)
+	assert.Equal(t, "green ping", exchange(t, conn, reader, "ping"))
+	assert.Equal(t, "blue again", exchange(t, old, oldReader, "again"))
+
+	drained := make(chan int64)
+	go func() { drained <- p.Drain(context.Background(), "blue", 5*time.Second) }()
+	old.Close()
+	assert.Zero(t, <-drained, "Draining waits for the open connection")
+	assert.Equal(t, int64(1), p.Drain(context.Background(), "green", 50*time.Millisecond), "Draining gives up after the timeout")
+
+	var metrics bytes.Buffer
+	require.NoError(t, p.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), fmt.Sprintf(`orchestrator_stream_connections_total{service="db",port="sql",protocol="tcp",listen="%d"} 2`, port))
+	assert.Contains(t, metrics.String(), fmt.Sprintf(`orchestrator_stream_connections_active{service="db",port="sql",protocol="tcp",listen="%d"} 1`, port))
+}
+
+func TestUDPStream(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(append([]byte("pong "), buf[:n]...), addr)
+		}
+	}()
+
+	p := proxy.New("127.0.0.1:0", testLogger{})
+	port := freePort(t)
+	require.NoError(t, p.Apply(routing.Table{Streams: []routing.Stream{{
+		Service: "dns", PortName: "dns", Protocol: "udp", Port: port,
+		Backends: []routing.Backend{{ContainerID: "dns", Address: backend.LocalAddr().String(), Healthy: true}},
+	}}}))
+	serve(t, p)
+
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	buf := make([]byte, 1024)
+	require.Eventually(t, func() bool {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return false
+		}
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		return err == nil && string(buf[:n]) == "pong ping"
+	}, 2*time.Second, 20*time.Millisecond, "Datagrams should be forwarded both ways")
+}
+
+type testChallenges struct{}
+
+func (testChallenges) HTTPChallenge(token string) (string, bool) {
+	return token + ".thumbprint", token == "token"
+}
+
+func (testChallenges) TLSALPNChallenge(domain string) (*tls.Certificate, bool) {
+	return nil, false
+}
+
+// selfSigned returns a certificate for domain and its key, PEM encoded
+func selfSigned(t *testing.T, domain string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestTLS(t *testing.T) {
+	p := proxy.New("127.0.0.1:0", testLogger{})
+	port := freePort(t)
+	p.EnableTLS(fmt.Sprintf("127.0.0.1:%d", port), testChallenges{})
+	certPEM, keyPEM := selfSigned(t, "web.example.com")
+	require.NoError(t, p.Apply(routing.Table{
+		Services: []routing.Service{{
+			Name:     "web",
+			Domain:   "web.example.com",
+			Backends: []routing.Backend{newBackend(t, "web", nil)},
+		}},
+		Certificates: []routing.Certificate{{Domain: "Web.Example.com", CertPEM: certPEM, KeyPEM: keyPEM}},
+	}))
+	serve(t, p)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(certPEM))
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, fmt.Sprintf("127.0.0.1:%d", port))
+		},
+	}}
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get("https://web.example.com/")
+		return err == nil
+	}, 2*time.Second, 20*time.Millisecond, "The proxy should serve HTTPS with the certificate of the domain")
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "web", string(body))
+
+	_, err = client.Get("https://other.example.com/")
+	assert.Error(t, err, "Domains without a certificate fail the handshake")
+
+	code, answer := getPath(t, p, "web.example.com", "/.well-known/acme-challenge/token")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "token.thumbprint", answer, "HTTP-01 challenges are answered before routing")
+	code, answer = getPath(t, p, "web.example.com", "/.well-known/acme-challenge/unknown")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "web", answer, "Unknown tokens go to the service")
+}
+
+func TestMiddleware(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	var seen http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Powered-By", "php")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+	backend := routing.Backend{Address: strings.TrimPrefix(server.URL, "http://"), Healthy: true}
+	certPEM, keyPEM := selfSigned(t, "example.com")
+	require.NoError(t, p.Apply(routing.Table{
+		Services: []routing.Service{{
+			Name: "site",
+			Routes: []routing.Route{
+				{Host: "www.example.com", Middleware: routing.Middleware{RedirectToApex: true}},
+				{Host: "example.com", Middleware: routing.Middleware{
+					HTTPSRedirect: true,
+					Redirects:     []routing.Redirect{{From: "/old", To: "/new", Status: http.StatusFound}, {From: "/search", To: "/find?src=old#results"}},
+					HSTS:          routing.HSTS{MaxAge: 63072000, IncludeSubdomains: true},
+				}},
+				{Host: "plain.example.com", Middleware: routing.Middleware{HTTPSRedirect: true}},
+				{Host: "api.example.com", Middleware: routing.Middleware{
+					RequestHeaders:  routing.HeaderRules{Set: map[string]string{"X-Env": "prod"}, Add: map[string]string{"X-Trace": "proxy"}, Remove: []string{"Cookie"}},
+					ResponseHeaders: routing.HeaderRules{Set: map[string]string{"Server": "orchestrator"}, Remove: []string{"X-Powered-By"}},
+					CORS: routing.CORS{
+						AllowedOrigins:   []string{"https://app.example.com"},
+						AllowedMethods:   []string{"GET", "PUT"},
+						AllowCredentials: true,
+						MaxAge:           600,
+					},
+				}},
+			},
+			Backends: []routing.Backend{backend},
+		}},
+		Certificates: []routing.Certificate{{Domain: "example.com", CertPEM: certPEM, KeyPEM: keyPEM}},
+	}))
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "http://www.example.com/about?x=1", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "http://example.com/about?x=1", rec.Header().Get("Location"))
+
+	rec = serve(httptest.NewRequest(http.MethodGet, "http://example.com/about", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "https://example.com/about", rec.Header().Get("Location"))
+	rec = serve(httptest.NewRequest(http.MethodGet, "http://www.example.com:8080/about", nil))
+	assert.Equal(t, "http://example.com:8080/about", rec.Header().Get("Location"), "Redirects to the apex keep the port")
+	rec = serve(httptest.NewRequest(http.MethodGet, "http://plain.example.com/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "Hosts without a certificate are not redirected to HTTPS")
+	p.EnableTLS("127.0.0.1:8443", nil)
+	rec = serve(httptest.NewRequest(http.MethodGet, "http://example.com:8080/about", nil))
+	assert.Equal(t, "https://example.com:8443/about", rec.Header().Get("Location"), "Redirects to HTTPS go to its port")
+	p.EnableTLS(":443", nil)
+	rec = serve(httptest.NewRequest(http.MethodGet, "http://example.com/about", nil))
+	assert.Equal(t, "https://example.com/about", rec.Header().Get("Location"))
+
+	secure := httptest.NewRequest(http.MethodGet, "https://example.com/old?page=2", nil)
+	rec = serve(secure)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/new?page=2", rec.Header().Get("Location"))
+	rec = serve(httptest.NewRequest(http.MethodGet, "https://example.com/search?q=go", nil))
+	assert.Equal(t, "/find?src=old&q=go#results", rec.Header().Get("Location"), "A target with a query joins the request's with &")
+	rec = serve(httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "max-age=63072000; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("X-Trace", "client")
+	rec = serve(req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "prod", seen.Get("X-Env"))
+	assert.Equal(t, []string{"client", "proxy"}, seen.Values("X-Trace"))
+	assert.Empty(t, seen.Get("Cookie"))
+	assert.Equal(t, "orchestrator", rec.Header().Get("Server"))
+	assert.Empty(t, rec.Header().Get("X-Powered-By"))
+	assert.Empty(t, rec.Header().Get("Strict-Transport-Security"), "HSTS is only sent over HTTPS")
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	preflight := httptest.NewRequest(http.MethodOptions, "http://api.example.com/items", nil)
+	preflight.Header.Set("Origin", "https://app.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", "PUT")
+	rec = serve(preflight)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "GET, PUT", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+
+	preflight.Header.Set("Origin", "https://evil.example.net")
+	rec = serve(preflight)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestAuth(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	var seen http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/verify" || r.Header.Get("X-Forwarded-Uri") != "/private?x=1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Cookie") != "session=alice" {
+			http.Redirect(w, r, "https://login.example.com/", http.StatusFound)
+			return
+		}
+		w.Header().Set("X-User", "alice")
+	}))
+	defer verifier.Close()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, p.Apply(routing.Table{
+		Services: []routing.Service{
+			{
+				Name: "site",
+				Routes: []routing.Route{
+					{Host: "internal.example.com", Middleware: routing.Middleware{Auth: routing.Auth{Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.1"}}}},
+					{Host: "office.example.com", Middleware: routing.Middleware{Auth: routing.Auth{Allow: []string{"192.0.2.0/24"}}}},
+					{Host: "basic.example.com", Middleware: routing.Middleware{Auth: routing.Auth{BasicAuth: map[string]string{"admin": string(hash)}, Realm: "Admin"}}},
+					{Host: "sso.example.com", Middleware: routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{
+						URL:             verifier.URL + "/verify",
+						ResponseHeaders: []string{"X-User"},
+					}}}},
+					{Host: "local.example.com", Middleware: routing.Middleware{Auth: routing.Auth{ForwardAuth: routing.ForwardAuth{
+						Service:         "verifier",
+						Path:            "/verify",
+						ResponseHeaders: []string{"X-User"},
+					}}}},
+				},
+				Backends: []routing.Backend{{Address: strings.TrimPrefix(server.URL, "http://"), Healthy: true}},
+			},
+			// A service without routes of its own, only asked by forward auth
+			{Name: "verifier", Backends: []routing.Backend{{Address: strings.TrimPrefix(verifier.URL, "http://"), Healthy: true}}},
+		},
+	}))
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// httptest requests come from 192.0.2.1
+	rec := serve(httptest.NewRequest(http.MethodGet, "http://internal.example.com/", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code, "Deny wins over allow")
+	rec = serve(httptest.NewRequest(http.MethodGet, "http://office.example.com/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	outside := httptest.NewRequest(http.MethodGet, "http://office.example.com/", nil)
+	outside.RemoteAddr = "198.51.100.7:4000"
+	rec = serve(outside)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve(httptest.NewRequest(http.MethodGet, "http://basic.example.com/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Basic realm="Admin"`, rec.Header().Get("WWW-Authenticate"))
+	req := httptest.NewRequest(http.MethodGet, "http://basic.example.com/", nil)
+	req.SetBasicAuth("admin", "wrong")
+	rec = serve(req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	req.SetBasicAuth("admin", "secret")
+	rec = serve(req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, seen.Get("Authorization"), "The password is not forwarded")
+
+	for _, host := range []string{"sso.example.com", "local.example.com"} {
+		req = httptest.NewRequest(http.MethodGet, "http://"+host+"/private?x=1", nil)
+		req.Header.Set("X-User", "mallory")
+		rec = serve(req)
+		assert.Equal(t, http.StatusFound, rec.Code, "The refusal of %s is passed on", host)
+		assert.Equal(t, "https://login.example.com/", rec.Header().Get("Location"))
+
+		seen = nil
+		req.Header.Set("Cookie", "session=alice")
+		rec = serve(req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, seen)
+		assert.Equal(t, []string{"alice"}, seen.Values("X-User"), "Identity headers come from the auth endpoint only")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	p := proxy.New(":0", testLogger{})
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	defer server.Close()
+	backend := routing.Backend{Address: strings.TrimPrefix(server.URL, "http://"), Healthy: true}
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{
+		{
+			Name: "api",
+			Routes: []routing.Route{
+				{Host: "api.example.com", Middleware: routing.Middleware{Limits: routing.Limits{
+					PerClient: routing.RateLimit{Rate: "1/m", Burst: 1},
+				}}},
+				{Host: "shared.example.com", Middleware: routing.Middleware{Limits: routing.Limits{
+					PerRoute: routing.RateLimit{Rate: "1/m"},
+					Status:   http.StatusServiceUnavailable,
+					Message:  "slow down",
+				}}},
+				{Host: "both.example.com", Middleware: routing.Middleware{Limits: routing.Limits{
+					PerClient: routing.RateLimit{Rate: "1/m"},
+					PerRoute:  routing.RateLimit{Rate: "10/s"},
+				}}},
+			},
+			Backends: []routing.Backend{backend},
+		},
+		{
+			Name:           "capped",
+			Domain:         "capped.example.com",
+			Backends:       []routing.Backend{backend},
+			MaxConnections: 1,
+		},
+	}}))
+
+	request := func(host, path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A client gets the request it makes and a burst of one more
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, request("api.example.com", "/", "192.0.2.1:1000").Code)
+	}
+	rec := request("api.example.com", "/", "192.0.2.1:1001")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, request("api.example.com", "/", "192.0.2.2:1000").Code, "Other clients have buckets of their own")
+
+	assert.Equal(t, http.StatusOK, request("shared.example.com", "/", "192.0.2.1:1000").Code)
+	rec = request("shared.example.com", "/", "192.0.2.2:1000")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "The route limit is shared by all clients")
+	assert.Equal(t, "slow down\n", rec.Body.String())
+
+	// A request the route limit turns away leaves the bucket of its client alone
+	assert.Equal(t, http.StatusOK, request("both.example.com", "/", "192.0.2.1:1000").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("both.example.com", "/", "192.0.2.2:1000").Code)
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, request("both.example.com", "/", "192.0.2.2:1000").Code, "The client still has its token")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request("capped.example.com", "/slow", "192.0.2.1:1000")
+	}()
+	<-started
+	assert.Equal(t, http.StatusTooManyRequests, request("capped.example.com", "/", "192.0.2.2:1000").Code, "The backend is at its cap")
+	close(release)
+	<-done
+	assert.Equal(t, http.StatusOK, request("capped.example.com", "/", "192.0.2.2:1000").Code)
+
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteMetrics(&buf))
+	metrics := buf.String()
+	assert.Contains(t, metrics, `orchestrator_proxy_rate_limited_total{service="api",host="api.example.com",path="/",limit="client"} 1`)
+	assert.Contains(t, metrics, `orchestrator_proxy_rate_limited_total{service="api",host="shared.example.com",path="/",limit="route"} 1`)
+	assert.Contains(t, metrics, `orchestrator_proxy_rate_limit_per_second{service="api",host="shared.example.com",path="/",limit="route"} 0.016666666666666666`)
+	assert.Contains(t, metrics, `orchestrator_proxy_connection_limited_total{service="capped"} 1`)
+	assert.Contains(t, metrics, `orchestrator_proxy_backend_max_connections{service="capped",backend="`+backend.Address+`"} 1`)
+
+	// Reapplying the same limits keeps the buckets
+	require.NoError(t, p.Apply(routing.Table{Services: []routing.Service{{
+		Name: "api",
+		Routes: []routing.Route{{Host: "api.example.com", Middleware: routing.Middleware{Limits: routing.Limits{
+			PerClient: routing.RateLimit{Rate: "1/m", Burst: 1},
+		}}}},
+		Backends: []routing.Backend{backend},
+	}}}))
+	assert.Equal(t, http.StatusTooManyRequests, request("api.example.com", "/", "192.0.2.1:1000").Code)
+}
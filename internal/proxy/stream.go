@@ -0,0 +1,326 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	// udpIdleTimeout is how long a UDP client keeps its backend without sending anything
+	udpIdleTimeout = time.Minute
+	udpBufferSize  = 64 * 1024
+)
+
+// streamBackend counts the connections forwarded to an instance. Like backend it outlives
+// table updates, and it stays around while connections to it are open so they can be drained.
+type streamBackend struct {
+	containerID string
+	address     string
+	active      atomic.Int64
+	total       atomic.Uint64
+}
+
+// stream listens on a public port and forwards each TCP connection, or each UDP client,
+// to one of the instances behind it
+type stream struct {
+	service  string
+	portName string
+	protocol string
+	port     int
+	active   atomic.Int64
+	total    atomic.Uint64
+
+	mu       sync.Mutex
+	backends []*streamBackend
+	next     int
+	listener net.Listener
+	packets  net.PacketConn
+	sessions map[string]*udpSession
+}
+
+type udpSession struct {
+	backend  *streamBackend
+	upstream net.Conn
+	lastSeen atomic.Int64
+}
+
+func streamKey(protocol string, port int) string {
+	return protocol + "/" + strconv.Itoa(port)
+}
+
+// applyStreams replaces the streams, p.mu is held. Streams that go away stop listening,
+// the connections they forwarded are left to finish.
+func (p *Proxy) applyStreams(routes []routing.Stream) {
+	streams := make(map[string]*stream, len(routes))
+	backends := make(map[string]*streamBackend)
+	for _, r := range routes {
+		key := streamKey(r.Protocol, r.Port)
+		s, ok := p.streams[key]
+		if !ok {
+			s = &stream{protocol: r.Protocol, port: r.Port, sessions: make(map[string]*udpSession)}
+		}
+		var active []*streamBackend
+		for _, b := range r.ActiveBackends() {
+			backendKey := r.Protocol + "/" + b.Address
+			existing, ok := p.streamBackends[backendKey]
+			if !ok || existing.containerID != b.ContainerID {
+				existing = &streamBackend{containerID: b.ContainerID, address: b.Address}
+			}
+			backends[backendKey] = existing
+			active = append(active, existing)
+		}
+		s.mu.Lock()
+		s.service, s.portName, s.backends = r.Service, r.PortName, active
+		s.mu.Unlock()
+		streams[key] = s
+		if p.ctx != nil {
+			p.listen(s)
+		}
+	}
+	for key, s := range p.streams {
+		if _, ok := streams[key]; !ok {
+			s.close()
+		}
+	}
+	for key, b := range p.streamBackends {
+		if _, ok := backends[key]; !ok && b.active.Load() > 0 {
+			backends[key] = b
+		}
+	}
+	p.streams = streams
+	p.streamBackends = backends
+}
+
+// listen starts serving a stream unless it already is. A port that cannot be listened on
+// is retried with the next table.
+func (p *Proxy) listen(s *stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil || s.packets != nil {
+		return
+	}
+	host, _, _ := net.SplitHostPort(p.addr)
+	address := net.JoinHostPort(host, strconv.Itoa(s.port))
+	var err error
+	if s.protocol == "udp" {
+		if s.packets, err = net.ListenPacket("udp", address); err == nil {
+			go p.servePackets(s, s.packets)
+		}
+	} else {
+		if s.listener, err = net.Listen("tcp", address); err == nil {
+			go p.serveConns(s, s.listener)
+		}
+	}
+	if err != nil {
+		p.logger.Error("Error listening on %s port %d for %s: %s", s.protocol, s.port, s.service, err)
+		return
+	}
+	p.logger.Info("Forwarding %s port %d to %s of %s", s.protocol, s.port, s.portName, s.service)
+}
+
+func (s *stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	if s.packets != nil {
+		s.packets.Close()
+		s.packets = nil
+	}
+}
+
+// dial connects to the backends round robin, trying each once
+func (s *stream) dial() (*streamBackend, net.Conn, error) {
+	s.mu.Lock()
+	backends, start := s.backends, s.next
+	s.next++
+	s.mu.Unlock()
+	if len(backends) == 0 {
+		return nil, nil, fmt.Errorf("no instances of %s", s.service)
+	}
+	var err error
+	for i := range backends {
+		b := backends[(start+i)%len(backends)]
+		var conn net.Conn
+		if conn, err = net.DialTimeout(s.protocol, b.address, dialTimeout); err == nil {
+			return b, conn, nil
+		}
+	}
+	return nil, nil, err
+}
+
+func (s *stream) opened(b *streamBackend) {
+	s.active.Add(1)
+	s.total.Add(1)
+	b.active.Add(1)
+	b.total.Add(1)
+}
+
+func (s *stream) closed(b *streamBackend) {
+	s.active.Add(-1)
+	b.active.Add(-1)
+}
+
+func (p *Proxy) serveConns(s *stream, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			p.logger.Error("Error accepting on tcp port %d: %s", s.port, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go p.forward(s, conn)
+	}
+}
+
+func (p *Proxy) forward(s *stream, client net.Conn) {
+	defer client.Close()
+	b, upstream, err := s.dial()
+	if err != nil {
+		p.logger.Error("Error forwarding tcp port %d: %s", s.port, err)
+		return
+	}
+	defer upstream.Close()
+	s.opened(b)
+	defer s.closed(b)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pipe(upstream, client)
+	}()
+	pipe(client, upstream)
+	wg.Wait()
+}
+
+// pipe copies until src is done and passes the end on to dst, the other direction may
+// still have data to send
+func pipe(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+func (p *Proxy) servePackets(s *stream, conn net.PacketConn) {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, client, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			p.logger.Error("Error reading on udp port %d: %s", s.port, err)
+			continue
+		}
+		session, err := p.udpSession(s, conn, client)
+		if err != nil {
+			p.logger.Error("Error forwarding udp port %d: %s", s.port, err)
+			continue
+		}
+		session.lastSeen.Store(time.Now().UnixNano())
+		if _, err := session.upstream.Write(buf[:n]); err != nil {
+			p.logger.Error("Error forwarding udp port %d to %s: %s", s.port, session.backend.address, err)
+		}
+	}
+}
+
+// udpSession returns the backend connection of a client, a new client is sent to the next
+// backend and keeps it until it goes quiet
+func (p *Proxy) udpSession(s *stream, conn net.PacketConn, client net.Addr) (*udpSession, error) {
+	key := client.String()
+	s.mu.Lock()
+	session, ok := s.sessions[key]
+	s.mu.Unlock()
+	if ok {
+		return session, nil
+	}
+
+	b, upstream, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	session = &udpSession{backend: b, upstream: upstream}
+	session.lastSeen.Store(time.Now().UnixNano())
+	s.mu.Lock()
+	s.sessions[key] = session
+	s.mu.Unlock()
+	s.opened(b)
+	go p.replyPackets(s, conn, client, session)
+	return session, nil
+}
+
+func (p *Proxy) replyPackets(s *stream, conn net.PacketConn, client net.Addr, session *udpSession) {
+	defer func() {
+		session.upstream.Close()
+		s.mu.Lock()
+		delete(s.sessions, client.String())
+		s.mu.Unlock()
+		s.closed(session.backend)
+	}()
+	buf := make([]byte, udpBufferSize)
+	for {
+		lastSeen := time.Unix(0, session.lastSeen.Load())
+		_ = session.upstream.SetReadDeadline(lastSeen.Add(udpIdleTimeout))
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && time.Since(time.Unix(0, session.lastSeen.Load())) < udpIdleTimeout {
+				continue
+			}
+			return
+		}
+		if _, err := conn.WriteTo(buf[:n], client); err != nil {
+			return
+		}
+	}
+}
+
+// Drain waits until no stream connection to an instance is open, or until timeout, and
+// returns how many are left
+func (p *Proxy) Drain(ctx context.Context, containerID string, timeout time.Duration) int64 {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		open := p.openConnections(containerID)
+		if open == 0 || time.Now().After(deadline) {
+			return open
+		}
+		select {
+		case <-ctx.Done():
+			return open
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Proxy) openConnections(containerID string) int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var open int64
+	for _, b := range p.streamBackends {
+		if b.containerID == containerID {
+			open += b.active.Load()
+		}
+	}
+	return open
+}
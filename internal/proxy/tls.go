@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dgunzy/go-container-orchestrator/internal/routing"
+	"golang.org/x/crypto/acme"
+)
+
+// challengePath is where the CA fetches the answer to an HTTP-01 challenge
+const challengePath = "/.well-known/acme-challenge/"
+
+// Challenges answers the ACME challenges of certificates being issued
+type Challenges interface {
+	// HTTPChallenge returns the key authorization for an HTTP-01 token
+	HTTPChallenge(token string) (string, bool)
+	// TLSALPNChallenge returns the certificate answering a TLS-ALPN-01 challenge for a domain
+	TLSALPNChallenge(domain string) (*tls.Certificate, bool)
+}
+
+// EnableTLS makes ListenAndServe also serve HTTPS on addr, with the certificates of the
+// routing table. challenges may be nil when no certificates are issued.
+func (p *Proxy) EnableTLS(addr string, challenges Challenges) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tlsAddr = addr
+	p.challenges = challenges
+}
+
+// applyCertificates parses the certificates of a table, p.mu is held. A certificate that
+// does not parse is left out so the other domains are still served.
+func (p *Proxy) applyCertificates(certs []routing.Certificate) {
+	parsed := make(map[string]*tls.Certificate, len(certs))
+	for _, c := range certs {
+		cert, err := tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+		if err != nil {
+			p.logger.Error("Error loading certificate of %s: %s", c.Domain, err)
+			continue
+		}
+		parsed[strings.ToLower(c.Domain)] = &cert
+	}
+	p.certificates = parsed
+}
+
+func (p *Proxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	domain := strings.ToLower(hello.ServerName)
+	if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		if p.challenges != nil {
+			if cert, ok := p.challenges.TLSALPNChallenge(domain); ok {
+				return cert, nil
+			}
+		}
+		return nil, fmt.Errorf("no challenge for %s", domain)
+	}
+	if cert, ok := p.certificates[domain]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no certificate for %q", domain)
+}
+
+// serveChallenge answers an HTTP-01 challenge and reports whether the request was one
+func (p *Proxy) serveChallenge(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.URL.Path, challengePath)
+	if !ok {
+		return false
+	}
+	p.mu.RLock()
+	challenges := p.challenges
+	p.mu.RUnlock()
+	if challenges == nil {
+		return false
+	}
+	keyAuth, ok := challenges.HTTPChallenge(token)
+	if !ok {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(keyAuth))
+	return true
+}
+
+// serveTLS serves HTTPS on the TLS address until the server is shut down
+func (p *Proxy) serveTLS(server *http.Server) {
+	p.logger.Info("Starting TLS proxy on %s", server.Addr)
+	if err := server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+		p.logger.Error("TLS proxy stopped: %s", err)
+	}
+}
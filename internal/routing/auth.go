@@ -0,0 +1,77 @@
+package routing
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// Auth decides which requests reach a route. Every part that is set must pass: the
+// networks, then the basic auth users, then the forward auth endpoint.
+type Auth struct {
+	// Allow lists the networks, such as 10.0.0.0/8 or a single address, requests may come
+	// from. Any network may when it is empty.
+	Allow []string `yaml:"allow,omitempty"`
+	// Deny lists the networks requests may not come from, it wins over Allow
+	Deny []string `yaml:"deny,omitempty"`
+	// BasicAuth maps user names to bcrypt hashes of their passwords
+	BasicAuth map[string]string `yaml:"basic_auth,omitempty"`
+	// Realm is shown by browsers when they ask for a password
+	Realm       string      `yaml:"realm,omitempty"`
+	ForwardAuth ForwardAuth `yaml:"forward_auth,omitempty"`
+}
+
+// ForwardAuth asks an HTTP endpoint to approve each request. The endpoint gets the headers
+// of the request along with X-Forwarded-Method, -Proto, -Host and -Uri. A 2xx response
+// lets the request through, any other is returned to the client.
+type ForwardAuth struct {
+	// URL is the endpoint, such as http://127.0.0.1:4181/verify
+	URL string `yaml:"url,omitempty"`
+	// Service is a service whose instances are the endpoint instead of URL, Path is the
+	// path asked on them
+	Service string `yaml:"service,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+	// ResponseHeaders are copied from an approving response to the request, the identity
+	// of the client such as X-User. Clients cannot set them themselves.
+	ResponseHeaders []string `yaml:"response_headers,omitempty"`
+}
+
+func (f ForwardAuth) Enabled() bool {
+	return f.URL != "" || f.Service != ""
+}
+
+// ServicePath is the path asked on the instances of Service, / when none is set
+func (f ForwardAuth) ServicePath() string {
+	if f.Path == "" {
+		return "/"
+	}
+	return f.Path
+}
+
+func (a Auth) IsZero() bool {
+	return len(a.Allow) == 0 && len(a.Deny) == 0 && len(a.BasicAuth) == 0 && a.Realm == "" &&
+		!a.ForwardAuth.Enabled() && a.ForwardAuth.Path == "" && len(a.ForwardAuth.ResponseHeaders) == 0
+}
+
+// RealmName is the realm of basic auth, Restricted when none is set
+func (a Auth) RealmName() string {
+	if a.Realm == "" {
+		return "Restricted"
+	}
+	return a.Realm
+}
+
+// ParseNetwork reads a network given as a CIDR or a single address
+func ParseNetwork(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
@@ -0,0 +1,61 @@
+package routing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits cap the requests a route takes. Requests over a limit are answered with Status
+// and Message instead of being forwarded.
+type Limits struct {
+	// PerClient limits the requests of each client address, PerRoute the requests of all
+	// clients together
+	PerClient RateLimit `yaml:"per_client,omitempty"`
+	PerRoute  RateLimit `yaml:"per_route,omitempty"`
+	// Status answers the requests over a limit, 429 when zero
+	Status int `yaml:"status,omitempty"`
+	// Message is the body of the answer, the text of the status when empty
+	Message string `yaml:"message,omitempty"`
+}
+
+// RateLimit is a token bucket refilled at Rate, such as 10/s or 300/m, that holds Burst
+// requests beyond the one being made. It is off when Rate is empty.
+type RateLimit struct {
+	Rate  string `yaml:"rate,omitempty"`
+	Burst int    `yaml:"burst,omitempty"`
+}
+
+func (r RateLimit) Enabled() bool {
+	return r.Rate != ""
+}
+
+// ParseRate reads a rate given as a number of requests per second or per minute, such
+// as 10/s or 300/m. It returns the number of requests and the period.
+func ParseRate(s string) (int, time.Duration, error) {
+	count, unit, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 1 {
+		return 0, 0, fmt.Errorf("invalid rate %q, use requests per second or minute such as 10/s or 300/m", s)
+	}
+	switch unit {
+	case "s":
+		return n, time.Second, nil
+	case "m":
+		return n, time.Minute, nil
+	}
+	return 0, 0, fmt.Errorf("invalid rate %q, use requests per second or minute such as 10/s or 300/m", s)
+}
+
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// StatusCode answers the requests over a limit, 429 when none is set
+func (l Limits) StatusCode() int {
+	if l.Status == 0 {
+		return 429
+	}
+	return l.Status
+}
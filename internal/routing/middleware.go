@@ -0,0 +1,155 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Middleware is what a route does to requests besides forwarding them. It is declared on
+// the routes of service specs, the zero value forwards requests unchanged.
+type Middleware struct {
+	// HTTPSRedirect answers plain HTTP requests with a redirect to HTTPS
+	HTTPSRedirect bool `yaml:"https_redirect,omitempty"`
+	// RedirectToApex answers requests for a www. host with a redirect to the host without it
+	RedirectToApex bool `yaml:"redirect_to_apex,omitempty"`
+	// Redirects answer requests for a path with a redirect elsewhere
+	Redirects       []Redirect  `yaml:"redirects,omitempty"`
+	RequestHeaders  HeaderRules `yaml:"request_headers,omitempty"`
+	ResponseHeaders HeaderRules `yaml:"response_headers,omitempty"`
+	HSTS            HSTS        `yaml:"hsts,omitempty"`
+	CORS            CORS        `yaml:"cors,omitempty"`
+	Auth            Auth        `yaml:"auth,omitempty"`
+	Limits          Limits      `yaml:"limits,omitempty"`
+}
+
+// Redirect answers the requests for the path From with a redirect to To, a path or an
+// absolute URL. The query string is kept.
+type Redirect struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Status is 301 or 302, 301 when zero
+	Status int `yaml:"status,omitempty"`
+}
+
+// StatusCode is the status of the redirect, 301 when none is set
+func (r Redirect) StatusCode() int {
+	if r.Status == 0 {
+		return 301
+	}
+	return r.Status
+}
+
+// Target is where a request is redirected. Its query string is joined to the query To may
+// have, in front of the fragment.
+func (r Redirect) Target(rawQuery string) string {
+	if rawQuery == "" {
+		return r.To
+	}
+	base, fragment := r.SplitFragment()
+	return base + r.QuerySeparator() + rawQuery + fragment
+}
+
+// QuerySeparator joins the query string of a request to To, & when To has a query of its own
+func (r Redirect) QuerySeparator() string {
+	if base, _ := r.SplitFragment(); strings.Contains(base, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+// SplitFragment cuts To in front of its fragment, such as #top
+func (r Redirect) SplitFragment() (string, string) {
+	if i := strings.IndexByte(r.To, '#'); i >= 0 {
+		return r.To[:i], r.To[i:]
+	}
+	return r.To, ""
+}
+
+// HeaderRules change the headers of requests or responses. Set replaces a header, Add
+// appends a value to it and Remove drops it. They are applied in that order: Remove, Set,
+// then Add.
+type HeaderRules struct {
+	Set    map[string]string `yaml:"set,omitempty"`
+	Add    map[string]string `yaml:"add,omitempty"`
+	Remove []string          `yaml:"remove,omitempty"`
+}
+
+func (h HeaderRules) IsZero() bool {
+	return len(h.Set) == 0 && len(h.Add) == 0 && len(h.Remove) == 0
+}
+
+// HSTS has browsers use HTTPS only for the host, it is sent on HTTPS responses
+type HSTS struct {
+	// MaxAge is how long browsers remember the policy in seconds, HSTS is off when zero
+	MaxAge            int  `yaml:"max_age,omitempty"`
+	IncludeSubdomains bool `yaml:"include_subdomains,omitempty"`
+	Preload           bool `yaml:"preload,omitempty"`
+}
+
+// Header is the value of the Strict-Transport-Security header
+func (h HSTS) Header() string {
+	value := fmt.Sprintf("max-age=%d", h.MaxAge)
+	if h.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if h.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// CORS lets the pages of other origins call the route from browsers
+type CORS struct {
+	// AllowedOrigins are origins such as https://app.example.com, or * for any. CORS is
+	// off when there are none.
+	AllowedOrigins []string `yaml:"allowed_origins,omitempty"`
+	// AllowedMethods are allowed in preflight requests, GET, HEAD and POST when empty
+	AllowedMethods   []string `yaml:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `yaml:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `yaml:"exposed_headers,omitempty"`
+	AllowCredentials bool     `yaml:"allow_credentials,omitempty"`
+	// MaxAge is how long browsers cache a preflight response in seconds
+	MaxAge int `yaml:"max_age,omitempty"`
+}
+
+func (c CORS) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// Allows reports whether requests from an origin are allowed
+func (c CORS) Allows(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Methods is the value of Access-Control-Allow-Methods
+func (c CORS) Methods() string {
+	if len(c.AllowedMethods) == 0 {
+		return "GET, HEAD, POST"
+	}
+	return strings.Join(c.AllowedMethods, ", ")
+}
+
+// ApexHost is the host a www. host redirects to, the host itself when it has no www.
+func ApexHost(host string) string {
+	if len(host) > 4 && strings.EqualFold(host[:4], "www.") {
+		return host[4:]
+	}
+	return host
+}
+
+// IsZero reports whether the middleware leaves requests as they are
+func (m Middleware) IsZero() bool {
+	return !m.HTTPSRedirect && !m.RedirectToApex && len(m.Redirects) == 0 &&
+		m.RequestHeaders.IsZero() && m.ResponseHeaders.IsZero() && m.HSTS == HSTS{} &&
+		len(m.CORS.AllowedOrigins) == 0 && len(m.CORS.AllowedMethods) == 0 && len(m.CORS.AllowedHeaders) == 0 &&
+		len(m.CORS.ExposedHeaders) == 0 && !m.CORS.AllowCredentials && m.CORS.MaxAge == 0 &&
+		m.Auth.IsZero() && m.Limits.IsZero()
+}
@@ -0,0 +1,217 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type Strategy string
+
+const (
+	RoundRobin       Strategy = "round_robin"
+	LeastConnections Strategy = "least_connections"
+)
+
+// ParseStrategy validates a load balancing strategy, an empty string selects round robin
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "", RoundRobin:
+		return RoundRobin, nil
+	case LeastConnections:
+		return LeastConnections, nil
+	}
+	return "", fmt.Errorf("unknown load balancing strategy %q", s)
+}
+
+// Backend is a single instance of a service reachable at Address (host:port)
+type Backend struct {
+	ContainerID string
+	Address     string
+	Healthy     bool
+	// Weight is the relative share of traffic, zero counts as one
+	Weight int
+}
+
+func (b Backend) EffectiveWeight() int {
+	if b.Weight < 1 {
+		return 1
+	}
+	return b.Weight
+}
+
+type Service struct {
+	Name string
+	// Domain routes every path of a domain to the service, Routes are used instead when set
+	Domain   string
+	Routes   []Route
+	Strategy Strategy
+	Backends []Backend
+	// GRPC is set when the backends speak gRPC, HTTP/2 without TLS
+	GRPC bool
+	// MaxConnections caps the requests in flight to each backend, there is no cap when zero
+	MaxConnections int
+}
+
+// Route sends the requests for Host whose path is Path or below it to a service. Of the
+// routes of a host the one with the longest matching path wins.
+type Route struct {
+	Host string
+	// Path is a prefix such as /api without a trailing slash, / when empty
+	Path string
+	// StripPrefix removes Path from requests before they are forwarded, /api/users
+	// reaches the service as /users
+	StripPrefix bool
+	Middleware
+}
+
+// PathPrefix is the path of the route, / when it is empty
+func (r Route) PathPrefix() string {
+	if r.Path == "" {
+		return "/"
+	}
+	return r.Path
+}
+
+// Matches reports whether a request path is the path of the route or below it, /api
+// matches /api and /api/users but not /apis
+func (r Route) Matches(path string) bool {
+	prefix := r.PathPrefix()
+	if prefix == "/" || path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, prefix+"/")
+}
+
+// EffectiveRoutes returns the routes of the service, the whole of Domain when it has none
+func (s Service) EffectiveRoutes() []Route {
+	if len(s.Routes) > 0 {
+		return s.Routes
+	}
+	if s.Domain == "" {
+		return nil
+	}
+	return []Route{{Host: s.Domain, Path: "/"}}
+}
+
+// Hosts returns the distinct hosts the service is routed on, lower case
+func (s Service) Hosts() []string {
+	var hosts []string
+	for _, r := range s.EffectiveRoutes() {
+		host := strings.ToLower(r.Host)
+		if !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Stream is a public TCP or UDP port forwarded to a port of the instances of a service.
+// Only the built-in proxy serves streams.
+type Stream struct {
+	Service string
+	// PortName is the named port of the service the stream forwards to
+	PortName string
+	Protocol string
+	Port     int
+	Backends []Backend
+}
+
+// ActiveBackends returns the healthy backends, or all of them if none are healthy
+func (s Stream) ActiveBackends() []Backend {
+	return Service{Backends: s.Backends}.ActiveBackends()
+}
+
+// Certificate is the TLS certificate chain and private key served for a domain, PEM encoded
+type Certificate struct {
+	Domain  string
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// Table is the complete routing state, it is always applied as a whole
+type Table struct {
+	Services []Service
+	Streams  []Stream
+	// Certificates are served for the domains of services over HTTPS
+	Certificates []Certificate
+	// ChallengeAddr answers ACME HTTP-01 challenges while certificates are issued, routers
+	// forward the challenge path to it. Empty when no certificates are issued.
+	ChallengeAddr string
+}
+
+// Certificate returns the certificate of a domain
+func (t Table) Certificate(domain string) (Certificate, bool) {
+	for _, c := range t.Certificates {
+		if strings.EqualFold(c.Domain, domain) {
+			return c, true
+		}
+	}
+	return Certificate{}, false
+}
+
+// Router receives the routing table whenever instances or their health change
+type Router interface {
+	Apply(table Table) error
+}
+
+// ActiveBackends returns the healthy backends, or all of them if none are healthy
+// so that a flapping health check does not take the whole service offline
+func (s Service) ActiveBackends() []Backend {
+	var healthy []Backend
+	for _, b := range s.Backends {
+		if b.Healthy {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return s.Backends
+	}
+	return healthy
+}
+
+// Group applies every table to several routers, one table at a time
+type Group struct {
+	mu      sync.Mutex
+	routers []Router
+}
+
+func NewGroup(routers ...Router) *Group {
+	return &Group{routers: routers}
+}
+
+func (g *Group) Apply(table Table) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var errs []error
+	for _, router := range g.routers {
+		if err := router.Apply(table); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Lines describes the table one backend per line, in a stable order suitable for diffs
+func (t Table) Lines() []string {
+	var lines []string
+	for _, s := range t.Services {
+		for _, r := range s.EffectiveRoutes() {
+			target := r.Host
+			if r.PathPrefix() != "/" {
+				target += r.Path
+			}
+			for _, b := range s.Backends {
+				line := fmt.Sprintf("%s -> %s (service %s, %s, weight %d)", target, b.Address, s.Name, s.Strategy, b.EffectiveWeight())
+				if !b.Healthy {
+					line += " down"
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,247 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+type BuildOptions struct {
+	Dockerfile string
+	BuildArgs  map[string]string
+	Target     string
+	Labels     map[string]string
+	Tags       []string
+}
+
+// BuildImage builds an image from a tar build context, writing the build output to out.
+// The image is labelled as managed, images built FROM it inherit the label so it does not
+// tell which images were built here.
+func (d *DockerClient) BuildImage(ctx context.Context, buildContext io.Reader, opts BuildOptions, out io.Writer) error {
+	buildArgs := make(map[string]*string, len(opts.BuildArgs))
+	for key, value := range opts.BuildArgs {
+		value := value
+		buildArgs[key] = &value
+	}
+	labels := map[string]string{managedLabel: managedValue}
+	for key, value := range opts.Labels {
+		labels[key] = value
+	}
+
+	resp, err := d.client.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Tags:        opts.Tags,
+		Dockerfile:  opts.Dockerfile,
+		BuildArgs:   buildArgs,
+		Target:      opts.Target,
+		Labels:      labels,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error building image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The build only reports failures inside the message stream, so it has to be read to the end
+	if err := jsonmessage.DisplayJSONMessagesStream(resp.Body, out, 0, false, nil); err != nil {
+		return fmt.Errorf("error building image: %w", err)
+	}
+	return nil
+}
+
+// TarBuildContext archives dir into a tar stream for BuildImage, skipping anything matched by .dockerignore
+func TarBuildContext(dir string) (io.ReadCloser, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading build context: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("build context %s is not a directory", dir)
+	}
+
+	ignore, err := readDockerignore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			excluded, err := ignore.excluded(rel, info.IsDir())
+			if err != nil {
+				return err
+			}
+			if excluded {
+				if info.IsDir() && !ignore.mayReinclude(rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return addToTar(tw, path, rel, info)
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+func addToTar(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// dockerignore matches paths of a build context against its .dockerignore, with the
+// matcher Docker itself uses
+type dockerignore struct {
+	matcher *patternmatcher.PatternMatcher
+	parents map[string]patternmatcher.MatchInfo
+}
+
+func readDockerignore(dir string) (*dockerignore, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading .dockerignore: %w", err)
+	}
+	defer f.Close()
+
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading .dockerignore: %w", err)
+	}
+	matcher, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("error reading .dockerignore: %w", err)
+	}
+	return &dockerignore{matcher: matcher, parents: make(map[string]patternmatcher.MatchInfo)}, nil
+}
+
+// excluded follows the .dockerignore rule that the last matching pattern wins. Paths
+// have to be passed parents first, as filepath.Walk does.
+func (d *dockerignore) excluded(rel string, isDir bool) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+	excluded, info, err := d.matcher.MatchesUsingParentResults(rel, d.parents[path.Dir(rel)])
+	if err != nil {
+		return false, fmt.Errorf("error matching .dockerignore: %w", err)
+	}
+	if isDir {
+		d.parents[rel] = info
+	}
+	return excluded, nil
+}
+
+// mayReinclude tells whether an exception pattern could match something below the
+// excluded directory dir, in which case the directory still has to be walked
+func (d *dockerignore) mayReinclude(dir string) bool {
+	dirSlash := dir + "/"
+	for _, pattern := range d.matcher.Patterns() {
+		if !pattern.Exclusion() {
+			continue
+		}
+		// Everything before the first wildcard has to match literally
+		literal := filepath.ToSlash(pattern.String())
+		if i := strings.IndexAny(literal, "*?[\\"); i >= 0 {
+			literal = literal[:i]
+		}
+		if strings.HasPrefix(dirSlash, literal) || strings.HasPrefix(literal, dirSlash) {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildVersion returns a version tag for a build context: a timestamp, suffixed
+// with the short commit hash when dir is a git checkout
+func BuildVersion(dir string) string {
+	version := time.Now().Format("20060102150405")
+	if commit := gitCommit(dir); len(commit) >= 7 {
+		version += "-" + commit[:7]
+	}
+	return version
+}
+
+func gitCommit(dir string) string {
+	gitDir := filepath.Join(dir, ".git")
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	ref, isRef := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !isRef {
+		return ref
+	}
+
+	if commit, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(commit))
+	}
+
+	packed, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		if commit, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == ref {
+			return commit
+		}
+	}
+	return ""
+}
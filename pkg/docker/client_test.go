@@ -1,8 +1,12 @@
 package docker
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -268,11 +272,28 @@ func TestExecuteContainerCommand(t *testing.T) {
 	require.NoError(t, err)
 
 	// Test ExecuteContainerCommand
-	execResp, err := client.ExecuteContainerCommand(ctx, resp.ID, []string{"echo", "hello world"})
+	result, err := client.ExecuteContainerCommand(ctx, resp.ID, []string{"echo", "hello world"})
 	assert.NoError(t, err)
-	assert.NotEmpty(t, execResp.ID)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "hello world\n", result.Output)
 
-	// You might want to add more assertions here to check the output of the executed command
+	result, err = client.ExecuteContainerCommand(ctx, resp.ID, []string{"sh", "-c", "echo oops >&2; exit 3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "oops\n", result.Output)
+}
+
+func TestRunContainer(t *testing.T) {
+	client := setupTest(t)
+	ctx := context.Background()
+
+	result, err := client.RunContainer(ctx, &container.Config{
+		Image: testImage,
+		Cmd:   []string{"sh", "-c", "echo migrated; exit 2"},
+	}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.ExitCode)
+	assert.Equal(t, "migrated\n", result.Output)
 }
 
 func TestHealthCheck(t *testing.T) {
@@ -303,3 +324,108 @@ func TestHealthCheck(t *testing.T) {
 	assert.NotNil(t, state.Health)
 	assert.Equal(t, "healthy", state.Health.Status)
 }
+
+func TestTarBuildContext(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM alpine:latest\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("# comment\n**/*.log\nsecrets\n!keep.log\n!secrets/public\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "debug.log"), []byte("ignored"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keep.log"), []byte("kept"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "secrets"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secrets", "key"), []byte("ignored"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secrets", "public"), []byte("kept"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "app", "logs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app", "logs", "debug.log"), []byte("ignored"), 0o644))
+
+	buildContext, err := TarBuildContext(dir)
+	require.NoError(t, err)
+	defer buildContext.Close()
+
+	var names []string
+	tr := tar.NewReader(buildContext)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+
+	assert.ElementsMatch(t, []string{".dockerignore", "Dockerfile", "keep.log", "app/", "app/logs/", "secrets/public"}, names)
+}
+
+func TestBuildImage(t *testing.T) {
+	client := setupTest(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	dockerfile := "FROM alpine:latest AS base\nARG MESSAGE\nRUN echo \"$MESSAGE\" > /message\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0o644))
+
+	buildContext, err := TarBuildContext(dir)
+	require.NoError(t, err)
+	defer buildContext.Close()
+
+	tag := "test-build-image:" + BuildVersion(dir)
+	err = client.BuildImage(ctx, buildContext, BuildOptions{
+		BuildArgs: map[string]string{"MESSAGE": "hello"},
+		Target:    "base",
+		Labels:    map[string]string{"org.opencontainers.image.title": "test"},
+		Tags:      []string{tag},
+	}, io.Discard)
+	require.NoError(t, err)
+	defer client.RemoveImage(ctx, tag)
+
+	exists, err := client.ImageExists(ctx, tag)
+	assert.NoError(t, err)
+	assert.True(t, exists, "Built image was not found")
+}
+
+func TestSaveAndLoadImages(t *testing.T) {
+	client := setupTest(t)
+	ctx := context.Background()
+
+	saved, err := client.SaveImages(ctx, []string{testImage})
+	require.NoError(t, err)
+	archive, err := io.ReadAll(saved)
+	saved.Close()
+	require.NoError(t, err)
+	assert.NotEmpty(t, archive)
+
+	require.NoError(t, client.RemoveImage(ctx, testImage))
+
+	err = client.LoadImages(ctx, bytes.NewReader(archive), io.Discard)
+	require.NoError(t, err)
+
+	exists, err := client.ImageExists(ctx, testImage)
+	assert.NoError(t, err)
+	assert.True(t, exists, "Loaded image was not found")
+}
+
+func TestNetworks(t *testing.T) {
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	ctx := context.Background()
+	name := "test-orchestrator-network"
+
+	id, err := client.EnsureNetwork(ctx, name)
+	require.NoError(t, err, "Error creating network")
+	again, err := client.EnsureNetwork(ctx, name)
+	require.NoError(t, err, "Error ensuring existing network")
+	assert.Equal(t, id, again, "Existing network is reused")
+
+	managed, err := client.ListManagedNetworks(ctx)
+	require.NoError(t, err, "Error listing networks")
+	assert.Contains(t, managed, name)
+
+	removed, err := client.PruneNetworks(ctx, map[string]bool{name: true})
+	require.NoError(t, err, "Error pruning networks")
+	assert.NotContains(t, removed, name, "Kept networks are not pruned")
+
+	removed, err = client.PruneNetworks(ctx, nil)
+	require.NoError(t, err, "Error pruning networks")
+	assert.Contains(t, removed, name)
+}
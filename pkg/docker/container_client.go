@@ -53,14 +53,6 @@ func (d *DockerClient) GetContainerLogs(ctx context.Context, containerID string)
 	return d.client.ContainerLogs(ctx, containerID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
 }
 
-func (d *DockerClient) ExecuteContainerCommand(ctx context.Context, containerID string, command []string) (types.IDResponse, error) {
-	return d.client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-		Cmd:          command,
-		AttachStdout: true,
-		AttachStderr: true,
-	})
-}
-
 func (d *DockerClient) HealthCheck(ctx context.Context, containerID string) (types.ContainerState, error) {
 	if d == nil || d.client == nil {
 		return types.ContainerState{}, errors.New("DockerClient or its client is nil")
@@ -0,0 +1,95 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ExecResult is the outcome of a command run to completion, Output interleaves stdout and stderr
+type ExecResult struct {
+	ExitCode int
+	Output   string
+}
+
+// ExecuteContainerCommand runs a command inside a running container and waits for it to exit
+func (d *DockerClient) ExecuteContainerCommand(ctx context.Context, containerID string, command []string) (ExecResult, error) {
+	exec, err := d.client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          command,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attach, err := d.client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer attach.Close()
+
+	// The stream ends when the command exits, or when ctx is cancelled and the connection closed
+	var output bytes.Buffer
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(&output, &output, attach.Reader)
+		copied <- err
+	}()
+	select {
+	case err := <-copied:
+		if err != nil {
+			return ExecResult{Output: output.String()}, fmt.Errorf("failed to read exec output: %w", err)
+		}
+	case <-ctx.Done():
+		attach.Close()
+		<-copied
+		return ExecResult{Output: output.String()}, ctx.Err()
+	}
+
+	inspect, err := d.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return ExecResult{Output: output.String()}, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	return ExecResult{ExitCode: inspect.ExitCode, Output: output.String()}, nil
+}
+
+// RunContainer runs a one-off container to completion and removes it
+func (d *DockerClient) RunContainer(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig) (ExecResult, error) {
+	if networkingConfig == nil {
+		networkingConfig = &network.NetworkingConfig{}
+	}
+	created, err := d.client.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, "")
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to create container: %w", err)
+	}
+	defer d.client.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+
+	if err := d.client.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return ExecResult{}, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	var exitCode int
+	statusCh, errCh := d.client.ContainerWait(ctx, created.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return ExecResult{}, fmt.Errorf("failed waiting for container: %w", err)
+	case status := <-statusCh:
+		exitCode = int(status.StatusCode)
+	}
+
+	logs, err := d.client.ContainerLogs(ctx, created.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return ExecResult{ExitCode: exitCode}, fmt.Errorf("failed to read container logs: %w", err)
+	}
+	defer logs.Close()
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, logs); err != nil {
+		return ExecResult{ExitCode: exitCode, Output: output.String()}, fmt.Errorf("failed to read container logs: %w", err)
+	}
+	return ExecResult{ExitCode: exitCode, Output: output.String()}, nil
+}
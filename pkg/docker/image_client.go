@@ -10,6 +10,7 @@ import (
 	"os"
 
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
 
 	"github.com/docker/docker/client"
 )
@@ -47,6 +48,11 @@ func (d *DockerClient) PullImageFromPrivateRegistry(ctx context.Context, fullIma
 		return errors.New("image already exists with same tag, not pulling again")
 	}
 
+	return d.PullImage(ctx, fullImageName, username, password)
+}
+
+// PullImage pulls an image, replacing a local image with the same tag
+func (d *DockerClient) PullImage(ctx context.Context, fullImageName, username, password string) error {
 	auth := AuthConfig{
 		Username: username,
 		Password: password,
@@ -82,3 +88,50 @@ func (d *DockerClient) ImageExists(ctx context.Context, imageName string) (bool,
 	}
 	return true, nil
 }
+
+// SaveImages exports the given images as a single tar stream in the docker save format
+func (d *DockerClient) SaveImages(ctx context.Context, images []string) (io.ReadCloser, error) {
+	return d.client.ImageSave(ctx, images)
+}
+
+// LoadImages imports images from a tar stream in the docker save format, writing the load output to out
+func (d *DockerClient) LoadImages(ctx context.Context, input io.Reader, out io.Writer) error {
+	resp, err := d.client.ImageLoad(ctx, input, true)
+	if err != nil {
+		return fmt.Errorf("error loading images: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if !resp.JSON {
+		_, err = io.Copy(out, resp.Body)
+		return err
+	}
+	if err := jsonmessage.DisplayJSONMessagesStream(resp.Body, out, 0, false, nil); err != nil {
+		return fmt.Errorf("error loading images: %w", err)
+	}
+	return nil
+}
+
+// ImageID returns the ID of a local image
+func (d *DockerClient) ImageID(ctx context.Context, imageName string) (string, error) {
+	inspect, _, err := d.client.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+// ImageLabels returns the labels of a local image, never nil for an image without labels
+func (d *DockerClient) ImageLabels(ctx context.Context, imageName string) (map[string]string, error) {
+	inspect, _, err := d.client.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	if inspect.Config != nil {
+		for key, value := range inspect.Config.Labels {
+			labels[key] = value
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+)
+
+// managedLabel marks the networks and images the orchestrator created, only those
+// networks are pruned
+const (
+	managedLabel = "managed-by"
+	managedValue = "go-container-orchestrator"
+)
+
+// EnsureNetwork creates a bridge network unless it exists and returns its ID
+func (d *DockerClient) EnsureNetwork(ctx context.Context, name string) (string, error) {
+	existing, err := d.client.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err == nil {
+		return existing.ID, nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return "", fmt.Errorf("failed to inspect network %s: %w", name, err)
+	}
+	created, err := d.client.NetworkCreate(ctx, name, network.CreateOptions{
+		Driver: "bridge",
+		Labels: map[string]string{managedLabel: managedValue},
+	})
+	if err != nil {
+		// Another process may have created it in the meantime
+		if existing, inspectErr := d.client.NetworkInspect(ctx, name, network.InspectOptions{}); inspectErr == nil {
+			return existing.ID, nil
+		}
+		return "", fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	return created.ID, nil
+}
+
+// ListManagedNetworks returns the names of the networks created by EnsureNetwork
+func (d *DockerClient) ListManagedNetworks(ctx context.Context) ([]string, error) {
+	networks, err := d.client.NetworkList(ctx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel+"="+managedValue)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	names := make([]string, 0, len(networks))
+	for _, n := range networks {
+		names = append(names, n.Name)
+	}
+	return names, nil
+}
+
+func (d *DockerClient) RemoveNetwork(ctx context.Context, name string) error {
+	if err := d.client.NetworkRemove(ctx, name); err != nil {
+		return fmt.Errorf("failed to remove network %s: %w", name, err)
+	}
+	return nil
+}
+
+// PruneNetworks removes the managed networks that are not in keep and no container is
+// attached to, and returns their names
+func (d *DockerClient) PruneNetworks(ctx context.Context, keep map[string]bool) ([]string, error) {
+	names, err := d.ListManagedNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, name := range names {
+		if keep[name] {
+			continue
+		}
+		inspect, err := d.client.NetworkInspect(ctx, name, network.InspectOptions{})
+		if err != nil {
+			return removed, fmt.Errorf("failed to inspect network %s: %w", name, err)
+		}
+		if len(inspect.Containers) > 0 {
+			continue
+		}
+		if err := d.RemoveNetwork(ctx, name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// ConnectNetwork attaches a container to a network under the given aliases
+func (d *DockerClient) ConnectNetwork(ctx context.Context, networkName, containerID string, aliases []string) error {
+	err := d.client.NetworkConnect(ctx, networkName, containerID, &network.EndpointSettings{Aliases: aliases})
+	if err != nil {
+		return fmt.Errorf("failed to connect container to network %s: %w", networkName, err)
+	}
+	return nil
+}
+
+func (d *DockerClient) DisconnectNetwork(ctx context.Context, networkName, containerID string) error {
+	if err := d.client.NetworkDisconnect(ctx, networkName, containerID, false); err != nil {
+		return fmt.Errorf("failed to disconnect container from network %s: %w", networkName, err)
+	}
+	return nil
+}
+
+// ContainerNetworks returns the networks a container is attached to
+func (d *DockerClient) ContainerNetworks(ctx context.Context, containerID string) ([]string, error) {
+	inspect, err := d.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	var names []string
+	if inspect.NetworkSettings != nil {
+		for name := range inspect.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// ContainerIP returns the address of a container on a network, on any of its networks
+// when networkName is empty
+func (d *DockerClient) ContainerIP(ctx context.Context, containerID, networkName string) (string, error) {
+	inspect, err := d.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.NetworkSettings != nil {
+		if endpoint, ok := inspect.NetworkSettings.Networks[networkName]; ok && endpoint.IPAddress != "" {
+			return endpoint.IPAddress, nil
+		}
+		if networkName == "" {
+			names := make([]string, 0, len(inspect.NetworkSettings.Networks))
+			for name := range inspect.NetworkSettings.Networks {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if ip := inspect.NetworkSettings.Networks[name].IPAddress; ip != "" {
+					return ip, nil
+				}
+			}
+			return "", fmt.Errorf("container %s has no address on any network", containerID)
+		}
+	}
+	return "", fmt.Errorf("container %s has no address on network %s", containerID, networkName)
+}
@@ -21,6 +21,21 @@ type ContainerServiceClient interface {
 	ListContainers(ctx context.Context, in *ListContainersRequest, opts ...grpc.CallOption) (*ListContainersResponse, error)
 	UpdateContainer(ctx context.Context, in *UpdateContainerRequest, opts ...grpc.CallOption) (*UpdateContainerResponse, error)
 	RemoveContainer(ctx context.Context, in *RemoveContainerRequest, opts ...grpc.CallOption) (*RemoveContainerResponse, error)
+	BuildAndDeploy(ctx context.Context, opts ...grpc.CallOption) (ContainerService_BuildAndDeployClient, error)
+	SaveImages(ctx context.Context, in *SaveImagesRequest, opts ...grpc.CallOption) (ContainerService_SaveImagesClient, error)
+	LoadImages(ctx context.Context, opts ...grpc.CallOption) (ContainerService_LoadImagesClient, error)
+	DeployStatus(ctx context.Context, in *DeployStatusRequest, opts ...grpc.CallOption) (*RolloutStatus, error)
+	ControlRollout(ctx context.Context, in *ControlRolloutRequest, opts ...grpc.CallOption) (*RolloutStatus, error)
+	ListDeployments(ctx context.Context, in *ListDeploymentsRequest, opts ...grpc.CallOption) (*ListDeploymentsResponse, error)
+	GetOperation(ctx context.Context, in *GetOperationRequest, opts ...grpc.CallOption) (*Operation, error)
+	ListOperations(ctx context.Context, in *ListOperationsRequest, opts ...grpc.CallOption) (*ListOperationsResponse, error)
+	ExportState(ctx context.Context, in *ExportStateRequest, opts ...grpc.CallOption) (*ExportStateResponse, error)
+	ImportState(ctx context.Context, in *ImportStateRequest, opts ...grpc.CallOption) (*ImportStateResponse, error)
+	ListAuditLog(ctx context.Context, in *ListAuditLogRequest, opts ...grpc.CallOption) (*ListAuditLogResponse, error)
+	ListPorts(ctx context.Context, in *ListPortsRequest, opts ...grpc.CallOption) (*ListPortsResponse, error)
+	UploadCertificate(ctx context.Context, in *UploadCertificateRequest, opts ...grpc.CallOption) (*CertificateInfo, error)
+	CreateSelfSignedCertificate(ctx context.Context, in *CreateSelfSignedCertificateRequest, opts ...grpc.CallOption) (*CertificateInfo, error)
+	ListCertificates(ctx context.Context, in *ListCertificatesRequest, opts ...grpc.CallOption) (*ListCertificatesResponse, error)
 }
 
 type containerServiceClient struct {
@@ -67,6 +82,211 @@ func (c *containerServiceClient) RemoveContainer(ctx context.Context, in *Remove
 	return out, nil
 }
 
+func (c *containerServiceClient) BuildAndDeploy(ctx context.Context, opts ...grpc.CallOption) (ContainerService_BuildAndDeployClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ContainerService_ServiceDesc.Streams[0], "/containerservice.ContainerService/BuildAndDeploy", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &containerServiceBuildAndDeployClient{stream}
+	return x, nil
+}
+
+type ContainerService_BuildAndDeployClient interface {
+	Send(*BuildAndDeployRequest) error
+	Recv() (*BuildAndDeployResponse, error)
+	grpc.ClientStream
+}
+
+type containerServiceBuildAndDeployClient struct {
+	grpc.ClientStream
+}
+
+func (x *containerServiceBuildAndDeployClient) Send(m *BuildAndDeployRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *containerServiceBuildAndDeployClient) Recv() (*BuildAndDeployResponse, error) {
+	m := new(BuildAndDeployResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *containerServiceClient) SaveImages(ctx context.Context, in *SaveImagesRequest, opts ...grpc.CallOption) (ContainerService_SaveImagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ContainerService_ServiceDesc.Streams[1], "/containerservice.ContainerService/SaveImages", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &containerServiceSaveImagesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ContainerService_SaveImagesClient interface {
+	Recv() (*SaveImagesResponse, error)
+	grpc.ClientStream
+}
+
+type containerServiceSaveImagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *containerServiceSaveImagesClient) Recv() (*SaveImagesResponse, error) {
+	m := new(SaveImagesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *containerServiceClient) LoadImages(ctx context.Context, opts ...grpc.CallOption) (ContainerService_LoadImagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ContainerService_ServiceDesc.Streams[2], "/containerservice.ContainerService/LoadImages", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &containerServiceLoadImagesClient{stream}
+	return x, nil
+}
+
+type ContainerService_LoadImagesClient interface {
+	Send(*LoadImagesRequest) error
+	CloseAndRecv() (*LoadImagesResponse, error)
+	grpc.ClientStream
+}
+
+type containerServiceLoadImagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *containerServiceLoadImagesClient) Send(m *LoadImagesRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *containerServiceLoadImagesClient) CloseAndRecv() (*LoadImagesResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(LoadImagesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *containerServiceClient) DeployStatus(ctx context.Context, in *DeployStatusRequest, opts ...grpc.CallOption) (*RolloutStatus, error) {
+	out := new(RolloutStatus)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/DeployStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ControlRollout(ctx context.Context, in *ControlRolloutRequest, opts ...grpc.CallOption) (*RolloutStatus, error) {
+	out := new(RolloutStatus)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ControlRollout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ListDeployments(ctx context.Context, in *ListDeploymentsRequest, opts ...grpc.CallOption) (*ListDeploymentsResponse, error) {
+	out := new(ListDeploymentsResponse)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ListDeployments", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) GetOperation(ctx context.Context, in *GetOperationRequest, opts ...grpc.CallOption) (*Operation, error) {
+	out := new(Operation)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/GetOperation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ListOperations(ctx context.Context, in *ListOperationsRequest, opts ...grpc.CallOption) (*ListOperationsResponse, error) {
+	out := new(ListOperationsResponse)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ListOperations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ExportState(ctx context.Context, in *ExportStateRequest, opts ...grpc.CallOption) (*ExportStateResponse, error) {
+	out := new(ExportStateResponse)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ExportState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ImportState(ctx context.Context, in *ImportStateRequest, opts ...grpc.CallOption) (*ImportStateResponse, error) {
+	out := new(ImportStateResponse)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ImportState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ListAuditLog(ctx context.Context, in *ListAuditLogRequest, opts ...grpc.CallOption) (*ListAuditLogResponse, error) {
+	out := new(ListAuditLogResponse)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ListAuditLog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ListPorts(ctx context.Context, in *ListPortsRequest, opts ...grpc.CallOption) (*ListPortsResponse, error) {
+	out := new(ListPortsResponse)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ListPorts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) UploadCertificate(ctx context.Context, in *UploadCertificateRequest, opts ...grpc.CallOption) (*CertificateInfo, error) {
+	out := new(CertificateInfo)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/UploadCertificate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) CreateSelfSignedCertificate(ctx context.Context, in *CreateSelfSignedCertificateRequest, opts ...grpc.CallOption) (*CertificateInfo, error) {
+	out := new(CertificateInfo)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/CreateSelfSignedCertificate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *containerServiceClient) ListCertificates(ctx context.Context, in *ListCertificatesRequest, opts ...grpc.CallOption) (*ListCertificatesResponse, error) {
+	out := new(ListCertificatesResponse)
+	err := c.cc.Invoke(ctx, "/containerservice.ContainerService/ListCertificates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ContainerServiceServer is the server API for ContainerService service.
 // All implementations must embed UnimplementedContainerServiceServer
 // for forward compatibility
@@ -75,6 +295,21 @@ type ContainerServiceServer interface {
 	ListContainers(context.Context, *ListContainersRequest) (*ListContainersResponse, error)
 	UpdateContainer(context.Context, *UpdateContainerRequest) (*UpdateContainerResponse, error)
 	RemoveContainer(context.Context, *RemoveContainerRequest) (*RemoveContainerResponse, error)
+	BuildAndDeploy(ContainerService_BuildAndDeployServer) error
+	SaveImages(*SaveImagesRequest, ContainerService_SaveImagesServer) error
+	LoadImages(ContainerService_LoadImagesServer) error
+	DeployStatus(context.Context, *DeployStatusRequest) (*RolloutStatus, error)
+	ControlRollout(context.Context, *ControlRolloutRequest) (*RolloutStatus, error)
+	ListDeployments(context.Context, *ListDeploymentsRequest) (*ListDeploymentsResponse, error)
+	GetOperation(context.Context, *GetOperationRequest) (*Operation, error)
+	ListOperations(context.Context, *ListOperationsRequest) (*ListOperationsResponse, error)
+	ExportState(context.Context, *ExportStateRequest) (*ExportStateResponse, error)
+	ImportState(context.Context, *ImportStateRequest) (*ImportStateResponse, error)
+	ListAuditLog(context.Context, *ListAuditLogRequest) (*ListAuditLogResponse, error)
+	ListPorts(context.Context, *ListPortsRequest) (*ListPortsResponse, error)
+	UploadCertificate(context.Context, *UploadCertificateRequest) (*CertificateInfo, error)
+	CreateSelfSignedCertificate(context.Context, *CreateSelfSignedCertificateRequest) (*CertificateInfo, error)
+	ListCertificates(context.Context, *ListCertificatesRequest) (*ListCertificatesResponse, error)
 	mustEmbedUnimplementedContainerServiceServer()
 }
 
@@ -94,6 +329,51 @@ func (UnimplementedContainerServiceServer) UpdateContainer(context.Context, *Upd
 func (UnimplementedContainerServiceServer) RemoveContainer(context.Context, *RemoveContainerRequest) (*RemoveContainerResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveContainer not implemented")
 }
+func (UnimplementedContainerServiceServer) BuildAndDeploy(ContainerService_BuildAndDeployServer) error {
+	return status.Errorf(codes.Unimplemented, "method BuildAndDeploy not implemented")
+}
+func (UnimplementedContainerServiceServer) SaveImages(*SaveImagesRequest, ContainerService_SaveImagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SaveImages not implemented")
+}
+func (UnimplementedContainerServiceServer) LoadImages(ContainerService_LoadImagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method LoadImages not implemented")
+}
+func (UnimplementedContainerServiceServer) DeployStatus(context.Context, *DeployStatusRequest) (*RolloutStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeployStatus not implemented")
+}
+func (UnimplementedContainerServiceServer) ControlRollout(context.Context, *ControlRolloutRequest) (*RolloutStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ControlRollout not implemented")
+}
+func (UnimplementedContainerServiceServer) ListDeployments(context.Context, *ListDeploymentsRequest) (*ListDeploymentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeployments not implemented")
+}
+func (UnimplementedContainerServiceServer) GetOperation(context.Context, *GetOperationRequest) (*Operation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOperation not implemented")
+}
+func (UnimplementedContainerServiceServer) ListOperations(context.Context, *ListOperationsRequest) (*ListOperationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOperations not implemented")
+}
+func (UnimplementedContainerServiceServer) ExportState(context.Context, *ExportStateRequest) (*ExportStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportState not implemented")
+}
+func (UnimplementedContainerServiceServer) ImportState(context.Context, *ImportStateRequest) (*ImportStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportState not implemented")
+}
+func (UnimplementedContainerServiceServer) ListAuditLog(context.Context, *ListAuditLogRequest) (*ListAuditLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAuditLog not implemented")
+}
+func (UnimplementedContainerServiceServer) ListPorts(context.Context, *ListPortsRequest) (*ListPortsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPorts not implemented")
+}
+func (UnimplementedContainerServiceServer) UploadCertificate(context.Context, *UploadCertificateRequest) (*CertificateInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UploadCertificate not implemented")
+}
+func (UnimplementedContainerServiceServer) CreateSelfSignedCertificate(context.Context, *CreateSelfSignedCertificateRequest) (*CertificateInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSelfSignedCertificate not implemented")
+}
+func (UnimplementedContainerServiceServer) ListCertificates(context.Context, *ListCertificatesRequest) (*ListCertificatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCertificates not implemented")
+}
 func (UnimplementedContainerServiceServer) mustEmbedUnimplementedContainerServiceServer() {}
 
 // UnsafeContainerServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -179,6 +459,295 @@ func _ContainerService_RemoveContainer_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ContainerService_BuildAndDeploy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ContainerServiceServer).BuildAndDeploy(&containerServiceBuildAndDeployServer{stream})
+}
+
+type ContainerService_BuildAndDeployServer interface {
+	Send(*BuildAndDeployResponse) error
+	Recv() (*BuildAndDeployRequest, error)
+	grpc.ServerStream
+}
+
+type containerServiceBuildAndDeployServer struct {
+	grpc.ServerStream
+}
+
+func (x *containerServiceBuildAndDeployServer) Send(m *BuildAndDeployResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *containerServiceBuildAndDeployServer) Recv() (*BuildAndDeployRequest, error) {
+	m := new(BuildAndDeployRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ContainerService_SaveImages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SaveImagesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ContainerServiceServer).SaveImages(m, &containerServiceSaveImagesServer{stream})
+}
+
+type ContainerService_SaveImagesServer interface {
+	Send(*SaveImagesResponse) error
+	grpc.ServerStream
+}
+
+type containerServiceSaveImagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *containerServiceSaveImagesServer) Send(m *SaveImagesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ContainerService_LoadImages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ContainerServiceServer).LoadImages(&containerServiceLoadImagesServer{stream})
+}
+
+type ContainerService_LoadImagesServer interface {
+	SendAndClose(*LoadImagesResponse) error
+	Recv() (*LoadImagesRequest, error)
+	grpc.ServerStream
+}
+
+type containerServiceLoadImagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *containerServiceLoadImagesServer) SendAndClose(m *LoadImagesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *containerServiceLoadImagesServer) Recv() (*LoadImagesRequest, error) {
+	m := new(LoadImagesRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ContainerService_DeployStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeployStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).DeployStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/DeployStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).DeployStatus(ctx, req.(*DeployStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ControlRollout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ControlRolloutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ControlRollout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ControlRollout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ControlRollout(ctx, req.(*ControlRolloutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ListDeployments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDeploymentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ListDeployments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ListDeployments",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ListDeployments(ctx, req.(*ListDeploymentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_GetOperation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOperationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).GetOperation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/GetOperation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).GetOperation(ctx, req.(*GetOperationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ListOperations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOperationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ListOperations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ListOperations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ListOperations(ctx, req.(*ListOperationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ExportState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ExportState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ExportState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ExportState(ctx, req.(*ExportStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ImportState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ImportState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ImportState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ImportState(ctx, req.(*ImportStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ListAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAuditLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ListAuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ListAuditLog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ListAuditLog(ctx, req.(*ListAuditLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ListPorts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPortsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ListPorts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ListPorts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ListPorts(ctx, req.(*ListPortsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_UploadCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).UploadCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/UploadCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).UploadCertificate(ctx, req.(*UploadCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_CreateSelfSignedCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSelfSignedCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).CreateSelfSignedCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/CreateSelfSignedCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).CreateSelfSignedCertificate(ctx, req.(*CreateSelfSignedCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContainerService_ListCertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCertificatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContainerServiceServer).ListCertificates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/containerservice.ContainerService/ListCertificates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContainerServiceServer).ListCertificates(ctx, req.(*ListCertificatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ContainerService_ServiceDesc is the grpc.ServiceDesc for ContainerService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -202,7 +771,72 @@ var ContainerService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveContainer",
 			Handler:    _ContainerService_RemoveContainer_Handler,
 		},
+		{
+			MethodName: "DeployStatus",
+			Handler:    _ContainerService_DeployStatus_Handler,
+		},
+		{
+			MethodName: "ControlRollout",
+			Handler:    _ContainerService_ControlRollout_Handler,
+		},
+		{
+			MethodName: "ListDeployments",
+			Handler:    _ContainerService_ListDeployments_Handler,
+		},
+		{
+			MethodName: "GetOperation",
+			Handler:    _ContainerService_GetOperation_Handler,
+		},
+		{
+			MethodName: "ListOperations",
+			Handler:    _ContainerService_ListOperations_Handler,
+		},
+		{
+			MethodName: "ExportState",
+			Handler:    _ContainerService_ExportState_Handler,
+		},
+		{
+			MethodName: "ImportState",
+			Handler:    _ContainerService_ImportState_Handler,
+		},
+		{
+			MethodName: "ListAuditLog",
+			Handler:    _ContainerService_ListAuditLog_Handler,
+		},
+		{
+			MethodName: "ListPorts",
+			Handler:    _ContainerService_ListPorts_Handler,
+		},
+		{
+			MethodName: "UploadCertificate",
+			Handler:    _ContainerService_UploadCertificate_Handler,
+		},
+		{
+			MethodName: "CreateSelfSignedCertificate",
+			Handler:    _ContainerService_CreateSelfSignedCertificate_Handler,
+		},
+		{
+			MethodName: "ListCertificates",
+			Handler:    _ContainerService_ListCertificates_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BuildAndDeploy",
+			Handler:       _ContainerService_BuildAndDeploy_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "SaveImages",
+			Handler:       _ContainerService_SaveImages_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "LoadImages",
+			Handler:       _ContainerService_LoadImages_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/proto/container_service.proto",
 }